@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/archive"
+	"sovereign-orchestrator/pkg/auth"
+	"sovereign-orchestrator/pkg/backup"
+	"sovereign-orchestrator/pkg/dataset"
+	"sovereign-orchestrator/pkg/dbmerge"
+	"sovereign-orchestrator/pkg/dbregistry"
+	"sovereign-orchestrator/pkg/dbschema"
+	"sovereign-orchestrator/pkg/embed"
+	"sovereign-orchestrator/pkg/llm"
+	"sovereign-orchestrator/pkg/search"
+	"sovereign-orchestrator/pkg/undo"
+	"sovereign-orchestrator/pkg/vault"
+)
+
+// apiAdapter encodes the responses of every JSON route.
+var apiAdapter = &api.Adapter{Classify: classifyError, Log: logAPIError}
+
+// handle adapts a handler that returns its data to the route table.
+func handle(h api.HandlerFunc) http.HandlerFunc {
+	return apiAdapter.Handle(h)
+}
+
+// sentinelCodes maps the sentinel errors of the engine packages to API
+// error codes. The client sees the sentinel and what the engine added to
+// it, which only names things the client supplied; the full chain, which
+// may hold paths and driver errors, goes to the log.
+var sentinelCodes = []struct {
+	err  error
+	code api.Code
+}{
+	{dbregistry.ErrInvalidName, api.CodeInvalidRequest},
+	{dbregistry.ErrNotFound, api.CodeNotFound},
+	{dbregistry.ErrExists, api.CodeConflict},
+	{dbregistry.ErrProtected, api.CodeForbidden},
+	{dbregistry.ErrBusy, api.CodeConflict},
+	{dbschema.ErrInvalidQuery, api.CodeInvalidRequest},
+	{archive.ErrInvalid, api.CodeInvalidRequest},
+	{archive.ErrNotFound, api.CodeNotFound},
+	{archive.ErrProtected, api.CodeForbidden},
+	{archive.ErrConflict, api.CodeConflict},
+	{archive.ErrChecksum, api.CodeIntegrity},
+	{undo.ErrInvalid, api.CodeInvalidRequest},
+	{undo.ErrProtected, api.CodeForbidden},
+	{undo.ErrNotFound, api.CodeNotFound},
+	{undo.ErrConflict, api.CodeConflict},
+	{undo.ErrExpired, api.CodeGone},
+	{dbmerge.ErrInvalid, api.CodeInvalidRequest},
+	{dbmerge.ErrConflict, api.CodeConflict},
+	{search.ErrInvalidQuery, api.CodeInvalidRequest},
+	{search.ErrUnavailable, api.CodeUnavailable},
+	{embed.ErrInvalid, api.CodeInvalidRequest},
+	{dataset.ErrInvalid, api.CodeInvalidRequest},
+	{backup.ErrInvalid, api.CodeInvalidRequest},
+	{backup.ErrNotFound, api.CodeNotFound},
+	{backup.ErrIntegrity, api.CodeIntegrity},
+	{vault.ErrNoKey, api.CodeConflict},
+	{vault.ErrWrongKey, api.CodeConflict},
+	{vault.ErrCorrupt, api.CodeIntegrity},
+	{auth.ErrInvalid, api.CodeInvalidRequest},
+	{auth.ErrCredentials, api.CodeUnauthenticated},
+	{auth.ErrUnauthenticated, api.CodeUnauthenticated},
+	{auth.ErrNotFound, api.CodeNotFound},
+	{auth.ErrExists, api.CodeConflict},
+	{llm.ErrEmptyPrompt, api.CodeInvalidRequest},
+}
+
+// classifyError finds the API error code of a sentinel error.
+func classifyError(err error) *api.Error {
+	for _, s := range sentinelCodes {
+		if !errors.Is(err, s.err) {
+			continue
+		}
+		if s.code == api.CodeIntegrity {
+			return api.Wrap(s.code, err, s.err.Error())
+		}
+		return api.Wrap(s.code, err, sentinelMessage(err, s.err))
+	}
+	return nil
+}
+
+// sentinelMessage returns the message of the error in err's chain that
+// wraps sentinel directly, leaving out the context callers added around it.
+func sentinelMessage(err, sentinel error) string {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if e == sentinel || errors.Unwrap(e) == sentinel {
+			return e.Error()
+		}
+	}
+	return sentinel.Error()
+}
+
+// logAPIError logs the cause of an error the client only sees the code
+// and a summary of.
+func logAPIError(r *http.Request, e *api.Error) {
+	id := "-"
+	if info := requestInfoFrom(r); info != nil {
+		id = info.id
+	}
+	log.Printf("Error: %s %s (id=%s): %v", r.Method, r.URL.Path, id, e)
+}
+
+// statusResponse answers routes that only report what they did.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// notImplemented answers routes that are declared but not built yet.
+func notImplemented(w http.ResponseWriter, r *http.Request) (any, error) {
+	return nil, api.Errorf(api.CodeNotImplemented, "%s is not implemented yet", r.URL.Path)
+}
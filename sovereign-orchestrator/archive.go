@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/archive"
+	"sovereign-orchestrator/pkg/dbregistry"
+)
+
+const (
+	snapshotDirName   = "archives"
+	archiveSidecarExt = ".archive.db"
+)
+
+// archiver returns the archiver for a registered database, usable until ctx
+// is done. Each database archives into <name>.archive.db next to its own file.
+func (app *SovereignApp) archiver(ctx context.Context, name string) (*archive.Archiver, error) {
+	if name == "" {
+		name = app.Databases.PrimaryName()
+	}
+	db, err := app.Databases.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	path, err := app.Databases.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	base := path
+	if app.sealed != nil && name == app.Databases.PrimaryName() {
+		// The unlocked copy lives in memory; a sidecar left from before the
+		// database was encrypted stays next to the sealed file. Nothing new
+		// is archived into it (see refuseSealedCopy), but its batches can
+		// still be restored.
+		base = app.sealed.plainPath
+	}
+	return &archive.Archiver{
+		DB:          db,
+		Name:        name,
+		Path:        path,
+		ArchivePath: strings.TrimSuffix(base, filepath.Ext(base)) + archiveSidecarExt,
+	}, nil
+}
+
+// refuseSealedCopy rejects copying the rows of an encrypted memory database
+// into a plaintext file: archive sidecars, snapshots, training datasets and
+// other registered databases are not encrypted. Backups are sealed with the
+// database key.
+func (app *SovereignApp) refuseSealedCopy(name, what string) error {
+	if app.sealed == nil || (name != "" && name != app.Databases.PrimaryName()) {
+		return nil
+	}
+	return api.Errorf(api.CodeConflict, "The memory database is encrypted and %s would write its rows unencrypted; back it up instead, which is sealed with its key, or decrypt it first with `db decrypt`", what)
+}
+
+// snapshotDir is where database archives are written.
+func (app *SovereignApp) snapshotDir() string {
+	if app.Config.Databases.SnapshotDir != "" {
+		return app.Config.Databases.SnapshotDir
+	}
+	return filepath.Join(app.AppDir, snapshotDirName)
+}
+
+// archiveRowsRequest is the body of /api/archive_rows.
+type archiveRowsRequest struct {
+	DB       string  `json:"db" doc:"Registered database; the memory database when empty"`
+	Table    string  `json:"table" schema:"required,minLength=1"`
+	IDs      []int64 `json:"ids" schema:"required,minItems=1"`
+	Override bool    `json:"override" doc:"Allow archiving from protected tables"`
+}
+
+// archiveTableRequest is the body of /api/archive_table.
+type archiveTableRequest struct {
+	DB       string `json:"db" doc:"Registered database; the memory database when empty"`
+	Table    string `json:"table" schema:"required,minLength=1"`
+	Drop     bool   `json:"drop" doc:"Drop the emptied table"`
+	Override bool   `json:"override" doc:"Allow archiving protected tables"`
+}
+
+// archiveBatchResponse answers the routes that archive or restore a batch.
+type archiveBatchResponse struct {
+	Status string         `json:"status" schema:"enum=archived|restored"`
+	Batch  *archive.Batch `json:"batch"`
+}
+
+// handleAPIArchiveRows moves selected rows into the database's archive.
+// prime_directives and schema_versions need "override": true.
+func (app *SovereignApp) handleAPIArchiveRows(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req archiveRowsRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if err := app.refuseSealedCopy(req.DB, "archiving rows"); err != nil {
+		return nil, err
+	}
+	a, err := app.archiver(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := a.ArchiveRows(r.Context(), req.Table, req.IDs, req.Override)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("archive", "info", fmt.Sprintf("Archived %d row(s) of %s.%s (batch %d)", batch.RowCount, a.Name, batch.Table, batch.ID), batch)
+	return archiveBatchResponse{Status: "archived", Batch: batch}, nil
+}
+
+// handleAPIArchiveTable moves every row of a table into the database's
+// archive, optionally dropping the table. prime_directives and
+// schema_versions need "override": true.
+func (app *SovereignApp) handleAPIArchiveTable(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req archiveTableRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if err := app.refuseSealedCopy(req.DB, "archiving a table"); err != nil {
+		return nil, err
+	}
+	a, err := app.archiver(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := a.ArchiveTable(r.Context(), req.Table, req.Drop, req.Override)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("archive", "info", fmt.Sprintf("Archived table %s.%s (%d rows, batch %d)", a.Name, batch.Table, batch.RowCount, batch.ID), batch)
+	return archiveBatchResponse{Status: "archived", Batch: batch}, nil
+}
+
+// archiveDatabaseRequest is the body of /api/archive_database.
+type archiveDatabaseRequest struct {
+	DB string `json:"db" doc:"Registered database; the memory database when empty"`
+}
+
+// snapshotResponse answers /api/archive_database.
+type snapshotResponse struct {
+	Status   string            `json:"status" schema:"enum=archived"`
+	Snapshot *archive.Snapshot `json:"snapshot"`
+}
+
+// handleAPIArchiveDatabase writes a compressed, checksummed snapshot of a
+// database. The database itself is left in place.
+func (app *SovereignApp) handleAPIArchiveDatabase(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req archiveDatabaseRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if err := app.refuseSealedCopy(req.DB, "a snapshot"); err != nil {
+		return nil, err
+	}
+	if req.DB == "" {
+		req.DB = app.Databases.PrimaryName()
+	}
+	db, err := app.Databases.Get(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	path, _ := app.Databases.Path(req.DB)
+	snap, err := archive.CreateSnapshot(r.Context(), db, req.DB, path, app.snapshotDir())
+	if err != nil {
+		return nil, err
+	}
+	app.publish("archive", "info", fmt.Sprintf("Archived database %s to %s", req.DB, snap.File), snap)
+	return snapshotResponse{Status: "archived", Snapshot: snap}, nil
+}
+
+// archivesParams holds the parameters of GET /api/archives.
+type archivesParams struct {
+	DB string `query:"db" doc:"Registered database; the memory database when empty"`
+}
+
+// archivesResponse lists the archive of a database and all snapshots.
+type archivesResponse struct {
+	Database    string             `json:"database"`
+	ArchivePath string             `json:"archive_path"`
+	Batches     []archive.Batch    `json:"batches"`
+	Snapshots   []archive.Snapshot `json:"snapshots"`
+}
+
+// handleAPIArchives lists the archive batches of a database and the
+// database snapshots.
+func (app *SovereignApp) handleAPIArchives(w http.ResponseWriter, r *http.Request) (any, error) {
+	a, err := app.archiver(r.Context(), r.URL.Query().Get("db"))
+	if err != nil {
+		return nil, err
+	}
+	batches, err := a.Batches(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := archive.ListSnapshots(app.snapshotDir())
+	if err != nil {
+		return nil, err
+	}
+	return archivesResponse{
+		Database:    a.Name,
+		ArchivePath: a.ArchivePath,
+		Batches:     batches,
+		Snapshots:   snaps,
+	}, nil
+}
+
+// restoreArchiveRequest is the body of /api/restore_archive.
+type restoreArchiveRequest struct {
+	DB      string `json:"db" doc:"Registered database; the memory database when empty"`
+	BatchID int64  `json:"batch_id" schema:"required,min=1"`
+}
+
+// handleAPIRestoreArchive moves an archived batch back into its database.
+func (app *SovereignApp) handleAPIRestoreArchive(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req restoreArchiveRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	a, err := app.archiver(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := a.Restore(r.Context(), req.BatchID)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("archive", "info", fmt.Sprintf("Restored %d row(s) of %s.%s (batch %d)", batch.RowCount, a.Name, batch.Table, batch.ID), batch)
+	return archiveBatchResponse{Status: "restored", Batch: batch}, nil
+}
+
+// restoreDatabaseRequest is the body of /api/restore_database.
+type restoreDatabaseRequest struct {
+	File string `json:"file" doc:"Snapshot file name, as listed by /api/archives" schema:"required,minLength=1"`
+	Name string `json:"name" doc:"Name of the new database" schema:"required,minLength=1"`
+}
+
+// snapshotRestoredResponse answers /api/restore_database.
+type snapshotRestoredResponse struct {
+	Status   string            `json:"status" schema:"enum=restored"`
+	Snapshot *archive.Snapshot `json:"snapshot"`
+	Database dbregistry.Info   `json:"database"`
+}
+
+// handleAPIRestoreDatabase verifies a snapshot and restores it as a new
+// registered database. Existing databases are never overwritten.
+func (app *SovereignApp) handleAPIRestoreDatabase(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req restoreDatabaseRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	dest, err := app.Databases.Path(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := archive.RestoreSnapshot(app.snapshotDir(), req.File, dest)
+	if err != nil {
+		return nil, err
+	}
+	info, err := app.Databases.Stat(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("archive", "info", fmt.Sprintf("Restored snapshot %s as database %s", snap.File, req.Name), info)
+	return snapshotRestoredResponse{Status: "restored", Snapshot: snap, Database: info}, nil
+}
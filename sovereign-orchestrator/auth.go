@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/auth"
+	"sovereign-orchestrator/pkg/command"
+)
+
+const (
+	authDBFileName = "auth.db"
+	sessionCookie  = "sovereign_session"
+	// csrfCookie is readable by the GUI scripts, which echo it in the
+	// auth.CSRFHeader header on every request that changes something.
+	csrfCookie = "sovereign_csrf"
+)
+
+// route is one entry of the API route table.
+type route struct {
+	pattern string // "METHOD /path"; GET also matches HEAD
+	scope   string // Needed by the caller; empty only needs a signed-in user
+	handler http.HandlerFunc
+	doc     endpoint // How the route appears in the API document
+}
+
+type principalKey struct{}
+
+// principal returns the authenticated user of a request, or nil when
+// authentication is disabled.
+func principal(r *http.Request) *auth.Principal {
+	p, _ := r.Context().Value(principalKey{}).(*auth.Principal)
+	return p
+}
+
+// authDBPath is where users, sessions and tokens are kept. They live apart
+// from the memory database, so restoring or merging memory never changes
+// who can sign in.
+func (app *SovereignApp) authDBPath() string {
+	if app.Config.Auth.DBPath != "" {
+		return app.Config.Auth.DBPath
+	}
+	return filepath.Join(app.AppDir, authDBFileName)
+}
+
+// openAuth opens the auth database. It is a no-op if it is already open.
+func (app *SovereignApp) openAuth() error {
+	if app.Auth != nil {
+		return nil
+	}
+	store, err := auth.Open(app.authDBPath())
+	if err != nil {
+		return err
+	}
+	app.Auth = store
+	return nil
+}
+
+// startAuth opens the auth database for the server and drops expired
+// sessions and tokens.
+func (app *SovereignApp) startAuth() error {
+	if !app.Config.Auth.Enabled {
+		log.Println("Warning: authentication is disabled; every endpoint is open to anyone who can reach the server")
+		return nil
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	if n, err := app.Auth.Purge(app.ctx); err != nil {
+		log.Printf("Warning: failed to purge expired sessions: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired session(s) and token(s)", n)
+	}
+	users, err := app.Auth.CountUsers(app.ctx)
+	if err != nil {
+		return err
+	}
+	if users == 0 {
+		log.Printf("No users yet; every API request will be refused. Create one with: %s auth user add <name>", appName)
+	}
+	return nil
+}
+
+// authenticate finds the principal of a request from its bearer token or
+// session cookie. A trusted Unix socket client that sends neither is
+// signed in as its local user.
+func (app *SovereignApp) authenticate(r *http.Request) (*auth.Principal, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, auth.ErrUnauthenticated
+		}
+		return app.Auth.Token(r.Context(), strings.TrimSpace(token))
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		if p := trustedPeer(r); p != nil {
+			return peerPrincipal(p), nil
+		}
+		return nil, auth.ErrUnauthenticated
+	}
+	return app.Auth.Session(r.Context(), c.Value)
+}
+
+// protect wraps a route in authentication, scope and CSRF checks. Only
+// requests that pass count as user activity for presence detection.
+func (app *SovereignApp) protect(rt route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Auth == nil {
+			app.touchPresence(r)
+			rt.handler(w, r)
+			return
+		}
+		p, err := app.authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrUnauthenticated) {
+				log.Printf("Error: failed to authenticate %s %s: %v", r.Method, r.URL.Path, err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+appName+`"`)
+			api.WriteError(w, api.Errorf(api.CodeUnauthenticated, "Authentication required"))
+			return
+		}
+		if info := requestInfoFrom(r); info != nil {
+			info.user = p.User
+		}
+		if rt.scope != "" && !p.Has(rt.scope) {
+			api.WriteError(w, api.Errorf(api.CodeForbidden, "Missing scope %s", rt.scope).WithDetails(map[string]string{"scope": rt.scope}))
+			return
+		}
+		if !auth.CheckCSRF(r, p) {
+			api.WriteError(w, api.Errorf(api.CodeForbidden, "Missing or invalid CSRF token"))
+			return
+		}
+		app.touchPresence(r)
+		rt.handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+func setSessionCookies(w http.ResponseWriter, r *http.Request, secret, csrf string, maxAge int) {
+	secure := r.TLS != nil
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: secret, Path: "/", MaxAge: maxAge,
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteStrictMode})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: csrf, Path: "/", MaxAge: maxAge,
+		Secure: secure, SameSite: http.SameSiteStrictMode})
+}
+
+// loginRequest is the body of /auth/login.
+type loginRequest struct {
+	Username string `json:"username" schema:"required,minLength=1"`
+	Password string `json:"password" schema:"required"`
+}
+
+// loginResponse answers /auth/login. The session secret itself is only
+// sent as a cookie.
+type loginResponse struct {
+	Status  string        `json:"status" schema:"enum=signed_in"`
+	Session *auth.Session `json:"session"`
+}
+
+// sessionResponse answers /auth/session.
+type sessionResponse struct {
+	Auth      bool            `json:"auth"`
+	Principal *auth.Principal `json:"principal,omitempty"`
+	CSRFToken string          `json:"csrf_token,omitempty" doc:"Echo in the X-CSRF-Token header of changes made with the session cookie"`
+}
+
+// handleAuthLogin signs a user in and sets the session and CSRF cookies.
+//
+//	POST /auth/login {"username": "", "password": ""}
+func (app *SovereignApp) handleAuthLogin(w http.ResponseWriter, r *http.Request) (any, error) {
+	if app.Auth == nil {
+		return nil, api.Errorf(api.CodeNotFound, "Authentication is disabled")
+	}
+	var req loginRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(app.Config.Auth.SessionTTL)
+	secret, sess, err := app.Auth.Login(r.Context(), strings.ToLower(strings.TrimSpace(req.Username)), req.Password, ttl, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		if errors.Is(err, auth.ErrCredentials) {
+			log.Printf("Failed sign-in for %q from %s", req.Username, r.RemoteAddr)
+		}
+		return nil, err
+	}
+	log.Printf("User %s signed in from %s", sess.User, r.RemoteAddr)
+	setSessionCookies(w, r, secret, sess.CSRFToken, int(ttl.Seconds()))
+	return loginResponse{Status: "signed_in", Session: sess}, nil
+}
+
+// handleAuthLogout ends the current session and clears its cookies.
+func (app *SovereignApp) handleAuthLogout(w http.ResponseWriter, r *http.Request) (any, error) {
+	if app.Auth == nil {
+		return nil, api.Errorf(api.CodeNotFound, "Authentication is disabled")
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := app.Auth.Logout(r.Context(), c.Value); err != nil {
+			return nil, err
+		}
+	}
+	setSessionCookies(w, r, "", "", -1)
+	return statusResponse{Status: "signed_out"}, nil
+}
+
+// handleAuthSession describes the signed-in user.
+func (app *SovereignApp) handleAuthSession(w http.ResponseWriter, r *http.Request) (any, error) {
+	p := principal(r)
+	if p == nil {
+		return sessionResponse{Auth: false}, nil
+	}
+	return sessionResponse{Auth: true, Principal: p, CSRFToken: p.CSRF}, nil
+}
+
+// authCommands builds the "auth" command tree.
+func (app *SovereignApp) authCommands() *command.Registry {
+	reg := command.NewRegistry("auth")
+	users := command.NewRegistry("user")
+	users.Register(&command.Subcommand{
+		Name:    "add",
+		Usage:   "add [flags] <name>",
+		Summary: "Create a user; the password is prompted for or read from stdin",
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("scopes", "all", "Comma-separated scopes: "+strings.Join(auth.AllScopes, ", ")+" or all")
+		},
+		Run: app.cmdAuthUserAdd,
+	})
+	users.Register(&command.Subcommand{
+		Name:    "list",
+		Summary: "List users",
+		Run:     app.cmdAuthUserList,
+	})
+	users.Register(&command.Subcommand{
+		Name:    "passwd",
+		Usage:   "passwd <name>",
+		Summary: "Change a user's password and sign out their sessions",
+		Run:     app.cmdAuthUserPasswd,
+	})
+	users.Register(&command.Subcommand{
+		Name:    "scopes",
+		Usage:   "scopes <name> <scopes>",
+		Summary: "Replace a user's scopes",
+		Run:     app.cmdAuthUserScopes,
+	})
+	users.Register(&command.Subcommand{
+		Name:    "delete",
+		Usage:   "delete <name>",
+		Summary: "Delete a user with their sessions and tokens",
+		Run:     app.cmdAuthUserDelete,
+	})
+	reg.Register(&command.Subcommand{
+		Name:    "user",
+		Usage:   "user <add|list|passwd|scopes|delete>",
+		Summary: "Manage local user accounts",
+		Sub:     users,
+	})
+
+	tokens := command.NewRegistry("token")
+	tokens.Register(&command.Subcommand{
+		Name:    "create",
+		Usage:   "create [flags] <user>",
+		Summary: "Issue a bearer API token",
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("name", "", "What the token is for")
+			fs.String("scopes", auth.ScopeDBRead, "Comma-separated scopes, at most the user's")
+			fs.Duration("ttl", 0, "Lifetime of the token (0 never expires)")
+		},
+		Run: app.cmdAuthTokenCreate,
+	})
+	tokens.Register(&command.Subcommand{
+		Name:    "list",
+		Usage:   "list [flags]",
+		Summary: "List API tokens",
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("user", "", "Only tokens of this user")
+		},
+		Run: app.cmdAuthTokenList,
+	})
+	tokens.Register(&command.Subcommand{
+		Name:    "revoke",
+		Usage:   "revoke <id>",
+		Summary: "Revoke an API token",
+		Run:     app.cmdAuthTokenRevoke,
+	})
+	reg.Register(&command.Subcommand{
+		Name:    "token",
+		Usage:   "token <create|list|revoke>",
+		Summary: "Manage bearer API tokens",
+		Sub:     tokens,
+	})
+	return reg
+}
+
+// readPassword reads a password from the terminal without echoing it, asking
+// twice when confirm is set. Without a terminal it reads one line of stdin.
+func readPassword(prompt string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return "", err
+	}
+	defer unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+
+	in := bufio.NewReader(os.Stdin)
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		line, err := in.ReadString('\n')
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	password, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := read("Repeat password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+func oneArg(cmd *command.Command, what string) (string, error) {
+	if len(cmd.Args) != 1 {
+		return "", fmt.Errorf("%s: give the %s", cmd.Name, what)
+	}
+	return cmd.Args[0], nil
+}
+
+func (app *SovereignApp) cmdAuthUserAdd(cmd *command.Command) error {
+	name, err := oneArg(cmd, "user name")
+	if err != nil {
+		return err
+	}
+	scopes, err := auth.ParseScopes(cmd.Flags["scopes"])
+	if err != nil {
+		return err
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	password, err := readPassword("Password for "+name+": ", true)
+	if err != nil {
+		return err
+	}
+	u, err := app.Auth.CreateUser(app.ctx, name, password, scopes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created user %s with scopes %s\n", u.Username, strings.Join(u.Scopes, ", "))
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthUserList(cmd *command.Command) error {
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	users, err := app.Auth.Users(app.ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		fmt.Printf("No users in %s.\n", app.authDBPath())
+		return nil
+	}
+	for _, u := range users {
+		last := "never"
+		if u.LastLoginAt != nil {
+			last = u.LastLoginAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %-20s %-45s last sign-in %s\n", u.Username, strings.Join(u.Scopes, ","), last)
+	}
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthUserPasswd(cmd *command.Command) error {
+	name, err := oneArg(cmd, "user name")
+	if err != nil {
+		return err
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	password, err := readPassword("New password for "+name+": ", true)
+	if err != nil {
+		return err
+	}
+	if err := app.Auth.SetPassword(app.ctx, name, password); err != nil {
+		return err
+	}
+	fmt.Printf("Changed the password of %s and signed out their sessions\n", name)
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthUserScopes(cmd *command.Command) error {
+	if len(cmd.Args) != 2 {
+		return errors.New("scopes: give the user name and the comma-separated scopes")
+	}
+	scopes, err := auth.ParseScopes(cmd.Args[1])
+	if err != nil {
+		return err
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	if err := app.Auth.SetScopes(app.ctx, cmd.Args[0], scopes); err != nil {
+		return err
+	}
+	fmt.Printf("Scopes of %s: %s\n", cmd.Args[0], strings.Join(scopes, ", "))
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthUserDelete(cmd *command.Command) error {
+	name, err := oneArg(cmd, "user name")
+	if err != nil {
+		return err
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	if err := app.Auth.DeleteUser(app.ctx, name); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted user %s\n", name)
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthTokenCreate(cmd *command.Command) error {
+	user, err := oneArg(cmd, "user the token acts as")
+	if err != nil {
+		return err
+	}
+	scopes, err := auth.ParseScopes(cmd.Flags["scopes"])
+	if err != nil {
+		return err
+	}
+	ttl, err := cmd.Duration("ttl")
+	if err != nil {
+		return err
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	secret, tok, err := app.Auth.CreateToken(app.ctx, user, cmd.Flags["name"], scopes, ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created token %d %q for %s with scopes %s. It is shown only once:\n", tok.ID, tok.Name, tok.User, strings.Join(tok.Scopes, ", "))
+	fmt.Println(secret)
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthTokenList(cmd *command.Command) error {
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	tokens, err := app.Auth.Tokens(app.ctx, cmd.Flags["user"])
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No tokens.")
+		return nil
+	}
+	for _, t := range tokens {
+		expires := "never"
+		if t.ExpiresAt != nil {
+			expires = t.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %4d  %-12s %-24s …%s  %-30s expires %s\n", t.ID, t.User, strconv.Quote(t.Name), t.Hint, strings.Join(t.Scopes, ","), expires)
+	}
+	return nil
+}
+
+func (app *SovereignApp) cmdAuthTokenRevoke(cmd *command.Command) error {
+	arg, err := oneArg(cmd, "token id (see auth token list)")
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return fmt.Errorf("revoke: invalid token id %q", arg)
+	}
+	if err := app.openAuth(); err != nil {
+		return err
+	}
+	if err := app.Auth.RevokeToken(app.ctx, id); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked token %d\n", id)
+	return nil
+}
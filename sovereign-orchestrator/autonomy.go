@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/presence"
+	"sovereign-orchestrator/pkg/schedule"
+)
+
+// autonomyConfig is the live Ghost Mode configuration, persisted in autonomy_config.
+type autonomyConfig struct {
+	Enabled          bool    `json:"enabled"`
+	BaseInterval     int     `json:"base_interval" doc:"Seconds between cycles" schema:"min=1,max=86400"`
+	MaxLoadThreshold float64 `json:"max_load_threshold" doc:"CPU/RAM percentage above which the loop backs off" schema:"min=0,max=100"`
+	SaveInterval     int     `json:"save_interval" doc:"Seconds between periodic memory saves" schema:"min=60,max=604800"`
+	Jitter           float64 `json:"jitter" doc:"Random spread of wakeups" schema:"min=0,max=0.5"`
+	MaxBackoff       int     `json:"max_backoff" doc:"Seconds; cap for the load backoff, at least base_interval" schema:"max=86400"`
+	QuietHoursStart  string  `json:"quiet_hours_start" doc:"HH:MM local time; empty disables quiet hours"`
+	QuietHoursEnd    string  `json:"quiet_hours_end" doc:"HH:MM local time"`
+}
+
+// defaultAutonomyConfig is used until a configuration has been saved.
+func defaultAutonomyConfig() autonomyConfig {
+	return autonomyConfig{
+		Enabled:          true,
+		BaseInterval:     int(GHOST_MODE_SLEEP_INTERVAL / time.Second),
+		MaxLoadThreshold: 80,
+		SaveInterval:     int(SAVE_INTERVAL / time.Second),
+		Jitter:           0.1,
+		MaxBackoff:       int(30 * time.Minute / time.Second),
+	}
+}
+
+// validate rejects settings the loop cannot run with.
+func (c autonomyConfig) validate() error {
+	if c.BaseInterval < 1 || c.BaseInterval > 86400 {
+		return fmt.Errorf("base_interval must be between 1 and 86400 seconds, got %d", c.BaseInterval)
+	}
+	if c.MaxLoadThreshold <= 0 || c.MaxLoadThreshold > 100 {
+		return fmt.Errorf("max_load_threshold must be a percentage in (0, 100], got %g", c.MaxLoadThreshold)
+	}
+	if c.SaveInterval < 60 || c.SaveInterval > 7*86400 {
+		return fmt.Errorf("save_interval must be between 60 and 604800 seconds, got %d", c.SaveInterval)
+	}
+	if c.Jitter < 0 || c.Jitter > 0.5 {
+		return fmt.Errorf("jitter must be between 0 and 0.5, got %g", c.Jitter)
+	}
+	if c.MaxBackoff < c.BaseInterval || c.MaxBackoff > 86400 {
+		return fmt.Errorf("max_backoff must be between base_interval and 86400 seconds, got %d", c.MaxBackoff)
+	}
+	if _, err := schedule.ParseQuietHours(c.QuietHoursStart, c.QuietHoursEnd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// policy converts the configuration into a scheduling policy. cfg must be valid.
+func (c autonomyConfig) policy() schedule.Policy {
+	quiet, _ := schedule.ParseQuietHours(c.QuietHoursStart, c.QuietHoursEnd)
+	return schedule.Policy{
+		Base:       time.Duration(c.BaseInterval) * time.Second,
+		MaxBackoff: time.Duration(c.MaxBackoff) * time.Second,
+		Jitter:     c.Jitter,
+		Quiet:      quiet,
+	}
+}
+
+// ghostState is shared between the Ghost Mode loop and the HTTP handlers.
+// The loop owns the runtime fields; handlers only read them.
+type ghostState struct {
+	mu       sync.Mutex
+	config   autonomyConfig
+	reload   chan struct{} // Wakes the loop when config changes
+	presence chan struct{} // Wakes the loop when the user arrives or leaves
+
+	status         string // "starting", "active", "paused", "disabled" or "stopped"
+	nextWake       time.Time
+	interval       time.Duration
+	wakeReason     string
+	backoffLevel   int
+	lastLoad       float64
+	lastRAM        float64
+	lastSec        string
+	lastContext    string
+	lastIntent     string
+	lastDecision   string
+	lastDecisionAt time.Time
+}
+
+func newGhostState(cfg autonomyConfig) *ghostState {
+	return &ghostState{
+		config:   cfg,
+		reload:   make(chan struct{}, 1),
+		presence: make(chan struct{}, 1),
+		status:   "starting",
+	}
+}
+
+// Config returns the current configuration.
+func (g *ghostState) Config() autonomyConfig {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.config
+}
+
+// setConfig replaces the configuration and wakes the loop so it takes effect
+// immediately rather than after the current wait.
+func (g *ghostState) setConfig(cfg autonomyConfig) {
+	g.mu.Lock()
+	g.config = cfg
+	g.mu.Unlock()
+	wake(g.reload)
+}
+
+// presenceChanged wakes the loop so it pauses or resumes without waiting
+// out its current sleep.
+func (g *ghostState) presenceChanged() {
+	wake(g.presence)
+}
+
+// wake signals a buffered channel without blocking; a pending signal is enough.
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (g *ghostState) setStatus(status string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status = status
+}
+
+// scheduled records that the loop is sleeping for delay. A zero delay means
+// the loop is disabled and waits for a configuration change.
+func (g *ghostState) scheduled(delay time.Duration, reason string, level int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.interval = delay
+	g.wakeReason = reason
+	g.backoffLevel = level
+	if delay <= 0 {
+		g.nextWake = time.Time{}
+		g.status = "disabled"
+		return
+	}
+	g.nextWake = time.Now().Add(delay)
+	if g.status != "paused" {
+		g.status = "active"
+	}
+}
+
+// recordCycle stores the outcome of one autonomous cycle.
+func (g *ghostState) recordCycle(c ghostCycle) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastLoad = c.CPU
+	g.lastRAM = c.RAM
+	g.lastSec = c.Sec
+	g.lastContext = c.Sense
+	g.lastIntent = c.Intent
+	g.lastDecision = c.Act
+	g.lastDecisionAt = c.Start
+}
+
+// autonomyStatus is the body of /autonomy/status.
+type autonomyStatus struct {
+	Status         string         `json:"status"`
+	NextWake       float64        `json:"next_wake"` // Unix seconds
+	Interval       int            `json:"interval"`  // Seconds until the planned wakeup
+	WakeReason     string         `json:"wake_reason"`
+	BackoffLevel   int            `json:"backoff_level"`
+	LastLoad       float64        `json:"last_load"` // CPU percentage
+	LastRAM        float64        `json:"last_ram"`
+	LastSec        string         `json:"last_sec"`
+	LastContext    string         `json:"last_context"`
+	LastIntent     string         `json:"last_intent"`
+	LastDecision   string         `json:"last_decision"`
+	LastDecisionTS float64        `json:"last_decision_ts"` // Unix seconds; 0 before the first cycle
+	Attached       bool           `json:"attached"`
+	Reason         string         `json:"reason"`
+	Presence       presence.State `json:"presence"`
+	Config         autonomyConfig `json:"config"`
+}
+
+func (g *ghostState) snapshot() autonomyStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := autonomyStatus{
+		Status:       g.status,
+		Interval:     int(g.interval / time.Second),
+		WakeReason:   g.wakeReason,
+		BackoffLevel: g.backoffLevel,
+		LastLoad:     g.lastLoad,
+		LastRAM:      g.lastRAM,
+		LastSec:      g.lastSec,
+		LastContext:  g.lastContext,
+		LastIntent:   g.lastIntent,
+		LastDecision: g.lastDecision,
+		Config:       g.config,
+	}
+	if !g.nextWake.IsZero() {
+		st.NextWake = unixSeconds(g.nextWake)
+	}
+	if !g.lastDecisionAt.IsZero() {
+		st.LastDecisionTS = unixSeconds(g.lastDecisionAt)
+	}
+	return st
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// loadAutonomyConfig reads the saved configuration, or the defaults if none was saved.
+func (app *SovereignApp) loadAutonomyConfig() (autonomyConfig, error) {
+	cfg := defaultAutonomyConfig()
+	err := app.DB.QueryRow(`SELECT enabled, base_interval, max_load_threshold, save_interval,
+		jitter, max_backoff, quiet_hours_start, quiet_hours_end FROM autonomy_config WHERE id = 1`).
+		Scan(&cfg.Enabled, &cfg.BaseInterval, &cfg.MaxLoadThreshold, &cfg.SaveInterval,
+			&cfg.Jitter, &cfg.MaxBackoff, &cfg.QuietHoursStart, &cfg.QuietHoursEnd)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultAutonomyConfig(), nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load autonomy config: %w", err)
+	}
+	return cfg, nil
+}
+
+// saveAutonomyConfig persists cfg as the single autonomy_config row.
+func (app *SovereignApp) saveAutonomyConfig(cfg autonomyConfig) error {
+	_, err := app.DB.Exec(`INSERT INTO autonomy_config (id, enabled, base_interval, max_load_threshold, save_interval,
+			jitter, max_backoff, quiet_hours_start, quiet_hours_end)
+		VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET enabled = excluded.enabled, base_interval = excluded.base_interval,
+			max_load_threshold = excluded.max_load_threshold, save_interval = excluded.save_interval,
+			jitter = excluded.jitter, max_backoff = excluded.max_backoff,
+			quiet_hours_start = excluded.quiet_hours_start, quiet_hours_end = excluded.quiet_hours_end,
+			updated_at = CURRENT_TIMESTAMP`,
+		cfg.Enabled, cfg.BaseInterval, cfg.MaxLoadThreshold, cfg.SaveInterval,
+		cfg.Jitter, cfg.MaxBackoff, cfg.QuietHoursStart, cfg.QuietHoursEnd)
+	if err != nil {
+		return fmt.Errorf("failed to save autonomy config: %w", err)
+	}
+	return nil
+}
+
+// sampleLoad returns the CPU utilisation since the previous sample and the
+// current RAM usage, both in percent. Unreadable values are reported as 0.
+func sampleLoad() (cpuPercent, ramPercent float64) {
+	if percents, err := cpu.Percent(0, false); err == nil && len(percents) > 0 {
+		cpuPercent = percents[0]
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		ramPercent = vm.UsedPercent
+	}
+	return cpuPercent, ramPercent
+}
+
+// securityLevel describes the privileges Ghost Mode is acting with.
+func securityLevel() string {
+	if os.Geteuid() == 0 {
+		return "ROOT"
+	}
+	return "USER"
+}
+
+// handleAutonomyStatus reports the Ghost Mode loop's state and the presence verdict behind it.
+func (app *SovereignApp) handleAutonomyStatus(w http.ResponseWriter, r *http.Request) (any, error) {
+	status := app.ghost.snapshot()
+	status.Presence = app.Presence.State()
+	status.Attached = status.Presence.Attached
+	status.Reason = status.Presence.Reason
+	return status, nil
+}
+
+// autonomyConfigResponse answers POST /autonomy/config.
+type autonomyConfigResponse struct {
+	Status string         `json:"status" schema:"enum=updated"`
+	Config autonomyConfig `json:"config"`
+}
+
+// handleAutonomyConfig returns the Ghost Mode configuration (GET) or updates it (POST).
+// POST accepts any subset of the fields; omitted fields keep their current values.
+func (app *SovereignApp) handleAutonomyConfig(w http.ResponseWriter, r *http.Request) (any, error) {
+	if r.Method == "GET" {
+		return app.ghost.Config(), nil
+	}
+
+	cfg := app.ghost.Config()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, api.DecodeError(err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, api.Errorf(api.CodeInvalidRequest, "%s", err)
+	}
+	if err := app.saveAutonomyConfig(cfg); err != nil {
+		return nil, err
+	}
+	app.ghost.setConfig(cfg)
+	app.publish("autonomy.config", "info", "Autonomy configuration updated", cfg)
+	return autonomyConfigResponse{Status: "updated", Config: cfg}, nil
+}
+
+// ghostCycle is one pass of the sense/intent/act loop, stored in autonomy_cycles.
+type ghostCycle struct {
+	Start   time.Time `json:"start"`
+	Outcome string    `json:"outcome"` // "acted", "backoff" or "quiet"
+	Sense   string    `json:"sense"`
+	Intent  string    `json:"intent"`
+	Act     string    `json:"act"`
+	CPU     float64   `json:"cpu_load"`
+	RAM     float64   `json:"ram_load"`
+	Sec     string    `json:"security_level"`
+}
+
+// planGhostWake decides how long the loop sleeps before its next cycle.
+// A zero delay means autonomy is disabled.
+func planGhostWake(cfg autonomyConfig, level int, attached bool) (time.Duration, string) {
+	switch {
+	case !cfg.Enabled:
+		return 0, "disabled"
+	case attached:
+		// Presence changes wake the loop, so this wait is only a fallback.
+		return 2 * time.Duration(cfg.BaseInterval) * time.Second, "user attached"
+	default:
+		return cfg.policy().Next(time.Now(), level)
+	}
+}
+
+// ghostWake is what ended a ghostWait.
+type ghostWake int
+
+const (
+	wokeTimer    ghostWake = iota // The planned delay elapsed
+	wokeReload                    // The configuration changed
+	wokePresence                  // The user arrived or left
+)
+
+// ghostWait sleeps for delay, or indefinitely when delay is zero, until the
+// configuration or presence changes. It reports what woke it, and returns
+// the context error once the app is shutting down.
+func (app *SovereignApp) ghostWait(delay time.Duration) (ghostWake, error) {
+	var timeout <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-app.ctx.Done():
+		return wokeTimer, app.ctx.Err()
+	case <-app.ghost.reload:
+		return wokeReload, nil
+	case <-app.ghost.presence:
+		return wokePresence, nil
+	case <-timeout:
+		return wokeTimer, nil
+	}
+}
+
+// runGhostCycle senses the machine and acts unless it is overloaded or inside
+// quiet hours. level is the load backoff level and is updated in place.
+func (app *SovereignApp) runGhostCycle(cfg autonomyConfig, level *int) ghostCycle {
+	c := ghostCycle{Start: time.Now(), Sec: securityLevel()}
+	c.CPU, c.RAM = sampleLoad()
+	load := c.CPU
+	if c.RAM > load {
+		load = c.RAM
+	}
+
+	policy := cfg.policy()
+	switch {
+	case policy.Quiet.Contains(c.Start):
+		// Only reachable when the clock jumped (suspend/resume) into the window.
+		c.Outcome = "quiet"
+		c.Sense = fmt.Sprintf("Quiet hours (%s-%s)", cfg.QuietHoursStart, cfg.QuietHoursEnd)
+		c.Intent = "Stay asleep until quiet hours end"
+		c.Act = "None"
+	case load > cfg.MaxLoadThreshold:
+		*level++
+		c.Outcome = "backoff"
+		c.Sense = fmt.Sprintf("CPU %.1f%%, RAM %.1f%% exceeds the %.0f%% threshold", c.CPU, c.RAM, cfg.MaxLoadThreshold)
+		c.Intent = "Defer autonomous work until the machine is less busy"
+		c.Act = fmt.Sprintf("Back off (level %d)", *level)
+	default:
+		*level = 0
+		c.Outcome = "acted"
+		c.Sense = app.getSystemContext()
+		c.Intent = "Routine self-diagnosis"
+		log.Println("Ghost Mode: Performing autonomous actions... (Placeholder)")
+		app.diagnoseAndCorrect()
+		c.Act = "Self-diagnosis and correction"
+	}
+
+	app.ghost.recordCycle(c)
+	app.publish("ghost.cycle", "context", c.Sense, c)
+	if c.Outcome == "acted" {
+		app.publish("ghost.decision", "decision", c.Act, c)
+	}
+	return c
+}
+
+// recordGhostCycle appends a cycle and the delay chosen after it to autonomy_cycles.
+func (app *SovereignApp) recordGhostCycle(c ghostCycle, level int, nextDelay time.Duration) {
+	app.writeMu.RLock()
+	defer app.writeMu.RUnlock()
+	_, err := app.DB.Exec(`INSERT INTO autonomy_cycles
+		(outcome, sense, intent, act, cpu_load, ram_load, backoff_level, next_delay_ms, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.Outcome, c.Sense, c.Intent, c.Act, c.CPU, c.RAM, level, nextDelay.Milliseconds(), time.Since(c.Start).Milliseconds())
+	if err != nil {
+		log.Printf("Failed to record autonomy cycle: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/backup"
+	"sovereign-orchestrator/pkg/command"
+	"sovereign-orchestrator/pkg/dbcopy"
+)
+
+const backupDirName = "backups"
+
+// backupDir is where backups of the memory database are written.
+func (app *SovereignApp) backupDir() string {
+	if app.Config.Backups.Dir != "" {
+		return app.Config.Backups.Dir
+	}
+	return filepath.Join(app.AppDir, backupDirName)
+}
+
+// backupStore returns the backup store of the memory database. Backups of
+// an encrypted database are sealed with its key and staged next to its
+// unlocked copy, so no plaintext reaches the backup directory.
+func (app *SovereignApp) backupStore() *backup.Store {
+	cfg := app.Config.Backups
+	s := &backup.Store{
+		Dir:       app.backupDir(),
+		Name:      primaryDBName,
+		Retention: backup.Retention{Hourly: cfg.Hourly, Daily: cfg.Daily, Weekly: cfg.Weekly},
+	}
+	if app.sealed != nil {
+		s.Key = app.sealed.key
+		s.TempDir = filepath.Dir(app.sealed.work)
+	}
+	return s
+}
+
+// runBackup backs up the memory database and, for scheduled backups,
+// prunes the generations that fell out of retention.
+func (app *SovereignApp) runBackup(ctx context.Context, manual bool) (*backup.Backup, error) {
+	app.backupMu.Lock()
+	defer app.backupMu.Unlock()
+	store := app.backupStore()
+	b, err := store.Create(ctx, app.DB, manual)
+	if err != nil {
+		return nil, err
+	}
+	msg := fmt.Sprintf("Backed up %s to %s (%d bytes)", b.Database, b.File, b.Size)
+	if !manual {
+		removed, err := store.Prune()
+		if err != nil {
+			log.Printf("Warning: failed to prune backups: %v", err)
+		}
+		if len(removed) > 0 {
+			msg += fmt.Sprintf("; pruned %d old backup(s)", len(removed))
+		}
+	}
+	app.publish("backups", "info", msg, b)
+	return b, nil
+}
+
+// startBackups backs up the memory database every configured interval.
+// The first backup is due one interval after the newest scheduled one, so
+// restarts do not reset the schedule.
+func (app *SovereignApp) startBackups() {
+	interval := time.Duration(app.Config.Backups.Interval)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	wait := time.Duration(0)
+	if backups, err := app.backupStore().List(); err == nil {
+		for _, b := range backups {
+			if !b.Manual {
+				wait = time.Until(b.CreatedAt.Add(interval))
+				break
+			}
+		}
+	}
+	log.Printf("Backing up the memory database every %v to %s", interval, app.backupDir())
+	for {
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-app.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := app.runBackup(app.ctx, false); err != nil && app.ctx.Err() == nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			app.publish("backups", "alert", fmt.Sprintf("Scheduled backup failed: %v", err), nil)
+		}
+		wait = interval
+	}
+}
+
+// restoreBackup verifies a backup and copies it over the live memory
+// database with the online backup API, so open connections see the
+// restored content. The current state is backed up first, and migrations
+// bring an older backup up to the current schema. Background writers are
+// paused meanwhile.
+func (app *SovereignApp) restoreBackup(ctx context.Context, file string) (restored, safety *backup.Backup, err error) {
+	app.backupMu.Lock()
+	defer app.backupMu.Unlock()
+	store := app.backupStore()
+	dir := store.TempDir
+	if dir == "" {
+		dir = store.Dir
+	}
+	plain := filepath.Join(dir, ".restore-"+filepath.Base(file)+".db")
+	defer os.Remove(plain)
+	if restored, err = store.Extract(ctx, file, plain); err != nil {
+		return nil, nil, err
+	}
+
+	// Pause the ingester, the embedder and Ghost Mode until the restored
+	// database is migrated: a row they wrote in the meantime would be missing
+	// from the safety backup, or land on top of the restored state with
+	// bookkeeping from the state it replaced.
+	app.writeMu.Lock()
+	defer app.writeMu.Unlock()
+	if safety, err = store.Create(ctx, app.DB, true); err != nil {
+		return nil, nil, fmt.Errorf("failed to back up the current database before restoring: %w", err)
+	}
+
+	src, err := sql.Open("sqlite3", plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	if err := dbcopy.Backup(ctx, app.DB, src, nil); err != nil {
+		return nil, nil, fmt.Errorf("failed to restore %s (the previous state is in %s): %w", file, safety.File, err)
+	}
+	// The backup being restored is the copy of the state before migrating,
+	// and backing up again would wait for app.backupMu, which is held.
+	if err := app.applyMigrations(false); err != nil {
+		return nil, nil, fmt.Errorf("restored %s but failed to migrate it: %w", file, err)
+	}
+	app.embedNotify() // Catch the vectors up with the restored rows
+	app.publish("backups", "info", fmt.Sprintf("Restored %s from %s (previous state saved as %s)", restored.Database, restored.File, safety.File), restored)
+	return restored, safety, nil
+}
+
+// backupResponse answers POST /api/backups.
+type backupResponse struct {
+	Status string         `json:"status" schema:"enum=created"`
+	Backup *backup.Backup `json:"backup"`
+}
+
+// backupsResponse lists the backups of the memory database and the
+// schedule that makes them.
+type backupsResponse struct {
+	Dir       string           `json:"dir"`
+	Scheduled bool             `json:"scheduled"`
+	Interval  Duration         `json:"interval"`
+	Retention backup.Retention `json:"retention"`
+	Encrypted bool             `json:"encrypted"`
+	Backups   []backup.Backup  `json:"backups"`
+}
+
+// restoreBackupRequest is the body of /api/backups/restore.
+type restoreBackupRequest struct {
+	File string `json:"file" doc:"Backup file name, as listed by GET /api/backups" schema:"required,minLength=1"`
+}
+
+// restoreBackupResponse answers /api/backups/restore.
+type restoreBackupResponse struct {
+	Status   string         `json:"status" schema:"enum=restored"`
+	Backup   *backup.Backup `json:"backup"`
+	Previous *backup.Backup `json:"previous" doc:"Backup of the state the restore replaced"`
+}
+
+// handleAPIBackups lists the backups of the memory database (GET) or takes
+// one now (POST).
+func (app *SovereignApp) handleAPIBackups(w http.ResponseWriter, r *http.Request) (any, error) {
+	if r.Method == "POST" {
+		b, err := app.runBackup(r.Context(), true)
+		if err != nil {
+			return nil, err
+		}
+		return backupResponse{Status: "created", Backup: b}, nil
+	}
+	backups, err := app.backupStore().List()
+	if err != nil {
+		return nil, err
+	}
+	cfg := app.Config.Backups
+	return backupsResponse{
+		Dir:       app.backupDir(),
+		Scheduled: cfg.Enabled,
+		Interval:  cfg.Interval,
+		Retention: backup.Retention{Hourly: cfg.Hourly, Daily: cfg.Daily, Weekly: cfg.Weekly},
+		Encrypted: app.sealed != nil,
+		Backups:   backups,
+	}, nil
+}
+
+// handleAPIRestoreBackup restores the memory database from a backup.
+func (app *SovereignApp) handleAPIRestoreBackup(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req restoreBackupRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	restored, safety, err := app.restoreBackup(r.Context(), req.File)
+	if err != nil {
+		return nil, err
+	}
+	return restoreBackupResponse{Status: "restored", Backup: restored, Previous: safety}, nil
+}
+
+// backupCommands adds backup, restore and list-backups to the db command tree.
+func (app *SovereignApp) backupCommands(db *command.Registry) {
+	db.Register(&command.Subcommand{
+		Name:    "backup",
+		Summary: "Back up the memory database now",
+		Run:     app.cmdDBBackup,
+	})
+	db.Register(&command.Subcommand{
+		Name:    "restore",
+		Usage:   "restore <backup file>",
+		Summary: "Restore the memory database from a backup",
+		Run:     app.cmdDBRestore,
+	})
+	db.Register(&command.Subcommand{
+		Name:    "list-backups",
+		Usage:   "list-backups [flags]",
+		Summary: "List backups of the memory database",
+		Flags: func(fs *flag.FlagSet) {
+			fs.Bool("json", false, "Print the backups as JSON")
+		},
+		Run: app.cmdDBListBackups,
+	})
+}
+
+func (app *SovereignApp) cmdDBBackup(cmd *command.Command) error {
+	if err := app.openDB(); err != nil {
+		return err
+	}
+	b, err := app.runBackup(app.ctx, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %s to %s\n", app.DBPath, filepath.Join(app.backupDir(), b.File))
+	fmt.Printf("  %d bytes (%d uncompressed), integrity %s\n", b.Size, b.RawSize, b.Integrity)
+	return nil
+}
+
+func (app *SovereignApp) cmdDBRestore(cmd *command.Command) error {
+	if len(cmd.Args) != 1 {
+		return errors.New("restore: give the backup file name (see db list-backups)")
+	}
+	if err := app.openDB(); err != nil {
+		return err
+	}
+	restored, safety, err := app.restoreBackup(app.ctx, filepath.Base(cmd.Args[0]))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s (taken %s)\n", app.DBPath, restored.File, restored.CreatedAt.Local().Format(time.RFC1123))
+	fmt.Printf("The previous state was backed up to %s\n", safety.File)
+	return nil
+}
+
+func (app *SovereignApp) cmdDBListBackups(cmd *command.Command) error {
+	// Listing reads manifests only, so an encrypted database is not unlocked.
+	backups, err := app.backupStore().List()
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(backups)
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups in %s.\n", app.backupDir())
+		return nil
+	}
+	fmt.Printf("Backups in %s:\n\n", app.backupDir())
+	for _, b := range backups {
+		fmt.Printf("  %-48s %s  %10d bytes  %s\n", b.File, b.CreatedAt.Local().Format("2006-01-02 15:04:05"), b.Size, strings.Join(b.Kept, ","))
+	}
+	return nil
+}
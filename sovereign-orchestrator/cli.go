@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"sovereign-orchestrator/pkg/command"
+)
+
+// exitCodeError carries a wrapped command's exit status back to main.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.code)
+}
+
+// commands builds the subcommand registry for the sovereign binary.
+func (app *SovereignApp) commands() *command.Registry {
+	reg := command.NewRegistry(appName)
+
+	reg.Register(&command.Subcommand{
+		Name:    "bootstrap",
+		Usage:   "bootstrap",
+		Summary: "Create ~/.sovereign, extract the runtime and seed the database",
+		Help: `Prepares a fresh installation: creates the application directory,
+extracts the embedded scripts and sovereign-system.tar.gz into the runtime
+directory and initializes the memory database with the core directives.
+Running it again is safe; existing data is kept.`,
+		Run: app.cmdBootstrap,
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "swrap",
+		Usage:   "swrap [flags] -- <command> [args...]",
+		Summary: "Run a command and record its invocation and output in memory",
+		Help: `Runs the given command with the terminal attached, mirroring its output,
+and records the command line ('input') and its combined stdout/stderr
+('output') into the ch table. The wrapped command's exit status is preserved.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("session", "", "Session id to record under (default: swrap-<timestamp>)")
+			fs.Int("max-output", 64<<10, "Maximum number of output bytes recorded in the database")
+		},
+		Run: app.cmdSwrap,
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "init-guake",
+		Usage:   "init-guake [flags]",
+		Summary: "Configure Guake to open a Sovereign tab on startup",
+		Help: `Writes a Guake startup script to the application directory and registers
+it through gsettings (org.guake.general startup-script), so every Guake
+session opens a tab running the Sovereign orchestrator.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("command", "", "Command to run in the Guake tab (default: this binary)")
+			fs.String("tab-name", "Sovereign", "Name of the Guake tab")
+			fs.Bool("no-gsettings", false, "Only write the startup script, do not change Guake settings")
+		},
+		Run: app.cmdInitGuake,
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "search",
+		Usage:   "search [flags] <query...>",
+		Summary: "Full-text search conversation and knowledge history",
+		Help: `Searches ch, jon, user_context, sovereign, philosophy and technologies
+through their FTS5 indexes and prints the best matches first, with the
+matching terms highlighted. Every query term must match; end a term with *
+for a prefix match, or pass --raw to use FTS5 query syntax directly.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.String("tables", "", "Comma-separated tables to search (default: all)")
+			fs.String("since", "", "Only rows at or after this time (RFC 3339 or YYYY-MM-DD)")
+			fs.String("until", "", "Only rows before this time; a bare date includes that day")
+			fs.String("session", "", "Only ch rows of this session id")
+			fs.Int("limit", 10, "Maximum number of results")
+			fs.Bool("raw", false, "Pass the query to FTS5 unchanged")
+			fs.Bool("json", false, "Print the results as JSON")
+		},
+		Run: app.cmdSearch,
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "runtime",
+		Usage:   "runtime <verify|reextract|path>",
+		Summary: "Inspect or repair the extracted runtime",
+		Sub:     app.runtimeCommands(),
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "auth",
+		Usage:   "auth <user|token> <command> [flags]",
+		Summary: "Manage users and API tokens of the HTTP API",
+		Sub:     app.authCommands(),
+	})
+
+	reg.Register(&command.Subcommand{
+		Name:    "db",
+		Usage:   "db <command> [flags]",
+		Summary: "Database maintenance: migrations, backups and encryption",
+		Sub:     app.dbCommands(),
+	})
+
+	return reg
+}
+
+// cmdBootstrap prepares the application directory, runtime and database.
+func (app *SovereignApp) cmdBootstrap(cmd *command.Command) error {
+	if err := os.MkdirAll(app.AppDir, 0755); err != nil {
+		return fmt.Errorf("failed to create app directory %s: %w", app.AppDir, err)
+	}
+	log.Printf("Bootstrap: app directory %s", app.AppDir)
+
+	if err := app.ensureRuntime(); err != nil {
+		return fmt.Errorf("failed to extract runtime: %w", err)
+	}
+	log.Printf("Bootstrap: runtime ready in %s", app.runtimeDir())
+
+	if err := app.openDB(); err != nil {
+		return err
+	}
+
+	var directives int
+	if err := app.DB.QueryRow("SELECT COUNT(*) FROM prime_directives").Scan(&directives); err != nil {
+		return fmt.Errorf("failed to verify seeded database: %w", err)
+	}
+	log.Printf("Bootstrap: database %s ready (%d prime directives)", app.DBPath, directives)
+	return nil
+}
+
+// cmdSwrap runs a command and records its invocation and output into the ch table.
+func (app *SovereignApp) cmdSwrap(cmd *command.Command) error {
+	if len(cmd.Args) == 0 {
+		return errors.New("swrap: no command given")
+	}
+	maxOutput, err := cmd.Int("max-output")
+	if err != nil {
+		return err
+	}
+	sessionID := cmd.Flags["session"]
+	if sessionID == "" {
+		sessionID = fmt.Sprintf("swrap-%d", time.Now().Unix())
+	}
+
+	if err := app.openDB(); err != nil {
+		return err
+	}
+
+	cwd, _ := os.Getwd()
+	commandLine := shellJoin(cmd.Args)
+	inputMeta, _ := json.Marshal(map[string]interface{}{"source": "swrap", "argv": cmd.Args, "cwd": cwd})
+	if _, err := app.DB.Exec("INSERT INTO ch (session_id, type, content, metadata) VALUES (?, 'input', ?, ?)", sessionID, commandLine, string(inputMeta)); err != nil {
+		return fmt.Errorf("failed to record invocation: %w", err)
+	}
+
+	captured := &limitedBuffer{limit: maxOutput}
+	child := exec.CommandContext(app.ctx, cmd.Args[0], cmd.Args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = io.MultiWriter(os.Stdout, captured)
+	child.Stderr = io.MultiWriter(os.Stderr, captured)
+
+	start := time.Now()
+	runErr := child.Run()
+	duration := time.Since(start)
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case runErr == nil:
+	case errors.As(runErr, &exitErr):
+		exitCode = exitErr.ExitCode()
+	default:
+		// The command could not be started at all; record that as the output.
+		exitCode = 127
+		captured.Write([]byte(runErr.Error()))
+	}
+
+	outputMeta, _ := json.Marshal(map[string]interface{}{
+		"source":      "swrap",
+		"exit_code":   exitCode,
+		"duration_ms": duration.Milliseconds(),
+		"truncated":   captured.truncated,
+	})
+	if _, err := app.DB.Exec("INSERT INTO ch (session_id, type, content, metadata) VALUES (?, 'output', ?, ?)", sessionID, captured.String(), string(outputMeta)); err != nil {
+		return fmt.Errorf("failed to record output: %w", err)
+	}
+
+	if exitCode != 0 {
+		return &exitCodeError{code: exitCode}
+	}
+	return nil
+}
+
+// cmdInitGuake writes a Guake startup script and registers it with gsettings.
+func (app *SovereignApp) cmdInitGuake(cmd *command.Command) error {
+	tabCommand := cmd.Flags["command"]
+	if tabCommand == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to resolve executable path: %w", err)
+		}
+		tabCommand = shellJoin([]string{exe})
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+# Generated by '%s init-guake'. Opens the Sovereign tab when Guake starts.
+guake --new-tab="$HOME" --rename-current-tab=%s --execute-command=%s
+`, appName, shellQuote(cmd.Flags["tab-name"]), shellQuote(tabCommand))
+
+	scriptPath := filepath.Join(app.AppDir, "guake_startup.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", scriptPath, err)
+	}
+	log.Printf("Guake startup script written to %s", scriptPath)
+
+	if cmd.Bool("no-gsettings") {
+		return nil
+	}
+	if _, err := exec.LookPath("gsettings"); err != nil {
+		log.Printf("gsettings not found; set Guake's startup script to %s manually.", scriptPath)
+		return nil
+	}
+	settings := [][]string{
+		{"set", "org.guake.general", "startup-script", scriptPath},
+		{"set", "org.guake.general", "execute-startup-script", "true"},
+	}
+	for _, args := range settings {
+		if out, err := exec.Command("gsettings", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("gsettings %s failed: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+		}
+	}
+	log.Println("Guake configured to open the Sovereign tab on startup.")
+	return nil
+}
+
+// limitedBuffer keeps at most limit bytes and remembers whether anything was dropped.
+// It cuts at a rune boundary so the kept output stays valid UTF-8, and is safe for
+// concurrent writers, as stdout and stderr are copied separately.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return len(p), nil
+	}
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		for room > 0 && !utf8.RuneStart(p[room]) {
+			room--
+		}
+		b.buf.Write(p[:room])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// shellJoin renders argv as a single shell-safe command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s for POSIX shells when it contains special characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,@+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sovereign-orchestrator/pkg/openapi"
+)
+
+const configFileName = "config.json"
+
+// Config is the optional on-disk configuration, read from AppDir/config.json
+// (or the path given with --config). Missing fields keep their defaults.
+type Config struct {
+	LLM        LLMConfig        `json:"llm"`
+	Presence   PresenceConfig   `json:"presence"`
+	Memory     MemoryConfig     `json:"memory"`
+	Ingest     IngestConfig     `json:"ingest"`
+	Databases  DatabasesConfig  `json:"databases"`
+	Embeddings EmbeddingsConfig `json:"embeddings"`
+	Encryption EncryptionConfig `json:"encryption"`
+	Backups    BackupsConfig    `json:"backups"`
+	Auth       AuthConfig       `json:"auth"`
+	Server     ServerConfig     `json:"server"`
+}
+
+// LLMConfig selects and configures the text generation backends.
+type LLMConfig struct {
+	DefaultProvider string                       `json:"default_provider"`
+	Modes           map[string]string            `json:"modes"` // mode -> provider name
+	Providers       map[string]LLMProviderConfig `json:"providers"`
+}
+
+// LLMProviderConfig configures a single named backend.
+type LLMProviderConfig struct {
+	Type         string            `json:"type"` // "openai", "gemini-cli" or "echo"
+	BaseURL      string            `json:"base_url,omitempty"`
+	APIKey       string            `json:"api_key,omitempty"`
+	APIKeyEnv    string            `json:"api_key_env,omitempty"` // Read the key from this environment variable
+	Model        string            `json:"model,omitempty"`
+	Command      string            `json:"command,omitempty"` // gemini-cli binary
+	Args         []string          `json:"args,omitempty"`
+	Temperature  *float64          `json:"temperature,omitempty"`
+	SystemPrompt string            `json:"system_prompt,omitempty"`
+	Timeout      Duration          `json:"timeout,omitempty"`
+	Fixtures     map[string]string `json:"fixtures,omitempty"`      // echo: prompt -> response
+	FixturesFile string            `json:"fixtures_file,omitempty"` // echo: JSON file of fixtures
+}
+
+// PresenceConfig controls how Ghost Mode decides that the user is at the machine.
+type PresenceConfig struct {
+	Signals      []string `json:"signals"`                // Any of "tmux", "utmp", "http"
+	TmuxCommand  string   `json:"tmux_command,omitempty"` // Default "tmux"
+	TmuxSocket   string   `json:"tmux_socket,omitempty"`  // Default AppDir/brain.sock
+	UtmpPath     string   `json:"utmp_path,omitempty"`    // Default /var/run/utmp
+	UtmpMaxIdle  Duration `json:"utmp_max_idle,omitempty"`
+	HTTPWindow   Duration `json:"http_window"`   // A GUI request counts as presence for this long
+	AttachAfter  Duration `json:"attach_after"`  // Debounce before pausing autonomy
+	DetachAfter  Duration `json:"detach_after"`  // Hold after the last signal before resuming
+	PollInterval Duration `json:"poll_interval"` // How often the signals are checked
+}
+
+// MemoryConfig controls the memory-save protocol that writes the Markdown vault.
+type MemoryConfig struct {
+	VaultPath   string `json:"vault_path,omitempty"` // Default AppDir/memory/Sovereign_Log.md
+	Summarizer  string `json:"summarizer"`           // "auto", "llm" or "extractive"
+	SummaryMode string `json:"summary_mode"`         // Generation mode used to pick the summary provider
+	MaxRows     int    `json:"max_rows"`             // Oldest unsaved ch rows included in one save; the rest wait for the next
+}
+
+// IngestConfig controls the Gemini chat session ingester.
+type IngestConfig struct {
+	Enabled    bool     `json:"enabled"`
+	ChatDirs   []string `json:"chat_dirs"`          // Globs allowed; "~/" expands to the home directory
+	Pattern    string   `json:"pattern,omitempty"`  // Default "session-*.json"
+	Debounce   Duration `json:"debounce,omitempty"` // Default 500ms
+	Rescan     Duration `json:"rescan,omitempty"`   // Default 1m
+	CaptureJon bool     `json:"capture_jon"`        // Heuristic "i like / remember" capture into jon
+	Keywords   []string `json:"keywords,omitempty"` // Default: the memory_daemon.py keyword list
+}
+
+// DatabasesConfig controls the registry of additional named databases.
+type DatabasesConfig struct {
+	Dir            string   `json:"dir,omitempty"`          // Default AppDir/databases
+	TrashRetention Duration `json:"trash_retention"`        // Deleted databases are purged after this long
+	SnapshotDir    string   `json:"snapshot_dir,omitempty"` // Database archives; default AppDir/archives
+	UndoWindow     Duration `json:"undo_window"`            // How long /api/delete_rows can be undone
+}
+
+// EmbeddingsConfig controls the vector memory used for semantic recall.
+type EmbeddingsConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Embedder        string   `json:"embedder"`              // "hash" (offline) or "openai"
+	Dims            int      `json:"dims,omitempty"`        // hash: default 512; openai: the model's dimension
+	BaseURL         string   `json:"base_url,omitempty"`    // openai
+	APIKey          string   `json:"api_key,omitempty"`     // openai
+	APIKeyEnv       string   `json:"api_key_env,omitempty"` // Read the key from this environment variable
+	Model           string   `json:"model,omitempty"`       // openai
+	Interval        Duration `json:"interval"`              // Catch-up pass for rows not written by the ingester
+	BatchSize       int      `json:"batch_size,omitempty"`  // Rows per embedder call
+	ContextMemories int      `json:"context_memories"`      // Memories recalled into the Ghost Mode context
+}
+
+// EncryptionConfig locates the key of an encrypted memory database. The
+// database is encrypted with "sovereign db encrypt"; while the app runs it
+// works on an unlocked copy in a memory-backed directory. Changes reach the
+// sealed file once writes pause for SealDelay, and at least every
+// SealInterval under steady writes, so a crash or power loss loses at most
+// the changes of that window.
+type EncryptionConfig struct {
+	KeyFile       string   `json:"key_file,omitempty"`       // Takes precedence over the passphrase
+	PassphraseEnv string   `json:"passphrase_env,omitempty"` // Environment variable holding the passphrase
+	WorkDir       string   `json:"work_dir,omitempty"`       // Default: $XDG_RUNTIME_DIR, then /dev/shm
+	SealDelay     Duration `json:"seal_delay"`               // Quiet time after a change before it is sealed
+	SealInterval  Duration `json:"seal_interval"`            // Longest a change waits while writes continue
+}
+
+// BackupsConfig schedules backups of the memory database.
+type BackupsConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Dir      string   `json:"dir,omitempty"` // Default: <app dir>/backups
+	Interval Duration `json:"interval"`
+	Hourly   int      `json:"hourly"` // Generations kept; see backup.Retention
+	Daily    int      `json:"daily"`
+	Weekly   int      `json:"weekly"`
+}
+
+// AuthConfig controls sign-in to the HTTP API. Users and tokens are managed
+// with "sovereign auth".
+type AuthConfig struct {
+	Enabled    bool     `json:"enabled"`
+	DBPath     string   `json:"db_path,omitempty"` // Default: <app dir>/auth.db
+	SessionTTL Duration `json:"session_ttl"`       // How long a browser stays signed in
+}
+
+// ServerConfig controls the HTTP server.
+type ServerConfig struct {
+	Listeners         []ListenerConfig `json:"listeners"` // Replaced as a whole by the --listen flag
+	ReadHeaderTimeout Duration         `json:"read_header_timeout"`
+	IdleTimeout       Duration         `json:"idle_timeout"`     // Keep-alive connections are closed after this long
+	RequestTimeout    Duration         `json:"request_timeout"`  // Per request, except event streams
+	MaxBodyBytes      int64            `json:"max_body_bytes"`   // Request bodies
+	MaxUploadBytes    int64            `json:"max_upload_bytes"` // /upload bodies
+	ShutdownTimeout   Duration         `json:"shutdown_timeout"` // How long in-flight requests may drain
+	AccessLog         bool             `json:"access_log"`
+}
+
+// ListenerConfig is one socket the HTTP server accepts connections on.
+type ListenerConfig struct {
+	Network  string   `json:"network"`             // "tcp" or "unix"
+	Addr     string   `json:"addr,omitempty"`      // host:port, or the socket path (default <app dir>/api.sock)
+	Mode     string   `json:"mode,omitempty"`      // Unix socket permissions, default "0600"
+	PeerAuth bool     `json:"peer_auth,omitempty"` // Unix socket clients running as a trusted uid skip sign-in
+	PeerUIDs []uint32 `json:"peer_uids,omitempty"` // Trusted uids; default: the server's own
+	TLS      bool     `json:"tls,omitempty"`       // Serve HTTPS on a tcp listener
+	CertFile string   `json:"cert_file,omitempty"` // Default: a self-signed certificate in <app dir>/tls
+	KeyFile  string   `json:"key_file,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"` // Extra names for the self-signed certificate
+}
+
+// Duration is a time.Duration that reads and writes as a Go duration string ("90s").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// JSONSchema describes Duration in the API document.
+func (Duration) JSONSchema() *openapi.Schema {
+	return &openapi.Schema{Type: "string", Description: `A Go duration, e.g. "90s" or "1h30m"`}
+}
+
+// defaultConfig returns the configuration used when no config file exists.
+// Generation defaults to the offline echo backend until a real provider is configured.
+func defaultConfig() *Config {
+	return &Config{
+		LLM: LLMConfig{
+			DefaultProvider: "echo",
+			Modes:           map[string]string{},
+			Providers: map[string]LLMProviderConfig{
+				"echo": {Type: "echo"},
+			},
+		},
+		Presence: PresenceConfig{
+			Signals:      []string{"tmux", "utmp", "http"},
+			HTTPWindow:   Duration(2 * time.Minute),
+			AttachAfter:  Duration(5 * time.Second),
+			DetachAfter:  Duration(time.Minute),
+			PollInterval: Duration(5 * time.Second),
+		},
+		Memory: MemoryConfig{
+			Summarizer:  "auto",
+			SummaryMode: "summary",
+			MaxRows:     200,
+		},
+		Ingest: IngestConfig{
+			Enabled:    true,
+			ChatDirs:   []string{"~/.gemini/tmp/*/chats"},
+			CaptureJon: true,
+		},
+		Databases: DatabasesConfig{
+			TrashRetention: Duration(7 * 24 * time.Hour),
+			UndoWindow:     Duration(30 * time.Minute),
+		},
+		Embeddings: EmbeddingsConfig{
+			Enabled:         true,
+			Embedder:        "hash",
+			Interval:        Duration(time.Minute),
+			ContextMemories: 5,
+		},
+		Encryption: EncryptionConfig{
+			PassphraseEnv: "SOVEREIGN_DB_PASSPHRASE",
+			SealDelay:     Duration(2 * time.Second),
+			SealInterval:  Duration(30 * time.Second),
+		},
+		Backups: BackupsConfig{
+			Enabled:  true,
+			Interval: Duration(time.Hour),
+			Hourly:   24,
+			Daily:    7,
+			Weekly:   4,
+		},
+		Auth: AuthConfig{
+			Enabled:    true,
+			SessionTTL: Duration(12 * time.Hour),
+		},
+		Server: ServerConfig{
+			Listeners: []ListenerConfig{
+				{Network: "tcp", Addr: "127.0.0.1:8080"},
+				{Network: "unix", Mode: "0600", PeerAuth: true},
+			},
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			RequestTimeout:    Duration(5 * time.Minute),
+			MaxBodyBytes:      1 << 20,
+			MaxUploadBytes:    64 << 20,
+			ShutdownTimeout:   Duration(30 * time.Second),
+			AccessLog:         true,
+		},
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults.
+// A missing file is not an error unless the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// configPath returns the config file location for an app directory.
+func configPath(appDir, custom string) (string, bool) {
+	if custom != "" {
+		return custom, true
+	}
+	return filepath.Join(appDir, configFileName), false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/dbregistry"
+	"sovereign-orchestrator/pkg/migrate"
+)
+
+const (
+	databasesDirName = "databases"
+	// Idle connections to secondary databases are released after this long.
+	dbConnMaxIdleTime = 5 * time.Minute
+)
+
+// primaryDBName is the name the memory database is listed under.
+var primaryDBName = strings.TrimSuffix(dbFileName, filepath.Ext(dbFileName))
+
+// newDatabaseRegistry builds the registry around the open primary database
+// and purges expired entries from its trash.
+func (app *SovereignApp) newDatabaseRegistry() (*dbregistry.Registry, error) {
+	dir := app.Config.Databases.Dir
+	if dir == "" {
+		dir = filepath.Join(app.AppDir, databasesDirName)
+	}
+	reg, err := dbregistry.New(dbregistry.Config{
+		Dir:         dir,
+		PrimaryName: primaryDBName,
+		PrimaryPath: app.DBPath,
+		Primary:     app.DB,
+		Retention:   time.Duration(app.Config.Databases.TrashRetention),
+		Open:        openSecondaryDB,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n, err := reg.PurgeTrash(time.Now()); err != nil {
+		log.Printf("Warning: failed to purge database trash: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired database(s) from the trash", n)
+	}
+	return reg, nil
+}
+
+// openSecondaryDB opens a registry database with a small connection pool.
+func openSecondaryDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(2)
+	db.SetConnMaxIdleTime(dbConnMaxIdleTime)
+	return db, nil
+}
+
+// applyStandardSchema runs the embedded migrations on a new database. A
+// blocked migration leaves the schema short of the latest version, as it
+// does for the memory database.
+func applyStandardSchema(ctx context.Context, db *sql.DB) error {
+	migrations, err := schemaMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	_, err = migrate.New(db, migrations).Up(ctx, 0)
+	if errors.Is(err, migrate.ErrBlocked) {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return err
+}
+
+// databasesResponse lists the registered databases.
+type databasesResponse struct {
+	Primary   string            `json:"primary" doc:"The memory database, used when a request names none"`
+	Databases []dbregistry.Info `json:"databases"`
+}
+
+// handleAPIDatabases lists the registered databases.
+func (app *SovereignApp) handleAPIDatabases(w http.ResponseWriter, r *http.Request) (any, error) {
+	infos, err := app.Databases.List()
+	if err != nil {
+		return nil, err
+	}
+	return databasesResponse{Primary: app.Databases.PrimaryName(), Databases: infos}, nil
+}
+
+// createDatabaseRequest is the body of /api/create_database.
+type createDatabaseRequest struct {
+	Name   string `json:"name" schema:"required,minLength=1,maxLength=64"`
+	Schema string `json:"schema" doc:"Tables to create; standard when empty" schema:"enum=|standard|empty"`
+}
+
+// databaseResponse answers the routes that create a database.
+type databaseResponse struct {
+	Status   string          `json:"status" schema:"enum=created|copied"`
+	Source   string          `json:"source,omitempty" doc:"The database a copy was made from"`
+	Database dbregistry.Info `json:"database"`
+}
+
+// deleteDatabaseRequest is the body of /api/delete_database.
+type deleteDatabaseRequest struct {
+	Name string `json:"name" schema:"required,minLength=1"`
+}
+
+// deleteDatabaseResponse answers /api/delete_database.
+type deleteDatabaseResponse struct {
+	Status    string    `json:"status" schema:"enum=deleted"`
+	Name      string    `json:"name"`
+	TrashPath string    `json:"trash_path"`
+	PurgeAt   time.Time `json:"purge_at" doc:"When the trashed file is removed for good"`
+}
+
+// handleAPICreateDatabase creates a named database, optionally with the
+// standard schema applied.
+func (app *SovereignApp) handleAPICreateDatabase(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req createDatabaseRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+
+	var init func(context.Context, *sql.DB) error
+	switch req.Schema {
+	case "", "standard":
+		req.Schema = "standard"
+		init = applyStandardSchema
+	case "empty":
+	default:
+		return nil, api.Errorf(api.CodeInvalidRequest, "Unknown schema %q (want \"standard\" or \"empty\")", req.Schema)
+	}
+
+	info, err := app.Databases.Create(r.Context(), req.Name, init)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("databases", "info", fmt.Sprintf("Created database %s (%s schema)", info.Name, req.Schema), info)
+	return api.Created(databaseResponse{Status: "created", Database: info}), nil
+}
+
+// handleAPIDeleteDatabase moves a named database to the trash. The primary
+// database cannot be deleted.
+func (app *SovereignApp) handleAPIDeleteDatabase(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req deleteDatabaseRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+
+	trashPath, err := app.Databases.Delete(r.Context(), req.Name)
+	if err != nil {
+		return nil, err
+	}
+	retention := time.Duration(app.Config.Databases.TrashRetention)
+	app.publish("databases", "info", fmt.Sprintf("Moved database %s to the trash", req.Name), map[string]string{"name": req.Name, "trash_path": trashPath})
+	if _, err := app.Databases.PurgeTrash(time.Now()); err != nil {
+		log.Printf("Warning: failed to purge database trash: %v", err)
+	}
+	return deleteDatabaseResponse{
+		Status:    "deleted",
+		Name:      req.Name,
+		TrashPath: trashPath,
+		PurgeAt:   time.Now().Add(retention),
+	}, nil
+}
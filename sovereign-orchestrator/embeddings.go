@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/embed"
+)
+
+// recentContextRows is how many of the latest ch rows make up the Ghost
+// Mode recall query.
+const recentContextRows = 5
+
+// newEmbedder builds the configured embedder. It returns nil when vector
+// memory is disabled.
+func newEmbedder(cfg EmbeddingsConfig) (embed.Embedder, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Embedder {
+	case "", "hash":
+		return embed.NewHash(cfg.Dims), nil
+	case "openai":
+		apiKey := cfg.APIKey
+		if cfg.APIKeyEnv != "" {
+			apiKey = os.Getenv(cfg.APIKeyEnv)
+		}
+		return embed.NewOpenAI(embed.OpenAIConfig{
+			BaseURL: cfg.BaseURL,
+			APIKey:  apiKey,
+			Model:   cfg.Model,
+			Dims:    cfg.Dims,
+		})
+	default:
+		return nil, fmt.Errorf("embeddings: unknown embedder %q (want hash or openai)", cfg.Embedder)
+	}
+}
+
+// embedNotify wakes the embedder after new rows were written. It never blocks.
+func (app *SovereignApp) embedNotify() {
+	if app.Vectors == nil {
+		return
+	}
+	select {
+	case app.embedWake <- struct{}{}:
+	default:
+	}
+}
+
+// startEmbedder embeds new ch and jon rows until the app shuts down. It runs
+// when woken by the ingester and every configured interval, so rows written
+// by other paths are picked up too.
+func (app *SovereignApp) startEmbedder() {
+	log.Printf("Vector memory using %s", app.Vectors.Embedder.Model())
+	interval := time.Duration(app.Config.Embeddings.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.writeMu.RLock()
+		res, err := app.Vectors.Sync(app.ctx)
+		app.writeMu.RUnlock()
+		if err != nil && app.ctx.Err() == nil {
+			log.Printf("Embedding pass failed: %v", err)
+		}
+		if res != nil && (res.Embedded["ch"]+res.Embedded["jon"] > 0 || res.Reindexed) {
+			msg := fmt.Sprintf("Embedded %d ch and %d jon row(s)", res.Embedded["ch"], res.Embedded["jon"])
+			if res.Reindexed {
+				msg += "; rebuilt the IVF index"
+			}
+			app.publish("embeddings", "info", msg, res)
+		}
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-app.embedWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// recallParams holds the parameters of /api/recall.
+type recallParams struct {
+	Q      string   `query:"q" schema:"required"`
+	K      int      `query:"k" doc:"Memories to return" schema:"min=1"`
+	Table  []string `query:"table" doc:"Tables to recall from, comma-separated or repeated; all when omitted"`
+	Method string   `query:"method" schema:"enum=auto|exact|ivf"`
+	Probes int      `query:"probes" doc:"IVF lists to scan" schema:"min=1"`
+}
+
+// handleAPIRecall returns the memories most similar to a query.
+//
+//	GET /api/recall?q=what+editor+do+i+use&k=10&table=ch,jon&method=auto|exact|ivf&probes=
+func (app *SovereignApp) handleAPIRecall(w http.ResponseWriter, r *http.Request) (any, error) {
+	if app.Vectors == nil {
+		return nil, api.Errorf(api.CodeUnavailable, "Vector memory is disabled")
+	}
+	p := r.URL.Query()
+	q := embed.Query{Text: p.Get("q"), Method: p.Get("method")}
+	for _, t := range strings.Split(strings.Join(p["table"], ","), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			q.Tables = append(q.Tables, t)
+		}
+	}
+	for _, n := range []struct {
+		name string
+		dst  *int
+	}{{"k", &q.K}, {"probes", &q.Probes}} {
+		if s := p.Get(n.name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 1 {
+				return nil, api.Errorf(api.CodeInvalidRequest, "%s must be a positive integer", n.name)
+			}
+			*n.dst = v
+		}
+	}
+	return app.Vectors.Recall(r.Context(), q)
+}
+
+// recallRecent returns the memories most related to the latest
+// conversation, leaving out the conversation rows themselves.
+func (app *SovereignApp) recallRecent(ctx context.Context, n int) ([]embed.Memory, error) {
+	rows, err := app.DB.QueryContext(ctx, "SELECT id, COALESCE(content, '') FROM ch ORDER BY id DESC LIMIT ?", recentContextRows)
+	if err != nil {
+		return nil, err
+	}
+	recent := make(map[int64]bool)
+	var texts []string
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recent[id] = true
+		texts = append(texts, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	query := strings.TrimSpace(strings.Join(texts, "\n"))
+	if query == "" {
+		return nil, nil
+	}
+
+	result, err := app.Vectors.Recall(ctx, embed.Query{Text: query, K: n + len(recent)})
+	if err != nil {
+		return nil, err
+	}
+	var memories []embed.Memory
+	for _, m := range result.Memories {
+		if m.Table == "ch" && recent[m.ID] {
+			continue
+		}
+		if memories = append(memories, m); len(memories) == n {
+			break
+		}
+	}
+	return memories, nil
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sovereign-orchestrator/pkg/archive"
+	"sovereign-orchestrator/pkg/command"
+	"sovereign-orchestrator/pkg/dbcopy"
+	"sovereign-orchestrator/pkg/fsutil"
+	"sovereign-orchestrator/pkg/vault"
+)
+
+// newPassphraseEnv holds the new passphrase for "db rekey".
+const newPassphraseEnv = "SOVEREIGN_DB_NEW_PASSPHRASE"
+
+// sealedDB is an encrypted memory database unlocked into a memory-backed
+// directory. The process holding the lock owns the working copy: it seals
+// changes back periodically and on Close, then removes the copy. Other
+// processes, such as CLI commands run next to the server, share it.
+type sealedDB struct {
+	path      string // The sealed file, e.g. ~/.sovereign/sovereign_memory.db.enc
+	plainPath string // Where the database lives when not encrypted
+	work      string // The unlocked working copy
+	key       *vault.Key
+	lock      *os.File // Held by the owner; nil for a sharer
+
+	mu    sync.Mutex // Serializes seals
+	state string     // Size and mtime of the working copy at the last seal
+}
+
+// workPaths returns the working copy and lock file for a database path.
+// The name is derived from the path so several databases can be unlocked
+// side by side.
+func (app *SovereignApp) workPaths(plainPath string) (string, string, error) {
+	dir := app.Config.Encryption.WorkDir
+	if dir == "" {
+		dir = os.Getenv("XDG_RUNTIME_DIR")
+	}
+	if dir == "" {
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			dir = "/dev/shm"
+		}
+	}
+	if dir == "" {
+		return "", "", fmt.Errorf("no memory-backed directory for the unlocked database; set encryption.work_dir in the config")
+	}
+	dir = filepath.Join(dir, appName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	abs, _ := filepath.Abs(plainPath)
+	sum := sha256.Sum256([]byte(abs))
+	base := strings.TrimSuffix(filepath.Base(plainPath), filepath.Ext(plainPath))
+	work := filepath.Join(dir, fmt.Sprintf("%s-%s.db", base, hex.EncodeToString(sum[:4])))
+	return work, work + ".lock", nil
+}
+
+// encryptionSecret reads the key from keyFile, the configured key file or
+// the configured passphrase variable, in that order.
+func (app *SovereignApp) encryptionSecret(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		keyFile = app.Config.Encryption.KeyFile
+	}
+	if keyFile != "" {
+		return readKeyFile(keyFile)
+	}
+	env := app.Config.Encryption.PassphraseEnv
+	if env != "" {
+		if secret := os.Getenv(env); secret != "" {
+			return []byte(secret), nil
+		}
+	}
+	if env == "" {
+		env = "a passphrase variable"
+	}
+	return nil, fmt.Errorf("%w: set %s or encryption.key_file in %s", vault.ErrNoKey, env, configFileName)
+}
+
+// readKeyFile reads a key file, ignoring a trailing newline.
+func readKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Printf("Warning: key file %s is readable by other users (mode %v)", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("key file %s: %w", path, fmt.Errorf("%w: the key file is empty", vault.ErrNoKey))
+	}
+	return data, nil
+}
+
+// keyError explains a key failure for the database at path.
+func keyError(path string, err error) error {
+	switch {
+	case errors.Is(err, vault.ErrNoKey):
+		return fmt.Errorf("the memory database %s is encrypted: %w", path, err)
+	case errors.Is(err, vault.ErrWrongKey):
+		return fmt.Errorf("cannot unlock %s: %w", path, err)
+	}
+	return err
+}
+
+// tryLock takes an exclusive lock on path without waiting. It returns nil
+// and no error when another process holds the lock.
+func tryLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlockDB opens the sealed database next to app.DBPath and points DBPath
+// at the working copy. A working copy left by a process that did not shut
+// down cleanly holds newer data than the sealed file, so it is kept and
+// sealed again rather than replaced.
+func (app *SovereignApp) unlockDB() error {
+	s := &sealedDB{path: app.DBPath + vault.Ext, plainPath: app.DBPath}
+	if _, err := os.Stat(s.plainPath); err == nil {
+		return fmt.Errorf("both %s and its encrypted copy %s exist; remove the stale one", s.plainPath, s.path)
+	}
+	secret, err := app.encryptionSecret("")
+	if err != nil {
+		return keyError(s.path, err)
+	}
+	work, lockPath, err := app.workPaths(s.plainPath)
+	if err != nil {
+		return err
+	}
+	s.work = work
+	if s.lock, err = tryLock(lockPath); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", lockPath, err)
+	}
+
+	_, statErr := os.Stat(work)
+	switch {
+	case s.lock == nil:
+		// Another process owns the working copy; share it.
+		if s.key, err = vault.Check(s.path, secret); err != nil {
+			return keyError(s.path, err)
+		}
+		if statErr != nil {
+			return fmt.Errorf("the database is being unlocked by another process; try again")
+		}
+	case statErr == nil:
+		if s.key, err = vault.Check(s.path, secret); err != nil {
+			s.lock.Close()
+			return keyError(s.path, err)
+		}
+		log.Printf("Recovering unlocked copy %s left by an earlier run", work)
+		if err := s.key.SealFile(work, s.path); err != nil {
+			s.lock.Close()
+			return fmt.Errorf("failed to seal recovered copy: %w", err)
+		}
+	default:
+		if s.key, err = vault.OpenFile(s.path, work, secret); err != nil {
+			s.lock.Close()
+			return keyError(s.path, err)
+		}
+	}
+	s.state = fileState(work)
+	app.sealed = s
+	app.DBPath = work
+	return nil
+}
+
+// fileState identifies a version of a file by its size and mtime.
+func fileState(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+}
+
+// seal writes the live database back to the sealed file if it changed since
+// the last seal. The copy is taken with the online backup API, so writers
+// are not blocked while it is encrypted.
+func (app *SovereignApp) seal() error {
+	s := app.sealed
+	if s == nil || s.lock == nil || app.DB == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := fileState(s.work)
+	if state == s.state {
+		return nil
+	}
+	tmp := s.work + ".seal"
+	defer os.Remove(tmp)
+	dst, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	err = dbcopy.Backup(app.ctx, dst, app.DB, nil)
+	dst.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy database for sealing: %w", err)
+	}
+	if err := s.key.SealFile(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to seal database: %w", err)
+	}
+	s.state = state
+	return nil
+}
+
+// sealPollEvery is how often the sealer looks for committed changes.
+const sealPollEvery = 250 * time.Millisecond
+
+// startSealer seals the database until shutdown: once writes have paused
+// for SealDelay after a commit, and at least every SealInterval while they
+// go on. Commits are seen by the working copy changing size or mtime.
+func (app *SovereignApp) startSealer() {
+	delay := time.Duration(app.Config.Encryption.SealDelay)
+	if delay <= 0 {
+		delay = 2 * time.Second
+	}
+	interval := time.Duration(app.Config.Encryption.SealInterval)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	s := app.sealed
+	s.mu.Lock()
+	last := s.state
+	s.mu.Unlock()
+
+	// first and latest are when the oldest unsealed and the newest change
+	// were seen; first is zero when everything is sealed.
+	var first, latest time.Time
+	ticker := time.NewTicker(sealPollEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if state := fileState(s.work); state != last {
+				last, latest = state, now
+				if first.IsZero() {
+					first = now
+				}
+			}
+			if first.IsZero() || (now.Sub(latest) < delay && now.Sub(first) < interval) {
+				continue
+			}
+			if err := app.seal(); err != nil {
+				log.Printf("Warning: %v", err)
+				first, latest = now, now // Retry after another delay
+				continue
+			}
+			first = time.Time{}
+		}
+	}
+}
+
+// closeSealed seals the closed working copy a last time and removes it.
+// If sealing fails the copy is left in place and recovered on next start.
+func (app *SovereignApp) closeSealed() error {
+	s := app.sealed
+	app.sealed = nil
+	if s == nil || s.lock == nil {
+		return nil
+	}
+	defer s.lock.Close()
+	if fileState(s.work) != s.state {
+		if err := s.key.SealFile(s.work, s.path); err != nil {
+			return fmt.Errorf("failed to seal database, unlocked copy kept at %s: %w", s.work, err)
+		}
+	}
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(s.work + suffix)
+	}
+	os.Remove(s.lock.Name())
+	return nil
+}
+
+// encryptionCommands adds encrypt, decrypt and rekey to the db command tree.
+func (app *SovereignApp) encryptionCommands(db *command.Registry) {
+	keyFlag := func(fs *flag.FlagSet) {
+		fs.String("key-file", "", "Read the key from this file (default: encryption.key_file or $SOVEREIGN_DB_PASSPHRASE)")
+	}
+	db.Register(&command.Subcommand{
+		Name:    "encrypt",
+		Usage:   "encrypt [flags]",
+		Summary: "Encrypt the memory database at rest",
+		Flags:   keyFlag,
+		Run:     app.cmdDBEncrypt,
+	})
+	db.Register(&command.Subcommand{
+		Name:    "decrypt",
+		Usage:   "decrypt [flags]",
+		Summary: "Turn the encrypted memory database back into a plain file",
+		Flags:   keyFlag,
+		Run:     app.cmdDBDecrypt,
+	})
+	db.Register(&command.Subcommand{
+		Name:    "rekey",
+		Usage:   "rekey [flags]",
+		Summary: "Re-encrypt the memory database under a new key",
+		Flags: func(fs *flag.FlagSet) {
+			keyFlag(fs)
+			fs.String("new-key-file", "", "Read the new key from this file (default: $"+newPassphraseEnv+")")
+		},
+		Run: app.cmdDBRekey,
+	})
+}
+
+// offlineSealed checks that no process has the sealed database unlocked
+// and seals a working copy left by one that did not shut down cleanly. The
+// returned lock must be closed when done.
+func (app *SovereignApp) offlineSealed(sealedPath string, secret []byte) (*os.File, error) {
+	if _, err := os.Stat(sealedPath); err != nil {
+		return nil, fmt.Errorf("%s is not encrypted (no %s)", app.DBPath, sealedPath)
+	}
+	work, lockPath, err := app.workPaths(app.DBPath)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := tryLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, fmt.Errorf("the database is unlocked by a running %s process; stop it first", appName)
+	}
+	key, err := vault.Check(sealedPath, secret)
+	if err != nil {
+		lock.Close()
+		return nil, keyError(sealedPath, err)
+	}
+	if _, err := os.Stat(work); err == nil {
+		fmt.Printf("Sealing unlocked copy %s left by an earlier run\n", work)
+		if err := key.SealFile(work, sealedPath); err != nil {
+			lock.Close()
+			return nil, err
+		}
+		os.Remove(work)
+		os.Remove(work + "-journal")
+	}
+	return lock, nil
+}
+
+func (app *SovereignApp) cmdDBEncrypt(cmd *command.Command) error {
+	sealedPath := app.DBPath + vault.Ext
+	if _, err := os.Stat(sealedPath); err == nil {
+		return fmt.Errorf("%s is already encrypted", sealedPath)
+	}
+	if _, err := os.Stat(app.DBPath); err != nil {
+		return fmt.Errorf("no database to encrypt: %w", err)
+	}
+	secret, err := app.encryptionSecret(cmd.Flags["key-file"])
+	if err != nil {
+		return err
+	}
+	key, err := vault.NewKey(secret, vault.DefaultParams)
+	if err != nil {
+		return err
+	}
+
+	// A read transaction keeps writers out while the file is sealed, so the
+	// sealed copy is consistent. No journal can be pending while it is held.
+	db, err := sql.Open("sqlite3", app.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.BeginTx(app.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		return fmt.Errorf("failed to read %s: %w", app.DBPath, err)
+	}
+	if err := key.SealFile(app.DBPath, sealedPath); err != nil {
+		return fmt.Errorf("failed to encrypt database: %w", err)
+	}
+	if err := verifySealed(app.DBPath, sealedPath, secret); err != nil {
+		os.Remove(sealedPath)
+		return err
+	}
+	tx.Rollback()
+	db.Close()
+
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(app.DBPath + suffix)
+	}
+	fmt.Printf("Encrypted %s -> %s\n", app.DBPath, sealedPath)
+	fmt.Println("Keep the key safe: the memory database cannot be recovered without it.")
+	fmt.Printf("While %s runs, changes are sealed once writes pause for %v, and at least every %v;\n", appName,
+		time.Duration(app.Config.Encryption.SealDelay), time.Duration(app.Config.Encryption.SealInterval))
+	fmt.Println("a crash or power loss loses the changes made since the last seal.")
+	fmt.Println("The plaintext file was deleted, but SSDs and journaling file systems may retain its blocks.")
+	if leftovers := app.plaintextCopies(); len(leftovers) > 0 {
+		fmt.Println("Plaintext copies of its data remain; remove them once they are no longer needed:")
+		for _, f := range leftovers {
+			fmt.Println("  " + f)
+		}
+	}
+	return nil
+}
+
+// plaintextCopies lists the unencrypted files holding data of the memory
+// database: pre-migration backups left by older versions, its archive
+// sidecar and its snapshots.
+func (app *SovereignApp) plaintextCopies() []string {
+	files, _ := filepath.Glob(app.DBPath + ".pre-v*.bak")
+	sidecar := strings.TrimSuffix(app.DBPath, filepath.Ext(app.DBPath)) + archiveSidecarExt
+	if _, err := os.Stat(sidecar); err == nil {
+		files = append(files, sidecar)
+	}
+	snaps, _ := archive.ListSnapshots(app.snapshotDir())
+	for _, s := range snaps {
+		if s.Database == primaryDBName {
+			files = append(files, filepath.Join(app.snapshotDir(), s.File))
+		}
+	}
+	return files
+}
+
+// verifySealed decrypts sealed and compares it with the plaintext it was
+// made from.
+func verifySealed(plainPath, sealedPath string, secret []byte) error {
+	want, err := fsutil.FileSHA256(plainPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(sealedPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := vault.Open(h, f, secret); err != nil {
+		return fmt.Errorf("failed to verify encrypted database: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != want {
+		return fmt.Errorf("encrypted database does not match %s; left unencrypted", plainPath)
+	}
+	return nil
+}
+
+func (app *SovereignApp) cmdDBDecrypt(cmd *command.Command) error {
+	sealedPath := app.DBPath + vault.Ext
+	if _, err := os.Stat(app.DBPath); err == nil {
+		return fmt.Errorf("%s already exists; move it away before decrypting", app.DBPath)
+	}
+	secret, err := app.encryptionSecret(cmd.Flags["key-file"])
+	if err != nil {
+		return keyError(sealedPath, err)
+	}
+	lock, err := app.offlineSealed(sealedPath, secret)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if _, err := vault.OpenFile(sealedPath, app.DBPath, secret); err != nil {
+		return keyError(sealedPath, err)
+	}
+	if err := os.Remove(sealedPath); err != nil {
+		return err
+	}
+	fmt.Printf("Decrypted %s -> %s\n", sealedPath, app.DBPath)
+	return nil
+}
+
+func (app *SovereignApp) cmdDBRekey(cmd *command.Command) error {
+	sealedPath := app.DBPath + vault.Ext
+	secret, err := app.encryptionSecret(cmd.Flags["key-file"])
+	if err != nil {
+		return keyError(sealedPath, err)
+	}
+	var newSecret []byte
+	if f := cmd.Flags["new-key-file"]; f != "" {
+		if newSecret, err = readKeyFile(f); err != nil {
+			return err
+		}
+	} else if newSecret = []byte(os.Getenv(newPassphraseEnv)); len(newSecret) == 0 {
+		return fmt.Errorf("%w: set %s or pass --new-key-file", vault.ErrNoKey, newPassphraseEnv)
+	}
+	lock, err := app.offlineSealed(sealedPath, secret)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	key, err := vault.NewKey(newSecret, vault.DefaultParams)
+	if err != nil {
+		return err
+	}
+	// Sealed pre-migration backups left by older versions move to the new key
+	// along with the database.
+	backups, _ := filepath.Glob(app.DBPath + ".pre-v*.bak" + vault.Ext)
+	for _, path := range append([]string{sealedPath}, backups...) {
+		if err := vault.RekeyFile(path, secret, key); err != nil {
+			return fmt.Errorf("failed to rekey %s: %w", path, keyError(path, err))
+		}
+		fmt.Printf("Rekeyed %s\n", path)
+	}
+	store := app.backupStore()
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, b := range list {
+		if !b.Encrypted {
+			continue
+		}
+		if err := store.Rekey(b.File, secret, key); err != nil {
+			fmt.Printf("Skipped backup %s: %v\n", b.File, err)
+			continue
+		}
+		fmt.Printf("Rekeyed backup %s\n", b.File)
+	}
+	fmt.Println("Update encryption.key_file or the passphrase variable to the new key.")
+	return nil
+}
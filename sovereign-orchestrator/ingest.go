@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/ingest"
+)
+
+// newIngester builds the session ingester from the config.
+func (app *SovereignApp) newIngester() *ingest.Ingester {
+	cfg := app.Config.Ingest
+	home, _ := os.UserHomeDir()
+	dirs := make([]string, 0, len(cfg.ChatDirs))
+	for _, d := range cfg.ChatDirs {
+		if strings.HasPrefix(d, "~/") && home != "" {
+			d = filepath.Join(home, d[2:])
+		}
+		dirs = append(dirs, d)
+	}
+
+	in := ingest.New(app.DB, ingest.Config{
+		Dirs:       dirs,
+		Pattern:    cfg.Pattern,
+		Debounce:   time.Duration(cfg.Debounce),
+		Rescan:     time.Duration(cfg.Rescan),
+		CaptureJon: cfg.CaptureJon,
+		Keywords:   cfg.Keywords,
+		Gate:       app.writeMu.RLocker(),
+	})
+	in.OnIngest = func(res ingest.FileResult) {
+		app.publish("ingest", "info", fmt.Sprintf("Ingested %d new message(s) from %s", res.Inserted, filepath.Base(res.Path)), res)
+		app.embedNotify()
+	}
+	return in
+}
+
+// startIngester runs the session ingester until the app shuts down.
+func (app *SovereignApp) startIngester() {
+	log.Printf("Session ingester watching %s", strings.Join(app.Config.Ingest.ChatDirs, ", "))
+	if err := app.Ingester.Run(app.ctx); err != nil && app.ctx.Err() == nil {
+		log.Printf("Session ingester stopped: %v", err)
+	}
+}
+
+// ingestFileStatus is one row of ingest_files.
+type ingestFileStatus struct {
+	Path       string `json:"path"`
+	SessionID  string `json:"session_id"`
+	Messages   int    `json:"messages"`
+	Inserted   int    `json:"inserted"`
+	IngestedAt string `json:"ingested_at"`
+}
+
+// ingestTotals counts what ingestion has stored over all runs.
+type ingestTotals struct {
+	Files    int `json:"files"`
+	Messages int `json:"messages"`
+	Jon      int `json:"jon_captured"`
+}
+
+// ingestStatsResponse answers /api/ingest_stats.
+type ingestStatsResponse struct {
+	Stats       ingest.Stats       `json:"stats"`
+	Totals      ingestTotals       `json:"totals"`
+	RecentFiles []ingestFileStatus `json:"recent_files"`
+}
+
+// handleIngestStats reports ingester counters, database totals and the most
+// recently ingested session files.
+func (app *SovereignApp) handleIngestStats(w http.ResponseWriter, r *http.Request) (any, error) {
+	if app.Ingester == nil {
+		return nil, api.Errorf(api.CodeUnavailable, "Session ingestion is disabled")
+	}
+
+	var totals ingestTotals
+	err := app.DB.QueryRowContext(r.Context(), `SELECT
+		(SELECT COUNT(*) FROM ingest_files),
+		(SELECT COALESCE(SUM(inserted), 0) FROM ingest_files),
+		(SELECT COUNT(*) FROM jon WHERE category = 'heuristic_capture')`).Scan(&totals.Files, &totals.Messages, &totals.Jon)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingest totals: %w", err)
+	}
+
+	rows, err := app.DB.QueryContext(r.Context(), `SELECT path, COALESCE(session_id, ''), messages, inserted, COALESCE(ingested_at, '')
+		FROM ingest_files ORDER BY ingested_at DESC LIMIT 20`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingested files: %w", err)
+	}
+	defer rows.Close()
+	files := []ingestFileStatus{}
+	for rows.Next() {
+		var f ingestFileStatus
+		if err := rows.Scan(&f.Path, &f.SessionID, &f.Messages, &f.Inserted, &f.IngestedAt); err != nil {
+			return nil, fmt.Errorf("failed to read ingested files: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ingested files: %w", err)
+	}
+	return ingestStatsResponse{Stats: app.Ingester.Stats(), Totals: totals, RecentFiles: files}, nil
+}
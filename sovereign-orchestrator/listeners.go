@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sovereign-orchestrator/pkg/auth"
+	"sovereign-orchestrator/pkg/listen"
+)
+
+const (
+	socketFileName = "api.sock"
+	tlsDirName     = "tls"
+)
+
+// listenFlags collects --listen values; any given replaces the configured
+// listeners.
+type listenFlags []string
+
+func (f *listenFlags) String() string { return strings.Join(*f, ",") }
+
+func (f *listenFlags) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// parseListen turns a --listen value into a listener: "HOST:PORT",
+// "tls:HOST:PORT" or "unix:PATH". Unix sockets made this way trust clients
+// running as the server's own user.
+func parseListen(s, certFile, keyFile string) (ListenerConfig, error) {
+	kind, rest, ok := strings.Cut(s, ":")
+	switch {
+	case ok && kind == "unix":
+		return ListenerConfig{Network: "unix", Addr: rest, Mode: "0600", PeerAuth: true}, nil
+	case ok && kind == "tls":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return ListenerConfig{}, fmt.Errorf("invalid --listen %q: %w", s, err)
+		}
+		return ListenerConfig{Network: "tcp", Addr: rest, TLS: true, CertFile: certFile, KeyFile: keyFile}, nil
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return ListenerConfig{}, fmt.Errorf("invalid --listen %q: %w", s, err)
+	}
+	return ListenerConfig{Network: "tcp", Addr: s}, nil
+}
+
+// peer is a Unix socket client whose uid the listener trusts.
+type peer struct {
+	cred listen.Cred
+}
+
+type peerKey struct{}
+
+// trustedPeer returns the trusted Unix socket client of a request, or nil.
+func trustedPeer(r *http.Request) *peer {
+	p, _ := r.Context().Value(peerKey{}).(*peer)
+	return p
+}
+
+// peerPrincipal signs a trusted Unix socket client in as its local user
+// with every scope. Only a client that can already act as that user can
+// reach the socket and pass the uid check.
+func peerPrincipal(p *peer) *auth.Principal {
+	name := strconv.FormatUint(uint64(p.cred.UID), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	return &auth.Principal{User: name, Scopes: auth.AllScopes, Via: "peer"}
+}
+
+// trustingListener marks the connections of peers whose uid it trusts.
+type trustingListener struct {
+	net.Listener // Accepts *listen.PeerConn
+	uids         []uint32
+}
+
+// trustedConn is a connection from a trusted peer.
+type trustedConn struct {
+	net.Conn
+	peer *peer
+}
+
+func (l trustingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if pc, ok := c.(*listen.PeerConn); ok && slices.Contains(l.uids, pc.Cred.UID) {
+		return &trustedConn{Conn: c, peer: &peer{cred: pc.Cred}}, nil
+	}
+	return c, nil
+}
+
+// openListeners opens every configured listener. On error the ones already
+// open are closed again.
+func (app *SovereignApp) openListeners() (listeners []net.Listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			listeners = nil
+		}
+	}()
+	cfgs := app.Config.Server.Listeners
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no listeners configured")
+	}
+	for _, lc := range cfgs {
+		l, err := app.openListener(lc)
+		if err != nil {
+			return listeners, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func (app *SovereignApp) openListener(lc ListenerConfig) (net.Listener, error) {
+	switch lc.Network {
+	case "unix":
+		if lc.TLS {
+			return nil, fmt.Errorf("unix socket listeners do not support TLS")
+		}
+		path := lc.Addr
+		if path == "" {
+			path = filepath.Join(app.AppDir, socketFileName)
+		}
+		mode := os.FileMode(0600)
+		if lc.Mode != "" {
+			m, err := strconv.ParseUint(lc.Mode, 8, 32)
+			if err != nil || m > 0777 {
+				return nil, fmt.Errorf("invalid socket mode %q for %s", lc.Mode, path)
+			}
+			mode = os.FileMode(m)
+		}
+		l, err := listen.Unix(path, mode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+		}
+		if !lc.PeerAuth {
+			log.Printf("Listening on unix socket %s (mode %04o)", path, mode)
+			return l, nil
+		}
+		uids := lc.PeerUIDs
+		if len(uids) == 0 {
+			uids = []uint32{uint32(os.Getuid())}
+		}
+		log.Printf("Listening on unix socket %s (mode %04o, uids %v skip sign-in)", path, mode, uids)
+		return trustingListener{Listener: listen.WithPeerCred(l), uids: uids}, nil
+
+	case "tcp", "":
+		l, err := net.Listen("tcp", lc.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", lc.Addr, err)
+		}
+		if !lc.TLS {
+			log.Printf("Listening on http://%s", l.Addr())
+			return l, nil
+		}
+		tlsCfg, err := app.listenerTLS(lc)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		log.Printf("Listening on https://%s", l.Addr())
+		return tls.NewListener(l, tlsCfg), nil
+	}
+	return nil, fmt.Errorf("unknown listener network %q", lc.Network)
+}
+
+// listenerTLS loads the listener's certificate, or the self-signed one in
+// <app dir>/tls when none is configured.
+func (app *SovereignApp) listenerTLS(lc ListenerConfig) (*tls.Config, error) {
+	certFile, keyFile := lc.CertFile, lc.KeyFile
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("listener %s needs both cert_file and key_file", lc.Addr)
+	}
+	if certFile == "" {
+		var err error
+		certFile, keyFile, err = listen.SelfSigned(filepath.Join(app.AppDir, tlsDirName), lc.Hosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up a self-signed certificate: %w", err)
+		}
+		if fp, err := listen.Fingerprint(certFile); err == nil {
+			log.Printf("Using self-signed certificate %s (SHA-256 %s)", certFile, fp)
+		}
+	}
+	cfg, err := listen.TLSConfig(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	return cfg, nil
+}
+
+// connContext hands the peer of a trusted connection to protect.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*trustedConn); ok {
+		return context.WithValue(ctx, peerKey{}, tc.peer)
+	}
+	return ctx
+}
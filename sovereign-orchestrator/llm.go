@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/llm"
+)
+
+// LLMProvider generates text for a prompt. Backends live in pkg/llm.
+type LLMProvider interface {
+	Name() string
+	Generate(ctx context.Context, req llm.Request) (*llm.Response, error)
+	StreamGenerate(ctx context.Context, req llm.Request, onToken llm.TokenFunc) (*llm.Response, error)
+}
+
+// llmRouter picks a provider for each generation mode.
+type llmRouter struct {
+	providers       map[string]LLMProvider
+	systemPrompts   map[string]string // provider name -> system prompt
+	modes           map[string]string // mode -> provider name
+	defaultProvider string
+}
+
+// newLLMRouter builds every configured provider and validates the mode table.
+func newLLMRouter(cfg LLMConfig) (*llmRouter, error) {
+	r := &llmRouter{
+		providers:       make(map[string]LLMProvider),
+		systemPrompts:   make(map[string]string),
+		modes:           cfg.Modes,
+		defaultProvider: cfg.DefaultProvider,
+	}
+	for name, pc := range cfg.Providers {
+		p, err := newLLMProvider(name, pc)
+		if err != nil {
+			return nil, err
+		}
+		r.providers[name] = p
+		r.systemPrompts[name] = pc.SystemPrompt
+	}
+
+	if _, ok := r.providers[r.defaultProvider]; !ok {
+		return nil, fmt.Errorf("llm: default provider %q is not configured", r.defaultProvider)
+	}
+	for mode, name := range r.modes {
+		if _, ok := r.providers[name]; !ok {
+			return nil, fmt.Errorf("llm: mode %q uses unknown provider %q", mode, name)
+		}
+	}
+	return r, nil
+}
+
+func newLLMProvider(name string, pc LLMProviderConfig) (LLMProvider, error) {
+	switch pc.Type {
+	case "openai":
+		apiKey := pc.APIKey
+		if pc.APIKeyEnv != "" {
+			apiKey = os.Getenv(pc.APIKeyEnv)
+		}
+		return llm.NewOpenAI(llm.OpenAIConfig{
+			Name:        name,
+			BaseURL:     pc.BaseURL,
+			APIKey:      apiKey,
+			Model:       pc.Model,
+			Temperature: pc.Temperature,
+			Timeout:     time.Duration(pc.Timeout),
+		})
+	case "gemini-cli":
+		return llm.NewGeminiCLI(llm.GeminiCLIConfig{
+			Name:    name,
+			Command: pc.Command,
+			Args:    pc.Args,
+			Model:   pc.Model,
+			Timeout: time.Duration(pc.Timeout),
+		}), nil
+	case "echo":
+		fixtures := pc.Fixtures
+		if pc.FixturesFile != "" {
+			loaded, err := llm.LoadFixtures(pc.FixturesFile)
+			if err != nil {
+				return nil, err
+			}
+			for prompt, resp := range fixtures {
+				loaded[prompt] = resp
+			}
+			fixtures = loaded
+		}
+		return llm.NewEcho(name, fixtures), nil
+	default:
+		return nil, fmt.Errorf("llm: provider %q has unknown type %q", name, pc.Type)
+	}
+}
+
+// forMode returns the provider configured for mode, falling back to the default.
+func (r *llmRouter) forMode(mode string) LLMProvider {
+	if name, ok := r.modes[mode]; ok {
+		return r.providers[name]
+	}
+	return r.providers[r.defaultProvider]
+}
+
+// generateRequest is the body accepted by /generate.
+type generateRequest struct {
+	Prompt    string `json:"prompt" schema:"required,minLength=1"`
+	Mode      string `json:"mode" doc:"A mode mapped to a provider in the llm config; the default provider when empty"`
+	MaxTokens int    `json:"max_tokens" doc:"Upper bound on the reply; the provider's default when 0" schema:"min=0"`
+	SessionID string `json:"session_id" doc:"Conversation the exchange is stored under"`
+}
+
+// generationResult is what a completed generation returns to the caller.
+type generationResult struct {
+	Response  string    `json:"response"`
+	SessionID string    `json:"session_id"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model,omitempty"`
+	Mode      string    `json:"mode"`
+	Usage     llm.Usage `json:"usage"`
+}
+
+// generate runs a generation through the router and records the exchange in ch.
+// When onToken is non-nil the provider is used in streaming mode.
+func (app *SovereignApp) generate(ctx context.Context, in generateRequest, onToken llm.TokenFunc) (*generationResult, error) {
+	if in.SessionID == "" {
+		in.SessionID = fmt.Sprintf("gen-%d", time.Now().UnixNano())
+	}
+	provider := app.LLM.forMode(in.Mode)
+	req := llm.Request{
+		Prompt:    in.Prompt,
+		System:    app.LLM.systemPrompts[provider.Name()],
+		Mode:      in.Mode,
+		MaxTokens: in.MaxTokens,
+	}
+
+	eventData := map[string]string{"session_id": in.SessionID, "mode": in.Mode, "provider": provider.Name()}
+	app.publish("generate.start", "info", fmt.Sprintf("Generation started on %s", provider.Name()), eventData)
+
+	start := time.Now()
+	var (
+		resp *llm.Response
+		err  error
+	)
+	if onToken != nil {
+		// Tokens also reach GET /generate/stream subscribers, but are not
+		// kept for replay.
+		tokenData := map[string]string{"session_id": in.SessionID}
+		resp, err = provider.StreamGenerate(ctx, req, func(delta string) error {
+			app.Events.PublishLive("generate.token", "token", delta, tokenData)
+			return onToken(delta)
+		})
+	} else {
+		resp, err = provider.Generate(ctx, req)
+	}
+	if err != nil {
+		app.publish("generate.error", "alert", err.Error(), eventData)
+		return nil, err
+	}
+
+	if err := app.recordGeneration(in, resp, time.Since(start)); err != nil {
+		// The caller still gets the response; losing the transcript is logged, not fatal.
+		log.Printf("Failed to record generation for session %s: %v", in.SessionID, err)
+	}
+	result := &generationResult{
+		Response:  resp.Text,
+		SessionID: in.SessionID,
+		Provider:  resp.Provider,
+		Model:     resp.Model,
+		Mode:      in.Mode,
+		Usage:     resp.Usage,
+	}
+	app.publish("generate.done", "info", fmt.Sprintf("Generation finished (%d tokens)", resp.Usage.TotalTokens), result)
+	return result, nil
+}
+
+// recordGeneration stores the prompt ('input') and response ('output') in ch.
+func (app *SovereignApp) recordGeneration(in generateRequest, resp *llm.Response, elapsed time.Duration) error {
+	inputMeta, _ := json.Marshal(map[string]interface{}{
+		"source":        "generate",
+		"mode":          in.Mode,
+		"provider":      resp.Provider,
+		"prompt_tokens": resp.Usage.PromptTokens,
+	})
+	outputMeta, _ := json.Marshal(map[string]interface{}{
+		"source":            "generate",
+		"mode":              in.Mode,
+		"provider":          resp.Provider,
+		"model":             resp.Model,
+		"prompt_tokens":     resp.Usage.PromptTokens,
+		"completion_tokens": resp.Usage.CompletionTokens,
+		"total_tokens":      resp.Usage.TotalTokens,
+		"tokens_estimated":  resp.Usage.Estimated,
+		"finish_reason":     resp.FinishReason,
+		"duration_ms":       elapsed.Milliseconds(),
+	})
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO ch (session_id, type, content, metadata) VALUES (?, 'input', ?, ?)", in.SessionID, in.Prompt, string(inputMeta)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO ch (session_id, type, content, metadata) VALUES (?, 'output', ?, ?)", in.SessionID, resp.Text, string(outputMeta)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handleGenerate answers {prompt, mode, max_tokens, session_id} with the generated text.
+func (app *SovereignApp) handleGenerate(w http.ResponseWriter, r *http.Request) (any, error) {
+	var requestBody generateRequest
+	if err := api.DecodeJSON(r, &requestBody); err != nil {
+		return nil, err
+	}
+	if requestBody.Prompt == "" {
+		return nil, api.Errorf(api.CodeInvalidRequest, "prompt is required")
+	}
+
+	result, err := app.generate(r.Context(), requestBody, nil)
+	if err != nil {
+		return nil, generateError(r.Context(), err)
+	}
+	return result, nil
+}
+
+// generateError reports a failed generation as a backend failure, unless
+// the request itself timed out or went away. The backend's own message
+// stays in the server log.
+func generateError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return api.Wrap(api.CodeUpstream, err, "Generation failed")
+}
@@ -1,51 +1,68 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"flag" // Import the flag package
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	_ "github.com/mattn/go-sqlite3" // Still needed for database/sql
+
+	"sovereign-orchestrator/pkg/command"
 )
 
 //go:embed core_directives.txt
+//go:embed migrations/*.sql
 //go:embed scripts/*
 //go:embed sovereign-system.tar.gz
-//go:embed web/* // Embed web assets
-var embeddedFiles embed.FS
-
-const (
-	appName    = "sovereign"
-	dbFileName = "sovereign_memory.db"
-)
+//go:embed web/*
+var embeddedFiles embed.FS // Embedded directives, migrations, runtime bundle and web assets
 
 func main() {
-	var customDBPath string
+	var customDBPath, customConfigPath, tlsCert, tlsKey string
+	var listenAddrs listenFlags
 	flag.StringVar(&customDBPath, "db-path", "", "Path to an existing sovereign_memory.db file")
+	flag.StringVar(&customConfigPath, "config", "", "Path to config.json (default: ~/.sovereign/config.json)")
+	flag.Var(&listenAddrs, "listen", "Listen on `ADDR` instead of the configured listeners: HOST:PORT, tls:HOST:PORT or unix:PATH (repeatable or comma-separated)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Certificate for tls: listeners (default: self-signed)")
+	flag.StringVar(&tlsKey, "tls-key", "", "Private key for --tls-cert")
 	flag.Parse()
 
-	app, err := NewSovereignApp(customDBPath) // Pass customDBPath to NewSovereignApp
+	app, err := NewSovereignApp(customDBPath, customConfigPath)
 	if err != nil {
 		log.Fatalf("Failed to create SovereignApp: %v", err)
 	}
 	defer app.Close() // Ensure DB connection is closed
 
-	// Handle command-line arguments for specific actions
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case "init-guake":
-			app.initGuake()
-			return
-		case "bootstrap":
-			if err := app.bootstrap(); err != nil {
-				log.Fatalf("Bootstrap failed: %v", err)
+	if len(listenAddrs) > 0 {
+		var listeners []ListenerConfig
+		for _, s := range listenAddrs {
+			lc, err := parseListen(s, tlsCert, tlsKey)
+			if err != nil {
+				app.Close()
+				log.Fatalf("%v", err)
 			}
+			listeners = append(listeners, lc)
+		}
+		app.Config.Server.Listeners = listeners
+	}
+
+	// Handle subcommands (bootstrap, swrap, init-guake, ...)
+	if flag.NArg() > 0 {
+		err := app.commands().Dispatch(flag.Args())
+		if err == nil || command.IsHelp(err) {
 			return
-		case "swrap":
-			app.swrap(os.Args[2:])
-			return
 		}
+		app.Close()
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		log.Fatalf("%v", err)
 	}
 
 	// Default application startup
@@ -53,5 +70,15 @@ func main() {
 		log.Fatalf("Failed to initialize SovereignApp: %v", err)
 	}
 
-	app.Run()
+	// SIGINT or SIGTERM starts a graceful shutdown; a second one kills the
+	// process right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	if err := app.Run(ctx); err != nil {
+		app.Close()
+		log.Fatalf("%v", err)
+	}
+	// The deferred Close cancels background work, then closes the database.
 }
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/fsutil"
+	"sovereign-orchestrator/pkg/llm"
+)
+
+const (
+	vaultFileName     = "Sovereign_Log.md"
+	saveTimeout       = 2 * time.Minute
+	maxTranscriptSize = 24000 // Characters of ch history sent to the summarizer
+	maxRowChars       = 500   // Per-row cap inside the transcript
+	extractiveBullets = 10
+)
+
+const summaryPrompt = `Summarize the following activity log from the Sovereign system as a concise long-term memory entry.
+Cover what was attempted, what succeeded or failed, and anything that needs follow-up.
+Answer with short Markdown bullet points only.
+
+Activity log:
+`
+
+// validate rejects memory settings that cannot work.
+func (c MemoryConfig) validate() error {
+	switch c.Summarizer {
+	case "auto", "llm", "extractive":
+	default:
+		return fmt.Errorf("memory: unknown summarizer %q (want auto, llm or extractive)", c.Summarizer)
+	}
+	if c.MaxRows < 1 {
+		return fmt.Errorf("memory: max_rows must be at least 1, got %d", c.MaxRows)
+	}
+	return nil
+}
+
+// vaultPath returns the Markdown file memory saves are appended to.
+func (app *SovereignApp) vaultPath() string {
+	if app.Config.Memory.VaultPath != "" {
+		return app.Config.Memory.VaultPath
+	}
+	return filepath.Join(app.AppDir, "memory", vaultFileName)
+}
+
+// saveMetadata is stored as JSON in sovereign.metadata for every save.
+type saveMetadata struct {
+	Reason    string `json:"reason"`
+	Method    string `json:"method"` // "llm:<provider>" or "extractive"
+	Rows      int    `json:"rows"`   // ch rows included in the summary
+	NewRows   int    `json:"new_rows"`
+	FromID    int64  `json:"from_id"`
+	ToID      int64  `json:"to_id"` // Watermark: the next save starts after this ch id
+	VaultPath string `json:"vault_path"`
+	LLMError  string `json:"llm_error,omitempty"`
+}
+
+// memorySave is one saved summary as listed by /memory/saves.
+type memorySave struct {
+	ID        int64  `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Summary   string `json:"summary"`
+	saveMetadata
+}
+
+// chRow is the part of a ch row the summarizer reads.
+type chRow struct {
+	ID        int64
+	Timestamp string
+	SessionID string
+	Type      string
+	Content   string
+}
+
+// triggerSave runs the memory save protocol and reports the outcome on the
+// event bus. Failures are logged; they never stop the caller.
+func (app *SovereignApp) triggerSave(reason string) {
+	log.Printf(">>> TRIGGERING MEMORY SAVE (%s) <<<", reason)
+	app.writeMu.RLock()
+	defer app.writeMu.RUnlock()
+	ctx, cancel := context.WithTimeout(app.ctx, saveTimeout)
+	defer cancel()
+
+	save, err := app.saveMemory(ctx, reason)
+	switch {
+	case err != nil:
+		log.Printf("Memory save (%s) failed: %v", reason, err)
+		app.publish("save", "alert", "Memory save failed: "+err.Error(), map[string]string{"reason": reason})
+	case save == nil:
+		log.Printf("Memory save (%s): no new activity since the last save", reason)
+	default:
+		log.Printf("Memory save (%s): summarized %d entries into %s", reason, save.Rows, save.VaultPath)
+		app.publish("save", "info", fmt.Sprintf("Memory saved (%s): %d entries summarized", reason, save.Rows), save)
+	}
+}
+
+// saveMemory summarizes the ch rows added since the last save, appends the
+// summary to the vault and records it in the sovereign table. It returns nil
+// without saving when there is nothing new.
+func (app *SovereignApp) saveMemory(ctx context.Context, reason string) (*memorySave, error) {
+	app.saveMu.Lock()
+	defer app.saveMu.Unlock()
+
+	since, err := app.lastSaveWatermark()
+	if err != nil {
+		return nil, err
+	}
+	rows, total, err := app.chRowsSince(since, app.Config.Memory.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	summary, method, llmErr := app.summarizeRows(ctx, rows)
+	save := &memorySave{
+		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		Summary:   summary,
+		saveMetadata: saveMetadata{
+			Reason:    reason,
+			Method:    method,
+			Rows:      len(rows),
+			NewRows:   total,
+			FromID:    rows[0].ID,
+			ToID:      rows[len(rows)-1].ID,
+			VaultPath: app.vaultPath(),
+			LLMError:  llmErr,
+		},
+	}
+	meta, err := json.Marshal(save.saveMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// The row and the vault entry succeed or fail together: the vault is only
+	// written once the row is committed, and a failed write deletes the row
+	// again so the next save covers the same rows.
+	res, err := app.DB.ExecContext(ctx, "INSERT INTO sovereign (focus_area, entry_type, content, metadata) VALUES ('memory', 'save', ?, ?)", summary, string(meta))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record memory save: %w", err)
+	}
+	if save.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	if err := appendToVault(save.VaultPath, formatVaultEntry(save)); err != nil {
+		if _, delErr := app.DB.Exec("DELETE FROM sovereign WHERE id = ?", save.ID); delErr != nil {
+			log.Printf("Warning: failed to remove memory save %d after the vault write failed: %v", save.ID, delErr)
+		}
+		return nil, err
+	}
+	return save, nil
+}
+
+// lastSaveWatermark returns the newest ch id covered by a previous save, or 0.
+func (app *SovereignApp) lastSaveWatermark() (int64, error) {
+	var meta sql.NullString
+	err := app.DB.QueryRow("SELECT metadata FROM sovereign WHERE entry_type = 'save' ORDER BY id DESC LIMIT 1").Scan(&meta)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the last memory save: %w", err)
+	}
+	var m saveMetadata
+	if err := json.Unmarshal([]byte(meta.String), &m); err != nil {
+		// A hand-written save row without our metadata; start from the beginning.
+		return 0, nil
+	}
+	return m.ToID, nil
+}
+
+// chRowsSince returns up to limit of the oldest ch rows after id, oldest
+// first, together with the total number of rows after id. Rows beyond limit
+// are left for the next save, which starts after the last row returned.
+func (app *SovereignApp) chRowsSince(id int64, limit int) ([]chRow, int, error) {
+	var total int
+	if err := app.DB.QueryRow("SELECT COUNT(*) FROM ch WHERE id > ?", id).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count new ch rows: %w", err)
+	}
+	dbRows, err := app.DB.Query(`SELECT id, COALESCE(timestamp, ''), COALESCE(session_id, ''), COALESCE(type, ''), COALESCE(content, '')
+		FROM ch WHERE id > ? ORDER BY id ASC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read new ch rows: %w", err)
+	}
+	defer dbRows.Close()
+
+	var rows []chRow
+	for dbRows.Next() {
+		var r chRow
+		if err := dbRows.Scan(&r.ID, &r.Timestamp, &r.SessionID, &r.Type, &r.Content); err != nil {
+			return nil, 0, err
+		}
+		rows = append(rows, r)
+	}
+	if err := dbRows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return rows, total, nil
+}
+
+// summaryProvider returns the provider used for summaries, or nil when the
+// extractive summarizer should be used. In "auto" mode the echo backend does
+// not count, since echoing the log back is not a summary.
+func (app *SovereignApp) summaryProvider() LLMProvider {
+	cfg := app.Config.Memory
+	if cfg.Summarizer == "extractive" {
+		return nil
+	}
+	p := app.LLM.forMode(cfg.SummaryMode)
+	if cfg.Summarizer == "auto" && app.Config.LLM.Providers[p.Name()].Type == "echo" {
+		return nil
+	}
+	return p
+}
+
+// summarizeRows produces the summary text and names the method used. When the
+// LLM fails, the extractive summary is used and the error is returned for the record.
+func (app *SovereignApp) summarizeRows(ctx context.Context, rows []chRow) (summary, method, llmErr string) {
+	if p := app.summaryProvider(); p != nil {
+		resp, err := p.Generate(ctx, llm.Request{
+			Prompt: summaryPrompt + formatTranscript(rows),
+			System: app.LLM.systemPrompts[p.Name()],
+			Mode:   app.Config.Memory.SummaryMode,
+		})
+		if err == nil && strings.TrimSpace(resp.Text) != "" {
+			return strings.TrimSpace(resp.Text), "llm:" + p.Name(), ""
+		}
+		if err == nil {
+			err = errors.New("empty summary")
+		}
+		log.Printf("Memory save: LLM summary via %s failed, using extractive summary: %v", p.Name(), err)
+		llmErr = err.Error()
+	}
+	return extractiveSummary(rows), "extractive", llmErr
+}
+
+// formatTranscript renders rows for the summarizer, dropping the oldest rows
+// when the transcript would exceed maxTranscriptSize.
+func formatTranscript(rows []chRow) string {
+	lines := make([]string, 0, len(rows))
+	size := 0
+	for i := len(rows) - 1; i >= 0; i-- {
+		r := rows[i]
+		line := fmt.Sprintf("[%s] (%s) %s", r.Timestamp, r.Type, clip(r.Content, maxRowChars))
+		if size+len(line) > maxTranscriptSize && len(lines) > 0 {
+			break
+		}
+		size += len(line) + 1
+		lines = append(lines, line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// extractiveSummary builds a summary without an LLM: counts by type and
+// session, followed by the most recent distinct entries.
+func extractiveSummary(rows []chRow) string {
+	types := make(map[string]int)
+	var typeOrder []string
+	sessions := make(map[string]bool)
+	for _, r := range rows {
+		if types[r.Type] == 0 {
+			typeOrder = append(typeOrder, r.Type)
+		}
+		types[r.Type]++
+		if r.SessionID != "" {
+			sessions[r.SessionID] = true
+		}
+	}
+	counts := make([]string, 0, len(typeOrder))
+	for _, t := range typeOrder {
+		name := t
+		if name == "" {
+			name = "untyped"
+		}
+		counts = append(counts, fmt.Sprintf("%d %s", types[t], name))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "- %d entries across %d session(s): %s\n", len(rows), len(sessions), strings.Join(counts, ", "))
+
+	seen := make(map[string]bool)
+	var picked []string
+	for i := len(rows) - 1; i >= 0 && len(picked) < extractiveBullets; i-- {
+		text := clip(strings.Join(strings.Fields(rows[i].Content), " "), 160)
+		if text == "" || seen[text] {
+			continue
+		}
+		seen[text] = true
+		picked = append(picked, fmt.Sprintf("- %s: %s", rows[i].Type, text))
+	}
+	for i := len(picked) - 1; i >= 0; i-- {
+		b.WriteString(picked[i])
+		b.WriteByte('\n')
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// clip shortens s to at most n runes, marking the cut.
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// formatVaultEntry renders one save as a Markdown section.
+func formatVaultEntry(s *memorySave) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s — %s\n\n", s.Timestamp, s.Reason)
+	fmt.Fprintf(&b, "_%d entries (ch #%d–#%d), summarized by %s_\n\n", s.Rows, s.FromID, s.ToID, s.Method)
+	b.WriteString(s.Summary)
+	b.WriteString("\n\n")
+	return b.String()
+}
+
+// appendToVault adds entry to the vault file. The whole file is rewritten and
+// renamed into place so a crash never leaves a half-written entry behind.
+func appendToVault(path, entry string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create vault directory: %w", err)
+	}
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		existing = []byte("# Sovereign Memory Vault\n\n")
+	} else if err != nil {
+		return fmt.Errorf("failed to read vault %s: %w", path, err)
+	}
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		existing = append(existing, '\n')
+	}
+	return fsutil.WriteFileAtomic(path, append(existing, entry...), 0644)
+}
+
+// handleMemorySaves lists saved summaries, newest first (GET, ?limit=), or
+// runs a save immediately (POST {"reason": "..."}).
+func (app *SovereignApp) handleMemorySaves(w http.ResponseWriter, r *http.Request) (any, error) {
+	if r.Method == "POST" {
+		return app.handleManualSave(w, r)
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return nil, api.Errorf(api.CodeInvalidRequest, "limit must be between 1 and 500")
+		}
+		limit = n
+	}
+
+	rows, err := app.DB.QueryContext(r.Context(), `SELECT id, COALESCE(timestamp, ''), COALESCE(content, ''), COALESCE(metadata, '')
+		FROM sovereign WHERE entry_type = 'save' ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query saves: %w", err)
+	}
+	defer rows.Close()
+
+	saves := []memorySave{}
+	for rows.Next() {
+		var (
+			s    memorySave
+			meta string
+		)
+		if err := rows.Scan(&s.ID, &s.Timestamp, &s.Summary, &meta); err != nil {
+			return nil, fmt.Errorf("failed to read saves: %w", err)
+		}
+		json.Unmarshal([]byte(meta), &s.saveMetadata) // Rows written by hand may lack metadata
+		saves = append(saves, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read saves: %w", err)
+	}
+	return saves, nil
+}
+
+// memorySavesParams holds the parameters of GET /memory/saves.
+type memorySavesParams struct {
+	Limit int `query:"limit" doc:"Saves to list; 50 when omitted" schema:"min=1,max=500"`
+}
+
+// manualSaveRequest is the optional body of POST /memory/saves.
+type manualSaveRequest struct {
+	Reason string `json:"reason" doc:"Recorded with the save; Manual when empty"`
+}
+
+// skippedSaveResponse answers POST /memory/saves when there was nothing new.
+type skippedSaveResponse struct {
+	Status  string `json:"status" schema:"enum=skipped"`
+	Message string `json:"message"`
+}
+
+func (app *SovereignApp) handleManualSave(w http.ResponseWriter, r *http.Request) (any, error) {
+	var requestBody manualSaveRequest
+	if r.ContentLength != 0 {
+		if err := api.DecodeJSON(r, &requestBody); err != nil {
+			return nil, err
+		}
+	}
+	if requestBody.Reason == "" {
+		requestBody.Reason = "Manual"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), saveTimeout)
+	defer cancel()
+	save, err := app.saveMemory(ctx, requestBody.Reason)
+	if err != nil {
+		return nil, fmt.Errorf("memory save failed: %w", err)
+	}
+	if save == nil {
+		return skippedSaveResponse{Status: "skipped", Message: "No new activity since the last save"}, nil
+	}
+	app.publish("save", "info", fmt.Sprintf("Memory saved (%s): %d entries summarized", save.Reason, save.Rows), save)
+	return save, nil
+}
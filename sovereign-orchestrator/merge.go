@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/dbcopy"
+	"sovereign-orchestrator/pkg/dbmerge"
+)
+
+// copyDatabaseRequest is the body of /api/copy_database.
+type copyDatabaseRequest struct {
+	Source string `json:"source" doc:"Registered database; the memory database when empty"`
+	Target string `json:"target" doc:"Name of the new database" schema:"required,minLength=1,maxLength=64"`
+}
+
+// handleAPICopyDatabase copies a database to a new name with the online
+// backup API, so the copy is consistent even while the source is written.
+func (app *SovereignApp) handleAPICopyDatabase(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req copyDatabaseRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if err := app.refuseSealedCopy(req.Source, "copying it"); err != nil {
+		return nil, err
+	}
+	src, err := app.Databases.Get(r.Context(), req.Source)
+	if err != nil {
+		return nil, err
+	}
+	info, err := app.Databases.Create(r.Context(), req.Target, func(ctx context.Context, dst *sql.DB) error {
+		return dbcopy.Backup(ctx, dst, src, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.Source == "" {
+		req.Source = app.Databases.PrimaryName()
+	}
+	app.publish("databases", "info", fmt.Sprintf("Copied database %s to %s", req.Source, req.Target), info)
+	return api.Created(databaseResponse{Status: "copied", Source: req.Source, Database: info}), nil
+}
+
+// mergeRequest is the body of /api/merge_databases. Merges are dry runs
+// unless dry_run is explicitly false.
+type mergeRequest struct {
+	Source string         `json:"source" schema:"required,minLength=1"`
+	Target string         `json:"target" doc:"Registered database; the memory database when empty"`
+	Policy dbmerge.Policy `json:"policy" doc:"What to do with conflicting rows; keep-newest when empty" schema:"enum=|keep-newest|keep-both|fail"`
+	DryRun *bool          `json:"dry_run" doc:"Only report what would change; true unless false is given"`
+	Tables []string       `json:"tables" doc:"Tables to merge; all shared tables when empty"`
+}
+
+// mergeResponse answers /api/merge_databases.
+type mergeResponse struct {
+	Status string          `json:"status" schema:"enum=dry_run|merged"`
+	Report *dbmerge.Report `json:"report"`
+}
+
+// handleAPIMergeDatabases unions the rows of source into target and returns
+// a per-table report of new, duplicate and conflicting rows.
+func (app *SovereignApp) handleAPIMergeDatabases(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req mergeRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Target == "" {
+		req.Target = app.Databases.PrimaryName()
+	}
+	if req.Source == "" || req.Source == req.Target {
+		return nil, api.Errorf(api.CodeInvalidRequest, "source must name a database other than the target")
+	}
+	if req.Target != app.Databases.PrimaryName() {
+		if err := app.refuseSealedCopy(req.Source, "merging it into another database"); err != nil {
+			return nil, err
+		}
+	}
+	sourcePath, err := app.Databases.Path(req.Source)
+	if err == nil {
+		_, err = app.Databases.Stat(req.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	target, err := app.Databases.Get(r.Context(), req.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := req.DryRun == nil || *req.DryRun
+	report, err := dbmerge.Merge(r.Context(), target, sourcePath, dbmerge.Options{
+		Policy: req.Policy,
+		DryRun: dryRun,
+		Tables: req.Tables,
+	})
+	if report != nil {
+		report.Source, report.Target = req.Source, req.Target
+	}
+	if err != nil && report == nil {
+		return nil, err
+	}
+	if err != nil {
+		// The fail policy found conflicts; the report says where.
+		log.Printf("Merge of %s into %s aborted: %v", req.Source, req.Target, err)
+		return nil, api.Errorf(api.CodeConflict, "%s", err).WithDetails(report)
+	}
+	if report.Unresolved > 0 {
+		log.Printf("Merge of %s into %s left %d conflict(s) unresolved: keep-newest needs a timestamp column", req.Source, req.Target, report.Unresolved)
+	}
+	if report.Committed {
+		app.publish("databases", "info", fmt.Sprintf("Merged %s into %s: %d inserted, %d updated", req.Source, req.Target, report.Inserted, report.Updated), report)
+	}
+	status := "dry_run"
+	if report.Committed {
+		status = "merged"
+	}
+	return mergeResponse{Status: status, Report: report}, nil
+}
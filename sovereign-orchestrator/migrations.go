@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/command"
+	"sovereign-orchestrator/pkg/migrate"
+	"sovereign-orchestrator/pkg/search"
+)
+
+// migrationsDir is the embedded directory holding the numbered schema migrations.
+const migrationsDir = "migrations"
+
+// searchMigration creates the FTS5 search indexes and their triggers.
+const searchMigration = 6
+
+// migrator returns a Migrator for the open database using the embedded migrations.
+func (app *SovereignApp) migrator() (*migrate.Migrator, error) {
+	migrations, err := schemaMigrations(app.ctx, app.DB)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(app.DB, migrations), nil
+}
+
+// schemaMigrations loads the embedded migrations for db. Without FTS5 the
+// search migration is marked blocked: it and every later migration stay
+// pending until a binary built with -tags sqlite_fts5 (the default of the
+// Makefile) opens the database, so they are never applied out of order. A
+// database that already has search indexes is refused, since their
+// triggers would fail every write.
+func schemaMigrations(ctx context.Context, db *sql.DB) ([]migrate.Migration, error) {
+	migrations, err := migrate.Load(embeddedFiles, migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := search.Available(ctx, db)
+	if err != nil || ok {
+		return migrations, err
+	}
+	indexed, err := search.HasIndexes(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if indexed {
+		return nil, errors.New("the database has full-text search indexes, which this binary cannot update: it was built without SQLite FTS5; rebuild with make, or go build -tags sqlite_fts5")
+	}
+	for i := range migrations {
+		if migrations[i].Version == searchMigration {
+			migrations[i].Blocked = "needs SQLite FTS5, which this binary was built without (build with make, or go build -tags sqlite_fts5)"
+		}
+	}
+	return migrations, nil
+}
+
+// applyMigrations verifies the schema history and applies any pending migrations.
+// Databases that already hold data are backed up before they are upgraded,
+// unless backupFirst is false because the caller already holds a copy.
+func (app *SovereignApp) applyMigrations(backupFirst bool) error {
+	m, err := app.migrator()
+	if err != nil {
+		return err
+	}
+	if err := m.Verify(app.ctx); err != nil {
+		return err
+	}
+	pending, err := m.Pending(app.ctx)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	if pending[0].Blocked != "" {
+		log.Printf("Warning: %v", migrate.BlockedError(pending[0]))
+		return nil
+	}
+
+	if backupFirst {
+		if err := app.backupBeforeMigrate(m); err != nil {
+			return err
+		}
+	}
+
+	applied, err := m.Up(app.ctx, 0)
+	for _, mig := range applied {
+		log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+	}
+	if errors.Is(err, migrate.ErrBlocked) {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return err
+}
+
+// backupBeforeMigrate backs up an existing database into the backup store
+// before pending migrations run, so the copy is sealed like any other backup
+// of an encrypted database and pruned with the scheduled ones. Fresh and
+// in-memory databases are skipped.
+func (app *SovereignApp) backupBeforeMigrate(m *migrate.Migrator) error {
+	if app.DBPath == ":memory:" || strings.HasPrefix(app.DBPath, "file::memory:") {
+		return nil
+	}
+	var tables int
+	if err := app.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_versions'").Scan(&tables); err != nil {
+		return fmt.Errorf("failed to inspect database before migrating: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	current, err := m.Current(app.ctx)
+	if err != nil {
+		return err
+	}
+	b, err := app.runBackup(app.ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+	log.Printf("Database at version %d backed up to %s before applying migrations", current, filepath.Join(app.backupDir(), b.File))
+	return nil
+}
+
+// dbCommands builds the "db" subcommand tree.
+func (app *SovereignApp) dbCommands() *command.Registry {
+	migrateCmds := command.NewRegistry("migrate")
+	migrateCmds.Register(&command.Subcommand{
+		Name:    "status",
+		Summary: "Show applied and pending migrations",
+		Run:     app.cmdMigrateStatus,
+	})
+	migrateCmds.Register(&command.Subcommand{
+		Name:    "up",
+		Usage:   "up [flags]",
+		Summary: "Apply pending migrations",
+		Flags: func(fs *flag.FlagSet) {
+			fs.Int("to", 0, "Apply migrations up to this version (default: latest)")
+			fs.Bool("no-backup", false, "Skip the pre-migration backup of an existing database")
+		},
+		Run: app.cmdMigrateUp,
+	})
+	migrateCmds.Register(&command.Subcommand{
+		Name:    "down",
+		Usage:   "down [flags]",
+		Summary: "Roll back the most recent migrations",
+		Flags: func(fs *flag.FlagSet) {
+			fs.Int("steps", 1, "Number of migrations to roll back")
+		},
+		Run: app.cmdMigrateDown,
+	})
+
+	db := command.NewRegistry("db")
+	db.Register(&command.Subcommand{
+		Name:    "migrate",
+		Usage:   "migrate <status|up|down> [flags]",
+		Summary: "Inspect and apply schema migrations",
+		Sub:     migrateCmds,
+	})
+	app.backupCommands(db)
+	app.encryptionCommands(db)
+	return db
+}
+
+// openForMigrate opens the database without applying migrations automatically.
+func (app *SovereignApp) openForMigrate() (*migrate.Migrator, error) {
+	if err := app.openDatabase(); err != nil {
+		return nil, err
+	}
+	return app.migrator()
+}
+
+func (app *SovereignApp) cmdMigrateStatus(cmd *command.Command) error {
+	m, err := app.openForMigrate()
+	if err != nil {
+		return err
+	}
+	statuses, err := m.Status(app.ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n\n", app.DBPath)
+	for _, st := range statuses {
+		state := "pending"
+		switch {
+		case st.Blocked != "":
+			state = "BLOCKED: " + st.Blocked
+		case st.Unknown:
+			state = "UNKNOWN (newer binary?)"
+		case st.Modified:
+			state = "MODIFIED since applied"
+		case st.Applied:
+			state = "applied " + st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("  %04d  %-32s %s\n", st.Version, st.Name, state)
+	}
+	return nil
+}
+
+func (app *SovereignApp) cmdMigrateUp(cmd *command.Command) error {
+	target, err := cmd.Int("to")
+	if err != nil {
+		return err
+	}
+	m, err := app.openForMigrate()
+	if err != nil {
+		return err
+	}
+	if err := m.Verify(app.ctx); err != nil {
+		return err
+	}
+	pending, err := m.Pending(app.ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 || (target > 0 && pending[0].Version > target) {
+		fmt.Println("Database is up to date.")
+		return nil
+	}
+	if pending[0].Blocked != "" {
+		return migrate.BlockedError(pending[0])
+	}
+	if !cmd.Bool("no-backup") {
+		if err := app.backupBeforeMigrate(m); err != nil {
+			return err
+		}
+	}
+
+	applied, err := m.Up(app.ctx, target)
+	for _, mig := range applied {
+		fmt.Printf("Applied %04d_%s\n", mig.Version, mig.Name)
+	}
+	return err
+}
+
+func (app *SovereignApp) cmdMigrateDown(cmd *command.Command) error {
+	steps, err := cmd.Int("steps")
+	if err != nil {
+		return err
+	}
+	if steps < 1 {
+		return errors.New("--steps must be at least 1")
+	}
+	m, err := app.openForMigrate()
+	if err != nil {
+		return err
+	}
+
+	reverted, err := m.Down(app.ctx, steps)
+	for _, mig := range reverted {
+		fmt.Printf("Rolled back %04d_%s\n", mig.Version, mig.Name)
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created before versioned
+-- migrations existed (including memory_daemon.py databases) adopt it in place.
+CREATE TABLE IF NOT EXISTS ch (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, session_id TEXT, type TEXT, content TEXT, metadata TEXT);
+CREATE TABLE IF NOT EXISTS vs (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, component TEXT, version TEXT, changelog TEXT);
+CREATE TABLE IF NOT EXISTS user_context (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, category TEXT, key TEXT, value TEXT, context TEXT);
+CREATE TABLE IF NOT EXISTS sovereign (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, focus_area TEXT, entry_type TEXT, content TEXT, metadata TEXT);
+CREATE TABLE IF NOT EXISTS evolution (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, milestone TEXT, description TEXT, growth_index REAL DEFAULT 1.0);
+CREATE TABLE IF NOT EXISTS prime_directives (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, directive TEXT UNIQUE, description TEXT);
+CREATE TABLE IF NOT EXISTS philosophy (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, topic TEXT, insight TEXT);
+CREATE TABLE IF NOT EXISTS technologies (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, topic TEXT, key TEXT, value TEXT, success_rate REAL DEFAULT 1.0);
+CREATE TABLE IF NOT EXISTS jon (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, category TEXT, key TEXT, value TEXT, context TEXT);
@@ -0,0 +1,10 @@
+-- Ghost Mode settings edited through /autonomy/config. A single row (id = 1);
+-- when it is absent the built-in defaults apply.
+CREATE TABLE autonomy_config (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    enabled INTEGER NOT NULL,
+    base_interval INTEGER NOT NULL,      -- Seconds between cycles
+    max_load_threshold REAL NOT NULL,    -- CPU/RAM percentage
+    save_interval INTEGER NOT NULL,      -- Seconds between periodic memory saves
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
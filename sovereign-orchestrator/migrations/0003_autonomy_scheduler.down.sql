@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS autonomy_cycles;
+ALTER TABLE autonomy_config DROP COLUMN quiet_hours_end;
+ALTER TABLE autonomy_config DROP COLUMN quiet_hours_start;
+ALTER TABLE autonomy_config DROP COLUMN max_backoff;
+ALTER TABLE autonomy_config DROP COLUMN jitter;
@@ -0,0 +1,21 @@
+-- Adaptive scheduling settings for Ghost Mode and a log of every cycle's
+-- sense/intent/act decision.
+ALTER TABLE autonomy_config ADD COLUMN jitter REAL NOT NULL DEFAULT 0.1;
+ALTER TABLE autonomy_config ADD COLUMN max_backoff INTEGER NOT NULL DEFAULT 1800;
+ALTER TABLE autonomy_config ADD COLUMN quiet_hours_start TEXT NOT NULL DEFAULT '';
+ALTER TABLE autonomy_config ADD COLUMN quiet_hours_end TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE autonomy_cycles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+    outcome TEXT NOT NULL,    -- "acted", "backoff" or "quiet"
+    sense TEXT,               -- What the cycle observed
+    intent TEXT,              -- What it decided to do
+    act TEXT,                 -- What it actually did
+    cpu_load REAL,
+    ram_load REAL,
+    backoff_level INTEGER NOT NULL DEFAULT 0,
+    next_delay_ms INTEGER,
+    duration_ms INTEGER
+);
+CREATE INDEX idx_autonomy_cycles_timestamp ON autonomy_cycles (timestamp);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ingest_files;
+DROP INDEX IF EXISTS idx_ch_message_id;
+UPDATE ch SET metadata = json_set(json_remove(metadata, '$.duplicate_of'), '$.id', json_extract(metadata, '$.duplicate_of'))
+WHERE json_valid(metadata) AND json_extract(metadata, '$.duplicate_of') IS NOT NULL;
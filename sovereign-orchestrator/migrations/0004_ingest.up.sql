@@ -0,0 +1,27 @@
+-- Session ingestion: enforce one ch row per source message id (kept in
+-- metadata) with a unique index, so re-reading a session file never inserts a
+-- message twice. No rows are deleted: later copies of a message already in ch
+-- have their id moved to $.duplicate_of, which the index ignores, so they can
+-- be reviewed and removed by hand.
+UPDATE ch SET metadata = json_set(json_remove(metadata, '$.id'), '$.duplicate_of', json_extract(metadata, '$.id'))
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY json_extract(metadata, '$.id') ORDER BY id) AS copy
+        FROM ch
+        WHERE json_valid(metadata) AND json_extract(metadata, '$.id') IS NOT NULL
+    ) WHERE copy > 1
+);
+
+CREATE UNIQUE INDEX idx_ch_message_id ON ch (json_extract(metadata, '$.id'))
+    WHERE json_valid(metadata) AND json_extract(metadata, '$.id') IS NOT NULL;
+
+-- One row per session file, so unchanged files are skipped on restart.
+CREATE TABLE ingest_files (
+    path TEXT PRIMARY KEY,
+    session_id TEXT,
+    size INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,          -- Unix nanoseconds
+    messages INTEGER NOT NULL,       -- Messages in the file when last read
+    inserted INTEGER NOT NULL DEFAULT 0, -- ch rows this file has contributed in total
+    ingested_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
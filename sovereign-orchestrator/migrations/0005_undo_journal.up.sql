@@ -0,0 +1,14 @@
+-- Rows removed through /api/delete_rows are copied here first so the delete
+-- can be undone with its token until expires_at.
+CREATE TABLE IF NOT EXISTS undo_journal (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    row_key INTEGER,
+    row_data TEXT NOT NULL,
+    deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    undone_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_undo_journal_token ON undo_journal (token);
+CREATE INDEX IF NOT EXISTS idx_undo_journal_expires_at ON undo_journal (expires_at);
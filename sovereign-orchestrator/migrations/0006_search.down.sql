@@ -0,0 +1,24 @@
+DROP TRIGGER IF EXISTS ch_fts_ai;
+DROP TRIGGER IF EXISTS ch_fts_ad;
+DROP TRIGGER IF EXISTS ch_fts_au;
+DROP TABLE IF EXISTS ch_fts;
+DROP TRIGGER IF EXISTS jon_fts_ai;
+DROP TRIGGER IF EXISTS jon_fts_ad;
+DROP TRIGGER IF EXISTS jon_fts_au;
+DROP TABLE IF EXISTS jon_fts;
+DROP TRIGGER IF EXISTS user_context_fts_ai;
+DROP TRIGGER IF EXISTS user_context_fts_ad;
+DROP TRIGGER IF EXISTS user_context_fts_au;
+DROP TABLE IF EXISTS user_context_fts;
+DROP TRIGGER IF EXISTS sovereign_fts_ai;
+DROP TRIGGER IF EXISTS sovereign_fts_ad;
+DROP TRIGGER IF EXISTS sovereign_fts_au;
+DROP TABLE IF EXISTS sovereign_fts;
+DROP TRIGGER IF EXISTS philosophy_fts_ai;
+DROP TRIGGER IF EXISTS philosophy_fts_ad;
+DROP TRIGGER IF EXISTS philosophy_fts_au;
+DROP TABLE IF EXISTS philosophy_fts;
+DROP TRIGGER IF EXISTS technologies_fts_ai;
+DROP TRIGGER IF EXISTS technologies_fts_ad;
+DROP TRIGGER IF EXISTS technologies_fts_au;
+DROP TABLE IF EXISTS technologies_fts;
@@ -0,0 +1,81 @@
+-- Full-text search over the memory tables. Each *_fts table is an
+-- external-content FTS5 index over its source table: only the index is
+-- stored, and the triggers below keep it in step with every insert, update
+-- and delete. Requires a binary built with -tags sqlite_fts5.
+CREATE VIRTUAL TABLE ch_fts USING fts5(content, content='ch', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER ch_fts_ai AFTER INSERT ON ch BEGIN
+    INSERT INTO ch_fts (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER ch_fts_ad AFTER DELETE ON ch BEGIN
+    INSERT INTO ch_fts (ch_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER ch_fts_au AFTER UPDATE ON ch BEGIN
+    INSERT INTO ch_fts (ch_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO ch_fts (rowid, content) VALUES (new.id, new.content);
+END;
+INSERT INTO ch_fts (ch_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE jon_fts USING fts5(category, key, value, context, content='jon', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER jon_fts_ai AFTER INSERT ON jon BEGIN
+    INSERT INTO jon_fts (rowid, category, key, value, context) VALUES (new.id, new.category, new.key, new.value, new.context);
+END;
+CREATE TRIGGER jon_fts_ad AFTER DELETE ON jon BEGIN
+    INSERT INTO jon_fts (jon_fts, rowid, category, key, value, context) VALUES ('delete', old.id, old.category, old.key, old.value, old.context);
+END;
+CREATE TRIGGER jon_fts_au AFTER UPDATE ON jon BEGIN
+    INSERT INTO jon_fts (jon_fts, rowid, category, key, value, context) VALUES ('delete', old.id, old.category, old.key, old.value, old.context);
+    INSERT INTO jon_fts (rowid, category, key, value, context) VALUES (new.id, new.category, new.key, new.value, new.context);
+END;
+INSERT INTO jon_fts (jon_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE user_context_fts USING fts5(category, key, value, context, content='user_context', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER user_context_fts_ai AFTER INSERT ON user_context BEGIN
+    INSERT INTO user_context_fts (rowid, category, key, value, context) VALUES (new.id, new.category, new.key, new.value, new.context);
+END;
+CREATE TRIGGER user_context_fts_ad AFTER DELETE ON user_context BEGIN
+    INSERT INTO user_context_fts (user_context_fts, rowid, category, key, value, context) VALUES ('delete', old.id, old.category, old.key, old.value, old.context);
+END;
+CREATE TRIGGER user_context_fts_au AFTER UPDATE ON user_context BEGIN
+    INSERT INTO user_context_fts (user_context_fts, rowid, category, key, value, context) VALUES ('delete', old.id, old.category, old.key, old.value, old.context);
+    INSERT INTO user_context_fts (rowid, category, key, value, context) VALUES (new.id, new.category, new.key, new.value, new.context);
+END;
+INSERT INTO user_context_fts (user_context_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE sovereign_fts USING fts5(focus_area, entry_type, content, content='sovereign', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER sovereign_fts_ai AFTER INSERT ON sovereign BEGIN
+    INSERT INTO sovereign_fts (rowid, focus_area, entry_type, content) VALUES (new.id, new.focus_area, new.entry_type, new.content);
+END;
+CREATE TRIGGER sovereign_fts_ad AFTER DELETE ON sovereign BEGIN
+    INSERT INTO sovereign_fts (sovereign_fts, rowid, focus_area, entry_type, content) VALUES ('delete', old.id, old.focus_area, old.entry_type, old.content);
+END;
+CREATE TRIGGER sovereign_fts_au AFTER UPDATE ON sovereign BEGIN
+    INSERT INTO sovereign_fts (sovereign_fts, rowid, focus_area, entry_type, content) VALUES ('delete', old.id, old.focus_area, old.entry_type, old.content);
+    INSERT INTO sovereign_fts (rowid, focus_area, entry_type, content) VALUES (new.id, new.focus_area, new.entry_type, new.content);
+END;
+INSERT INTO sovereign_fts (sovereign_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE philosophy_fts USING fts5(topic, insight, content='philosophy', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER philosophy_fts_ai AFTER INSERT ON philosophy BEGIN
+    INSERT INTO philosophy_fts (rowid, topic, insight) VALUES (new.id, new.topic, new.insight);
+END;
+CREATE TRIGGER philosophy_fts_ad AFTER DELETE ON philosophy BEGIN
+    INSERT INTO philosophy_fts (philosophy_fts, rowid, topic, insight) VALUES ('delete', old.id, old.topic, old.insight);
+END;
+CREATE TRIGGER philosophy_fts_au AFTER UPDATE ON philosophy BEGIN
+    INSERT INTO philosophy_fts (philosophy_fts, rowid, topic, insight) VALUES ('delete', old.id, old.topic, old.insight);
+    INSERT INTO philosophy_fts (rowid, topic, insight) VALUES (new.id, new.topic, new.insight);
+END;
+INSERT INTO philosophy_fts (philosophy_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE technologies_fts USING fts5(topic, key, value, content='technologies', content_rowid='id', tokenize='porter unicode61 remove_diacritics 2');
+CREATE TRIGGER technologies_fts_ai AFTER INSERT ON technologies BEGIN
+    INSERT INTO technologies_fts (rowid, topic, key, value) VALUES (new.id, new.topic, new.key, new.value);
+END;
+CREATE TRIGGER technologies_fts_ad AFTER DELETE ON technologies BEGIN
+    INSERT INTO technologies_fts (technologies_fts, rowid, topic, key, value) VALUES ('delete', old.id, old.topic, old.key, old.value);
+END;
+CREATE TRIGGER technologies_fts_au AFTER UPDATE ON technologies BEGIN
+    INSERT INTO technologies_fts (technologies_fts, rowid, topic, key, value) VALUES ('delete', old.id, old.topic, old.key, old.value);
+    INSERT INTO technologies_fts (rowid, topic, key, value) VALUES (new.id, new.topic, new.key, new.value);
+END;
+INSERT INTO technologies_fts (technologies_fts) VALUES ('rebuild');
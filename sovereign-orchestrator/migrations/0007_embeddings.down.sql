@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS ch_embeddings_ad;
+DROP TRIGGER IF EXISTS ch_embeddings_au;
+DROP TRIGGER IF EXISTS jon_embeddings_ad;
+DROP TRIGGER IF EXISTS jon_embeddings_au;
+DROP TABLE IF EXISTS embedding_lists;
+DROP TABLE IF EXISTS embeddings;
@@ -0,0 +1,38 @@
+-- Vector memory: one embedding per source row and model. Vectors are
+-- little-endian float32 blobs normalized to unit length, so cosine
+-- similarity is a dot product.
+CREATE TABLE embeddings (
+    source_table TEXT NOT NULL,
+    source_id INTEGER NOT NULL,
+    model TEXT NOT NULL,
+    vector BLOB NOT NULL,
+    list INTEGER,                    -- IVF list (embedding_lists.id), NULL until indexed
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (source_table, source_id, model)
+);
+CREATE INDEX idx_embeddings_model_list ON embeddings (model, list);
+
+-- IVF centroids per model, rebuilt with k-means as the store grows.
+CREATE TABLE embedding_lists (
+    model TEXT NOT NULL,
+    id INTEGER NOT NULL,
+    centroid BLOB NOT NULL,
+    size INTEGER NOT NULL,           -- Vectors assigned when the index was built
+    built_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (model, id)
+);
+
+-- Deleted rows lose their vectors; edited rows lose them too and are
+-- embedded again on the next pass.
+CREATE TRIGGER ch_embeddings_ad AFTER DELETE ON ch BEGIN
+    DELETE FROM embeddings WHERE source_table = 'ch' AND source_id = old.id;
+END;
+CREATE TRIGGER ch_embeddings_au AFTER UPDATE OF content ON ch BEGIN
+    DELETE FROM embeddings WHERE source_table = 'ch' AND source_id = old.id;
+END;
+CREATE TRIGGER jon_embeddings_ad AFTER DELETE ON jon BEGIN
+    DELETE FROM embeddings WHERE source_table = 'jon' AND source_id = old.id;
+END;
+CREATE TRIGGER jon_embeddings_au AFTER UPDATE OF category, key, value, context ON jon BEGIN
+    DELETE FROM embeddings WHERE source_table = 'jon' AND source_id = old.id;
+END;
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/openapi"
+)
+
+// apiVersion is the version of the API in the OpenAPI document. It changes
+// when a route or a schema changes incompatibly.
+const apiVersion = "1.0.0"
+
+// endpoint describes a route for the API document. Go types are given as
+// zero values; their JSON Schemas document the route and validate its
+// request bodies. Routes without a summary are left out of the document.
+type endpoint struct {
+	tag          string // Section of the docs page
+	summary      string
+	description  string
+	query        any  // Struct whose query-tagged fields are the parameters
+	body         any  // JSON request body, or the multipart form of an upload route
+	optionalBody bool // An empty body is accepted too
+	resp         any  // Data of the success envelope; see either
+	status       int  // Status of a success, when not 200 OK
+	events       any  // Data of the server-sent events of a stream route
+	planned      bool // Declared but not implemented yet: answers 501
+}
+
+// alternatives is the data of a route that answers with one of several types.
+type alternatives []any
+
+// either describes data that is one of the given types.
+func either(types ...any) alternatives { return types }
+
+// apiTags introduces the sections of the docs page, in order.
+var apiTags = []openapi.Tag{
+	{Name: "Auth", Description: "Sign-in and sessions. Other routes take the session cookie or a bearer token from `sovereign token create`; changes made with the cookie must echo the CSRF token in the X-CSRF-Token header."},
+	{Name: "System", Description: "Health and host information."},
+	{Name: "Files", Description: "Uploads and the tools that read them."},
+	{Name: "Generation", Description: "Text generation through the configured LLM providers."},
+	{Name: "Events", Description: "Server-sent event streams of the event bus."},
+	{Name: "Autonomy", Description: "Ghost Mode status and configuration."},
+	{Name: "Memory", Description: "Saved summaries, ingestion and semantic recall."},
+	{Name: "Databases", Description: "Registered databases, their tables and rows."},
+	{Name: "Search", Description: "Full-text search over the memory tables."},
+	{Name: "Archives", Description: "Archived rows, tables and database snapshots."},
+	{Name: "Backups", Description: "Backups of the memory database."},
+	{Name: "Training", Description: "Dataset exports for fine-tuning."},
+	{Name: "Analysis", Description: "Tools of the GUIs that are declared but not built yet."},
+}
+
+// apiSpec is the OpenAPI document of the route table, together with the
+// schemas request bodies are checked against.
+type apiSpec struct {
+	gen    *openapi.Generator
+	doc    *openapi.Document
+	json   []byte
+	bodies map[string]*openapi.Schema // JSON request bodies, by route pattern
+}
+
+// newAPISpec describes the protected and public routes.
+func newAPISpec(routes, public []route) (*apiSpec, error) {
+	s := &apiSpec{gen: openapi.NewGenerator(), bodies: map[string]*openapi.Schema{}}
+	s.doc = &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:   "Sovereign Orchestrator API",
+			Version: apiVersion,
+			Description: "Every JSON route answers with an envelope: " +
+				"`{\"status\": \"ok\", \"data\": ...}` or `{\"status\": \"error\", \"error\": {\"code\", \"message\", \"details\"}}`. " +
+				"Clients of the Unix socket running as a trusted user are signed in without credentials.",
+		},
+		Tags:  apiTags,
+		Paths: map[string]*openapi.PathItem{},
+		Components: openapi.Components{
+			Schemas:   s.gen.Schemas,
+			Responses: map[string]*openapi.Response{"Error": s.errorResponse()},
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: sessionCookie,
+					Description: "Set by POST /auth/login. Changes also need the X-CSRF-Token header."},
+				"bearer": {Type: "http", Scheme: "bearer",
+					Description: "An API token; its scopes limit the routes it may call."},
+			},
+		},
+	}
+	for _, rt := range routes {
+		s.add(rt, true)
+	}
+	for _, rt := range public {
+		s.add(rt, false)
+	}
+
+	data, err := json.MarshalIndent(s.doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	s.json = data
+	return s, nil
+}
+
+// add describes one route.
+func (s *apiSpec) add(rt route, protected bool) {
+	e := rt.doc
+	if e.summary == "" {
+		return
+	}
+	method, path, _ := strings.Cut(rt.pattern, " ")
+	op := &openapi.Operation{
+		OperationID: operationID(method, path),
+		Summary:     e.summary,
+		Description: e.description,
+		Tags:        []string{e.tag},
+		Responses:   map[string]*openapi.Response{"default": {Ref: "#/components/responses/Error"}},
+	}
+	if e.query != nil {
+		op.Parameters = s.gen.Parameters(e.query, "query")
+	}
+	if e.body != nil {
+		schema := s.gen.SchemaOf(e.body)
+		mediaType := "application/json"
+		if uploadRoutes[rt.pattern] {
+			mediaType = "multipart/form-data"
+		} else {
+			s.bodies[rt.pattern] = schema
+		}
+		op.RequestBody = &openapi.RequestBody{
+			Required: !e.optionalBody,
+			Content:  map[string]*openapi.MediaType{mediaType: {Schema: schema}},
+		}
+	}
+	if protected {
+		scopes := []string{}
+		if rt.scope != "" {
+			scopes = append(scopes, rt.scope)
+		}
+		op.Security = []openapi.SecurityRequirement{{"session": scopes}, {"bearer": scopes}}
+	}
+
+	if e.planned {
+		op.Description = strings.TrimSpace(e.description + "\n\nNot implemented yet: answers 501 with the code not_implemented.")
+		op.Responses["501"] = &openapi.Response{Ref: "#/components/responses/Error"}
+	} else {
+		status := e.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := &openapi.Response{Description: http.StatusText(status), Content: map[string]*openapi.MediaType{}}
+		if e.events != nil {
+			events := s.dataSchema(e.events)
+			events.Description = "The data of each event, as JSON"
+			resp.Content["text/event-stream"] = &openapi.MediaType{Schema: events}
+		}
+		if e.resp != nil {
+			resp.Content["application/json"] = &openapi.MediaType{Schema: envelope(s.dataSchema(e.resp))}
+		}
+		op.Responses[fmt.Sprint(status)] = resp
+	}
+
+	item := s.doc.Paths[path]
+	if item == nil {
+		item = &openapi.PathItem{}
+		s.doc.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = op
+}
+
+// dataSchema returns the schema of the type of v, or of any of the types of
+// alternatives.
+func (s *apiSpec) dataSchema(v any) *openapi.Schema {
+	alts, ok := v.(alternatives)
+	if !ok {
+		return s.gen.SchemaOf(v)
+	}
+	schema := &openapi.Schema{}
+	for _, alt := range alts {
+		schema.AnyOf = append(schema.AnyOf, s.gen.SchemaOf(alt))
+	}
+	return schema
+}
+
+// envelope wraps data in the success envelope.
+func envelope(data *openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{
+		Type: "object",
+		Properties: map[string]*openapi.Schema{
+			"status": {Type: "string", Enum: []any{"ok"}},
+			"data":   data,
+		},
+		Required:             []string{"status", "data"},
+		AdditionalProperties: false,
+	}
+}
+
+// errorResponse is shared by every operation for its failures.
+func (s *apiSpec) errorResponse() *openapi.Response {
+	errSchema := s.gen.SchemaOf(api.Error{})
+	name := strings.TrimPrefix(errSchema.Ref, "#/components/schemas/")
+	code := s.gen.Schemas[name].Properties["code"]
+	for _, c := range api.Codes() {
+		code.Enum = append(code.Enum, string(c))
+	}
+	code.Description = "Stable; clients branch on it"
+	return &openapi.Response{
+		Description: "An error envelope. The HTTP status follows from the code.",
+		Content: map[string]*openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"status": {Type: "string", Enum: []any{"error"}},
+				"error":  errSchema,
+			},
+			Required:             []string{"status", "error"},
+			AdditionalProperties: false,
+		}}},
+	}
+}
+
+// operationID names an operation after its method and path, e.g.
+// "postApiCreateDatabase" for POST /api/create_database.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// validateBody checks the JSON body of a request against the schema of its
+// route before the handler decodes it. Routes without a JSON body are
+// returned unchanged.
+func (s *apiSpec) validateBody(rt route) http.HandlerFunc {
+	schema := s.bodies[rt.pattern]
+	if schema == nil {
+		return rt.handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			api.WriteError(w, api.DecodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		if len(bytes.TrimSpace(data)) == 0 {
+			if !rt.doc.optionalBody {
+				api.WriteError(w, api.Errorf(api.CodeInvalidRequest, "A JSON request body is required"))
+				return
+			}
+			rt.handler(w, r)
+			return
+		}
+		violations, err := s.gen.Validate(schema, data)
+		if err != nil {
+			api.WriteError(w, api.DecodeError(err))
+			return
+		}
+		if len(violations) > 0 {
+			api.WriteError(w, api.Errorf(api.CodeInvalidRequest, "Invalid request body: %s", violations[0]).WithDetails(violations))
+			return
+		}
+		rt.handler(w, r)
+	}
+}
+
+// handleOpenAPI serves the API document. It is the document itself, not
+// an envelope, so OpenAPI tools can read it.
+//
+//	GET /api/openapi.json
+func (app *SovereignApp) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(app.openAPI.json)
+}
@@ -0,0 +1,236 @@
+// Package api defines the JSON envelope every API response is wrapped in,
+// the stable error codes clients can branch on, and the adapter that turns
+// handlers returning a value and an error into responses.
+//
+// A successful response is
+//
+//	{"status": "ok", "data": ...}
+//
+// and a failed one is
+//
+//	{"status": "error", "error": {"code": "not_found", "message": "...", "details": ...}}
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Code identifies a class of error. Codes are part of the API: clients
+// branch on them, so they are never renamed.
+type Code string
+
+const (
+	CodeInvalidRequest   Code = "invalid_request"    // The request is malformed or fails validation
+	CodeUnauthenticated  Code = "unauthenticated"    // No valid session cookie or bearer token
+	CodeForbidden        Code = "forbidden"          // Missing scope or CSRF token, or a protected resource
+	CodeNotFound         Code = "not_found"          // No such route, database, table, job, ...
+	CodeMethodNotAllowed Code = "method_not_allowed" // The route exists, but not for this method
+	CodeConflict         Code = "conflict"           // Already exists, or the current state forbids it
+	CodeGone             Code = "gone"               // Existed once but expired, e.g. an undo token
+	CodeTooLarge         Code = "payload_too_large"  // The request body exceeds its limit
+	CodeIntegrity        Code = "integrity_failed"   // A checksum or integrity check failed
+	CodeInternal         Code = "internal"           // A server fault; the details are in the server log
+	CodeNotImplemented   Code = "not_implemented"    // The route is declared but has no implementation yet
+	CodeUpstream         Code = "upstream_failed"    // A backend this server relies on, like an LLM, failed
+	CodeUnavailable      Code = "unavailable"        // The feature is disabled or the server is shutting down
+	CodeTimeout          Code = "timeout"            // The request ran past its deadline
+)
+
+var codeStatus = map[Code]int{
+	CodeInvalidRequest:   http.StatusBadRequest,
+	CodeUnauthenticated:  http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeConflict:         http.StatusConflict,
+	CodeGone:             http.StatusGone,
+	CodeTooLarge:         http.StatusRequestEntityTooLarge,
+	CodeIntegrity:        http.StatusUnprocessableEntity,
+	CodeInternal:         http.StatusInternalServerError,
+	CodeNotImplemented:   http.StatusNotImplemented,
+	CodeUpstream:         http.StatusBadGateway,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+	CodeTimeout:          http.StatusGatewayTimeout,
+}
+
+// Codes returns every error code, sorted.
+func Codes() []Code {
+	codes := make([]Code, 0, len(codeStatus))
+	for c := range codeStatus {
+		codes = append(codes, c)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// HTTPStatus returns the status code responses with this code are sent with.
+func (c Code) HTTPStatus() int {
+	if s, ok := codeStatus[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is the error object of the envelope. The cause it wraps is for the
+// server log only and is never sent to the client.
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+
+	cause error
+}
+
+// Errorf returns an error with a formatted client-facing message.
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an error that tells the client message and keeps err for
+// the server log.
+func Wrap(code Code, err error, message string) *Error {
+	return &Error{Code: code, Message: message, cause: err}
+}
+
+// WithDetails returns a copy of e carrying structured details, such as the
+// report of a merge that hit conflicts.
+func (e *Error) WithDetails(details any) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.cause }
+
+// Envelope is the body of every API response.
+type Envelope struct {
+	Status string `json:"status"` // "ok" or "error"
+	Data   any    `json:"data,omitempty"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// Response lets a handler answer with a status other than 200 OK.
+type Response struct {
+	Status int
+	Data   any
+}
+
+// Created wraps data for a 201 Created response.
+func Created(data any) Response { return Response{Status: http.StatusCreated, Data: data} }
+
+// Accepted wraps data for a 202 Accepted response.
+func Accepted(data any) Response { return Response{Status: http.StatusAccepted, Data: data} }
+
+// Write sends data in a success envelope.
+func Write(w http.ResponseWriter, status int, data any) {
+	writeEnvelope(w, status, Envelope{Status: "ok", Data: data})
+}
+
+// WriteError sends e in an error envelope.
+func WriteError(w http.ResponseWriter, e *Error) {
+	writeEnvelope(w, e.Code.HTTPStatus(), Envelope{Status: "error", Error: e})
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, env Envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(env)
+}
+
+// DecodeJSON reads the JSON request body into v.
+func DecodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return DecodeError(err)
+	}
+	return nil
+}
+
+// DecodeError describes a failure to decode a request body.
+func DecodeError(err error) *Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return Errorf(CodeTooLarge, "Request body exceeds %d bytes", tooLarge.Limit)
+	}
+	return Errorf(CodeInvalidRequest, "Invalid JSON body: %v", err)
+}
+
+// HandlerFunc serves a request by returning the data of the response, or
+// an error. It may set response headers and cookies on w but never writes
+// the body. Return a Response for a status other than 200 OK.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) (any, error)
+
+// Adapter encodes the results of HandlerFuncs.
+type Adapter struct {
+	// Classify maps errors that are not an *Error, such as the sentinel
+	// errors of other packages, to one. It returns nil for errors it does
+	// not know.
+	Classify func(error) *Error
+	// Log records an error whose cause is withheld from the client.
+	Log func(r *http.Request, e *Error)
+}
+
+// Handle adapts h to an http.HandlerFunc.
+func (a *Adapter) Handle(h HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := h(w, r)
+		if err != nil {
+			WriteError(w, a.Resolve(r, err))
+			return
+		}
+		status := http.StatusOK
+		if resp, ok := v.(Response); ok {
+			status, v = resp.Status, resp.Data
+		}
+		Write(w, status, v)
+	}
+}
+
+// Resolve turns err into the *Error sent to the client. Server-side
+// failures, failed integrity checks and client errors whose cause says
+// more than the message are logged with their cause.
+func (a *Adapter) Resolve(r *http.Request, err error) *Error {
+	e := a.classify(err)
+	if a.Log != nil && e.cause != nil &&
+		(e.Code.HTTPStatus() >= 500 || e.Code == CodeIntegrity || e.cause.Error() != e.Message) {
+		a.Log(r, e)
+	}
+	return e
+}
+
+func (a *Adapter) classify(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if a.Classify != nil {
+		if e := a.Classify(err); e != nil {
+			if e.cause == nil {
+				e.cause = err
+			}
+			return e
+		}
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return Wrap(CodeTooLarge, err, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(CodeTimeout, err, "The request timed out")
+	case errors.Is(err, context.Canceled):
+		return Wrap(CodeUnavailable, err, "The request was canceled")
+	}
+	return Wrap(CodeInternal, err, "Internal server error")
+}
@@ -0,0 +1,488 @@
+// Package archive moves rows and tables out of a database into an archive
+// sidecar database, and back again. Every move is one transaction over both
+// files (the sidecar is ATTACHed to the source connection) and is recorded
+// as a batch with its provenance, so any batch can be restored.
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/dbschema"
+)
+
+const (
+	schemaName = "archive"
+	// Extra columns on archived copies of a table.
+	batchColumn = "__archive_batch"
+	rowIDColumn = "__archive_rowid"
+)
+
+var (
+	// ErrInvalid is returned for requests that cannot be archived as asked.
+	ErrInvalid = errors.New("invalid archive request")
+	// ErrNotFound is returned for unknown batches and snapshots.
+	ErrNotFound = errors.New("archive batch not found")
+	// ErrProtected is returned for protected tables without the override.
+	ErrProtected = errors.New("table is protected")
+	// ErrConflict is returned when a restore would overwrite live data.
+	ErrConflict = errors.New("restore conflicts with existing data")
+)
+
+const batchesSchema = `CREATE TABLE IF NOT EXISTS archive.archive_batches (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	source_db TEXT NOT NULL,
+	source_path TEXT NOT NULL,
+	source_table TEXT NOT NULL,
+	original_ids TEXT,
+	row_count INTEGER NOT NULL,
+	table_sql TEXT,
+	dropped INTEGER NOT NULL DEFAULT 0,
+	archived_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	restored_at DATETIME
+)`
+
+// Batch is one archival move.
+type Batch struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"` // "rows" or "table"
+	SourceDB    string     `json:"source_db"`
+	SourcePath  string     `json:"source_path"`
+	Table       string     `json:"table"`
+	OriginalIDs []int64    `json:"original_ids"` // Row keys; empty for WITHOUT ROWID tables
+	RowCount    int64      `json:"row_count"`
+	Dropped     bool       `json:"dropped"` // The source table was dropped
+	ArchivedAt  time.Time  `json:"archived_at"`
+	RestoredAt  *time.Time `json:"restored_at,omitempty"`
+}
+
+// Archiver archives from one database into its sidecar.
+type Archiver struct {
+	DB          *sql.DB
+	Name        string // Registry name of the source, recorded as provenance
+	Path        string // Source database file
+	ArchivePath string // Sidecar database file; created on first use
+}
+
+// session runs fn on a single connection with the sidecar attached and a
+// transaction open, committing if fn succeeds.
+func (a *Archiver) session(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	conn, err := a.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+schemaName, a.ArchivePath); err != nil {
+		return fmt.Errorf("failed to attach archive %s: %w", a.ArchivePath, err)
+	}
+	// Detach with a fresh context so a cancelled request cannot leave the
+	// pooled connection with the sidecar attached.
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE "+schemaName)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin archive transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, batchesSchema); err != nil {
+		return fmt.Errorf("failed to prepare archive schema: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit archive transaction: %w", err)
+	}
+	return nil
+}
+
+// lookupTable validates a table name against the source schema. Protected
+// tables need override.
+func (a *Archiver) lookupTable(ctx context.Context, name string, override bool) (*dbschema.Table, error) {
+	if dbschema.Protected[name] && !override {
+		return nil, fmt.Errorf("%w: %s requires the override flag", ErrProtected, name)
+	}
+	schema, err := dbschema.Inspect(ctx, a.DB, false)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := schema.Table(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown table %q", ErrInvalid, name)
+	}
+	if t.Virtual {
+		return nil, fmt.Errorf("%w: virtual table %s cannot be archived", ErrInvalid, name)
+	}
+	return t, nil
+}
+
+// ArchiveRows moves the rows with the given keys (the rowid or INTEGER
+// PRIMARY KEY) from table into the sidecar. Protected tables need override.
+func (a *Archiver) ArchiveRows(ctx context.Context, table string, ids []int64, override bool) (*Batch, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: no row ids given", ErrInvalid)
+	}
+	t, err := a.lookupTable(ctx, table, override)
+	if err != nil {
+		return nil, err
+	}
+	keys := t.KeyColumns()
+	if t.WithoutRowID || len(keys) != 1 {
+		return nil, fmt.Errorf("%w: rows of %s have no integer key; archive the whole table instead", ErrInvalid, table)
+	}
+	where, args := dbschema.InList(keys[0], ids)
+	return a.move(ctx, t, "rows", where, args, false)
+}
+
+// ArchiveTable moves every row of table into the sidecar. With drop the
+// table itself is dropped as well; restoring the batch recreates it.
+func (a *Archiver) ArchiveTable(ctx context.Context, table string, drop, override bool) (*Batch, error) {
+	t, err := a.lookupTable(ctx, table, override)
+	if err != nil {
+		return nil, err
+	}
+	return a.move(ctx, t, "table", "1", nil, drop)
+}
+
+func (a *Archiver) move(ctx context.Context, t *dbschema.Table, kind, where string, args []interface{}, drop bool) (*Batch, error) {
+	batch := &Batch{Kind: kind, SourceDB: a.Name, SourcePath: a.Path, Table: t.Name, Dropped: drop, OriginalIDs: []int64{}}
+	keys := t.KeyColumns()
+	src := "main." + dbschema.Quote(t.Name)
+
+	err := a.session(ctx, func(tx *sql.Tx) error {
+		if !t.WithoutRowID {
+			rows, err := tx.QueryContext(ctx, "SELECT "+keys[0]+" FROM "+src+" WHERE "+where+" ORDER BY 1", args...)
+			if err != nil {
+				return fmt.Errorf("failed to read row ids: %w", err)
+			}
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				batch.OriginalIDs = append(batch.OriginalIDs, id)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if kind == "rows" && len(batch.OriginalIDs) == 0 {
+				return fmt.Errorf("%w: none of the given rows exist in %s", ErrInvalid, t.Name)
+			}
+		}
+
+		var tableSQL interface{}
+		if drop {
+			stmts, err := tableStatements(ctx, tx, t.Name)
+			if err != nil {
+				return err
+			}
+			data, _ := json.Marshal(stmts)
+			tableSQL = string(data)
+		}
+		ids, _ := json.Marshal(batch.OriginalIDs)
+		res, err := tx.ExecContext(ctx, `INSERT INTO archive.archive_batches
+			(kind, source_db, source_path, source_table, original_ids, row_count, table_sql, dropped)
+			VALUES (?, ?, ?, ?, ?, 0, ?, ?)`,
+			kind, a.Name, a.Path, t.Name, string(ids), tableSQL, drop)
+		if err != nil {
+			return fmt.Errorf("failed to record archive batch: %w", err)
+		}
+		if batch.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		if err := ensureMirror(ctx, tx, t); err != nil {
+			return err
+		}
+		cols := quotedColumns(t)
+		rowID := "NULL"
+		if !t.WithoutRowID {
+			rowID = "rowid"
+		}
+		copied, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO archive.%s (%s, %s, %s) SELECT %s, ?, %s FROM %s WHERE %s",
+			dbschema.Quote(t.Name), cols, batchColumn, rowIDColumn, cols, rowID, src, where),
+			append([]interface{}{batch.ID}, args...)...)
+		if err != nil {
+			return fmt.Errorf("failed to copy rows to the archive: %w", err)
+		}
+		if batch.RowCount, err = copied.RowsAffected(); err != nil {
+			return err
+		}
+
+		// Rows are deleted even when the table is dropped so delete
+		// triggers keep dependent tables, such as search indexes, in sync.
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+src+" WHERE "+where, args...)
+		if err == nil && drop {
+			_, err = tx.ExecContext(ctx, "DROP TABLE "+src)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove archived rows from %s: %w", t.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE archive.archive_batches SET row_count = ? WHERE id = ?", batch.RowCount, batch.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	batch.ArchivedAt = time.Now().UTC()
+	return batch, nil
+}
+
+// Restore moves the rows of a batch back into the source database,
+// recreating the table first if the archive dropped it.
+func (a *Archiver) Restore(ctx context.Context, id int64) (*Batch, error) {
+	var batch *Batch
+	err := a.session(ctx, func(tx *sql.Tx) error {
+		var err error
+		var tableSQL sql.NullString
+		batch, tableSQL, err = readBatch(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if batch.RestoredAt != nil {
+			return fmt.Errorf("%w: batch %d was already restored", ErrConflict, id)
+		}
+
+		var exists int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = ?", batch.Table).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			if !tableSQL.Valid {
+				return fmt.Errorf("%w: table %s no longer exists", ErrConflict, batch.Table)
+			}
+			var stmts []string
+			if err := json.Unmarshal([]byte(tableSQL.String), &stmts); err != nil {
+				return fmt.Errorf("invalid table definition in batch %d: %w", id, err)
+			}
+			for _, stmt := range stmts {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("failed to recreate %s: %w", batch.Table, err)
+				}
+			}
+		}
+
+		// Only columns present on both sides are restored, so restores work
+		// across schema changes that added columns.
+		live, err := columnNames(ctx, tx, "main", batch.Table)
+		if err != nil {
+			return err
+		}
+		archived, err := columnNames(ctx, tx, schemaName, batch.Table)
+		if err != nil {
+			return err
+		}
+		var cols []string
+		for _, c := range live {
+			if contains(archived, c) {
+				cols = append(cols, dbschema.Quote(c))
+			}
+		}
+		if len(cols) == 0 {
+			return fmt.Errorf("%w: archived columns of %s no longer match the table", ErrConflict, batch.Table)
+		}
+
+		dst := "main." + dbschema.Quote(batch.Table)
+		src := "archive." + dbschema.Quote(batch.Table)
+		if len(batch.OriginalIDs) > 0 {
+			var clash int
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+dst+" WHERE rowid IN (SELECT "+rowIDColumn+" FROM "+src+" WHERE "+batchColumn+" = ?)", id).Scan(&clash); err != nil {
+				return err
+			}
+			if clash > 0 {
+				return fmt.Errorf("%w: %d archived row id(s) of %s are in use again", ErrConflict, clash, batch.Table)
+			}
+		}
+
+		list := strings.Join(cols, ", ")
+		insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s = ?", dst, list, list, src, batchColumn)
+		alias, err := hasRowIDAlias(ctx, tx, batch.Table)
+		if err != nil {
+			return err
+		}
+		if len(batch.OriginalIDs) > 0 && !alias {
+			// Without an INTEGER PRIMARY KEY the rowid is not a column, so
+			// restore it explicitly to keep the original ids.
+			insert = fmt.Sprintf("INSERT INTO %s (rowid, %s) SELECT %s, %s FROM %s WHERE %s = ?", dst, list, rowIDColumn, list, src, batchColumn)
+		}
+		if _, err := tx.ExecContext(ctx, insert, id); err != nil {
+			if strings.Contains(err.Error(), "constraint failed") {
+				// The driver's message names the constraint and is only
+				// for the log.
+				conflict := fmt.Errorf("%w: rows of %s clash with rows added since", ErrConflict, batch.Table)
+				return fmt.Errorf("%w: %v", conflict, err)
+			}
+			return fmt.Errorf("failed to restore rows into %s: %w", batch.Table, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+src+" WHERE "+batchColumn+" = ?", id); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		batch.RestoredAt = &now
+		_, err = tx.ExecContext(ctx, "UPDATE archive.archive_batches SET restored_at = ? WHERE id = ?", now.Format("2006-01-02 15:04:05"), id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// Batches lists the archive batches, newest first.
+func (a *Archiver) Batches(ctx context.Context) ([]Batch, error) {
+	batches := []Batch{}
+	err := a.session(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM archive.archive_batches ORDER BY id DESC")
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			b, _, err := readBatch(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			batches = append(batches, *b)
+		}
+		return nil
+	})
+	return batches, err
+}
+
+func readBatch(ctx context.Context, tx *sql.Tx, id int64) (*Batch, sql.NullString, error) {
+	b := &Batch{}
+	var ids, tableSQL sql.NullString
+	var restored sql.NullTime
+	err := tx.QueryRowContext(ctx, `SELECT id, kind, source_db, source_path, source_table, original_ids, row_count,
+		table_sql, dropped, archived_at, restored_at FROM archive.archive_batches WHERE id = ?`, id).
+		Scan(&b.ID, &b.Kind, &b.SourceDB, &b.SourcePath, &b.Table, &ids, &b.RowCount, &tableSQL, &b.Dropped, &b.ArchivedAt, &restored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tableSQL, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, tableSQL, fmt.Errorf("failed to read archive batch %d: %w", id, err)
+	}
+	b.OriginalIDs = []int64{}
+	if ids.Valid {
+		json.Unmarshal([]byte(ids.String), &b.OriginalIDs)
+	}
+	if restored.Valid {
+		b.RestoredAt = &restored.Time
+	}
+	return b, tableSQL, nil
+}
+
+// ensureMirror creates the archive copy of a table, or adds columns the
+// source gained since the last archive. Constraints are not copied, so rows
+// from many batches can coexist.
+func ensureMirror(ctx context.Context, tx *sql.Tx, t *dbschema.Table) error {
+	existing, err := columnNames(ctx, tx, schemaName, t.Name)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE archive.%s AS SELECT %s, 0 AS %s, 0 AS %s FROM main.%s WHERE 0",
+			dbschema.Quote(t.Name), quotedColumns(t), batchColumn, rowIDColumn, dbschema.Quote(t.Name)))
+		if err != nil {
+			return fmt.Errorf("failed to create archive table %s: %w", t.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS archive.%s ON %s (%s)",
+			dbschema.Quote("idx_"+t.Name+"_archive_batch"), dbschema.Quote(t.Name), batchColumn))
+		return err
+	}
+	for _, c := range t.Columns {
+		if contains(existing, c.Name) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE archive.%s ADD COLUMN %s %s",
+			dbschema.Quote(t.Name), dbschema.Quote(c.Name), c.Type)); err != nil {
+			return fmt.Errorf("failed to extend archive table %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// tableStatements returns the SQL that recreates a table and its indexes.
+func tableStatements(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT sql FROM main.sqlite_master
+		WHERE tbl_name = ? AND type IN ('table', 'index', 'trigger') AND sql IS NOT NULL
+		ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read definition of %s: %w", table, err)
+	}
+	defer rows.Close()
+	var stmts []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, rows.Err()
+}
+
+func columnNames(ctx context.Context, tx *sql.Tx, schema, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, ?)", table, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s.%s: %w", schema, table, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	return names, rows.Err()
+}
+
+// hasRowIDAlias reports whether a table has an INTEGER PRIMARY KEY column,
+// which holds the rowid.
+func hasRowIDAlias(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	var n int
+	var typ sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*), MAX(type) FROM pragma_table_info(?, 'main') WHERE pk > 0", table).Scan(&n, &typ); err != nil {
+		return false, err
+	}
+	return n == 1 && strings.EqualFold(typ.String, "INTEGER"), nil
+}
+
+func quotedColumns(t *dbschema.Table) string {
+	cols := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = dbschema.Quote(c.Name)
+	}
+	return strings.Join(cols, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/fsutil"
+)
+
+const (
+	snapshotExt  = ".db.gz"
+	manifestExt  = ".json"
+	snapshotTime = "20060102-150405"
+)
+
+// ErrChecksum is returned when a snapshot does not match its manifest.
+var ErrChecksum = errors.New("snapshot checksum mismatch")
+
+// Snapshot describes a compressed database snapshot. It is stored as
+// <file>.json next to the snapshot.
+type Snapshot struct {
+	File       string    `json:"file"` // Base name inside the snapshot directory
+	Database   string    `json:"database"`
+	SourcePath string    `json:"source_path"`
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int64     `json:"size"`     // Compressed
+	RawSize    int64     `json:"raw_size"` // Uncompressed database
+	SHA256     string    `json:"sha256"`   // Of the compressed file
+	RawSHA256  string    `json:"raw_sha256"`
+}
+
+// CreateSnapshot writes a consistent copy of db (VACUUM INTO) to dir as a
+// gzip file with a checksummed manifest.
+func CreateSnapshot(ctx context.Context, db *sql.DB, name, sourcePath, dir string) (*Snapshot, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory %s: %w", dir, err)
+	}
+	now := time.Now()
+	snap := &Snapshot{
+		File:       fmt.Sprintf("%s-%s%s", name, now.Format(snapshotTime), snapshotExt),
+		Database:   name,
+		SourcePath: sourcePath,
+		CreatedAt:  now.UTC(),
+	}
+
+	raw := filepath.Join(dir, "."+snap.File+".raw")
+	defer os.Remove(raw)
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", raw); err != nil {
+		return nil, fmt.Errorf("failed to copy database %s: %w", name, err)
+	}
+
+	in, err := os.Open(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	dest := filepath.Join(dir, snap.File)
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	rawHash, gzHash := sha256.New(), sha256.New()
+	counter := &fsutil.CountWriter{W: io.MultiWriter(out, gzHash)}
+	zw := gzip.NewWriter(counter)
+	zw.Name = name + ".db"
+	zw.ModTime = now
+	snap.RawSize, err = io.Copy(io.MultiWriter(zw, rawHash), in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot of %s: %w", name, err)
+	}
+	snap.Size = counter.N
+	snap.SHA256 = hex.EncodeToString(gzHash.Sum(nil))
+	snap.RawSHA256 = hex.EncodeToString(rawHash.Sum(nil))
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, err
+	}
+	manifest, _ := json.MarshalIndent(snap, "", "  ")
+	if err := fsutil.WriteFileAtomic(dest+manifestExt, manifest, 0644); err != nil {
+		os.Remove(dest)
+		return nil, fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+	return snap, nil
+}
+
+// ListSnapshots returns the snapshots in dir, newest first.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+snapshotExt+manifestExt))
+	if err != nil {
+		return nil, err
+	}
+	snaps := []Snapshot{}
+	for _, m := range matches {
+		snap, err := readManifest(m)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, *snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+	return snaps, nil
+}
+
+// RestoreSnapshot verifies a snapshot and decompresses it to dest, which
+// must not exist yet.
+func RestoreSnapshot(dir, file, dest string) (*Snapshot, error) {
+	if file != filepath.Base(file) || !strings.HasSuffix(file, snapshotExt) {
+		return nil, fmt.Errorf("%w: invalid snapshot name %q", ErrInvalid, file)
+	}
+	path := filepath.Join(dir, file)
+	snap, err := readManifest(path + manifestExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: snapshot %s", ErrNotFound, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return nil, fmt.Errorf("%w: %s already exists", ErrConflict, filepath.Base(dest))
+	}
+
+	gzSum, err := fsutil.FileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	if gzSum != snap.SHA256 {
+		return nil, fmt.Errorf("%w: %s", ErrChecksum, file)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", file, err)
+	}
+	tmp := dest + ".restore.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	rawHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, rawHash), zr)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot %s: %w", file, err)
+	}
+	if hex.EncodeToString(rawHash.Sum(nil)) != snap.RawSHA256 {
+		return nil, fmt.Errorf("%w: %s (decompressed)", ErrChecksum, file)
+	}
+	// Link fails if dest appeared meanwhile, so nothing is ever overwritten.
+	if err := os.Link(tmp, dest); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("%w: %s already exists", ErrConflict, filepath.Base(dest))
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+func readManifest(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest %s: %w", path, err)
+	}
+	return &snap, nil
+}
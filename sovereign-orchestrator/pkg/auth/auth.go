@@ -0,0 +1,159 @@
+// Package auth keeps local user accounts, browser sessions and scoped API
+// tokens in their own SQLite database.
+//
+// Passwords are stored as Argon2id hashes. Session and token secrets are
+// random 256-bit values handed to the client once; only their SHA-256 is
+// stored, so a copy of the database cannot be replayed as a credential.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalid is returned for malformed users, scopes and passwords.
+	ErrInvalid = errors.New("invalid auth request")
+	// ErrCredentials is returned for a wrong username or password. It does
+	// not say which one was wrong.
+	ErrCredentials = errors.New("wrong username or password")
+	// ErrUnauthenticated is returned for missing, unknown or expired
+	// sessions and tokens.
+	ErrUnauthenticated = errors.New("not authenticated")
+	// ErrNotFound is returned for unknown users and tokens.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when creating a user that already exists.
+	ErrExists = errors.New("already exists")
+)
+
+// Scopes grant access to groups of endpoints.
+const (
+	ScopeDBRead        = "db:read"        // Read memory tables, searches and backups
+	ScopeDBWrite       = "db:write"       // Change memory tables and databases
+	ScopeAutonomyAdmin = "autonomy:admin" // Configure Ghost Mode
+	ScopeExec          = "exec"           // Run commands and tools on the host
+)
+
+// AllScopes lists every scope in the order they are documented.
+var AllScopes = []string{ScopeDBRead, ScopeDBWrite, ScopeAutonomyAdmin, ScopeExec}
+
+// CSRFHeader carries the CSRF token of a session on requests that change
+// something.
+const CSRFHeader = "X-CSRF-Token"
+
+const (
+	minPassword   = 8
+	secretBytes   = 32
+	tokenPrefix   = "sov_"
+	sessionPrefix = "sess_"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// Principal is the user behind an authenticated request.
+type Principal struct {
+	UserID  int64    `json:"user_id"`
+	User    string   `json:"user"`
+	Scopes  []string `json:"scopes"`
+	Via     string   `json:"via"`                // "session", "token" or "peer"
+	TokenID int64    `json:"token_id,omitempty"` // Set for tokens
+	CSRF    string   `json:"-"`                  // Set for sessions
+}
+
+// Has reports whether p was granted scope.
+func (p *Principal) Has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCSRF reports whether r may go ahead for p. Browsers attach the
+// session cookie to cross-site requests too, so requests that change
+// something through a session must echo its CSRF token in CSRFHeader.
+// Tokens and peer credentials cannot be sent by another site.
+func CheckCSRF(r *http.Request, p *Principal) bool {
+	if p.Via != "session" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	return p.CSRF != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRFHeader)), []byte(p.CSRF)) == 1
+}
+
+// ParseScopes splits a comma- or space-separated scope list and validates
+// it. "all" stands for every scope.
+func ParseScopes(s string) ([]string, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, f := range fields {
+		if f == "all" {
+			return append([]string(nil), AllScopes...), nil
+		}
+	}
+	return normalizeScopes(fields)
+}
+
+// normalizeScopes validates scopes and returns them sorted without duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	out := []string{}
+	for _, s := range scopes {
+		if !isScope(s) {
+			return nil, fmt.Errorf("%w: unknown scope %q (valid: %s)", ErrInvalid, s, strings.Join(AllScopes, ", "))
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func isScope(s string) bool {
+	for _, scope := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validUsername(name string) error {
+	if !usernamePattern.MatchString(name) {
+		return fmt.Errorf("%w: user names are 1-64 lowercase letters, digits, '_', '.' or '-'", ErrInvalid)
+	}
+	return nil
+}
+
+func validPassword(password string) error {
+	if len(password) < minPassword {
+		return fmt.Errorf("%w: passwords need at least %d characters", ErrInvalid, minPassword)
+	}
+	return nil
+}
+
+// newSecret returns a random secret with the given prefix.
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret is the stored form of a session or token secret. The secrets
+// carry 256 bits of entropy, so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
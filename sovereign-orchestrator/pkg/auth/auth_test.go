@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// fastParams keep the tests quick; the format and checks are the same.
+var fastParams = Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestPasswordHash(t *testing.T) {
+	encoded, err := hashPassword("correct horse", fastParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format %q", encoded)
+	}
+	again, _ := hashPassword("correct horse", fastParams)
+	if again == encoded {
+		t.Errorf("two hashes of one password are equal; the salt is not random")
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		want      Params
+		wantOK    bool
+		wantStale bool
+	}{
+		{"right password", "correct horse", fastParams, true, false},
+		{"wrong password", "correct horsf", fastParams, false, false},
+		{"empty password", "", fastParams, false, false},
+		{"right password, older parameters", "correct horse", Params{Time: 2, Memory: 64, Threads: 1}, true, true},
+		{"wrong password, older parameters", "wrong", Params{Time: 2, Memory: 64, Threads: 1}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, stale, err := checkPassword(encoded, tt.password, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || stale != tt.wantStale {
+				t.Errorf("got ok=%v stale=%v, want ok=%v stale=%v", ok, stale, tt.wantOK, tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestPasswordHashMalformed(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$!!",
+	} {
+		if ok, _, err := checkPassword(encoded, "x", fastParams); err == nil || ok {
+			t.Errorf("checkPassword(%q) = %v, %v; want an error", encoded, ok, err)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"db:read", []string{"db:read"}, false},
+		{"exec, db:read db:read", []string{"db:read", "exec"}, false},
+		{"all", AllScopes, false},
+		{"db:read,all", AllScopes, false},
+		{"", []string{}, false},
+		{"db:admin", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseScopes(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("ParseScopes(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	session := &Principal{Via: "session", CSRF: "csrf-secret"}
+	tests := []struct {
+		name   string
+		method string
+		header string
+		p      *Principal
+		want   bool
+	}{
+		{"session read", http.MethodGet, "", session, true},
+		{"session head", http.MethodHead, "", session, true},
+		{"session change with token", http.MethodPost, "csrf-secret", session, true},
+		{"session change without token", http.MethodPost, "", session, false},
+		{"session change with wrong token", http.MethodPost, "csrf-secreT", session, false},
+		{"session change with longer token", http.MethodPost, "csrf-secret2", session, false},
+		{"session delete without token", http.MethodDelete, "", session, false},
+		{"session without a token of its own", http.MethodPost, "", &Principal{Via: "session"}, false},
+		{"bearer token change", http.MethodPost, "", &Principal{Via: "token"}, true},
+		{"peer change", http.MethodPost, "", &Principal{Via: "peer"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/delete_rows", nil)
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if got := CheckCSRF(r, tt.p); got != tt.want {
+				t.Errorf("CheckCSRF = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.Params = fastParams
+	return s
+}
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		scopes   []string
+		want     error
+	}{
+		{"valid", "alice", "correct horse", []string{ScopeDBRead}, nil},
+		{"upper case name", "Alice", "correct horse", nil, ErrInvalid},
+		{"empty name", "", "correct horse", nil, ErrInvalid},
+		{"short password", "bob", "short", nil, ErrInvalid},
+		{"unknown scope", "bob", "correct horse", []string{"root"}, ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			_, err := s.CreateUser(context.Background(), tt.user, tt.password, tt.scopes)
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	s := openStore(t)
+	ctx := context.Background()
+	if _, err := s.CreateUser(ctx, "alice", "correct horse", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(ctx, "alice", "other password", nil); !errors.Is(err, ErrExists) {
+		t.Errorf("duplicate user: got %v, want ErrExists", err)
+	}
+	var hash string
+	s.db.QueryRow("SELECT password_hash FROM users WHERE username = 'alice'").Scan(&hash)
+	if !strings.HasPrefix(hash, "$argon2id$") || strings.Contains(hash, "correct horse") {
+		t.Errorf("stored password is not an Argon2id hash: %q", hash)
+	}
+}
+
+func TestLoginAndSession(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	if _, err := s.CreateUser(ctx, "alice", "correct horse", []string{ScopeDBRead, ScopeExec}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ user, password string }{{"alice", "wrong horse"}, {"nobody", "correct horse"}} {
+		if _, _, err := s.Login(ctx, c.user, c.password, time.Hour, "", ""); !errors.Is(err, ErrCredentials) {
+			t.Errorf("login as %s with %q: got %v, want ErrCredentials", c.user, c.password, err)
+		}
+	}
+
+	secret, sess, err := s.Login(ctx, "alice", "correct horse", time.Hour, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, sessionPrefix) || sess.CSRFToken == "" {
+		t.Fatalf("unexpected session %q with CSRF token %q", secret, sess.CSRFToken)
+	}
+	var stored int
+	s.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id_hash = ? OR csrf_token = ?", secret, secret).Scan(&stored)
+	if stored != 0 {
+		t.Errorf("the session secret is stored in the clear")
+	}
+
+	p, err := s.Session(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.User != "alice" || p.Via != "session" || p.CSRF != sess.CSRFToken || !p.Has(ScopeExec) || p.Has(ScopeDBWrite) {
+		t.Errorf("unexpected principal %+v", p)
+	}
+	for _, bad := range []string{"", sessionPrefix + "x", secret + "x", strings.TrimPrefix(secret, sessionPrefix)} {
+		if _, err := s.Session(ctx, bad); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("session %q: got %v, want ErrUnauthenticated", bad, err)
+		}
+	}
+
+	if err := s.Logout(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(ctx, secret); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("after logout: got %v, want ErrUnauthenticated", err)
+	}
+
+	expired, _, err := s.Login(ctx, "alice", "correct horse", -time.Second, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(ctx, expired); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expired session: got %v, want ErrUnauthenticated", err)
+	}
+
+	// A new password signs out every session.
+	secret, _, _ = s.Login(ctx, "alice", "correct horse", time.Hour, "", "")
+	if err := s.SetPassword(ctx, "alice", "battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(ctx, secret); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("session after password change: got %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestLoginUpgradesStaleHash(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	if _, err := s.CreateUser(ctx, "alice", "correct horse", nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Params = Params{Time: 2, Memory: 128, Threads: 1}
+	if _, _, err := s.Login(ctx, "alice", "correct horse", time.Hour, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	var hash string
+	s.db.QueryRow("SELECT password_hash FROM users WHERE username = 'alice'").Scan(&hash)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=128,t=2,p=1$") {
+		t.Errorf("hash was not upgraded: %q", hash)
+	}
+	if _, _, err := s.Login(ctx, "alice", "correct horse", time.Hour, "", ""); err != nil {
+		t.Errorf("login with the upgraded hash: %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	if _, err := s.CreateUser(ctx, "alice", "correct horse", []string{ScopeDBRead, ScopeDBWrite}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		scopes []string
+	}{{"", []string{ScopeDBRead}}, {"ci", nil}, {"ci", []string{ScopeExec}}, {"ci", []string{"nope"}}} {
+		if _, _, err := s.CreateToken(ctx, "alice", tt.name, tt.scopes, 0); !errors.Is(err, ErrInvalid) {
+			t.Errorf("token %q with %v: got %v, want ErrInvalid", tt.name, tt.scopes, err)
+		}
+	}
+
+	secret, tok, err := s.CreateToken(ctx, "alice", "ci", []string{ScopeDBWrite, ScopeDBRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || !strings.HasSuffix(secret, tok.Hint) {
+		t.Errorf("unexpected token %q with hint %q", secret, tok.Hint)
+	}
+	p, err := s.Token(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Via != "token" || !reflect.DeepEqual(p.Scopes, []string{ScopeDBRead, ScopeDBWrite}) {
+		t.Errorf("unexpected principal %+v", p)
+	}
+	if _, err := s.Session(ctx, secret); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("token accepted as a session: %v", err)
+	}
+
+	// Tokens lose the scopes their user loses.
+	if err := s.SetScopes(ctx, "alice", []string{ScopeDBRead}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = s.Token(ctx, secret); err != nil || !reflect.DeepEqual(p.Scopes, []string{ScopeDBRead}) {
+		t.Errorf("after losing db:write: got %+v, %v", p, err)
+	}
+
+	if err := s.RevokeToken(ctx, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Token(ctx, secret); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("revoked token: got %v, want ErrUnauthenticated", err)
+	}
+	if err := s.RevokeToken(ctx, tok.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking twice: got %v, want ErrNotFound", err)
+	}
+
+	// A token without a lifetime never expires.
+	expired, old, err := s.CreateToken(ctx, "alice", "old", []string{ScopeDBRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Token(ctx, expired); err != nil {
+		t.Fatalf("token without expiry: %v", err)
+	}
+	past := time.Now().UTC().Add(-time.Minute).Format(timeLayout)
+	if _, err := s.db.Exec("UPDATE tokens SET expires_at = ? WHERE id = ?", past, old.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Token(ctx, expired); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expired token: got %v, want ErrUnauthenticated", err)
+	}
+	if n, err := s.Purge(ctx); err != nil || n != 1 {
+		t.Errorf("purge removed %d (%v), want 1", n, err)
+	}
+}
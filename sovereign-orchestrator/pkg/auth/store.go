@@ -0,0 +1,548 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	timeLayout = "2006-01-02 15:04:05"
+	// touchEvery limits how often a session or token records its last use.
+	touchEvery = time.Minute
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,       -- Argon2id, PHC string format
+    scopes TEXT NOT NULL,              -- Space-separated
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    last_login_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id_hash TEXT PRIMARY KEY,          -- SHA-256 of the cookie value
+    user_id INTEGER NOT NULL,
+    csrf_token TEXT NOT NULL,
+    remote_addr TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    secret_hash TEXT NOT NULL UNIQUE,  -- SHA-256 of the bearer token
+    hint TEXT NOT NULL,                -- Last characters, to tell tokens apart
+    scopes TEXT NOT NULL,              -- Space-separated; capped by the user's scopes
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME,               -- NULL never expires
+    last_used_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_tokens_user ON tokens (user_id);
+`
+
+// User is a local account.
+type User struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Scopes      []string   `json:"scopes"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// Session is a signed-in browser.
+type Session struct {
+	User      string    `json:"user"`
+	Scopes    []string  `json:"scopes"`
+	CSRFToken string    `json:"csrf_token"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Token is a bearer API token. Its secret is only returned when it is created.
+type Token struct {
+	ID         int64      `json:"id"`
+	User       string     `json:"user"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Store holds the accounts, sessions and tokens.
+type Store struct {
+	db     *sql.DB
+	Params Params // Cost of new password hashes
+
+	// Each Argon2id check takes Params.Memory; checking one password at a
+	// time bounds the memory a burst of logins can take.
+	hashMu sync.Mutex
+	dummy  string // Checked for unknown users, so they take as long as known ones
+}
+
+// Open opens or creates the auth database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth database %s: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create auth schema in %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Store{db: db, Params: DefaultParams}
+	if s.dummy, err = hashPassword("dummy password", s.Params); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func splitScopes(s string) []string {
+	return strings.Fields(s)
+}
+
+func (s *Store) hash(password string) (string, error) {
+	s.hashMu.Lock()
+	defer s.hashMu.Unlock()
+	return hashPassword(password, s.Params)
+}
+
+func (s *Store) check(encoded, password string) (ok, stale bool, err error) {
+	s.hashMu.Lock()
+	defer s.hashMu.Unlock()
+	return checkPassword(encoded, password, s.Params)
+}
+
+// CountUsers returns the number of accounts.
+func (s *Store) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// CreateUser adds an account with the given scopes.
+func (s *Store) CreateUser(ctx context.Context, username, password string, scopes []string) (*User, error) {
+	if err := validUsername(username); err != nil {
+		return nil, err
+	}
+	if err := validPassword(password); err != nil {
+		return nil, err
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.user(ctx, username); err == nil {
+		return nil, fmt.Errorf("%w: user %s", ErrExists, username)
+	}
+	hash, err := s.hash(password)
+	if err != nil {
+		return nil, err
+	}
+	t := now()
+	res, err := s.db.ExecContext(ctx, "INSERT INTO users (username, password_hash, scopes, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		username, hash, joinScopes(scopes), t.Format(timeLayout), t.Format(timeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user %s: %w", username, err)
+	}
+	id, _ := res.LastInsertId()
+	return &User{ID: id, Username: username, Scopes: scopes, CreatedAt: t, UpdatedAt: t}, nil
+}
+
+type userRow struct {
+	User
+	hash string
+}
+
+func (s *Store) user(ctx context.Context, username string) (*userRow, error) {
+	var (
+		u         userRow
+		scopes    string
+		lastLogin sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, password_hash, scopes, created_at, updated_at, last_login_at FROM users WHERE username = ?", username).
+		Scan(&u.ID, &u.Username, &u.hash, &scopes, &u.CreatedAt, &u.UpdatedAt, &lastLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: user %s", ErrNotFound, username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Scopes = splitScopes(scopes)
+	if lastLogin.Valid {
+		u.LastLoginAt = &lastLogin.Time
+	}
+	return &u, nil
+}
+
+// Users lists the accounts by name.
+func (s *Store) Users(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, username, scopes, created_at, updated_at, last_login_at FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var (
+			u         User
+			scopes    string
+			lastLogin sql.NullTime
+		)
+		if err := rows.Scan(&u.ID, &u.Username, &scopes, &u.CreatedAt, &u.UpdatedAt, &lastLogin); err != nil {
+			return nil, err
+		}
+		u.Scopes = splitScopes(scopes)
+		if lastLogin.Valid {
+			u.LastLoginAt = &lastLogin.Time
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetPassword replaces a user's password and signs out their sessions.
+func (s *Store) SetPassword(ctx context.Context, username, password string) error {
+	if err := validPassword(password); err != nil {
+		return err
+	}
+	u, err := s.user(ctx, username)
+	if err != nil {
+		return err
+	}
+	hash, err := s.hash(password)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?", hash, now().Format(timeLayout), u.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", u.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetScopes replaces a user's scopes. Their sessions and tokens lose any
+// scope the user no longer has on their next request.
+func (s *Store) SetScopes(ctx context.Context, username string, scopes []string) error {
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET scopes = ?, updated_at = ? WHERE username = ?", joinScopes(scopes), now().Format(timeLayout), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: user %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+// DeleteUser removes an account with its sessions and tokens.
+func (s *Store) DeleteUser(ctx context.Context, username string) error {
+	u, err := s.user(ctx, username)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM tokens WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, q, u.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Login checks a username and password and starts a session of length ttl.
+// It returns the session secret, which only the client keeps.
+func (s *Store) Login(ctx context.Context, username, password string, ttl time.Duration, remoteAddr, userAgent string) (string, *Session, error) {
+	u, err := s.user(ctx, username)
+	if errors.Is(err, ErrNotFound) {
+		s.check(s.dummy, password)
+		return "", nil, ErrCredentials
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	ok, stale, err := s.check(u.hash, password)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to check the password of %s: %w", username, err)
+	}
+	if !ok {
+		return "", nil, ErrCredentials
+	}
+
+	secret, err := newSecret(sessionPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := newSecret("")
+	if err != nil {
+		return "", nil, err
+	}
+	t := now()
+	sess := &Session{User: u.Username, Scopes: u.Scopes, CSRFToken: csrf, CreatedAt: t, ExpiresAt: t.Add(ttl)}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO sessions (id_hash, user_id, csrf_token, remote_addr, user_agent, created_at, expires_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		hashSecret(secret), u.ID, csrf, remoteAddr, userAgent, t.Format(timeLayout), sess.ExpiresAt.Format(timeLayout), t.Format(timeLayout)); err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET last_login_at = ? WHERE id = ?", t.Format(timeLayout), u.ID); err != nil {
+		return "", nil, err
+	}
+	if stale {
+		// Upgrade hashes made under older cost parameters while the
+		// password is at hand.
+		if hash, err := s.hash(password); err == nil {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE id = ?", hash, u.ID); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return secret, sess, nil
+}
+
+// Session returns the principal of a session secret.
+func (s *Store) Session(ctx context.Context, secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, sessionPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	var (
+		p         = Principal{Via: "session"}
+		scopes    string
+		expiresAt time.Time
+		lastSeen  time.Time
+	)
+	id := hashSecret(secret)
+	err := s.db.QueryRowContext(ctx, `SELECT u.id, u.username, u.scopes, s.csrf_token, s.expires_at, s.last_seen_at
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.id_hash = ?`, id).
+		Scan(&p.UserID, &p.User, &scopes, &p.CSRF, &expiresAt, &lastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := now()
+	if !t.Before(expiresAt) {
+		s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id_hash = ?", id)
+		return nil, ErrUnauthenticated
+	}
+	if t.Sub(lastSeen) >= touchEvery {
+		s.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id_hash = ?", t.Format(timeLayout), id)
+	}
+	p.Scopes = splitScopes(scopes)
+	return &p, nil
+}
+
+// Logout ends the session of a secret. Unknown secrets are ignored.
+func (s *Store) Logout(ctx context.Context, secret string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id_hash = ?", hashSecret(secret))
+	return err
+}
+
+// CreateToken issues a bearer token for a user. Its scopes must be a subset
+// of the user's; ttl 0 never expires. It returns the secret, which is not
+// stored and cannot be shown again.
+func (s *Store) CreateToken(ctx context.Context, username, name string, scopes []string, ttl time.Duration) (string, *Token, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", nil, fmt.Errorf("%w: tokens need a name", ErrInvalid)
+	}
+	u, err := s.user(ctx, username)
+	if err != nil {
+		return "", nil, err
+	}
+	if scopes, err = normalizeScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: tokens need at least one scope", ErrInvalid)
+	}
+	for _, scope := range scopes {
+		if !(&Principal{Scopes: u.Scopes}).Has(scope) {
+			return "", nil, fmt.Errorf("%w: user %s does not have scope %s", ErrInvalid, username, scope)
+		}
+	}
+
+	secret, err := newSecret(tokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	t := now()
+	tok := &Token{User: u.Username, Name: name, Hint: secret[len(secret)-4:], Scopes: scopes, CreatedAt: t}
+	var expires interface{}
+	if ttl > 0 {
+		e := t.Add(ttl)
+		tok.ExpiresAt = &e
+		expires = e.Format(timeLayout)
+	}
+	res, err := s.db.ExecContext(ctx, "INSERT INTO tokens (user_id, name, secret_hash, hint, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		u.ID, name, hashSecret(secret), tok.Hint, joinScopes(scopes), t.Format(timeLayout), expires)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	tok.ID, _ = res.LastInsertId()
+	return secret, tok, nil
+}
+
+// Token returns the principal of a bearer token. Its scopes are the token's
+// scopes that its user still has.
+func (s *Store) Token(ctx context.Context, secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	var (
+		p          = Principal{Via: "token"}
+		userScopes string
+		tokScopes  string
+		expiresAt  sql.NullTime
+		lastUsed   sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT t.id, u.id, u.username, u.scopes, t.scopes, t.expires_at, t.last_used_at
+		FROM tokens t JOIN users u ON u.id = t.user_id WHERE t.secret_hash = ?`, hashSecret(secret)).
+		Scan(&p.TokenID, &p.UserID, &p.User, &userScopes, &tokScopes, &expiresAt, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := now()
+	if expiresAt.Valid && !t.Before(expiresAt.Time) {
+		return nil, ErrUnauthenticated
+	}
+	if !lastUsed.Valid || t.Sub(lastUsed.Time) >= touchEvery {
+		s.db.ExecContext(ctx, "UPDATE tokens SET last_used_at = ? WHERE id = ?", t.Format(timeLayout), p.TokenID)
+	}
+	user := Principal{Scopes: splitScopes(userScopes)}
+	p.Scopes = []string{}
+	for _, scope := range splitScopes(tokScopes) {
+		if user.Has(scope) {
+			p.Scopes = append(p.Scopes, scope)
+		}
+	}
+	return &p, nil
+}
+
+// Tokens lists the tokens of a user, or of every user when username is empty.
+func (s *Store) Tokens(ctx context.Context, username string) ([]Token, error) {
+	q := `SELECT t.id, u.username, t.name, t.hint, t.scopes, t.created_at, t.expires_at, t.last_used_at
+		FROM tokens t JOIN users u ON u.id = t.user_id`
+	var args []interface{}
+	if username != "" {
+		q += " WHERE u.username = ?"
+		args = append(args, username)
+	}
+	rows, err := s.db.QueryContext(ctx, q+" ORDER BY t.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []Token{}
+	for rows.Next() {
+		var (
+			t         Token
+			scopes    string
+			expiresAt sql.NullTime
+			lastUsed  sql.NullTime
+		)
+		if err := rows.Scan(&t.ID, &t.User, &t.Name, &t.Hint, &scopes, &t.CreatedAt, &expiresAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.Scopes = splitScopes(scopes)
+		if expiresAt.Valid {
+			t.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes a token.
+func (s *Store) RevokeToken(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: token %d", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Purge deletes expired sessions and tokens and returns how many it removed.
+func (s *Store) Purge(ctx context.Context) (int64, error) {
+	cutoff := now().Format(timeLayout)
+	var total int64
+	for _, q := range []string{
+		"DELETE FROM sessions WHERE expires_at <= ?",
+		"DELETE FROM tokens WHERE expires_at IS NOT NULL AND expires_at <= ?",
+	} {
+		res, err := s.db.ExecContext(ctx, q, cutoff)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
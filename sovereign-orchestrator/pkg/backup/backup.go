@@ -0,0 +1,439 @@
+// Package backup takes compressed, integrity-checked backups of a live
+// SQLite database with the online backup API and prunes them into hourly,
+// daily and weekly generations.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/dbcopy"
+	"sovereign-orchestrator/pkg/fsutil"
+	"sovereign-orchestrator/pkg/vault"
+)
+
+var (
+	ErrInvalid   = errors.New("invalid backup")
+	ErrNotFound  = errors.New("backup not found")
+	ErrIntegrity = errors.New("backup failed verification")
+)
+
+const (
+	fileExt     = ".db.gz"
+	manifestExt = ".json"
+	timeLayout  = "20060102-150405"
+)
+
+// Backup describes one backup file. The manifest is written next to it.
+type Backup struct {
+	File      string    `json:"file"`
+	Database  string    `json:"database"`
+	CreatedAt time.Time `json:"created_at"`
+	Manual    bool      `json:"manual"` // Taken on request; never pruned
+	Encrypted bool      `json:"encrypted"`
+	Size      int64     `json:"size"`
+	RawSize   int64     `json:"raw_size"`   // Uncompressed database
+	SHA256    string    `json:"sha256"`     // Of the file as written
+	RawSHA256 string    `json:"raw_sha256"` // Of the uncompressed database
+	Integrity string    `json:"integrity"`  // PRAGMA integrity_check of the written file
+	Kept      []string  `json:"kept,omitempty"`
+}
+
+// Retention is how many backups each generation keeps: the newest backup of
+// each of the last Hourly hours, Daily days and Weekly ISO weeks.
+type Retention struct {
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+	Weekly int `json:"weekly"`
+}
+
+// Store manages the backups of one database in a directory.
+type Store struct {
+	Dir       string
+	Name      string     // Database name; prefixes the backup files
+	Key       *vault.Key // Seals backups when set
+	TempDir   string     // Where plaintext copies are staged; default Dir
+	Retention Retention
+}
+
+func (s *Store) tempDir() string {
+	if s.TempDir != "" {
+		return s.TempDir
+	}
+	return s.Dir
+}
+
+// Create backs up db and verifies the written file by restoring it to a
+// scratch copy and running PRAGMA integrity_check on it.
+func (s *Store) Create(ctx context.Context, db *sql.DB, manual bool) (*Backup, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory %s: %w", s.Dir, err)
+	}
+	now := time.Now()
+	b := &Backup{
+		Database:  s.Name,
+		CreatedAt: now.UTC(),
+		Manual:    manual,
+		Encrypted: s.Key != nil,
+	}
+	// Backups taken within the same second get a sequence suffix.
+	var dest string
+	for seq := 1; ; seq++ {
+		b.File = s.Name + "-" + now.Format(timeLayout)
+		if seq > 1 {
+			b.File += fmt.Sprintf("-%d", seq)
+		}
+		b.File += fileExt
+		if b.Encrypted {
+			b.File += vault.Ext
+		}
+		dest = filepath.Join(s.Dir, b.File)
+		if _, err := os.Stat(dest); err != nil {
+			break
+		}
+	}
+
+	raw := filepath.Join(s.tempDir(), "."+b.File+".raw")
+	defer os.Remove(raw)
+	if err := copyDB(ctx, db, raw); err != nil {
+		return nil, err
+	}
+
+	tmp := dest + ".tmp"
+	defer os.Remove(tmp)
+	if err := s.compress(raw, tmp, b); err != nil {
+		return nil, err
+	}
+	check := filepath.Join(s.tempDir(), "."+b.File+".check")
+	defer os.Remove(check)
+	if err := s.extract(ctx, tmp, check, b); err != nil {
+		return nil, err
+	}
+	b.Integrity = "ok"
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, err
+	}
+	manifest, _ := json.MarshalIndent(b, "", "  ")
+	if err := fsutil.WriteFileAtomic(dest+manifestExt, manifest, 0600); err != nil {
+		os.Remove(dest)
+		return nil, fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return b, nil
+}
+
+// copyDB writes a consistent copy of db to path with the online backup API.
+func copyDB(ctx context.Context, db *sql.DB, path string) error {
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if err := dbcopy.Backup(ctx, dst, db, nil); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	return nil
+}
+
+// compress gzips raw into path, sealing it when the store has a key, and
+// records sizes and hashes in b.
+func (s *Store) compress(raw, path string, b *Backup) error {
+	in, err := os.Open(raw)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	rawHash, fileHash := sha256.New(), sha256.New()
+	counter := &fsutil.CountWriter{W: io.MultiWriter(out, fileHash)}
+	var sink io.Writer = counter
+	var pw *io.PipeWriter
+	var sealed chan error
+	if s.Key != nil {
+		// Compress first: sealed data does not compress.
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		sealed = make(chan error, 1)
+		go func() {
+			err := s.Key.Seal(counter, pr)
+			pr.CloseWithError(err)
+			sealed <- err
+		}()
+		sink = pw
+	}
+	zw := gzip.NewWriter(sink)
+	zw.Name = b.Database + ".db"
+	zw.ModTime = b.CreatedAt
+	b.RawSize, err = io.Copy(io.MultiWriter(zw, rawHash), in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if pw != nil {
+		pw.CloseWithError(err)
+		if serr := <-sealed; err == nil {
+			err = serr
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compress backup: %w", err)
+	}
+	b.Size = counter.N
+	b.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	b.RawSHA256 = hex.EncodeToString(rawHash.Sum(nil))
+	return nil
+}
+
+// extract checks path against b, unpacks it to dest and runs
+// PRAGMA integrity_check on the result.
+func (s *Store) extract(ctx context.Context, path, dest string, b *Backup) error {
+	if b.Encrypted && s.Key == nil {
+		return fmt.Errorf("%w: %s is encrypted", vault.ErrNoKey, b.File)
+	}
+	sum, err := fsutil.FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if sum != b.SHA256 {
+		return fmt.Errorf("%w: %s checksum mismatch", ErrIntegrity, b.File)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var src io.Reader = in
+	if b.Encrypted {
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(s.Key.Open(pw, in)) }()
+		defer pr.Close()
+		src = pr
+	}
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrIntegrity, b.File, err)
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	rawHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, rawHash), zr)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("%w: %s: %v", ErrIntegrity, b.File, err)
+	}
+	if hex.EncodeToString(rawHash.Sum(nil)) != b.RawSHA256 {
+		os.Remove(dest)
+		return fmt.Errorf("%w: %s content checksum mismatch", ErrIntegrity, b.File)
+	}
+	if err := integrityCheck(ctx, dest); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("%w: %s: %v", ErrIntegrity, b.File, err)
+	}
+	return nil
+}
+
+func integrityCheck(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity_check: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Extract verifies a backup and unpacks it to dest, which must not exist.
+func (s *Store) Extract(ctx context.Context, file, dest string) (*Backup, error) {
+	b, err := s.Get(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return nil, fmt.Errorf("%w: %s already exists", ErrInvalid, filepath.Base(dest))
+	}
+	if err := s.extract(ctx, filepath.Join(s.Dir, b.File), dest, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Get reads the manifest of one backup.
+func (s *Store) Get(file string) (*Backup, error) {
+	if file != filepath.Base(file) || !strings.Contains(file, fileExt) {
+		return nil, fmt.Errorf("%w: %q is not a backup file name", ErrInvalid, file)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, file+manifestExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%w: %s has an unreadable manifest", ErrIntegrity, file)
+	}
+	if b.File != file {
+		return nil, fmt.Errorf("%w: %s manifest names %s", ErrIntegrity, file, b.File)
+	}
+	return &b, nil
+}
+
+// List returns the store's backups newest first, each with the generations
+// that keep it.
+func (s *Store) List() ([]Backup, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, s.Name+"-*"+fileExt+"*"+manifestExt))
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, m := range matches {
+		b, err := s.Get(strings.TrimSuffix(filepath.Base(m), manifestExt))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, *b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	s.Retention.mark(backups)
+	return backups, nil
+}
+
+// mark fills in Kept for backups sorted newest first. The newest backup
+// is always kept.
+func (r Retention) mark(backups []Backup) {
+	generations := []struct {
+		name   string
+		keep   int
+		bucket func(time.Time) string
+	}{
+		{"hourly", r.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{"daily", r.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{"weekly", r.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", y, w)
+		}},
+	}
+	for i := range backups {
+		backups[i].Kept = nil
+		if backups[i].Manual {
+			backups[i].Kept = []string{"manual"}
+		}
+	}
+	for _, g := range generations {
+		seen := make(map[string]bool)
+		for i := range backups {
+			if backups[i].Manual {
+				continue
+			}
+			key := g.bucket(backups[i].CreatedAt.Local())
+			if seen[key] {
+				continue
+			}
+			if len(seen) == g.keep {
+				break
+			}
+			seen[key] = true
+			backups[i].Kept = append(backups[i].Kept, g.name)
+		}
+	}
+	if len(backups) > 0 && len(backups[0].Kept) == 0 {
+		backups[0].Kept = []string{"latest"}
+	}
+}
+
+// Prune deletes the scheduled backups no generation keeps and returns them.
+func (s *Store) Prune() ([]Backup, error) {
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []Backup
+	for _, b := range backups {
+		if len(b.Kept) > 0 {
+			continue
+		}
+		path := filepath.Join(s.Dir, b.File)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		os.Remove(path + manifestExt)
+		removed = append(removed, b)
+	}
+	return removed, nil
+}
+
+// Rekey re-encrypts a sealed backup from secret to k and updates its
+// manifest.
+func (s *Store) Rekey(file string, secret []byte, k *vault.Key) error {
+	b, err := s.Get(file)
+	if err != nil {
+		return err
+	}
+	if !b.Encrypted {
+		return fmt.Errorf("%w: %s is not encrypted", ErrInvalid, file)
+	}
+	path := filepath.Join(s.Dir, b.File)
+	if sum, err := fsutil.FileSHA256(path); err != nil || sum != b.SHA256 {
+		return fmt.Errorf("%w: %s checksum mismatch", ErrIntegrity, b.File)
+	}
+	if err := vault.RekeyFile(path, secret, k); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if b.SHA256, err = fsutil.FileSHA256(path); err != nil {
+		return err
+	}
+	b.Size = info.Size()
+	b.Kept = nil
+	manifest, _ := json.MarshalIndent(b, "", "  ")
+	return fsutil.WriteFileAtomic(path+manifestExt, manifest, 0600)
+}
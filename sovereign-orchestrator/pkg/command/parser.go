@@ -7,8 +7,8 @@ import (
 
 // Command represents a parsed command with its name, arguments, and flags.
 type Command struct {
-	Name string
-	Args []string
+	Name  string
+	Args  []string
 	Flags map[string]string
 }
 
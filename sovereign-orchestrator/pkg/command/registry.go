@@ -0,0 +1,230 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrHelp is returned by Dispatch when help was requested instead of a command being run.
+var ErrHelp = flag.ErrHelp
+
+// Handler executes a parsed subcommand.
+type Handler func(cmd *Command) error
+
+// Subcommand describes a single CLI subcommand, its flags and its help text.
+type Subcommand struct {
+	Name    string
+	Usage   string // e.g. "swrap [flags] -- <command> [args...]"
+	Summary string // One line shown in the command list
+	Help    string // Longer description shown by "<name> -h"
+
+	// Flags registers the subcommand's flags on its own FlagSet.
+	Flags func(fs *flag.FlagSet)
+	// Run is called with the parsed command. It may be nil when Sub is set.
+	Run Handler
+	// Sub holds nested subcommands, e.g. "db migrate status".
+	Sub *Registry
+}
+
+// Registry maps subcommand names to their definitions.
+type Registry struct {
+	prefix string
+	cmds   map[string]*Subcommand
+	out    io.Writer
+}
+
+// NewRegistry creates an empty registry. prefix is the command path printed in
+// usage messages (e.g. "sovereign" or "sovereign db").
+func NewRegistry(prefix string) *Registry {
+	return &Registry{
+		prefix: prefix,
+		cmds:   make(map[string]*Subcommand),
+		out:    os.Stderr,
+	}
+}
+
+// SetOutput sets the destination for usage and help messages.
+func (r *Registry) SetOutput(w io.Writer) {
+	r.out = w
+	for _, sc := range r.cmds {
+		if sc.Sub != nil {
+			sc.Sub.SetOutput(w)
+		}
+	}
+}
+
+// Register adds a subcommand. It panics on duplicate names, as that is a programming error.
+func (r *Registry) Register(sc *Subcommand) {
+	if _, exists := r.cmds[sc.Name]; exists {
+		panic(fmt.Sprintf("command: duplicate subcommand %q", sc.Name))
+	}
+	if sc.Sub != nil {
+		sc.Sub.setPrefix(r.prefix + " " + sc.Name)
+		sc.Sub.SetOutput(r.out)
+	}
+	r.cmds[sc.Name] = sc
+}
+
+// setPrefix updates the usage prefix of r and of any nested registries.
+func (r *Registry) setPrefix(prefix string) {
+	r.prefix = prefix
+	for name, sc := range r.cmds {
+		if sc.Sub != nil {
+			sc.Sub.setPrefix(prefix + " " + name)
+		}
+	}
+}
+
+// Lookup returns the subcommand registered under name.
+func (r *Registry) Lookup(name string) (*Subcommand, bool) {
+	sc, ok := r.cmds[name]
+	return sc, ok
+}
+
+// Names returns the registered subcommand names in sorted order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.cmds))
+	for name := range r.cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dispatch parses args (starting with the subcommand name) and runs the matching handler.
+func (r *Registry) Dispatch(args []string) error {
+	if len(args) == 0 {
+		r.PrintUsage()
+		return fmt.Errorf("%s: missing subcommand", r.prefix)
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		if len(args) > 1 {
+			if sc, ok := r.cmds[args[1]]; ok {
+				r.printSubcommandHelp(sc, r.newFlagSet(sc))
+				return ErrHelp
+			}
+		}
+		r.PrintUsage()
+		return ErrHelp
+	}
+
+	sc, ok := r.cmds[name]
+	if !ok {
+		r.PrintUsage()
+		return fmt.Errorf("%s: unknown subcommand %q", r.prefix, name)
+	}
+
+	fs := r.newFlagSet(sc)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if sc.Run == nil {
+		if sc.Sub == nil {
+			return fmt.Errorf("%s %s: subcommand has no handler", r.prefix, name)
+		}
+		return sc.Sub.Dispatch(fs.Args())
+	}
+
+	return sc.Run(newCommand(name, fs))
+}
+
+// PrintUsage writes the list of registered subcommands.
+func (r *Registry) PrintUsage() {
+	fmt.Fprintf(r.out, "Usage: %s <command> [flags] [args...]\n\n", r.prefix)
+	r.printCommands()
+}
+
+func (r *Registry) printCommands() {
+	fmt.Fprintln(r.out, "Commands:")
+	for _, name := range r.Names() {
+		fmt.Fprintf(r.out, "  %-14s %s\n", name, r.cmds[name].Summary)
+	}
+	fmt.Fprintf(r.out, "\nRun '%s help <command>' for details on a command.\n", r.prefix)
+}
+
+func (r *Registry) newFlagSet(sc *Subcommand) *flag.FlagSet {
+	fs := flag.NewFlagSet(r.prefix+" "+sc.Name, flag.ContinueOnError)
+	fs.SetOutput(r.out)
+	if sc.Flags != nil {
+		sc.Flags(fs)
+	}
+	fs.Usage = func() { r.printSubcommandHelp(sc, fs) }
+	return fs
+}
+
+func (r *Registry) printSubcommandHelp(sc *Subcommand, fs *flag.FlagSet) {
+	usage := sc.Usage
+	if usage == "" {
+		usage = sc.Name + " [flags]"
+	}
+	fmt.Fprintf(r.out, "Usage: %s %s\n", r.prefix, usage)
+	if sc.Help != "" {
+		fmt.Fprintf(r.out, "\n%s\n", strings.TrimSpace(sc.Help))
+	} else if sc.Summary != "" {
+		fmt.Fprintf(r.out, "\n%s\n", sc.Summary)
+	}
+
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(r.out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if sc.Sub != nil {
+		fmt.Fprintln(r.out)
+		sc.Sub.printCommands()
+	}
+}
+
+// newCommand converts a parsed FlagSet into a Command. Every defined flag is
+// present in Flags, holding either the parsed value or its default.
+func newCommand(name string, fs *flag.FlagSet) *Command {
+	cmd := &Command{
+		Name:  name,
+		Args:  fs.Args(),
+		Flags: make(map[string]string),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		cmd.Flags[f.Name] = f.Value.String()
+	})
+	return cmd
+}
+
+// IsHelp reports whether err only signals that help text was printed.
+func IsHelp(err error) bool {
+	return errors.Is(err, ErrHelp)
+}
+
+// Bool reports whether the named flag holds a true value.
+func (c *Command) Bool(name string) bool {
+	v, _ := strconv.ParseBool(c.Flags[name])
+	return v
+}
+
+// Int returns the named flag parsed as an integer.
+func (c *Command) Int(name string) (int, error) {
+	v, err := strconv.Atoi(c.Flags[name])
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for --%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// Duration returns the named flag parsed as a time.Duration.
+func (c *Command) Duration(name string) (time.Duration, error) {
+	v, err := time.ParseDuration(c.Flags[name])
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for --%s: %w", name, err)
+	}
+	return v, nil
+}
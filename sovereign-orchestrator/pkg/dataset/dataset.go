@@ -0,0 +1,254 @@
+// Package dataset exports memory tables as JSONL fine-tuning corpora.
+//
+// Conversation history (ch) becomes chat-format examples, one per session,
+// and the knowledge tables (jon, user_context) become instruction pairs.
+// Examples are scrubbed of personal data, deduplicated, split into train
+// and validation files by a stable hash, and described by a manifest with
+// counts and SHA-256 digests.
+package dataset
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned for malformed export requests.
+var ErrInvalid = errors.New("invalid dataset request")
+
+const (
+	// DefaultValRatio is the share of examples written to the validation file.
+	DefaultValRatio = 0.1
+	// progressEvery is how many rows are read between progress reports.
+	progressEvery = 500
+
+	TrainFile    = "train.jsonl"
+	ValFile      = "val.jsonl"
+	ManifestFile = "manifest.json"
+)
+
+// Formats lists the exportable tables and the format each produces.
+var Formats = map[string]string{
+	"ch":           "chat",
+	"jon":          "instruction",
+	"user_context": "instruction",
+}
+
+// Spec selects what to export and how.
+type Spec struct {
+	Table    string  `json:"table" schema:"required,minLength=1"`
+	IDs      []int64 `json:"ids,omitempty"`              // Selected rows; empty exports the whole table
+	ValRatio float64 `json:"val_ratio" schema:"max=0.5"` // 0 to 0.5; negative disables the split
+	Seed     string  `json:"seed,omitempty"`
+	Dedupe   bool    `json:"dedupe"`
+	Scrub    bool    `json:"scrub"`
+	Rules    []Rule  `json:"rules,omitempty"` // Extra scrub rules, applied after the defaults
+}
+
+// Progress is reported while an export runs.
+type Progress struct {
+	Phase string `json:"phase"` // "queued", "reading", "writing" or "done"
+	Rows  int    `json:"rows"`  // Source rows read so far
+	Total int    `json:"total"` // Source rows selected
+}
+
+// FileInfo describes a written JSONL file.
+type FileInfo struct {
+	Name     string `json:"name"`
+	Examples int    `json:"examples"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+}
+
+// Counts summarizes an export.
+type Counts struct {
+	Rows       int            `json:"rows"`       // Source rows read
+	Skipped    int            `json:"skipped"`    // Rows that could not form an example
+	Examples   int            `json:"examples"`   // Unique examples written
+	Duplicates int            `json:"duplicates"` // Examples dropped as duplicates
+	Train      int            `json:"train"`
+	Val        int            `json:"val"`
+	Scrubbed   map[string]int `json:"scrubbed"` // Replacements per scrub rule
+}
+
+// Manifest is written next to the dataset files.
+type Manifest struct {
+	ID          string     `json:"id"` // Name of the dataset directory
+	Database    string     `json:"database"`
+	Table       string     `json:"table"`
+	Format      string     `json:"format"`
+	Spec        Spec       `json:"spec"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Counts      Counts     `json:"counts"`
+	Files       []FileInfo `json:"files"`
+	Fingerprint string     `json:"fingerprint"` // SHA-256 over the file digests
+}
+
+// Validate checks a spec and fills in defaults.
+func (s *Spec) Validate() error {
+	if _, ok := Formats[s.Table]; !ok {
+		names := make([]string, 0, len(Formats))
+		for name := range Formats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%w: table %q cannot be exported (want %s)", ErrInvalid, s.Table, strings.Join(names, ", "))
+	}
+	if s.ValRatio == 0 {
+		s.ValRatio = DefaultValRatio
+	}
+	if s.ValRatio > 0.5 {
+		return fmt.Errorf("%w: val_ratio must be at most 0.5", ErrInvalid)
+	}
+	if s.ValRatio < 0 {
+		s.ValRatio = 0
+	}
+	_, err := NewScrubber(s.Rules)
+	return err
+}
+
+// example is one training record before encoding.
+type example interface{}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatExample struct {
+	Messages []chatMessage `json:"messages"`
+}
+
+type instructionExample struct {
+	Instruction string `json:"instruction"`
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+}
+
+// Export writes the dataset selected by spec from db into dir, which must
+// not exist yet, and returns its manifest. Files are written under a
+// temporary name and only appear in dir once complete.
+func Export(ctx context.Context, db *sql.DB, name string, spec Spec, dir string, progress func(Progress)) (*Manifest, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+	var rules []Rule
+	if spec.Scrub {
+		rules = append(rules, DefaultRules...)
+	}
+	rules = append(rules, spec.Rules...)
+	scrubber, err := NewScrubber(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := dir + ".tmp"
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dataset directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	w, err := newSplitWriter(tmp, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+
+	m := &Manifest{
+		ID:        filepath.Base(dir),
+		Database:  name,
+		Table:     spec.Table,
+		Format:    Formats[spec.Table],
+		Spec:      spec,
+		CreatedAt: time.Now().UTC(),
+	}
+	where, args := selection(spec.IDs)
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+spec.Table+" WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count %s rows: %w", spec.Table, err)
+	}
+	progress(Progress{Phase: "reading", Total: total})
+
+	r := &reader{
+		ctx:    ctx,
+		db:     db,
+		where:  where,
+		args:   args,
+		scrub:  scrubber.Scrub,
+		counts: &m.Counts,
+		emit:   func(ex example) error { return w.write(ex, &m.Counts) },
+		advance: func() {
+			if m.Counts.Rows++; m.Counts.Rows%progressEvery == 0 {
+				progress(Progress{Phase: "reading", Rows: m.Counts.Rows, Total: total})
+			}
+		},
+	}
+	if m.Format == "chat" {
+		err = r.chat()
+	} else {
+		err = r.instructions(spec.Table)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress(Progress{Phase: "writing", Rows: m.Counts.Rows, Total: m.Counts.Rows})
+	if m.Files, err = w.finish(); err != nil {
+		return nil, err
+	}
+	m.Counts.Scrubbed = scrubber.Counts()
+	h := sha256.New()
+	for _, f := range m.Files {
+		fmt.Fprintf(h, "%s %s\n", f.SHA256, f.Name)
+	}
+	m.Fingerprint = hex.EncodeToString(h.Sum(nil))
+
+	data, _ := json.MarshalIndent(m, "", "  ")
+	if err := os.WriteFile(filepath.Join(tmp, ManifestFile), data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, fmt.Errorf("failed to publish dataset: %w", err)
+	}
+	progress(Progress{Phase: "done", Rows: m.Counts.Rows, Total: m.Counts.Rows})
+	return m, nil
+}
+
+// List reads the manifests of the datasets under root, newest first.
+// Unfinished exports have no manifest and are left out.
+func List(root string) ([]Manifest, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	manifests := []Manifest{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(root, e.Name(), ManifestFile))
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		if json.Unmarshal(data, &m) == nil {
+			manifests = append(manifests, m)
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].CreatedAt.After(manifests[j].CreatedAt) })
+	return manifests, nil
+}
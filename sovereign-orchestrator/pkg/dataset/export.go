@@ -0,0 +1,143 @@
+package dataset
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// potentialInsightKey marks jon rows captured heuristically from chat.
+const potentialInsightKey = "potential_insight"
+
+// selection returns the WHERE clause for the selected ids, or every row.
+func selection(ids []int64) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "1", nil
+	}
+	marks := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args[i] = id
+	}
+	return "id IN (" + strings.Join(marks, ", ") + ")", args
+}
+
+// reader turns selected rows into examples.
+type reader struct {
+	ctx     context.Context
+	db      *sql.DB
+	where   string
+	args    []interface{}
+	scrub   func(string) string
+	counts  *Counts
+	emit    func(example) error
+	advance func() // Called once per source row
+}
+
+// chat groups ch rows by session into one conversation each. Inputs become
+// user turns and outputs assistant turns; consecutive turns of one role are
+// joined. Conversations are trimmed to start with the user and end with the
+// assistant. Rows without a session or of another type are skipped.
+func (r *reader) chat() error {
+	rows, err := r.db.QueryContext(r.ctx, `SELECT COALESCE(session_id, ''), COALESCE(type, ''), COALESCE(content, '')
+		FROM ch WHERE `+r.where+` ORDER BY session_id, julianday(timestamp), id`, r.args...)
+	if err != nil {
+		return fmt.Errorf("failed to read ch: %w", err)
+	}
+	defer rows.Close()
+
+	var session string
+	var turns []chatMessage
+	var used int // Rows folded into turns
+	flush := func() error {
+		start, end := 0, len(turns)
+		for start < end && turns[start].Role != "user" {
+			start++
+		}
+		for end > start && turns[end-1].Role != "assistant" {
+			end--
+		}
+		if end-start < 2 {
+			r.counts.Skipped += used
+		} else {
+			if err := r.emit(chatExample{Messages: turns[start:end]}); err != nil {
+				return err
+			}
+		}
+		turns, used = nil, 0
+		return nil
+	}
+
+	for rows.Next() {
+		var sid, typ, content string
+		if err := rows.Scan(&sid, &typ, &content); err != nil {
+			return err
+		}
+		r.advance()
+		if sid != session {
+			if err := flush(); err != nil {
+				return err
+			}
+			session = sid
+		}
+		role := map[string]string{"input": "user", "output": "assistant"}[typ]
+		content = strings.TrimSpace(r.scrub(content))
+		if sid == "" || role == "" || content == "" {
+			r.counts.Skipped++
+			continue
+		}
+		used++
+		if n := len(turns); n > 0 && turns[n-1].Role == role {
+			turns[n-1].Content += "\n\n" + content
+		} else {
+			turns = append(turns, chatMessage{Role: role, Content: content})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// instructions turns knowledge rows into instruction/output pairs: the key
+// becomes the question, the value the answer and the context the input.
+// Rows without a value are skipped.
+func (r *reader) instructions(table string) error {
+	rows, err := r.db.QueryContext(r.ctx, `SELECT COALESCE(category, ''), COALESCE(key, ''), COALESCE(value, ''), COALESCE(context, '')
+		FROM `+table+` WHERE `+r.where+` ORDER BY id`, r.args...)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category, key, value, context string
+		if err := rows.Scan(&category, &key, &value, &context); err != nil {
+			return err
+		}
+		r.advance()
+		value = strings.TrimSpace(r.scrub(value))
+		if value == "" {
+			r.counts.Skipped++
+			continue
+		}
+		ex := instructionExample{Input: strings.TrimSpace(r.scrub(context)), Output: value}
+		topic := strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ").Replace(key))
+		switch {
+		case key == potentialInsightKey:
+			ex.Instruction = "What have you learned about the user?"
+		case topic == "":
+			ex.Instruction = "What do you know about the user?"
+		default:
+			ex.Instruction = fmt.Sprintf("What do you know about the user's %s?", r.scrub(topic))
+		}
+		if category != "" && key != potentialInsightKey {
+			ex.Instruction = fmt.Sprintf("[%s] %s", r.scrub(category), ex.Instruction)
+		}
+		if err := r.emit(ex); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
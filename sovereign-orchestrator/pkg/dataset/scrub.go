@@ -0,0 +1,67 @@
+package dataset
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Rule replaces every match of Pattern with Replace. Replace may use $1
+// style references to groups.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// DefaultRules scrub the personal data most often found in terminal and
+// chat history. Secrets come first so tokens are not half-matched as
+// phone or card numbers.
+var DefaultRules = []Rule{
+	{Name: "private_key", Pattern: `(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`, Replace: "[PRIVATE_KEY]"},
+	{Name: "api_key", Pattern: `\b(?:sk-[A-Za-z0-9_-]{16,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[A-Za-z0-9]{30,}|xox[abpr]-[A-Za-z0-9-]{10,})\b`, Replace: "[SECRET]"},
+	{Name: "bearer", Pattern: `(?i)\b(bearer|token|password|passwd|secret|api[_-]?key)(\s*[:=]\s*|\s+)["']?[^\s"']{6,}`, Replace: "$1$2[SECRET]"},
+	{Name: "email", Pattern: `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`, Replace: "[EMAIL]"},
+	{Name: "card", Pattern: `\b(?:\d[ -]?){13,16}\b`, Replace: "[CARD]"},
+	{Name: "ssn", Pattern: `\b\d{3}-\d{2}-\d{4}\b`, Replace: "[SSN]"},
+	{Name: "phone", Pattern: `(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`, Replace: "[PHONE]"},
+	{Name: "ipv4", Pattern: `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`, Replace: "[IP]"},
+	{Name: "home_path", Pattern: `(/home/|/Users/)[^/\s]+`, Replace: "${1}[USER]"},
+}
+
+// Scrubber applies compiled rules and counts their matches.
+type Scrubber struct {
+	rules  []Rule
+	res    []*regexp.Regexp
+	counts map[string]int
+}
+
+// NewScrubber compiles rules. An invalid pattern is reported with its rule name.
+func NewScrubber(rules []Rule) (*Scrubber, error) {
+	s := &Scrubber{rules: rules, counts: make(map[string]int)}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: scrub rule %q: %v", ErrInvalid, r.Name, err)
+		}
+		s.res = append(s.res, re)
+	}
+	return s, nil
+}
+
+// Scrub returns text with every rule applied in order.
+func (s *Scrubber) Scrub(text string) string {
+	for i, re := range s.res {
+		n := 0
+		text = re.ReplaceAllStringFunc(text, func(m string) string {
+			n++
+			return re.ReplaceAllString(m, s.rules[i].Replace)
+		})
+		s.counts[s.rules[i].Name] += n
+	}
+	return text
+}
+
+// Counts returns the number of replacements made per rule.
+func (s *Scrubber) Counts() map[string]int {
+	return s.counts
+}
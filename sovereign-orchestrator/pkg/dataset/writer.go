@@ -0,0 +1,127 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// jsonlFile is one output file being written and hashed.
+type jsonlFile struct {
+	name     string
+	f        *os.File
+	buf      *bufio.Writer
+	sum      hash.Hash
+	examples int
+	bytes    int64
+}
+
+func createJSONL(dir, name string) (*jsonlFile, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	sum := sha256.New()
+	return &jsonlFile{name: name, f: f, buf: bufio.NewWriter(io.MultiWriter(f, sum)), sum: sum}, nil
+}
+
+func (j *jsonlFile) write(line []byte) error {
+	n, err := j.buf.Write(line)
+	j.bytes += int64(n)
+	j.examples++
+	return err
+}
+
+// splitWriter deduplicates examples and spreads them over the train and
+// validation files. An example lands in validation when a hash of the seed
+// and its encoding falls below the ratio, so a given example always goes
+// to the same file for a given seed.
+type splitWriter struct {
+	spec  Spec
+	train *jsonlFile
+	val   *jsonlFile // nil when the split is disabled
+	seen  map[[sha256.Size]byte]bool
+}
+
+func newSplitWriter(dir string, spec Spec) (*splitWriter, error) {
+	w := &splitWriter{spec: spec, seen: make(map[[sha256.Size]byte]bool)}
+	var err error
+	if w.train, err = createJSONL(dir, TrainFile); err != nil {
+		return nil, err
+	}
+	if spec.ValRatio > 0 {
+		if w.val, err = createJSONL(dir, ValFile); err != nil {
+			w.close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *splitWriter) write(ex example, counts *Counts) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ex); err != nil {
+		return err
+	}
+	line := buf.Bytes()
+	if w.spec.Dedupe {
+		key := sha256.Sum256(line)
+		if w.seen[key] {
+			counts.Duplicates++
+			return nil
+		}
+		w.seen[key] = true
+	}
+
+	counts.Examples++
+	if w.val != nil {
+		h := sha256.New()
+		h.Write([]byte(w.spec.Seed))
+		h.Write([]byte{0})
+		h.Write(line)
+		if float64(binary.BigEndian.Uint64(h.Sum(nil)))/math.MaxUint64 < w.spec.ValRatio {
+			counts.Val++
+			return w.val.write(line)
+		}
+	}
+	counts.Train++
+	return w.train.write(line)
+}
+
+// finish flushes and closes the files and describes them.
+func (w *splitWriter) finish() ([]FileInfo, error) {
+	var files []FileInfo
+	for _, j := range []*jsonlFile{w.train, w.val} {
+		if j == nil {
+			continue
+		}
+		if err := j.buf.Flush(); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", j.name, err)
+		}
+		if err := j.f.Close(); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", j.name, err)
+		}
+		files = append(files, FileInfo{Name: j.name, Examples: j.examples, Bytes: j.bytes, SHA256: hex.EncodeToString(j.sum.Sum(nil))})
+	}
+	return files, nil
+}
+
+// close releases the files; it is safe to call after finish.
+func (w *splitWriter) close() {
+	for _, j := range []*jsonlFile{w.train, w.val} {
+		if j != nil {
+			j.f.Close()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package dbcopy copies live SQLite databases with the online backup API,
+// which produces a consistent copy while other connections keep writing.
+package dbcopy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// pagesPerStep bounds how long the source is locked at a time.
+	pagesPerStep = 256
+	// stepPause lets writers on the source make progress between steps.
+	stepPause = 5 * time.Millisecond
+)
+
+// Progress is reported after every backup step.
+type Progress struct {
+	Remaining int `json:"remaining"` // Pages left to copy
+	Total     int `json:"total"`     // Pages in the source
+}
+
+// Backup copies the main database of src over the main database of dst.
+// progress, if non-nil, is called after every step. If the source is written
+// to during the copy, SQLite restarts the copy so the result is consistent.
+func Backup(ctx context.Context, dst, src *sql.DB, progress func(Progress)) error {
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstRaw interface{}) error {
+		return srcConn.Raw(func(srcRaw interface{}) error {
+			d, ok := dstRaw.(*sqlite3.SQLiteConn)
+			s, ok2 := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return fmt.Errorf("backup requires sqlite3 connections")
+			}
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			for {
+				done, err := b.Step(pagesPerStep)
+				if err != nil {
+					b.Close()
+					return fmt.Errorf("backup step failed: %w", err)
+				}
+				if progress != nil {
+					progress(Progress{Remaining: b.Remaining(), Total: b.PageCount()})
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					b.Close()
+					return ctx.Err()
+				case <-time.After(stepPause):
+				}
+			}
+			if err := b.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
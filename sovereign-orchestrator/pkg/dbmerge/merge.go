@@ -0,0 +1,479 @@
+// Package dbmerge unions the rows of one SQLite database into another, table
+// by table. Rows are matched on natural keys (ids are local to each copy),
+// identical rows are skipped, and rows whose key matches but whose content
+// differs are resolved by a conflict policy. A merge can run as a dry run,
+// which does all the work in a transaction and rolls it back.
+package dbmerge
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sovereign-orchestrator/pkg/dbschema"
+)
+
+// Policy decides what happens to a source row whose natural key exists in
+// the target with different content.
+type Policy string
+
+const (
+	KeepNewest Policy = "keep-newest" // The row with the later timestamp wins; tables without one keep the target row
+	KeepBoth   Policy = "keep-both"   // Insert the source row as well, unless a unique index forbids it
+	Fail       Policy = "fail"        // Abort the merge
+)
+
+var (
+	// ErrInvalid is returned for merge requests that cannot run.
+	ErrInvalid = errors.New("invalid merge request")
+	// ErrConflict is returned by the fail policy when any conflict exists.
+	ErrConflict = errors.New("merge conflicts found")
+)
+
+// NaturalKeys are the expressions identifying the same logical row in two
+// copies of the memory database; "{}" stands for the table alias. Rows whose
+// first key is NULL, and tables not listed, are matched on their content.
+// Heuristic jon captures all share one key, so their value is part of it.
+var NaturalKeys = map[string][]string{
+	"ch":               {"CASE WHEN json_valid({}.metadata) THEN json_extract({}.metadata, '$.id') END"},
+	"prime_directives": {"{}.directive"},
+	"jon":              {"{}.key", "{}.category", "CASE WHEN {}.key = 'potential_insight' THEN {}.value END"},
+	"user_context":     {"{}.key", "{}.category"},
+}
+
+// Skipped tables are never merged.
+var Skipped = map[string]string{
+	"schema_versions": "schema history belongs to each database",
+	"autonomy_config": "settings are local to each machine",
+	"undo_journal":    "undo tokens are local to each database",
+	"embeddings":      "vectors are keyed to local row ids and re-embedded after the merge",
+	"embedding_lists": "the IVF index is rebuilt from the local vectors",
+}
+
+// timestampColumn orders rows for the keep-newest policy.
+const timestampColumn = "timestamp"
+
+// maxConflictSamples limits the source row ids listed per table.
+const maxConflictSamples = 20
+
+// Options configure a merge.
+type Options struct {
+	Policy Policy
+	DryRun bool
+	Tables []string // Limit the merge to these tables; empty means all
+}
+
+// TableReport is the outcome for one table.
+type TableReport struct {
+	Table       string  `json:"table"`
+	Skipped     string  `json:"skipped,omitempty"` // Reason the table was not merged
+	SourceRows  int64   `json:"source_rows"`
+	New         int64   `json:"new"`
+	Duplicates  int64   `json:"duplicates"`
+	Conflicts   int64   `json:"conflicts"`
+	Inserted    int64   `json:"inserted"`
+	Updated     int64   `json:"updated"`
+	Ignored     int64   `json:"ignored"`    // Rows a unique index kept out of the target
+	Unresolved  int64   `json:"unresolved"` // Conflicts keep-newest could not decide for lack of a timestamp column
+	ConflictIDs []int64 `json:"conflict_ids,omitempty"`
+}
+
+// Report is the outcome of a merge.
+type Report struct {
+	Source     string        `json:"source"`
+	Target     string        `json:"target"`
+	Policy     Policy        `json:"policy"`
+	DryRun     bool          `json:"dry_run"`
+	Committed  bool          `json:"committed"`
+	Tables     []TableReport `json:"tables"`
+	Inserted   int64         `json:"inserted"`
+	Updated    int64         `json:"updated"`
+	Duplicates int64         `json:"duplicates"`
+	Conflicts  int64         `json:"conflicts"`
+	Unresolved int64         `json:"unresolved"`
+}
+
+// Merge merges the database file at sourcePath into target. The report is
+// returned with ErrConflict when the fail policy finds conflicts.
+func Merge(ctx context.Context, target *sql.DB, sourcePath string, opts Options) (*Report, error) {
+	switch opts.Policy {
+	case KeepNewest, KeepBoth, Fail:
+	case "":
+		opts.Policy = KeepNewest
+	default:
+		return nil, fmt.Errorf("%w: unknown policy %q", ErrInvalid, opts.Policy)
+	}
+	report := &Report{Policy: opts.Policy, DryRun: opts.DryRun, Tables: []TableReport{}}
+
+	conn, err := target.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS src", sourcePath); err != nil {
+		return nil, fmt.Errorf("failed to attach %s: %w", sourcePath, err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE src")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin merge transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	targetTables, err := tableColumns(ctx, tx, "main")
+	if err != nil {
+		return nil, err
+	}
+	sourceTables, err := tableColumns(ctx, tx, "src")
+	if err != nil {
+		return nil, err
+	}
+	names := opts.Tables
+	if len(names) == 0 {
+		for name := range sourceTables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		tr := TableReport{Table: name}
+		src, inSource := sourceTables[name]
+		dst, inTarget := targetTables[name]
+		switch {
+		case Skipped[name] != "":
+			tr.Skipped = Skipped[name]
+		case !inSource:
+			if len(opts.Tables) > 0 {
+				return nil, fmt.Errorf("%w: table %q not in source", ErrInvalid, name)
+			}
+			continue
+		case !inTarget:
+			tr.Skipped = "table does not exist in the target"
+		case src.virtual || dst.virtual:
+			tr.Skipped = "virtual tables are rebuilt from their content tables"
+		case src.withoutRowID || dst.withoutRowID:
+			tr.Skipped = "WITHOUT ROWID tables are not supported"
+		default:
+			if err := mergeTable(ctx, tx, name, src, dst, opts.Policy, &tr); err != nil {
+				return nil, fmt.Errorf("failed to merge %s: %w", name, err)
+			}
+		}
+		report.Tables = append(report.Tables, tr)
+		report.Inserted += tr.Inserted
+		report.Updated += tr.Updated
+		report.Duplicates += tr.Duplicates
+		report.Conflicts += tr.Conflicts
+		report.Unresolved += tr.Unresolved
+	}
+
+	if opts.Policy == Fail && report.Conflicts > 0 {
+		return report, fmt.Errorf("%w: %d row(s) differ from the target", ErrConflict, report.Conflicts)
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	report.Committed = true
+	return report, nil
+}
+
+// tableInfo is what the merge needs to know about a table on one side.
+type tableInfo struct {
+	columns      []string
+	rowIDAlias   string // INTEGER PRIMARY KEY column, not copied between databases
+	virtual      bool
+	withoutRowID bool
+}
+
+func tableColumns(ctx context.Context, tx *sql.Tx, schema string) (map[string]*tableInfo, error) {
+	// Shadow tables hold the data of virtual tables (FTS5 indexes) and are
+	// maintained by SQLite, so they are left out like sqlite_* tables.
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT m.name, COALESCE(m.sql, '') FROM %s.sqlite_master m
+		JOIN pragma_table_list l ON l.schema = ? AND l.name = m.name
+		WHERE m.type = 'table' AND l.type != 'shadow' AND m.name NOT LIKE 'sqlite\_%%' ESCAPE '\'`, schema), schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables in %s: %w", schema, err)
+	}
+	tables := make(map[string]*tableInfo)
+	for rows.Next() {
+		var name, ddl string
+		if err := rows.Scan(&name, &ddl); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		upper := strings.ToUpper(ddl)
+		tables[name] = &tableInfo{
+			virtual:      strings.HasPrefix(upper, "CREATE VIRTUAL TABLE"),
+			withoutRowID: strings.Contains(upper, "WITHOUT ROWID"),
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, t := range tables {
+		if t.virtual {
+			continue
+		}
+		cols, err := tx.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_info(?, ?)", name, schema)
+		if err != nil {
+			return nil, err
+		}
+		var pk []string
+		var pkType string
+		for cols.Next() {
+			var col, typ string
+			var pos int
+			if err := cols.Scan(&col, &typ, &pos); err != nil {
+				cols.Close()
+				return nil, err
+			}
+			t.columns = append(t.columns, col)
+			if pos > 0 {
+				pk, pkType = append(pk, col), typ
+			}
+		}
+		cols.Close()
+		if len(pk) == 1 && strings.EqualFold(pkType, "INTEGER") {
+			t.rowIDAlias = pk[0]
+		}
+	}
+	return tables, nil
+}
+
+func mergeTable(ctx context.Context, tx *sql.Tx, name string, src, dst *tableInfo, policy Policy, tr *TableReport) error {
+	// Columns present on both sides, minus the local row id.
+	var cols, content []string
+	for _, c := range dst.columns {
+		if c == dst.rowIDAlias || !contains(src.columns, c) {
+			continue
+		}
+		cols = append(cols, c)
+		if c != timestampColumn {
+			content = append(content, c)
+		}
+	}
+	if len(content) == 0 {
+		content = cols
+	}
+	if len(cols) == 0 {
+		tr.Skipped = "no columns in common"
+		return nil
+	}
+	hasTimestamp := contains(cols, timestampColumn)
+
+	// Both sides are reduced to (rowid, key, content) in indexed temp
+	// tables; quote() renders any value, blobs included, as comparable text.
+	keyExpr := func(alias string) string {
+		rowKey := "'r:' || " + quoteList(alias, content)
+		keys, ok := NaturalKeys[name]
+		if !ok || !hasColumns(keys, cols) {
+			return rowKey
+		}
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = "quote(" + strings.ReplaceAll(k, "{}", alias) + ")"
+		}
+		first := strings.ReplaceAll(keys[0], "{}", alias)
+		return fmt.Sprintf("CASE WHEN (%s) IS NOT NULL THEN 'k:' || %s ELSE %s END", first, strings.Join(parts, " || ',' || "), rowKey)
+	}
+	ts := "NULL"
+	if hasTimestamp {
+		ts = "{}." + dbschema.Quote(timestampColumn)
+	}
+
+	table := dbschema.Quote(name)
+	stmts := []string{
+		"DROP TABLE IF EXISTS temp.merge_target",
+		"DROP TABLE IF EXISTS temp.merge_source",
+		fmt.Sprintf("CREATE TEMP TABLE merge_target AS SELECT m.rowid AS rid, %s AS k, %s AS ident, %s AS ts FROM main.%s m",
+			keyExpr("m"), quoteList("m", content), strings.ReplaceAll(ts, "{}", "m"), table),
+		"CREATE INDEX temp.merge_target_k ON merge_target (k, ident)",
+		fmt.Sprintf(`CREATE TEMP TABLE merge_source AS SELECT rid, k, ts,
+			CASE WHEN EXISTS (SELECT 1 FROM merge_target t WHERE t.k = x.k AND t.ident = x.ident) THEN 'duplicate'
+				WHEN EXISTS (SELECT 1 FROM merge_target t WHERE t.k = x.k) THEN 'conflict'
+				ELSE 'new' END AS class
+			FROM (SELECT s.rowid AS rid, %s AS k, %s AS ident, %s AS ts FROM src.%s s) x`,
+			keyExpr("s"), quoteList("s", content), strings.ReplaceAll(ts, "{}", "s"), table),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	defer tx.ExecContext(ctx, "DROP TABLE IF EXISTS temp.merge_source")
+	defer tx.ExecContext(ctx, "DROP TABLE IF EXISTS temp.merge_target")
+
+	rows, err := tx.QueryContext(ctx, "SELECT class, COUNT(*) FROM merge_source GROUP BY class")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var class string
+		var n int64
+		if err := rows.Scan(&class, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		tr.SourceRows += n
+		switch class {
+		case "new":
+			tr.New = n
+		case "duplicate":
+			tr.Duplicates = n
+		case "conflict":
+			tr.Conflicts = n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if tr.Conflicts > 0 {
+		if tr.ConflictIDs, err = conflictSamples(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	colList := quoteNames(cols)
+	insert := fmt.Sprintf("INSERT OR IGNORE INTO main.%s (%s) SELECT %s FROM src.%s WHERE rowid IN (SELECT rid FROM merge_source WHERE class = ?) ORDER BY rowid",
+		table, colList, colList, table)
+	if tr.Conflicts > 0 {
+		switch policy {
+		case KeepBoth:
+			res, err := tx.ExecContext(ctx, insert, "conflict")
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			tr.Inserted += n
+			tr.Ignored += tr.Conflicts - n
+		case KeepNewest:
+			if !hasTimestamp {
+				// Nothing says which row is newer; the target rows stay
+				// and the conflicts are reported, not silently dropped.
+				tr.Unresolved = tr.Conflicts
+				break
+			}
+			if tr.Updated, err = keepNewest(ctx, tx, table, colList); err != nil {
+				return err
+			}
+		}
+	}
+	if tr.New > 0 {
+		res, err := tx.ExecContext(ctx, insert, "new")
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		tr.Inserted += n
+		tr.Ignored += tr.New - n
+	}
+	return nil
+}
+
+// keepNewest overwrites target rows with the newest conflicting source row
+// for the same key, when that row is newer.
+func keepNewest(ctx context.Context, tx *sql.Tx, table, colList string) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT t.rid, s.rid FROM merge_target t
+		JOIN (SELECT k, rid, ts, ROW_NUMBER() OVER (PARTITION BY k ORDER BY ts DESC, rid DESC) AS rn
+			FROM merge_source WHERE class = 'conflict') s ON s.k = t.k AND s.rn = 1
+		WHERE s.ts > t.ts`)
+	if err != nil {
+		return 0, err
+	}
+	var pairs [][2]int64
+	for rows.Next() {
+		var p [2]int64
+		if err := rows.Scan(&p[0], &p[1]); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var updated int64
+	for _, p := range pairs {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE OR IGNORE main.%s SET (%s) = (SELECT %s FROM src.%s WHERE rowid = ?) WHERE rowid = ?",
+			table, colList, colList, table), p[1], p[0])
+		if err != nil {
+			return updated, err
+		}
+		n, _ := res.RowsAffected()
+		updated += n
+	}
+	return updated, nil
+}
+
+func conflictSamples(ctx context.Context, tx *sql.Tx) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT rid FROM merge_source WHERE class = 'conflict' ORDER BY rid LIMIT ?", maxConflictSamples)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// hasColumns reports whether every column a key template references with
+// "{}.name" is among cols.
+func hasColumns(keys []string, cols []string) bool {
+	for _, k := range keys {
+		for _, part := range strings.Split(k, "{}.")[1:] {
+			name := part
+			if i := strings.IndexFunc(part, func(r rune) bool {
+				return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+			}); i >= 0 {
+				name = part[:i]
+			}
+			if !contains(cols, name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func quoteList(alias string, cols []string) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = "quote(" + alias + "." + dbschema.Quote(c) + ")"
+	}
+	return strings.Join(parts, " || ',' || ")
+}
+
+func quoteNames(cols []string) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = dbschema.Quote(c)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
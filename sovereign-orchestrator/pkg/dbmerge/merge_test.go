@@ -0,0 +1,231 @@
+package dbmerge
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testSchema = []string{
+	`CREATE TABLE ch (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME, session_id TEXT, type TEXT, content TEXT, metadata TEXT)`,
+	`CREATE TABLE prime_directives (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME, directive TEXT UNIQUE, description TEXT)`,
+	`CREATE TABLE user_context (id INTEGER PRIMARY KEY AUTOINCREMENT, category TEXT, key TEXT, value TEXT)`,
+	`CREATE TABLE schema_versions (version INTEGER PRIMARY KEY, name TEXT)`,
+}
+
+// Source rows are numbered apart from the target's: ids are local to each
+// copy and must not decide matches.
+var (
+	targetRows = []string{
+		`INSERT INTO ch VALUES (1, '2025-01-01', 's', 'input', 'hi', '{"id":"m1"}')`,
+		`INSERT INTO ch VALUES (2, '2025-01-01', 's', 'input', 'x', NULL)`,
+		`INSERT INTO prime_directives VALUES (1, '2025-01-01', 'a', 'alpha')`,
+		`INSERT INTO prime_directives VALUES (2, '2025-01-01', 'b', 'beta')`,
+		`INSERT INTO prime_directives VALUES (3, '2025-03-01', 'd', 'delta, edited later')`,
+		`INSERT INTO user_context VALUES (1, 'pref', 'color', 'blue')`,
+		`INSERT INTO schema_versions VALUES (1, 'init')`,
+	}
+	sourceRows = []string{
+		`INSERT INTO ch VALUES (7, '2025-02-01', 's', 'input', 'hi, edited', '{"id":"m1"}')`, // Conflict, newer
+		`INSERT INTO ch VALUES (8, '2025-05-01', 's', 'input', 'x', NULL)`,                   // Same content, no key: duplicate
+		`INSERT INTO ch VALUES (9, '2025-01-01', 's', 'input', 'y', NULL)`,                   // New
+		`INSERT INTO prime_directives VALUES (11, '2025-01-01', 'a', 'alpha')`,               // Duplicate
+		`INSERT INTO prime_directives VALUES (12, '2025-02-01', 'b', 'beta, revised')`,       // Conflict, newer
+		`INSERT INTO prime_directives VALUES (13, '2024-01-01', 'c', 'gamma')`,               // New
+		`INSERT INTO prime_directives VALUES (14, '2025-01-01', 'd', 'delta')`,               // Conflict, older
+		`INSERT INTO user_context VALUES (4, 'pref', 'color', 'red')`,                        // Conflict, no timestamp
+		`INSERT INTO user_context VALUES (5, 'pref', 'size', 'L')`,                           // New
+		`INSERT INTO schema_versions VALUES (2, 'other')`,
+	}
+)
+
+func createDB(t *testing.T, path string, rows []string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range append(append([]string{}, testSchema...), rows...) {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return db
+}
+
+// counts is a TableReport reduced to its numbers.
+type counts struct {
+	New, Duplicates, Conflicts, Inserted, Updated, Ignored, Unresolved int64
+}
+
+func countsOf(tr TableReport) counts {
+	return counts{tr.New, tr.Duplicates, tr.Conflicts, tr.Inserted, tr.Updated, tr.Ignored, tr.Unresolved}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr error
+		want    map[string]counts
+		// Values read from the target after the merge.
+		wantValues map[string]string
+	}{
+		{
+			name: "keep newest",
+			opts: Options{Policy: KeepNewest},
+			want: map[string]counts{
+				"ch":               {New: 1, Duplicates: 1, Conflicts: 1, Inserted: 1, Updated: 1},
+				"prime_directives": {New: 1, Duplicates: 1, Conflicts: 2, Inserted: 1, Updated: 1},
+				"user_context":     {New: 1, Conflicts: 1, Inserted: 1, Unresolved: 1},
+			},
+			wantValues: map[string]string{
+				`SELECT description FROM prime_directives WHERE directive = 'b'`:   "beta, revised",
+				`SELECT description FROM prime_directives WHERE directive = 'd'`:   "delta, edited later",
+				`SELECT group_concat(value) FROM user_context WHERE key = 'color'`: "blue",
+				`SELECT content FROM ch WHERE id = 1`:                              "hi, edited",
+				`SELECT group_concat(content) FROM ch WHERE content IN ('x', 'y')`: "x,y",
+				`SELECT group_concat(name) FROM schema_versions`:                   "init",
+			},
+		},
+		{
+			name: "default policy is keep newest",
+			opts: Options{},
+			want: map[string]counts{
+				"prime_directives": {New: 1, Duplicates: 1, Conflicts: 2, Inserted: 1, Updated: 1},
+				"user_context":     {New: 1, Conflicts: 1, Inserted: 1, Unresolved: 1},
+			},
+		},
+		{
+			name: "keep both",
+			opts: Options{Policy: KeepBoth},
+			want: map[string]counts{
+				"ch":               {New: 1, Duplicates: 1, Conflicts: 1, Inserted: 2},
+				"prime_directives": {New: 1, Duplicates: 1, Conflicts: 2, Inserted: 1, Ignored: 2},
+				"user_context":     {New: 1, Conflicts: 1, Inserted: 2},
+			},
+			wantValues: map[string]string{
+				`SELECT description FROM prime_directives WHERE directive = 'b'`:   "beta",
+				`SELECT group_concat(value) FROM user_context WHERE key = 'color'`: "blue,red",
+			},
+		},
+		{
+			name:    "fail",
+			opts:    Options{Policy: Fail},
+			wantErr: ErrConflict,
+			want: map[string]counts{
+				"prime_directives": {New: 1, Duplicates: 1, Conflicts: 2, Inserted: 1},
+			},
+			wantValues: map[string]string{
+				`SELECT COUNT(*) FROM prime_directives`: "3",
+			},
+		},
+		{
+			name: "dry run",
+			opts: Options{Policy: KeepNewest, DryRun: true},
+			want: map[string]counts{
+				"prime_directives": {New: 1, Duplicates: 1, Conflicts: 2, Inserted: 1, Updated: 1},
+			},
+			wantValues: map[string]string{
+				`SELECT COUNT(*) FROM prime_directives`:                          "3",
+				`SELECT description FROM prime_directives WHERE directive = 'b'`: "beta",
+			},
+		},
+		{
+			name: "selected tables",
+			opts: Options{Tables: []string{"user_context"}},
+			want: map[string]counts{
+				"user_context": {New: 1, Conflicts: 1, Inserted: 1, Unresolved: 1},
+			},
+			wantValues: map[string]string{
+				`SELECT COUNT(*) FROM ch`: "2",
+			},
+		},
+		{
+			name:    "unknown policy",
+			opts:    Options{Policy: "newest"},
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "unknown table",
+			opts:    Options{Tables: []string{"nope"}},
+			wantErr: ErrInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := createDB(t, filepath.Join(dir, "target.db"), targetRows)
+			sourcePath := filepath.Join(dir, "source.db")
+			createDB(t, sourcePath, sourceRows)
+
+			report, err := Merge(context.Background(), target, sourcePath, tt.opts)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if report == nil {
+				if tt.want != nil {
+					t.Fatal("no report")
+				}
+				return
+			}
+			wantCommitted := err == nil && !tt.opts.DryRun
+			if report.Committed != wantCommitted {
+				t.Errorf("committed = %v, want %v", report.Committed, wantCommitted)
+			}
+
+			byTable := map[string]TableReport{}
+			for _, tr := range report.Tables {
+				byTable[tr.Table] = tr
+			}
+			for table, want := range tt.want {
+				if got := countsOf(byTable[table]); got != want {
+					t.Errorf("%s: got %+v, want %+v", table, got, want)
+				}
+			}
+			if len(tt.opts.Tables) == 0 && byTable["schema_versions"].Skipped == "" {
+				t.Errorf("schema_versions was not skipped")
+			}
+			var unresolved int64
+			for _, tr := range report.Tables {
+				unresolved += tr.Unresolved
+			}
+			if report.Unresolved != unresolved {
+				t.Errorf("report has %d unresolved, tables %d", report.Unresolved, unresolved)
+			}
+
+			for query, want := range tt.wantValues {
+				var got string
+				if err := target.QueryRow(query).Scan(&got); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", query, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSameRowsTwice(t *testing.T) {
+	dir := t.TempDir()
+	target := createDB(t, filepath.Join(dir, "target.db"), targetRows)
+	sourcePath := filepath.Join(dir, "source.db")
+	createDB(t, sourcePath, sourceRows)
+
+	if _, err := Merge(context.Background(), target, sourcePath, Options{Policy: KeepNewest}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Merge(context.Background(), target, sourcePath, Options{Policy: KeepNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Inserted != 0 || report.Updated != 0 {
+		t.Errorf("second merge inserted %d and updated %d rows", report.Inserted, report.Updated)
+	}
+}
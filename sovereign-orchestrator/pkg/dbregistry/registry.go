@@ -0,0 +1,394 @@
+// Package dbregistry manages the named SQLite databases the orchestrator can
+// work with: the primary memory database plus any number of additional
+// databases kept as <name>.db files in one directory. Handles are opened on
+// first use and shared; deleted databases are moved to a trash directory, once
+// the requests using them are done, and purged after a retention period.
+package dbregistry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileExt   = ".db"
+	trashName = ".trash"
+)
+
+var (
+	// ErrNotFound is returned for a name with no database file.
+	ErrNotFound = errors.New("database not found")
+	// ErrExists is returned when creating a database that already exists.
+	ErrExists = errors.New("database already exists")
+	// ErrProtected is returned when deleting the primary database.
+	ErrProtected = errors.New("the primary database cannot be deleted")
+	// ErrBusy is returned when a database stays in use while it is deleted.
+	ErrBusy = errors.New("database is in use")
+	// ErrInvalidName is returned for names that are not safe file names.
+	ErrInvalidName = errors.New("database names must be 1-64 letters, digits, '_' or '-', starting with a letter or digit")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// sidecars are the files SQLite keeps next to a database; they move with it.
+var sidecars = []string{"-wal", "-shm", "-journal"}
+
+// Info describes one database.
+type Info struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Primary  bool      `json:"primary"`
+	Open     bool      `json:"open"` // A handle is currently pooled
+}
+
+// Registry tracks the databases in Dir.
+type Registry struct {
+	dir         string
+	trashDir    string
+	primaryName string
+	primaryPath string
+	primary     *sql.DB
+	retention   time.Duration
+	open        func(path string) (*sql.DB, error)
+
+	mu       sync.Mutex
+	handles  map[string]*pooled
+	creating map[string]bool // Files created but not yet initialized
+}
+
+// pooled is a shared handle and the count of contexts using it.
+type pooled struct {
+	db       *sql.DB
+	refs     int
+	deleting bool          // Delete is waiting for refs to drain
+	drained  chan struct{} // Closed when refs drops to zero while deleting
+}
+
+// Config configures a Registry.
+type Config struct {
+	Dir         string                             // Directory holding <name>.db files
+	PrimaryName string                             // Name the primary database is listed under
+	PrimaryPath string                             // May be outside Dir
+	Primary     *sql.DB                            // Already-open primary handle; never closed by the registry
+	Retention   time.Duration                      // How long deleted databases stay in the trash
+	Open        func(path string) (*sql.DB, error) // Opens a handle for a database file
+}
+
+// New creates the registry and its directories.
+func New(cfg Config) (*Registry, error) {
+	r := &Registry{
+		dir:         cfg.Dir,
+		trashDir:    filepath.Join(cfg.Dir, trashName),
+		primaryName: cfg.PrimaryName,
+		primaryPath: cfg.PrimaryPath,
+		primary:     cfg.Primary,
+		retention:   cfg.Retention,
+		open:        cfg.Open,
+		handles:     make(map[string]*pooled),
+		creating:    make(map[string]bool),
+	}
+	if err := os.MkdirAll(r.trashDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory %s: %w", r.dir, err)
+	}
+	return r, nil
+}
+
+// PrimaryName returns the name of the primary database.
+func (r *Registry) PrimaryName() string { return r.primaryName }
+
+// ValidateName checks that name can be used as a database name.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// Path returns the file for a database name. It does not check existence.
+func (r *Registry) Path(name string) (string, error) {
+	if name == r.primaryName {
+		return r.primaryPath, nil
+	}
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(r.dir, name+fileExt), nil
+}
+
+// List returns every database, the primary first and the rest by name.
+func (r *Registry) List() ([]Info, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", r.dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), fileExt)
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), fileExt) && validName.MatchString(name) && name != r.primaryName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	infos := make([]Info, 0, len(names)+1)
+	if info, err := r.Stat(r.primaryName); err == nil {
+		infos = append(infos, info)
+	}
+	for _, name := range names {
+		if info, err := r.Stat(name); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Stat describes a single database.
+func (r *Registry) Stat(name string) (Info, error) {
+	path, err := r.Path(name)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	r.mu.Lock()
+	_, open := r.handles[name]
+	r.mu.Unlock()
+	return Info{
+		Name:     name,
+		Path:     path,
+		Size:     fi.Size(),
+		Modified: fi.ModTime(),
+		Primary:  name == r.primaryName,
+		Open:     open || name == r.primaryName,
+	}, nil
+}
+
+// Get returns the shared handle for a database, opening it on first use. The
+// handle stays usable until ctx is done, and Delete waits for that, so ctx
+// must end when the caller is finished with it, as a request's does.
+func (r *Registry) Get(ctx context.Context, name string) (*sql.DB, error) {
+	if name == r.primaryName || name == "" {
+		return r.primary, nil
+	}
+	path, err := r.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.creating[name] {
+		return nil, fmt.Errorf("%w: %s is still being created", ErrNotFound, name)
+	}
+	p, ok := r.handles[name]
+	if ok && p.deleting {
+		return nil, fmt.Errorf("%w: %s is being deleted", ErrNotFound, name)
+	}
+	if !ok {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		db, err := r.open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database %s: %w", name, err)
+		}
+		p = &pooled{db: db}
+		r.handles[name] = p
+	}
+	p.refs++
+	context.AfterFunc(ctx, func() { r.release(p) })
+	return p.db, nil
+}
+
+// release drops a reference taken by Get.
+func (r *Registry) release(p *pooled) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p.refs--
+	if p.refs == 0 && p.drained != nil {
+		close(p.drained)
+		p.drained = nil
+	}
+}
+
+// Create makes a new database file. init, if non-nil, prepares its schema;
+// when init fails the new file is removed again. The database cannot be used
+// or deleted until init returns.
+func (r *Registry) Create(ctx context.Context, name string, init func(context.Context, *sql.DB) error) (Info, error) {
+	if name == r.primaryName {
+		return Info{}, fmt.Errorf("%w: %s", ErrExists, name)
+	}
+	path, err := r.Path(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	r.mu.Lock()
+	// O_EXCL makes the existence check and the creation one step.
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		r.mu.Unlock()
+		return Info{}, fmt.Errorf("%w: %s", ErrExists, name)
+	}
+	if err != nil {
+		r.mu.Unlock()
+		return Info{}, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	f.Close()
+	r.creating[name] = true
+	r.mu.Unlock()
+
+	// Initializing can take a while (migrations, a merge), so it runs
+	// without the lock; the creating mark keeps the name reserved.
+	db, err := r.open(path)
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
+	if err == nil && init != nil {
+		err = init(ctx, db)
+	}
+
+	r.mu.Lock()
+	delete(r.creating, name)
+	if err != nil {
+		if db != nil {
+			db.Close()
+		}
+		removeWithSidecars(path)
+		r.mu.Unlock()
+		return Info{}, fmt.Errorf("failed to initialize database %s: %w", name, err)
+	}
+	r.handles[name] = &pooled{db: db}
+	r.mu.Unlock()
+	return r.Stat(name)
+}
+
+// Delete closes a database and moves its files to the trash. It returns the
+// path the database was moved to. A database still in use is first drained:
+// Get refuses it, and Delete waits until the contexts holding it are done,
+// or fails with ErrBusy when ctx ends first.
+func (r *Registry) Delete(ctx context.Context, name string) (string, error) {
+	if name == r.primaryName {
+		return "", ErrProtected
+	}
+	path, err := r.Path(name)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.creating[name] {
+		return "", fmt.Errorf("%w: %s is still being created", ErrBusy, name)
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if p, ok := r.handles[name]; ok {
+		if p.deleting {
+			return "", fmt.Errorf("%w: %s is already being deleted", ErrBusy, name)
+		}
+		if p.refs > 0 {
+			p.deleting = true
+			drained := make(chan struct{})
+			p.drained = drained
+			r.mu.Unlock()
+			select {
+			case <-drained:
+				r.mu.Lock()
+			case <-ctx.Done():
+				r.mu.Lock()
+				p.deleting, p.drained = false, nil
+				return "", fmt.Errorf("%w: %s, still in use after waiting: %v", ErrBusy, name, ctx.Err())
+			}
+		}
+		p.db.Close()
+		delete(r.handles, name)
+	}
+
+	dest := filepath.Join(r.trashDir, fmt.Sprintf("%s.%s%s", name, time.Now().Format("20060102-150405.000"), fileExt))
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("failed to move %s to the trash: %w", name, err)
+	}
+	for _, s := range sidecars {
+		if err := os.Rename(path+s, dest+s); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return dest, fmt.Errorf("failed to move %s%s to the trash: %w", name, s, err)
+		}
+	}
+	return dest, nil
+}
+
+// PurgeTrash permanently removes trashed databases older than the retention
+// period and returns how many were removed.
+func (r *Registry) PurgeTrash(now time.Time) (int, error) {
+	entries, err := os.ReadDir(r.trashDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list trash: %w", err)
+	}
+	removed := 0
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), fileExt) {
+			continue // Sidecars go with their database
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		// Rename keeps the original mtime, so use the deletion time in the name.
+		deletedAt := info.ModTime()
+		if parts := strings.Split(strings.TrimSuffix(e.Name(), fileExt), "."); len(parts) >= 3 {
+			if t, err := time.ParseInLocation("20060102-150405.000", parts[len(parts)-2]+"."+parts[len(parts)-1], time.Local); err == nil {
+				deletedAt = t
+			}
+		}
+		if now.Sub(deletedAt) < r.retention {
+			continue
+		}
+		if err := removeWithSidecars(filepath.Join(r.trashDir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Close closes every pooled handle except the primary.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var firstErr error
+	for name, p := range r.handles {
+		if err := p.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.handles, name)
+	}
+	return firstErr
+}
+
+func removeWithSidecars(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, s := range sidecars {
+		os.Remove(path + s)
+	}
+	return nil
+}
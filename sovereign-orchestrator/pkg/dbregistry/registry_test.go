@@ -0,0 +1,130 @@
+package dbregistry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := New(Config{
+		Dir:         dir,
+		PrimaryName: "main",
+		PrimaryPath: filepath.Join(dir, "main.sqlite"),
+		Open:        func(path string) (*sql.DB, error) { return sql.Open("sqlite3", path) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestDeleteDrains(t *testing.T) {
+	r := newTestRegistry(t)
+	bg := context.Background()
+	if _, err := r.Create(bg, "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	req, done := context.WithCancel(bg)
+	db, err := r.Get(req, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete gives up when its own context ends first, and leaves the
+	// database usable.
+	short, cancel := context.WithTimeout(bg, 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.Delete(short, "a"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("Delete while in use = %v, want ErrBusy", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("handle closed by a failed Delete: %v", err)
+	}
+
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := r.Delete(bg, "a")
+		deleted <- err
+	}()
+	for {
+		probe, end := context.WithCancel(bg)
+		_, err := r.Get(probe, "a")
+		end()
+		if errors.Is(err, ErrNotFound) {
+			break // Draining: no new users
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-deleted:
+		t.Fatalf("Delete returned %v while the handle was in use", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := db.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatalf("handle closed under its user: %v", err)
+	}
+
+	done()
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateInitOutsideLock(t *testing.T) {
+	r := newTestRegistry(t)
+	bg := context.Background()
+	if _, err := r.Create(bg, "other", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	created := make(chan error, 1)
+	go func() {
+		_, err := r.Create(bg, "slow", func(ctx context.Context, db *sql.DB) error {
+			close(started)
+			<-release
+			_, err := db.ExecContext(ctx, "CREATE TABLE t (x)")
+			return err
+		})
+		created <- err
+	}()
+	<-started
+
+	// Other databases stay usable, and the new one is reserved.
+	if _, err := r.Get(bg, "other"); err != nil {
+		t.Errorf("Get during another database's init = %v", err)
+	}
+	if _, err := r.Get(bg, "slow"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a database being created = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Create(bg, "slow", nil); !errors.Is(err, ErrExists) {
+		t.Errorf("second Create = %v, want ErrExists", err)
+	}
+	if _, err := r.Delete(bg, "slow"); !errors.Is(err, ErrBusy) {
+		t.Errorf("Delete of a database being created = %v, want ErrBusy", err)
+	}
+
+	close(release)
+	if err := <-created; err != nil {
+		t.Fatal(err)
+	}
+	db, err := r.Get(bg, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Errorf("initialized database: %v", err)
+	}
+}
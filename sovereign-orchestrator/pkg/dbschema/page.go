@@ -0,0 +1,381 @@
+package dbschema
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Page sizes for ReadPage.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// ErrInvalidQuery wraps every error caused by the caller's parameters rather
+// than the database.
+var ErrInvalidQuery = errors.New("invalid query")
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+// Filter is one condition of the filter grammar:
+//
+//	column:op[:value]
+//
+// where op is one of eq, ne, lt, le, gt, ge, contains, prefix, in (value is a
+// comma-separated list), null and notnull (no value). The value is everything
+// after the second colon and is always bound as a parameter.
+type Filter struct {
+	Column string
+	Op     string
+	Value  string
+}
+
+var filterOps = map[string]string{
+	"eq": "=", "ne": "!=", "lt": "<", "le": "<=", "gt": ">", "ge": ">=",
+	"contains": "LIKE", "prefix": "LIKE", "in": "IN", "null": "IS NULL", "notnull": "IS NOT NULL",
+}
+
+// ParseFilter parses one filter expression.
+func ParseFilter(expr string) (Filter, error) {
+	parts := strings.SplitN(expr, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return Filter{}, invalid("filter %q must look like column:op:value", expr)
+	}
+	f := Filter{Column: parts[0], Op: strings.ToLower(parts[1])}
+	if len(parts) == 3 {
+		f.Value = parts[2]
+	}
+	if _, ok := filterOps[f.Op]; !ok {
+		return Filter{}, invalid("unknown filter operator %q", parts[1])
+	}
+	if (f.Op == "null" || f.Op == "notnull") != (len(parts) == 2) {
+		if len(parts) == 2 {
+			return Filter{}, invalid("filter %q needs a value", expr)
+		}
+		return Filter{}, invalid("filter operator %q takes no value", f.Op)
+	}
+	return f, nil
+}
+
+// sql renders the condition for an already validated column.
+func (f Filter) sql() (string, []interface{}) {
+	col := Quote(f.Column)
+	switch f.Op {
+	case "null", "notnull":
+		return col + " " + filterOps[f.Op], nil
+	case "contains":
+		return col + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(f.Value) + "%"}
+	case "prefix":
+		return col + ` LIKE ? ESCAPE '\'`, []interface{}{escapeLike(f.Value) + "%"}
+	case "in":
+		values := strings.Split(f.Value, ",")
+		args := make([]interface{}, len(values))
+		for i, v := range values {
+			args[i] = v
+		}
+		return col + " IN (" + strings.TrimSuffix(strings.Repeat("?,", len(values)), ",") + ")", args
+	default:
+		return col + " " + filterOps[f.Op] + " ?", []interface{}{f.Value}
+	}
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// PageRequest selects a page of rows from one table.
+type PageRequest struct {
+	Table   string
+	Sort    string // Column to order by; default is the row key
+	Desc    bool
+	Limit   int
+	Cursor  string // NextCursor of the previous page
+	Filters []Filter
+}
+
+// Page is one page of rows.
+type Page struct {
+	Table      string                   `json:"table"`
+	Columns    []string                 `json:"columns"`
+	Rows       []map[string]interface{} `json:"rows"`
+	Total      int64                    `json:"total"` // Rows matching the filters
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	HasMore    bool                     `json:"has_more"`
+}
+
+// cursor is the position after the last row of a page: the sort value and
+// the row key. It is opaque to clients.
+type cursor struct {
+	Sort interface{}   `json:"s"`
+	Key  []interface{} `json:"k"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string, keys int) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalid("malformed cursor")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil || len(c.Key) != keys {
+		return c, invalid("malformed cursor")
+	}
+	if c.Sort, err = fromJSON(c.Sort); err != nil {
+		return c, err
+	}
+	for i := range c.Key {
+		if c.Key[i], err = fromJSON(c.Key[i]); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// fromJSON turns decoded JSON numbers back into int64 or float64 so they
+// compare as numbers in SQLite, and tagged blobs back into []byte.
+func fromJSON(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i, nil
+		}
+		f, _ := x.Float64()
+		return f, nil
+	case map[string]interface{}:
+		s, ok := x["$blob"].(string)
+		if !ok || len(x) != 1 {
+			return nil, invalid("malformed cursor")
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, invalid("malformed cursor")
+		}
+		return b, nil
+	}
+	return v, nil
+}
+
+// ReadPage returns one page of a table. Rows are ordered by the sort column
+// and then the row key, and the next page continues strictly after the last
+// row of this one, so concurrent inserts and deletes never shift pages.
+func ReadPage(ctx context.Context, db *sql.DB, schema *Schema, req PageRequest) (*Page, error) {
+	t, ok := schema.Table(req.Table)
+	if !ok {
+		return nil, invalid("unknown table %q", req.Table)
+	}
+	if req.Sort != "" {
+		if _, ok := t.Column(req.Sort); !ok {
+			return nil, invalid("unknown column %q in table %s", req.Sort, t.Name)
+		}
+	}
+	for _, f := range req.Filters {
+		if _, ok := t.Column(f.Column); !ok {
+			return nil, invalid("unknown column %q in table %s", f.Column, t.Name)
+		}
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	var where []string
+	var args []interface{}
+	for _, f := range req.Filters {
+		cond, a := f.sql()
+		where = append(where, cond)
+		args = append(args, a...)
+	}
+
+	page := &Page{Table: t.Name, Columns: make([]string, len(t.Columns)), Rows: []map[string]interface{}{}}
+	for i, c := range t.Columns {
+		page.Columns[i] = c.Name
+	}
+	countSQL := "SELECT COUNT(*) FROM " + Quote(t.Name)
+	if len(where) > 0 {
+		countSQL += " WHERE " + strings.Join(where, " AND ")
+	}
+	if err := db.QueryRowContext(ctx, countSQL, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count rows: %w", err)
+	}
+
+	keys := t.KeyColumns()
+	sortExpr := ""
+	if req.Sort != "" {
+		sortExpr = Quote(req.Sort)
+	}
+	if req.Cursor != "" {
+		c, err := decodeCursor(req.Cursor, len(keys))
+		if err != nil {
+			return nil, err
+		}
+		cond, a := keysetCondition(sortExpr, keys, c, req.Desc)
+		where = append(where, cond)
+		args = append(args, a...)
+	}
+
+	dir := "ASC"
+	if req.Desc {
+		dir = "DESC"
+	}
+	var order []string
+	if sortExpr != "" {
+		order = append(order, sortExpr+" "+dir)
+	}
+	for _, k := range keys {
+		order = append(order, k+" "+dir)
+	}
+
+	// The sort value and key are selected again through likely(), which
+	// returns its argument unchanged but drops the declared type. That keeps
+	// the stored representation (the driver would turn timestamp columns
+	// into time.Time), so the cursor compares exactly.
+	selectList := "*"
+	if sortExpr != "" {
+		selectList += ", likely(" + sortExpr + ")"
+	}
+	for _, k := range keys {
+		selectList += ", likely(" + k + ")"
+	}
+	query := "SELECT " + selectList + " FROM " + Quote(t.Name)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + strings.Join(order, ", ") + fmt.Sprintf(" LIMIT %d", limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var last cursor
+	for rows.Next() {
+		values := make([]interface{}, len(names))
+		ptrs := make([]interface{}, len(names))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		if len(page.Rows) == limit {
+			page.HasMore = true
+			break
+		}
+
+		row := make(map[string]interface{}, len(t.Columns))
+		for i := range t.Columns {
+			row[t.Columns[i].Name] = jsonValue(values[i])
+		}
+		page.Rows = append(page.Rows, row)
+
+		extra := values[len(t.Columns):]
+		last = cursor{Key: make([]interface{}, len(keys))}
+		if sortExpr != "" {
+			last.Sort = rawValue(extra[0])
+			extra = extra[1:]
+		}
+		for i := range keys {
+			last.Key[i] = rawValue(extra[i])
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if page.HasMore {
+		if page.NextCursor, err = encodeCursor(last); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// keysetCondition selects the rows after the cursor in the given direction.
+// NULLs sort first in ascending order, so they need their own branches.
+func keysetCondition(sortExpr string, keys []string, c cursor, desc bool) (string, []interface{}) {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	keyExpr := strings.Join(keys, ", ")
+	keyParams := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	if len(keys) > 1 {
+		keyExpr, keyParams = "("+keyExpr+")", "("+keyParams+")"
+	}
+	keyCond := keyExpr + " " + op + " " + keyParams
+	if sortExpr == "" {
+		return keyCond, c.Key
+	}
+
+	args := []interface{}{}
+	switch {
+	case c.Sort == nil && !desc:
+		// After a NULL: the rest of the NULLs, then every non-NULL value.
+		return "(" + sortExpr + " IS NOT NULL OR (" + sortExpr + " IS NULL AND " + keyCond + "))", c.Key
+	case c.Sort == nil && desc:
+		// NULLs come last when descending.
+		return "(" + sortExpr + " IS NULL AND " + keyCond + ")", c.Key
+	case !desc:
+		args = append(args, c.Sort, c.Sort)
+		args = append(args, c.Key...)
+		return "(" + sortExpr + " > ? OR (" + sortExpr + " = ? AND " + keyCond + "))", args
+	default:
+		args = append(args, c.Sort, c.Sort)
+		args = append(args, c.Key...)
+		return "(" + sortExpr + " < ? OR " + sortExpr + " IS NULL OR (" + sortExpr + " = ? AND " + keyCond + "))", args
+	}
+}
+
+// jsonValue converts a scanned value for JSON output: text stored as bytes
+// becomes a string; real blobs stay []byte and encode as base64.
+func jsonValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok && isText(b) {
+		return string(b)
+	}
+	return v
+}
+
+// rawValue converts a scanned cursor value into something that survives the
+// JSON round trip with the same SQLite type. Blobs are tagged, as in the
+// undo journal: a blob turned into text would compare below every blob, and
+// the next page would start over.
+func rawValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return map[string]string{"$blob": base64.StdEncoding.EncodeToString(b)}
+	}
+	return v
+}
+
+func isText(b []byte) bool {
+	for _, c := range b {
+		if c == 0 {
+			return false
+		}
+	}
+	return true
+}
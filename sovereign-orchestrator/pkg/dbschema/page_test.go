@@ -0,0 +1,236 @@
+package dbschema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB creates a database with a rowid table and a WITHOUT ROWID
+// table whose sort columns hold NULLs, ties, blobs and mixed types.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "page.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	stmts := []string{
+		`CREATE TABLE notes (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB, mixed, timestamp DATETIME)`,
+		`CREATE TABLE pairs (a TEXT, b INTEGER, note TEXT, PRIMARY KEY (a, b)) WITHOUT ROWID`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 23; i++ {
+		var name, score, mixed interface{}
+		if i%4 != 0 {
+			name = fmt.Sprintf("n%d", i%5) // Ties
+		}
+		if i%3 != 0 {
+			score = float64(i%7) / 2
+		}
+		switch i % 4 {
+		case 0:
+			mixed = int64(i)
+		case 1:
+			mixed = fmt.Sprintf("s%02d", i)
+		case 2:
+			mixed = []byte{byte(i % 3), 0, byte(i)}
+		}
+		data := []byte{byte(i % 6), 0xff, 0} // Blobs with ties and NUL bytes
+		ts := fmt.Sprintf("2025-01-%02d 10:00:00", i%9+1)
+		if _, err := db.Exec(`INSERT INTO notes (id, name, score, data, mixed, timestamp) VALUES (?, ?, ?, ?, ?, ?)`,
+			i, name, score, data, mixed, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 17; i++ {
+		if _, err := db.Exec(`INSERT INTO pairs (a, b, note) VALUES (?, ?, ?)`,
+			fmt.Sprintf("k%d", i%4), i, fmt.Sprintf("x%d", i%3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// readAll follows next_cursor to the end and returns the keys of every row
+// in the order served.
+func readAll(t *testing.T, db *sql.DB, schema *Schema, req PageRequest, key func(map[string]interface{}) string) []string {
+	t.Helper()
+	var got []string
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("pagination did not finish; rows so far: %v", got)
+		}
+		page, err := ReadPage(context.Background(), db, schema, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range page.Rows {
+			got = append(got, key(row))
+		}
+		if !page.HasMore {
+			if page.NextCursor != "" {
+				t.Errorf("last page has a cursor")
+			}
+			return got
+		}
+		req.Cursor = page.NextCursor
+	}
+}
+
+func TestReadPageKeyset(t *testing.T) {
+	db := openTestDB(t)
+	schema, err := Inspect(context.Background(), db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noteKey := func(row map[string]interface{}) string { return fmt.Sprint(row["id"]) }
+	pairKey := func(row map[string]interface{}) string { return fmt.Sprint(row["a"], "/", row["b"]) }
+
+	tests := []struct {
+		name    string
+		req     PageRequest
+		key     func(map[string]interface{}) string
+		want    string // Query giving the expected keys in order
+		wantArg []interface{}
+	}{
+		{"by key", PageRequest{Table: "notes", Limit: 4}, noteKey,
+			`SELECT id FROM notes ORDER BY id`, nil},
+		{"by key desc", PageRequest{Table: "notes", Limit: 5, Desc: true}, noteKey,
+			`SELECT id FROM notes ORDER BY id DESC`, nil},
+		{"text with nulls and ties", PageRequest{Table: "notes", Sort: "name", Limit: 3}, noteKey,
+			`SELECT id FROM notes ORDER BY name, id`, nil},
+		{"text with nulls desc", PageRequest{Table: "notes", Sort: "name", Desc: true, Limit: 3}, noteKey,
+			`SELECT id FROM notes ORDER BY name DESC, id DESC`, nil},
+		{"real with nulls", PageRequest{Table: "notes", Sort: "score", Limit: 2}, noteKey,
+			`SELECT id FROM notes ORDER BY score, id`, nil},
+		{"real with nulls desc", PageRequest{Table: "notes", Sort: "score", Desc: true, Limit: 2}, noteKey,
+			`SELECT id FROM notes ORDER BY score DESC, id DESC`, nil},
+		{"blob", PageRequest{Table: "notes", Sort: "data", Limit: 4}, noteKey,
+			`SELECT id FROM notes ORDER BY data, id`, nil},
+		{"blob desc", PageRequest{Table: "notes", Sort: "data", Desc: true, Limit: 4}, noteKey,
+			`SELECT id FROM notes ORDER BY data DESC, id DESC`, nil},
+		{"mixed types", PageRequest{Table: "notes", Sort: "mixed", Limit: 3}, noteKey,
+			`SELECT id FROM notes ORDER BY mixed, id`, nil},
+		{"mixed types desc", PageRequest{Table: "notes", Sort: "mixed", Desc: true, Limit: 3}, noteKey,
+			`SELECT id FROM notes ORDER BY mixed DESC, id DESC`, nil},
+		{"timestamp", PageRequest{Table: "notes", Sort: "timestamp", Limit: 5}, noteKey,
+			`SELECT id FROM notes ORDER BY timestamp, id`, nil},
+		{"filtered", PageRequest{Table: "notes", Sort: "score", Limit: 2,
+			Filters: []Filter{{Column: "name", Op: "notnull"}, {Column: "score", Op: "ge", Value: "1"}}}, noteKey,
+			`SELECT id FROM notes WHERE name IS NOT NULL AND score >= 1 ORDER BY score, id`, nil},
+		{"composite key", PageRequest{Table: "pairs", Limit: 4}, pairKey,
+			`SELECT a || '/' || b FROM pairs ORDER BY a, b`, nil},
+		{"composite key sorted desc", PageRequest{Table: "pairs", Sort: "note", Desc: true, Limit: 3}, pairKey,
+			`SELECT a || '/' || b FROM pairs ORDER BY note DESC, a DESC, b DESC`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Query(tt.want, tt.wantArg...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for rows.Next() {
+				var k string
+				if err := rows.Scan(&k); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, k)
+			}
+			rows.Close()
+
+			got := readAll(t, db, schema, tt.req, tt.key)
+			if !slices.Equal(got, want) {
+				t.Errorf("rows\n got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadPageTotal(t *testing.T) {
+	db := openTestDB(t)
+	schema, err := Inspect(context.Background(), db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ReadPage(context.Background(), db, schema, PageRequest{Table: "notes", Limit: 2,
+		Filters: []Filter{{Column: "name", Op: "prefix", Value: "n1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 4 || len(page.Rows) != 2 || !page.HasMore {
+		t.Errorf("got total %d, %d rows, has_more %v; want 4, 2, true", page.Total, len(page.Rows), page.HasMore)
+	}
+}
+
+func TestReadPageInvalid(t *testing.T) {
+	db := openTestDB(t)
+	schema, err := Inspect(context.Background(), db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		req  PageRequest
+	}{
+		{"unknown table", PageRequest{Table: "nope"}},
+		{"unknown sort column", PageRequest{Table: "notes", Sort: "nope"}},
+		{"unknown filter column", PageRequest{Table: "notes", Filters: []Filter{{Column: "nope", Op: "eq"}}}},
+		{"cursor not base64", PageRequest{Table: "notes", Cursor: "!!"}},
+		{"cursor with wrong key count", PageRequest{Table: "notes", Cursor: mustCursor(t, cursor{Key: []interface{}{1, 2}})}},
+		{"cursor with bad blob", PageRequest{Table: "notes", Sort: "data",
+			Cursor: mustCursor(t, cursor{Sort: map[string]string{"$blob": "%%"}, Key: []interface{}{1}})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadPage(context.Background(), db, schema, tt.req); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("got %v, want ErrInvalidQuery", err)
+			}
+		})
+	}
+}
+
+func mustCursor(t *testing.T, c cursor) string {
+	t.Helper()
+	s, err := encodeCursor(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Filter
+		wantErr bool
+	}{
+		{"type:eq:input", Filter{Column: "type", Op: "eq", Value: "input"}, false},
+		{"content:contains:a:b", Filter{Column: "content", Op: "contains", Value: "a:b"}, false},
+		{"score:GE:2", Filter{Column: "score", Op: "ge", Value: "2"}, false},
+		{"name:null", Filter{Column: "name", Op: "null"}, false},
+		{"name:null:x", Filter{}, true},
+		{"name:eq", Filter{}, true},
+		{"name:like:x", Filter{}, true},
+		{":eq:x", Filter{}, true},
+		{"name", Filter{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.expr)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFilter(%q) = %+v, %v; want %+v, error %v", tt.expr, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
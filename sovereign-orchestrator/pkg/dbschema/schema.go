@@ -0,0 +1,217 @@
+// Package dbschema introspects SQLite databases and reads table rows with
+// keyset pagination. Table and column names supplied by callers are only
+// ever used after they have been matched against the introspected schema,
+// and filter values are always bound as parameters.
+package dbschema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Protected lists the tables that hold the rules and the schema history of
+// a database. Deleting or archiving their rows needs an explicit override.
+var Protected = map[string]bool{"prime_directives": true, "schema_versions": true}
+
+// Column is one column of a table, as reported by PRAGMA table_info.
+type Column struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	NotNull    bool    `json:"not_null"`
+	Default    *string `json:"default"`
+	PrimaryKey int     `json:"primary_key"` // Position in the primary key, 0 if not part of it
+}
+
+// Index is one index of a table.
+type Index struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+	Origin  string   `json:"origin"` // "c" CREATE INDEX, "u" UNIQUE constraint, "pk" PRIMARY KEY
+	Partial bool     `json:"partial"`
+	Columns []string `json:"columns"` // Expression columns are reported as "<expr>"
+}
+
+// Table describes a table and its contents.
+type Table struct {
+	Name         string   `json:"name"`
+	Columns      []Column `json:"columns"`
+	Indexes      []Index  `json:"indexes"`
+	RowCount     int64    `json:"row_count"`
+	WithoutRowID bool     `json:"without_rowid"`
+	Virtual      bool     `json:"virtual"`
+	SQL          string   `json:"sql"`
+}
+
+// Column returns the named column, matched exactly.
+func (t *Table) Column(name string) (Column, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// Schema is the set of user tables in a database.
+type Schema struct {
+	Tables []*Table `json:"tables"`
+}
+
+// Table returns the named table, matched exactly.
+func (s *Schema) Table(name string) (*Table, bool) {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Inspect reads the tables of db. Internal sqlite_* tables and the shadow
+// tables that store virtual tables (such as FTS5 indexes) are skipped.
+// Row counts are only gathered when withCounts is set, since they scan
+// every table.
+func Inspect(ctx context.Context, db *sql.DB, withCounts bool) (*Schema, error) {
+	rows, err := db.QueryContext(ctx, `SELECT m.name, COALESCE(m.sql, ''), l.type = 'virtual' FROM sqlite_master m
+		JOIN pragma_table_list l ON l.schema = 'main' AND l.name = m.name
+		WHERE m.type = 'table' AND l.type != 'shadow' AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY m.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	schema := &Schema{Tables: []*Table{}}
+	for rows.Next() {
+		t := &Table{}
+		if err := rows.Scan(&t.Name, &t.SQL, &t.Virtual); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.WithoutRowID = strings.Contains(strings.ToUpper(t.SQL), "WITHOUT ROWID")
+		schema.Tables = append(schema.Tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range schema.Tables {
+		if t.Columns, err = tableColumns(ctx, db, t.Name); err != nil {
+			return nil, err
+		}
+		if t.Indexes, err = tableIndexes(ctx, db, t.Name); err != nil {
+			return nil, err
+		}
+		if withCounts {
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+Quote(t.Name)).Scan(&t.RowCount); err != nil {
+				return nil, fmt.Errorf("failed to count rows in %s: %w", t.Name, err)
+			}
+		}
+	}
+	return schema, nil
+}
+
+// Quote returns name as a quoted SQLite identifier.
+func Quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// InList returns "expr IN (?, ...)" with one placeholder per id, and the ids
+// as its arguments.
+func InList(expr string, ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return expr + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
+
+func tableColumns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	// Pragma arguments cannot be bound, but the table-valued form can.
+	rows, err := db.QueryContext(ctx, "SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols := []Column{}
+	for rows.Next() {
+		var c Column
+		var dflt sql.NullString
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &dflt, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		if dflt.Valid {
+			c.Default = &dflt.String
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+func tableIndexes(ctx context.Context, db *sql.DB, table string) ([]Index, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, \"unique\", origin, partial FROM pragma_index_list(?) ORDER BY name", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes of %s: %w", table, err)
+	}
+	indexes := []Index{}
+	for rows.Next() {
+		var idx Index
+		if err := rows.Scan(&idx.Name, &idx.Unique, &idx.Origin, &idx.Partial); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range indexes {
+		cols, err := db.QueryContext(ctx, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", indexes[i].Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index %s: %w", indexes[i].Name, err)
+		}
+		indexes[i].Columns = []string{}
+		for cols.Next() {
+			var name sql.NullString
+			if err := cols.Scan(&name); err != nil {
+				cols.Close()
+				return nil, err
+			}
+			if name.Valid {
+				indexes[i].Columns = append(indexes[i].Columns, name.String)
+			} else {
+				indexes[i].Columns = append(indexes[i].Columns, "<expr>")
+			}
+		}
+		cols.Close()
+		if err := cols.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+// KeyColumns returns the expressions that identify a row uniquely, used to
+// break ties in keyset pagination: the rowid (or its INTEGER PRIMARY KEY
+// alias), or the primary key columns of a WITHOUT ROWID table.
+func (t *Table) KeyColumns() []string {
+	var pk []Column
+	for _, c := range t.Columns {
+		if c.PrimaryKey > 0 {
+			pk = append(pk, c)
+		}
+	}
+	if t.WithoutRowID {
+		keys := make([]string, len(pk))
+		for _, c := range pk {
+			keys[c.PrimaryKey-1] = Quote(c.Name)
+		}
+		return keys
+	}
+	if len(pk) == 1 && strings.EqualFold(pk[0].Type, "INTEGER") {
+		return []string{Quote(pk[0].Name)}
+	}
+	return []string{"rowid"}
+}
@@ -0,0 +1,169 @@
+// Package embed stores vector embeddings of memory rows in SQLite and
+// recalls the rows closest to a query by cosine similarity, either by
+// scanning every vector or through an IVF index of k-means lists.
+//
+// Vectors are produced by an Embedder. Hash works offline and is fully
+// deterministic; OpenAI calls any OpenAI-compatible /embeddings endpoint.
+package embed
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalid is returned for malformed recall requests.
+var ErrInvalid = errors.New("invalid recall request")
+
+// Embedder turns texts into vectors of a fixed dimension.
+type Embedder interface {
+	// Model identifies the vectors; rows embedded by another model are
+	// ignored and re-embedded.
+	Model() string
+	Dims() int
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Hash is a deterministic feature-hashing embedder. Words, word bigrams and
+// character trigrams are hashed into a signed vector with sublinear term
+// frequency weights, so texts sharing rare words and word stems land close
+// together without any model or network access.
+type Hash struct {
+	dims int
+}
+
+// DefaultHashDims is the dimension used when NewHash is given 0.
+const DefaultHashDims = 512
+
+// NewHash returns a hashing embedder producing vectors of dims dimensions.
+func NewHash(dims int) *Hash {
+	if dims <= 0 {
+		dims = DefaultHashDims
+	}
+	return &Hash{dims: dims}
+}
+
+// Model includes the dimension since vectors of different sizes never mix.
+func (h *Hash) Model() string { return fmt.Sprintf("hash-v1-%d", h.dims) }
+
+// Dims returns the vector dimension.
+func (h *Hash) Dims() int { return h.dims }
+
+// Embed hashes each text into a unit vector. Texts without any word yield
+// the zero vector.
+func (h *Hash) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		out[i] = h.embed(text)
+	}
+	return out, nil
+}
+
+// Feature weights relative to a single word.
+const (
+	bigramWeight  = 0.5
+	trigramWeight = 0.25
+)
+
+func (h *Hash) embed(text string) []float32 {
+	words := Tokenize(text)
+	features := make(map[string]float64)
+	for i, w := range words {
+		features["w:"+w]++
+		if i > 0 {
+			features["b:"+words[i-1]+" "+w] += bigramWeight
+		}
+		runes := []rune("^" + w + "$")
+		for j := 0; j+3 <= len(runes); j++ {
+			features["c:"+string(runes[j:j+3])] += trigramWeight
+		}
+	}
+
+	v := make([]float32, h.dims)
+	for f, tf := range features {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(f))
+		sum := hasher.Sum64()
+		weight := 1 + math.Log(1+tf)
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		v[sum%uint64(h.dims)] += float32(weight)
+	}
+	Normalize(v)
+	return v
+}
+
+// stopwords carry no topic and are left out of hashed features.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "i": true, "in": true, "is": true,
+	"it": true, "its": true, "me": true, "my": true, "of": true, "on": true, "or": true, "so": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true, "we": true, "were": true,
+	"what": true, "when": true, "which": true, "with": true, "you": true, "your": true,
+}
+
+// Tokenize lowercases text and splits it into words, dropping stopwords.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words := fields[:0]
+	for _, f := range fields {
+		if !stopwords[f] {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// Normalize scales v to unit length in place. The zero vector is left as is.
+func Normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	inv := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= inv
+	}
+}
+
+// Dot returns the dot product of a and b, which is the cosine similarity
+// for unit vectors.
+func Dot(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+// Encode serializes v as little-endian float32s.
+func Encode(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+// Decode parses a vector written by Encode.
+func Decode(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector blob of %d bytes", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
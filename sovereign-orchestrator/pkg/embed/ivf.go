@@ -0,0 +1,248 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// maxLists bounds the number of IVF lists.
+	maxLists = 1024
+	// maxTrain bounds the vectors k-means is trained on; every vector is
+	// still assigned to its nearest list.
+	maxTrain = 20000
+	// kmeansIterations is enough for the lists to settle on real data.
+	kmeansIterations = 10
+)
+
+// list is one IVF list: its centroid and the vectors assigned to it.
+type list struct {
+	id       int
+	centroid []float32
+}
+
+// IndexInfo describes a built IVF index.
+type IndexInfo struct {
+	Model   string `json:"model"`
+	Lists   int    `json:"lists"`
+	Vectors int    `json:"vectors"`
+}
+
+// lists loads the centroids of the current model, if an index was built.
+func (s *Store) lists(ctx context.Context) ([]list, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, centroid FROM embedding_lists WHERE model = ? ORDER BY id", s.Embedder.Model())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IVF lists: %w", err)
+	}
+	defer rows.Close()
+	var lists []list
+	for rows.Next() {
+		var l list
+		var blob []byte
+		if err := rows.Scan(&l.id, &blob); err != nil {
+			return nil, err
+		}
+		if l.centroid, err = Decode(blob); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// nearest returns the id of the list whose centroid is closest to v.
+func nearest(lists []list, v []float32) int {
+	best, bestScore := lists[0].id, float32(math.Inf(-1))
+	for _, l := range lists {
+		if score := Dot(l.centroid, v); score > bestScore {
+			best, bestScore = l.id, score
+		}
+	}
+	return best
+}
+
+// probeLists returns the ids of the probes lists closest to v.
+func probeLists(lists []list, v []float32, probes int) []int {
+	if probes <= 0 {
+		probes = int(math.Ceil(math.Sqrt(float64(len(lists)))))
+	}
+	if probes > len(lists) {
+		probes = len(lists)
+	}
+	ranked := make([]list, len(lists))
+	copy(ranked, lists)
+	sort.SliceStable(ranked, func(i, j int) bool { return Dot(ranked[i].centroid, v) > Dot(ranked[j].centroid, v) })
+	ids := make([]int, probes)
+	for i := range ids {
+		ids[i] = ranked[i].id
+	}
+	return ids
+}
+
+// BuildIndex clusters the vectors of the current model with spherical
+// k-means into about sqrt(n) lists and assigns every vector to one.
+// Training is seeded, so the same vectors always give the same index.
+func (s *Store) BuildIndex(ctx context.Context) (*IndexInfo, error) {
+	model := s.Embedder.Model()
+	rows, err := s.DB.QueryContext(ctx, "SELECT rowid, vector FROM embeddings WHERE model = ? ORDER BY rowid", model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embeddings: %w", err)
+	}
+	var ids []int64
+	var vectors [][]float32
+	for rows.Next() {
+		var id int64
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		v, err := Decode(blob)
+		if err != nil || len(v) != s.Embedder.Dims() {
+			continue
+		}
+		ids = append(ids, id)
+		vectors = append(vectors, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	info := &IndexInfo{Model: model, Vectors: len(vectors)}
+	if len(vectors) == 0 {
+		return info, nil
+	}
+
+	k := int(math.Sqrt(float64(len(vectors))))
+	if k < 1 {
+		k = 1
+	}
+	if k > maxLists {
+		k = maxLists
+	}
+	train := vectors
+	if len(train) > maxTrain {
+		stride := float64(len(vectors)) / maxTrain
+		train = make([][]float32, maxTrain)
+		for i := range train {
+			train[i] = vectors[int(float64(i)*stride)]
+		}
+	}
+	centroids := kmeans(train, k, s.Embedder.Dims())
+	lists := make([]list, len(centroids))
+	for i, c := range centroids {
+		lists[i] = list{id: i, centroid: c}
+	}
+
+	// Vectors are read outside the write transaction so concurrent writers
+	// are not locked out while k-means runs. Rows embedded meanwhile keep a
+	// NULL list and are scanned on every query until the next build.
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	sizes := make([]int, len(lists))
+	update, err := tx.PrepareContext(ctx, "UPDATE embeddings SET list = ? WHERE rowid = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer update.Close()
+	for i, v := range vectors {
+		id := nearest(lists, v)
+		sizes[id]++
+		if _, err := update.ExecContext(ctx, id, ids[i]); err != nil {
+			return nil, fmt.Errorf("failed to assign IVF list: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM embedding_lists WHERE model = ?", model); err != nil {
+		return nil, err
+	}
+	for _, l := range lists {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO embedding_lists (model, id, centroid, size) VALUES (?, ?, ?, ?)",
+			model, l.id, Encode(l.centroid), sizes[l.id]); err != nil {
+			return nil, fmt.Errorf("failed to store IVF list: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	info.Lists = len(lists)
+	return info, nil
+}
+
+// kmeans runs spherical k-means with k-means++ seeding and returns unit
+// centroids. Lists that end up empty keep their previous centroid.
+func kmeans(vectors [][]float32, k, dims int) [][]float32 {
+	rng := rand.New(rand.NewSource(1))
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, clone(vectors[rng.Intn(len(vectors))]))
+	dist := make([]float64, len(vectors))
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vectors {
+			d := 1 - float64(Dot(v, centroids[len(centroids)-1]))
+			if len(centroids) == 1 || d < dist[i] {
+				dist[i] = d
+			}
+			total += dist[i]
+		}
+		if total <= 0 {
+			break // Fewer distinct vectors than lists
+		}
+		target := rng.Float64() * total
+		pick := len(vectors) - 1
+		for i, d := range dist {
+			if target -= d; target <= 0 {
+				pick = i
+				break
+			}
+		}
+		centroids = append(centroids, clone(vectors[pick]))
+	}
+
+	assign := make([]int, len(vectors))
+	for iter := 0; iter < kmeansIterations; iter++ {
+		changed := false
+		for i, v := range vectors {
+			best, bestScore := 0, float32(math.Inf(-1))
+			for c, centroid := range centroids {
+				if score := Dot(v, centroid); score > bestScore {
+					best, bestScore = c, score
+				}
+			}
+			if iter == 0 || assign[i] != best {
+				assign[i], changed = best, true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][]float32, len(centroids))
+		for i, v := range vectors {
+			c := assign[i]
+			if sums[c] == nil {
+				sums[c] = make([]float32, dims)
+			}
+			for d, x := range v {
+				sums[c][d] += x
+			}
+		}
+		for c, sum := range sums {
+			if sum == nil {
+				continue
+			}
+			Normalize(sum)
+			centroids[c] = sum
+		}
+	}
+	return centroids
+}
+
+func clone(v []float32) []float32 {
+	return append([]float32(nil), v...)
+}
@@ -0,0 +1,133 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI embeds texts with an OpenAI-compatible /embeddings endpoint
+// (OpenAI itself, Ollama's /v1 endpoint, llama.cpp server, ...).
+type OpenAI struct {
+	baseURL string
+	apiKey  string
+	model   string
+	dims    int
+	client  *http.Client
+}
+
+// OpenAIConfig configures an OpenAI-compatible embedder.
+type OpenAIConfig struct {
+	BaseURL string // e.g. https://api.openai.com/v1
+	APIKey  string // Optional for local servers
+	Model   string
+	Dims    int // Dimension the model returns
+	Timeout time.Duration
+}
+
+// NewOpenAI creates an OpenAI-compatible embedder.
+func NewOpenAI(cfg OpenAIConfig) (*OpenAI, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("embed: base_url is required")
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("embed: model is required")
+	}
+	if cfg.Dims <= 0 {
+		return nil, fmt.Errorf("embed: dims is required for model %q", cfg.Model)
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	return &OpenAI{
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:  cfg.APIKey,
+		model:   cfg.Model,
+		dims:    cfg.Dims,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Model returns the configured model name.
+func (o *OpenAI) Model() string { return o.model }
+
+// Dims returns the configured vector dimension.
+func (o *OpenAI) Dims() int { return o.dims }
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Embed requests embeddings for texts in one call and normalizes them.
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Model: o.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, fmt.Errorf("embed: failed to read response: %w", err)
+	}
+	var parsed embeddingResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("embed: server returned %s: %s", resp.Status, bytes.TrimSpace(data[:min(len(data), 4096)]))
+		}
+		return nil, fmt.Errorf("embed: invalid response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || parsed.Error != nil {
+		msg := resp.Status
+		if parsed.Error != nil {
+			msg = parsed.Error.Message
+		}
+		return nil, fmt.Errorf("embed: server returned %s", msg)
+	}
+
+	out := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(out) {
+			return nil, fmt.Errorf("embed: response index %d out of range", d.Index)
+		}
+		if len(d.Embedding) != o.dims {
+			return nil, fmt.Errorf("embed: model %q returned %d dimensions, configured %d", o.model, len(d.Embedding), o.dims)
+		}
+		Normalize(d.Embedding)
+		out[d.Index] = d.Embedding
+	}
+	for i, v := range out {
+		if v == nil {
+			return nil, fmt.Errorf("embed: response is missing input %d", i)
+		}
+	}
+	return out, nil
+}
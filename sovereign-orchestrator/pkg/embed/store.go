@@ -0,0 +1,356 @@
+package embed
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Source is an embedded table. Text is the SQL expression over alias t
+// whose value is embedded for each row.
+type Source struct {
+	Table   string
+	Text    string
+	Session bool // Has a session_id column
+}
+
+// Sources lists the tables kept in the store, matching the triggers of
+// migration 0007_embeddings.
+var Sources = []Source{
+	{Table: "ch", Text: "TRIM(COALESCE(t.content, ''))", Session: true},
+	{Table: "jon", Text: "TRIM(COALESCE(t.key, '') || ': ' || COALESCE(t.value, '') || ' ' || COALESCE(t.context, ''))"},
+}
+
+const (
+	// DefaultBatchSize is the number of rows embedded per call to the Embedder.
+	DefaultBatchSize = 128
+	// DefaultMinIVF is the store size at which an IVF index is first built.
+	DefaultMinIVF = 1024
+	// DefaultK is the number of memories recalled when none is given.
+	DefaultK = 10
+	// MaxK bounds the number of memories recalled at once.
+	MaxK = 200
+)
+
+// Store embeds rows of the Sources into the embeddings table and recalls
+// them by similarity to a query.
+type Store struct {
+	DB        *sql.DB
+	Embedder  Embedder
+	BatchSize int // Default DefaultBatchSize
+	MinIVF    int // Default DefaultMinIVF
+}
+
+// SyncResult reports one Sync pass.
+type SyncResult struct {
+	Embedded  map[string]int `json:"embedded"` // New vectors per table
+	Reindexed bool           `json:"reindexed"`
+}
+
+// Sync embeds every row that has no vector for the current model yet, then
+// rebuilds the IVF index once the store has doubled since it was built.
+// Rows with empty text are skipped.
+func (s *Store) Sync(ctx context.Context) (*SyncResult, error) {
+	res := &SyncResult{Embedded: map[string]int{}}
+	lists, err := s.lists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range Sources {
+		var after int64
+		for {
+			ids, texts, err := s.pending(ctx, src, after)
+			if err != nil {
+				return res, err
+			}
+			if len(ids) == 0 {
+				break
+			}
+			vectors, err := s.Embedder.Embed(ctx, texts)
+			if err != nil {
+				return res, fmt.Errorf("failed to embed %s rows: %w", src.Table, err)
+			}
+			if err := s.insert(ctx, src.Table, ids, vectors, lists); err != nil {
+				return res, err
+			}
+			res.Embedded[src.Table] += len(ids)
+			after = ids[len(ids)-1]
+		}
+	}
+
+	var total, indexed int
+	if err := s.DB.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM embeddings WHERE model = ?),
+		(SELECT COALESCE(SUM(size), 0) FROM embedding_lists WHERE model = ?)`, s.Embedder.Model(), s.Embedder.Model()).Scan(&total, &indexed); err != nil {
+		return res, err
+	}
+	if total >= s.minIVF() && total >= 2*indexed {
+		if _, err := s.BuildIndex(ctx); err != nil {
+			return res, err
+		}
+		res.Reindexed = true
+	}
+	return res, nil
+}
+
+func (s *Store) batchSize() int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (s *Store) minIVF() int {
+	if s.MinIVF > 0 {
+		return s.MinIVF
+	}
+	return DefaultMinIVF
+}
+
+// pending returns the next rows of src after id that have no vector.
+func (s *Store) pending(ctx context.Context, src Source, after int64) ([]int64, []string, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`SELECT t.id, %s FROM %s t
+		LEFT JOIN embeddings e ON e.source_table = ? AND e.source_id = t.id AND e.model = ?
+		WHERE e.source_id IS NULL AND t.id > ? AND %s != ''
+		ORDER BY t.id LIMIT ?`, src.Text, src.Table, src.Text),
+		src.Table, s.Embedder.Model(), after, s.batchSize())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s rows to embed: %w", src.Table, err)
+	}
+	defer rows.Close()
+	var ids []int64
+	var texts []string
+	for rows.Next() {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		texts = append(texts, text)
+	}
+	return ids, texts, rows.Err()
+}
+
+func (s *Store) insert(ctx context.Context, table string, ids []int64, vectors [][]float32, lists []list) error {
+	if len(vectors) != len(ids) {
+		return fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(ids))
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO embeddings (source_table, source_id, model, vector, list) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, v := range vectors {
+		if len(v) != s.Embedder.Dims() {
+			return fmt.Errorf("embedder returned %d dimensions, expected %d", len(v), s.Embedder.Dims())
+		}
+		var assigned interface{}
+		if len(lists) > 0 {
+			assigned = nearest(lists, v)
+		}
+		if _, err := stmt.ExecContext(ctx, table, ids[i], s.Embedder.Model(), Encode(v), assigned); err != nil {
+			return fmt.Errorf("failed to store embedding: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Query is a recall request.
+type Query struct {
+	Text   string
+	K      int      // Default DefaultK
+	Tables []string // Empty means every source
+	Method string   // "auto" (IVF when built), "exact" or "ivf"
+	Probes int      // IVF lists scanned; default the square root of the list count
+}
+
+// Memory is one recalled row.
+type Memory struct {
+	Table     string  `json:"table"`
+	ID        int64   `json:"id"`
+	Score     float32 `json:"score"` // Cosine similarity
+	Text      string  `json:"text"`
+	Timestamp string  `json:"timestamp"`
+	SessionID string  `json:"session_id,omitempty"`
+}
+
+// Recall is the result of a query, best match first.
+type Recall struct {
+	Query    string   `json:"query"`
+	Model    string   `json:"model"`
+	Method   string   `json:"method"`
+	Scanned  int      `json:"scanned"` // Vectors compared
+	Memories []Memory `json:"memories"`
+}
+
+// Recall returns the K rows most similar to q.Text.
+func (s *Store) Recall(ctx context.Context, q Query) (*Recall, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalid)
+	}
+	if q.K <= 0 {
+		q.K = DefaultK
+	}
+	if q.K > MaxK {
+		q.K = MaxK
+	}
+	sources, err := selectSources(q.Tables)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := s.Embedder.Embed(ctx, []string{q.Text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	query := vectors[0]
+
+	lists, err := s.lists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	method := q.Method
+	switch method {
+	case "", "auto":
+		method = "exact"
+		if len(lists) > 0 {
+			method = "ivf"
+		}
+	case "exact":
+	case "ivf":
+		if len(lists) == 0 {
+			return nil, fmt.Errorf("%w: no IVF index has been built for %s", ErrInvalid, s.Embedder.Model())
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown method %q", ErrInvalid, q.Method)
+	}
+
+	where := []string{"model = ?"}
+	args := []interface{}{s.Embedder.Model()}
+	var names []string
+	for _, src := range sources {
+		names = append(names, "?")
+		args = append(args, src.Table)
+	}
+	where = append(where, "source_table IN ("+strings.Join(names, ", ")+")")
+	if method == "ivf" {
+		// Vectors embedded since the last build have no list and are
+		// always scanned.
+		probe := probeLists(lists, query, q.Probes)
+		marks := make([]string, len(probe))
+		for i, id := range probe {
+			marks[i] = "?"
+			args = append(args, id)
+		}
+		where = append(where, "(list IN ("+strings.Join(marks, ", ")+") OR list IS NULL)")
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT source_table, source_id, vector FROM embeddings WHERE "+strings.Join(where, " AND "), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan embeddings: %w", err)
+	}
+	defer rows.Close()
+	result := &Recall{Query: q.Text, Model: s.Embedder.Model(), Method: method, Memories: []Memory{}}
+	top := &memoryHeap{}
+	for rows.Next() {
+		var m Memory
+		var blob []byte
+		if err := rows.Scan(&m.Table, &m.ID, &blob); err != nil {
+			return nil, err
+		}
+		v, err := Decode(blob)
+		if err != nil || len(v) != len(query) {
+			continue
+		}
+		result.Scanned++
+		m.Score = Dot(query, v)
+		if m.Score <= 0 {
+			continue
+		}
+		if top.Len() < q.K {
+			heap.Push(top, m)
+		} else if m.Score > (*top)[0].Score {
+			(*top)[0] = m
+			heap.Fix(top, 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	hits := []Memory(*top)
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	for _, m := range hits {
+		if err := s.load(ctx, &m); err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result.Memories = append(result.Memories, m)
+	}
+	return result, nil
+}
+
+// load fills in the text and metadata of a recalled row.
+func (s *Store) load(ctx context.Context, m *Memory) error {
+	for _, src := range Sources {
+		if src.Table != m.Table {
+			continue
+		}
+		session := "''"
+		if src.Session {
+			session = "COALESCE(t.session_id, '')"
+		}
+		// likely() keeps the DATETIME column as the stored text.
+		return s.DB.QueryRowContext(ctx, fmt.Sprintf("SELECT %s, COALESCE(likely(t.timestamp), ''), %s FROM %s t WHERE t.id = ?", src.Text, session, src.Table), m.ID).
+			Scan(&m.Text, &m.Timestamp, &m.SessionID)
+	}
+	return sql.ErrNoRows
+}
+
+func selectSources(tables []string) ([]Source, error) {
+	if len(tables) == 0 {
+		return Sources, nil
+	}
+	var sources []Source
+	for _, name := range tables {
+		found := false
+		for _, src := range Sources {
+			if src.Table == name {
+				sources = append(sources, src)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: table %q has no embeddings", ErrInvalid, name)
+		}
+	}
+	return sources, nil
+}
+
+// memoryHeap is a min-heap on score holding the best matches so far.
+type memoryHeap []Memory
+
+func (h memoryHeap) Len() int            { return len(h) }
+func (h memoryHeap) Less(i, j int) bool  { return h[i].Score < h[j].Score }
+func (h memoryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *memoryHeap) Push(x interface{}) { *h = append(*h, x.(Memory)) }
+func (h *memoryHeap) Pop() interface{} {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
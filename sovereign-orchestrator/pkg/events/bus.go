@@ -0,0 +1,176 @@
+// Package events is the in-process publish/subscribe bus that Ghost Mode,
+// memory saves, generation and uploads report to. Recent events are kept in
+// a bounded ring buffer so subscribers can resume after reconnecting.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single published message.
+type Event struct {
+	ID    uint64      `json:"id"`
+	Topic string      `json:"topic"` // Dot-separated, e.g. "ghost.cycle"
+	Type  string      `json:"type"`  // Display class: "info", "alert", "context", "decision", ...
+	Msg   string      `json:"msg"`
+	TS    float64     `json:"ts"` // Unix seconds
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Bus fans published events out to subscribers.
+type Bus struct {
+	mu     sync.Mutex
+	nextID uint64
+	ring   []Event
+	head   int // Index of the oldest event once the ring is full
+	subs   map[*Subscription]struct{}
+}
+
+// NewBus creates a bus that retains the last bufferSize events for replay.
+func NewBus(bufferSize int) *Bus {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &Bus{
+		ring: make([]Event, 0, bufferSize),
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to matching subscribers.
+// Subscribers that cannot keep up are disconnected rather than blocking the
+// publisher; they can resume from the ring buffer with their last event id.
+func (b *Bus) Publish(topic, typ, msg string, data interface{}) Event {
+	return b.publish(topic, typ, msg, data, true)
+}
+
+// PublishLive delivers an event to current subscribers without keeping it
+// for replay. It is meant for high-volume events such as generated tokens,
+// which would otherwise push everything else out of the ring buffer; a
+// subscriber that resumes misses them but still gets the events around them.
+func (b *Bus) PublishLive(topic, typ, msg string, data interface{}) Event {
+	return b.publish(topic, typ, msg, data, false)
+}
+
+func (b *Bus) publish(topic, typ, msg string, data interface{}, keep bool) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ev := Event{
+		ID:    b.nextID,
+		Topic: topic,
+		Type:  typ,
+		Msg:   msg,
+		TS:    float64(time.Now().UnixNano()) / 1e9,
+		Data:  data,
+	}
+	if keep && len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, ev)
+	} else if keep {
+		b.ring[b.head] = ev
+		b.head = (b.head + 1) % len(b.ring)
+	}
+
+	for sub := range b.subs {
+		if !sub.matches(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			b.removeLocked(sub)
+		}
+	}
+	return ev
+}
+
+// Since returns buffered events newer than lastID that match topics.
+// An empty topic list matches everything.
+func (b *Bus) Since(lastID uint64, topics []string) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sinceLocked(lastID, newFilter(topics))
+}
+
+func (b *Bus) sinceLocked(lastID uint64, f filter) []Event {
+	var out []Event
+	for i := 0; i < len(b.ring); i++ {
+		ev := b.ring[(b.head+i)%len(b.ring)]
+		if ev.ID > lastID && f.matches(ev.Topic) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// Subscribe registers a subscriber for topics and returns it together with the
+// buffered events after lastID, so nothing is missed between replay and live delivery.
+func (b *Bus) Subscribe(topics []string, lastID uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		bus:    b,
+		filter: newFilter(topics),
+		ch:     make(chan Event, 256),
+	}
+	b.subs[sub] = struct{}{}
+	return sub, b.sinceLocked(lastID, sub.filter)
+}
+
+func (b *Bus) removeLocked(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscription receives live events for a set of topics.
+type Subscription struct {
+	bus *Bus
+	filter
+	ch chan Event
+}
+
+// C returns the channel of live events. It is closed when the subscription
+// ends, either through Close or because the subscriber fell behind.
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Close unregisters the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.removeLocked(s)
+}
+
+// filter matches topics exactly or by dotted prefix: "ghost" matches "ghost.cycle".
+type filter struct {
+	topics []string
+}
+
+func newFilter(topics []string) filter {
+	var cleaned []string
+	for _, t := range topics {
+		if t = strings.TrimSpace(t); t != "" {
+			cleaned = append(cleaned, t)
+		}
+	}
+	return filter{topics: cleaned}
+}
+
+func (f filter) matches(topic string) bool {
+	if len(f.topics) == 0 {
+		return true
+	}
+	for _, t := range f.topics {
+		if topic == t || strings.HasPrefix(topic, t+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPublishLive(t *testing.T) {
+	b := NewBus(2)
+	sub, _ := b.Subscribe([]string{"generate"}, 0)
+	defer sub.Close()
+
+	start := b.Publish("generate.start", "info", "start", nil)
+	for i := range 5 {
+		b.PublishLive("generate.token", "token", fmt.Sprint(i), nil)
+	}
+	done := b.Publish("generate.done", "info", "done", nil)
+
+	// Subscribers see every event, in order.
+	var got []string
+	for range 7 {
+		ev := <-sub.C()
+		got = append(got, ev.Msg)
+	}
+	if want := "[start 0 1 2 3 4 done]"; fmt.Sprint(got) != want {
+		t.Errorf("live events %v, want %s", got, want)
+	}
+
+	// The ring only kept the others, so tokens never push them out.
+	backlog := b.Since(0, nil)
+	if len(backlog) != 2 || backlog[0].ID != start.ID || backlog[1].ID != done.ID {
+		t.Errorf("backlog %+v, want the start and done events", backlog)
+	}
+	if done.ID != start.ID+6 {
+		t.Errorf("ids %d and %d; live events should take ids too", start.ID, done.ID)
+	}
+}
@@ -0,0 +1,278 @@
+// Package ingest imports Gemini CLI chat sessions into the memory database.
+// It is the native replacement for scripts/memory_daemon.py: session files
+// are watched with inotify instead of polled, messages map onto ch rows, and
+// duplicates are rejected by the unique index on the message id in
+// ch.metadata rather than an in-memory set.
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Config describes where sessions are found and how they are ingested.
+type Config struct {
+	Dirs       []string      // Directories to watch; glob patterns are expanded on every rescan
+	Pattern    string        // Session file name pattern, default "session-*.json"
+	Debounce   time.Duration // Quiet period after a write before a file is read
+	Rescan     time.Duration // Interval for discovering new directories and catching missed events
+	CaptureJon bool          // Run the "i like / remember" heuristic into jon
+	Keywords   []string      // Heuristic triggers, default DefaultKeywords
+	Gate       sync.Locker   // If set, held while a file is ingested, so the owner can pause writes
+}
+
+// FileResult is the outcome of ingesting one session file.
+type FileResult struct {
+	Path       string `json:"path"`
+	SessionID  string `json:"session_id"`
+	Messages   int    `json:"messages"`
+	Inserted   int    `json:"inserted"`
+	Duplicates int    `json:"duplicates"`
+	Captured   int    `json:"captured"` // New jon rows
+	Skipped    bool   `json:"skipped"`  // Unchanged since the last run
+}
+
+// Stats are counters since the ingester started.
+type Stats struct {
+	Running         bool       `json:"running"`
+	StartedAt       time.Time  `json:"started_at"`
+	WatchedDirs     []string   `json:"watched_dirs"`
+	FilesIngested   int        `json:"files_ingested"`
+	FilesSkipped    int        `json:"files_skipped"`
+	MessagesNew     int        `json:"messages_inserted"`
+	Duplicates      int        `json:"duplicates_skipped"`
+	JonCaptured     int        `json:"jon_captured"`
+	Errors          int        `json:"errors"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastFile        string     `json:"last_file,omitempty"`
+	LastIngestAt    *time.Time `json:"last_ingest_at,omitempty"` // Nil until the first file is read
+	WatcherFallback bool       `json:"watcher_fallback"`         // inotify unavailable; polling on Rescan
+}
+
+// Ingester reads session files into ch (and jon).
+type Ingester struct {
+	db  *sql.DB
+	cfg Config
+
+	// OnIngest, if set, is called after every file that inserted rows.
+	OnIngest func(FileResult)
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New creates an ingester. Zero config values get their defaults.
+func New(db *sql.DB, cfg Config) *Ingester {
+	if cfg.Pattern == "" {
+		cfg.Pattern = "session-*.json"
+	}
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = 500 * time.Millisecond
+	}
+	if cfg.Rescan <= 0 {
+		cfg.Rescan = time.Minute
+	}
+	if cfg.Keywords == nil {
+		cfg.Keywords = DefaultKeywords
+	}
+	return &Ingester{db: db, cfg: cfg, stats: Stats{WatchedDirs: []string{}}}
+}
+
+// Stats returns a copy of the counters.
+func (in *Ingester) Stats() Stats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	st := in.stats
+	st.WatchedDirs = make([]string, len(in.stats.WatchedDirs))
+	copy(st.WatchedDirs, in.stats.WatchedDirs)
+	return st
+}
+
+func (in *Ingester) recordError(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.stats.Errors++
+	in.stats.LastError = err.Error()
+}
+
+// IngestFile imports one session file. Files whose size and modification
+// time match the last successful read are skipped.
+func (in *Ingester) IngestFile(ctx context.Context, path string) (FileResult, error) {
+	if in.cfg.Gate != nil {
+		in.cfg.Gate.Lock()
+		defer in.cfg.Gate.Unlock()
+	}
+	res := FileResult{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return res, err
+	}
+
+	var size, mtime int64
+	err = in.db.QueryRowContext(ctx, "SELECT size, mtime FROM ingest_files WHERE path = ?", path).Scan(&size, &mtime)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return res, fmt.Errorf("failed to look up %s: %w", path, err)
+	}
+	if err == nil && size == info.Size() && mtime == info.ModTime().UnixNano() {
+		res.Skipped = true
+		in.count(res)
+		return res, nil
+	}
+
+	session, err := readSession(path)
+	if err != nil {
+		return res, err
+	}
+	res.SessionID = session.SessionID
+	res.Messages = len(session.Messages)
+
+	tx, err := in.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("failed to begin ingest transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, msg := range session.Messages {
+		if msg.ID == "" {
+			continue
+		}
+		inserted, err := in.insertMessage(ctx, tx, session.SessionID, msg)
+		if err != nil {
+			return res, err
+		}
+		if !inserted {
+			res.Duplicates++
+			continue
+		}
+		res.Inserted++
+
+		if in.cfg.CaptureJon && msg.Type == "user" {
+			captured, err := captureInsight(ctx, tx, msg.text(), in.cfg.Keywords)
+			if err != nil {
+				return res, err
+			}
+			if captured {
+				res.Captured++
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO ingest_files (path, session_id, size, mtime, messages, inserted)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET session_id = excluded.session_id, size = excluded.size, mtime = excluded.mtime,
+			messages = excluded.messages, inserted = ingest_files.inserted + excluded.inserted, ingested_at = CURRENT_TIMESTAMP`,
+		path, session.SessionID, info.Size(), info.ModTime().UnixNano(), res.Messages, res.Inserted); err != nil {
+		return res, fmt.Errorf("failed to record ingest of %s: %w", path, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("failed to commit ingest of %s: %w", path, err)
+	}
+
+	in.count(res)
+	if res.Inserted > 0 && in.OnIngest != nil {
+		in.OnIngest(res)
+	}
+	return res, nil
+}
+
+func (in *Ingester) count(res FileResult) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if res.Skipped {
+		in.stats.FilesSkipped++
+		return
+	}
+	in.stats.FilesIngested++
+	in.stats.MessagesNew += res.Inserted
+	in.stats.Duplicates += res.Duplicates
+	in.stats.JonCaptured += res.Captured
+	in.stats.LastFile = res.Path
+	now := time.Now()
+	in.stats.LastIngestAt = &now
+}
+
+// insertMessage adds a message to ch. It reports false when the message id is
+// already present; the unique index idx_ch_message_id makes that check atomic.
+func (in *Ingester) insertMessage(ctx context.Context, tx *sql.Tx, sessionID string, msg Message) (bool, error) {
+	meta, err := json.Marshal(map[string]string{"id": msg.ID})
+	if err != nil {
+		return false, err
+	}
+	var ts interface{}
+	if msg.Timestamp != "" {
+		ts = msg.Timestamp
+	}
+	result, err := tx.ExecContext(ctx, `INSERT INTO ch (session_id, timestamp, type, content, metadata)
+		VALUES (?, COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		sessionID, ts, msg.chType(), msg.text(), string(meta))
+	if err != nil {
+		return false, fmt.Errorf("failed to insert message %s: %w", msg.ID, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// captureInsight stores a heuristic match in jon unless the same text is already there.
+func captureInsight(ctx context.Context, tx *sql.Tx, text string, keywords []string) (bool, error) {
+	if !isInsight(text, keywords) {
+		return false, nil
+	}
+	var exists int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM jon WHERE value = ? LIMIT 1", text).Scan(&exists)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to check jon for duplicates: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO jon (category, key, value, context) VALUES ('heuristic_capture', 'potential_insight', ?, 'Auto-captured from stream')", text); err != nil {
+		return false, fmt.Errorf("failed to capture insight: %w", err)
+	}
+	return true, nil
+}
+
+// resolveDirs expands the configured directories (which may be globs) into
+// existing directories.
+func (in *Ingester) resolveDirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, pattern := range in.cfg.Dirs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			in.recordError(fmt.Errorf("invalid chat directory pattern %q: %w", pattern, err))
+			continue
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.IsDir() && !seen[m] {
+				seen[m] = true
+				dirs = append(dirs, m)
+			}
+		}
+	}
+	return dirs
+}
+
+// scanDir ingests every session file in dir.
+func (in *Ingester) scanDir(ctx context.Context, dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, in.cfg.Pattern))
+	if err != nil {
+		in.recordError(err)
+		return
+	}
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := in.IngestFile(ctx, f); err != nil {
+			in.recordError(err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultKeywords trigger the jon heuristic capture, as in memory_daemon.py.
+var DefaultKeywords = []string{"i am", "i like", "i want", "my system", "remember", "i'm", "preference"}
+
+// maxCaptureLen skips long messages (pasted logs, code) in the jon heuristic.
+const maxCaptureLen = 2000
+
+// Session is a Gemini CLI chat file (session-*.json).
+type Session struct {
+	SessionID string    `json:"sessionId"`
+	Messages  []Message `json:"messages"`
+}
+
+// Message is one entry of a session.
+type Message struct {
+	ID        string          `json:"id"`
+	Timestamp string          `json:"timestamp"`
+	Type      string          `json:"type"` // "user", "model", "info", ...
+	Content   json.RawMessage `json:"content"`
+	ToolCalls json.RawMessage `json:"toolCalls"`
+}
+
+// readSession parses a session file.
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// chType maps a Gemini message type onto the ch type column.
+func (m Message) chType() string {
+	switch m.Type {
+	case "user":
+		return "input"
+	case "model":
+		return "output"
+	default:
+		return "system_event"
+	}
+}
+
+// text returns the message content, falling back to the tool calls as JSON
+// for model turns that only called tools.
+func (m Message) text() string {
+	if len(m.Content) > 0 && string(m.Content) != "null" {
+		var s string
+		if err := json.Unmarshal(m.Content, &s); err == nil {
+			if s != "" {
+				return s
+			}
+		} else {
+			// Structured content (parts); keep it verbatim.
+			return string(m.Content)
+		}
+	}
+	if len(m.ToolCalls) > 0 && string(m.ToolCalls) != "null" {
+		return string(m.ToolCalls)
+	}
+	return "[]"
+}
+
+// isInsight reports whether a user message looks like a statement about the
+// user worth keeping in jon ("I like...", "remember that...").
+func isInsight(text string, keywords []string) bool {
+	if text == "" || len(text) >= maxCaptureLen {
+		return false
+	}
+	lower := strings.ToLower(text)
+	for _, k := range keywords {
+		if strings.Contains(lower, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Run ingests existing sessions, then watches the chat directories until ctx
+// is cancelled. Writes to a session file are debounced, since the CLI
+// rewrites the whole file after every message. If inotify is unavailable
+// the ingester falls back to rescanning on the Rescan interval.
+func (in *Ingester) Run(ctx context.Context) error {
+	in.mu.Lock()
+	in.stats.Running = true
+	in.stats.StartedAt = time.Now()
+	in.mu.Unlock()
+	defer func() {
+		in.mu.Lock()
+		in.stats.Running = false
+		in.mu.Unlock()
+	}()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		in.recordError(err)
+		in.mu.Lock()
+		in.stats.WatcherFallback = true
+		in.mu.Unlock()
+	} else {
+		defer watcher.Close()
+	}
+
+	watched := make(map[string]bool)
+	rescan := func() {
+		dirs := in.resolveDirs()
+		for _, dir := range dirs {
+			if watcher != nil && !watched[dir] {
+				if err := watcher.Add(dir); err != nil {
+					in.recordError(err)
+					continue
+				}
+				watched[dir] = true
+			}
+			// Unchanged files are skipped cheaply, so a full scan also
+			// recovers anything a dropped inotify event missed.
+			in.scanDir(ctx, dir)
+		}
+		in.setWatched(dirs)
+	}
+	rescan()
+
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+	)
+	if watcher != nil {
+		events, errs = watcher.Events, watcher.Errors
+	}
+	ticker := time.NewTicker(in.cfg.Rescan)
+	defer ticker.Stop()
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+	pending := make(map[string]time.Time) // path -> last write
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			rescan()
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			// Usually an event queue overflow; the next rescan catches up.
+			in.recordError(err)
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			// Atomic replacements arrive as Create on the target name.
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+				continue
+			}
+			if match, _ := filepath.Match(in.cfg.Pattern, filepath.Base(ev.Name)); !match {
+				continue
+			}
+			pending[ev.Name] = time.Now()
+			debounce.Reset(in.cfg.Debounce)
+		case <-debounce.C:
+			now := time.Now()
+			var next time.Duration
+			for path, at := range pending {
+				if wait := in.cfg.Debounce - now.Sub(at); wait > 0 {
+					if next == 0 || wait < next {
+						next = wait
+					}
+					continue
+				}
+				delete(pending, path)
+				if _, err := in.IngestFile(ctx, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					in.recordError(err)
+				}
+			}
+			if next > 0 {
+				debounce.Reset(next)
+			}
+		}
+	}
+}
+
+func (in *Ingester) setWatched(dirs []string) {
+	sorted := append([]string(nil), dirs...)
+	sort.Strings(sorted)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.stats.WatchedDirs = sorted
+}
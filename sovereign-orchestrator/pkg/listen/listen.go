@@ -0,0 +1,115 @@
+// Package listen opens the sockets the HTTP server accepts connections on:
+// Unix domain sockets that report their peer's credentials, and TLS
+// listeners backed by user-supplied or self-signed certificates.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Cred identifies the process on the other end of a Unix socket.
+type Cred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Unix listens on a Unix domain socket at path with the given permissions.
+// A socket left behind by a process that is gone is replaced; one that
+// still accepts connections is an error.
+func Unix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("another process is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	// Create the socket without group or other access, so there is no
+	// window in which it is more open than mode.
+	old := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// PeerConn is a Unix socket connection with the credentials of its peer,
+// read when it was accepted.
+type PeerConn struct {
+	net.Conn
+	Cred Cred
+}
+
+type peerListener struct {
+	net.Listener
+}
+
+// WithPeerCred wraps a Unix socket listener so that it accepts *PeerConn.
+// Connections whose credentials cannot be read are refused.
+func WithPeerCred(l net.Listener) net.Listener {
+	return peerListener{l}
+}
+
+func (l peerListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		cred, err := PeerCred(c)
+		if err != nil {
+			c.Close()
+			continue
+		}
+		return &PeerConn{Conn: c, Cred: cred}, nil
+	}
+}
+
+// PeerCred returns the credentials of the peer of a Unix socket connection.
+func PeerCred(c net.Conn) (Cred, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return Cred{}, errors.New("not a unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var (
+		ucred *unix.Ucred
+		cerr  error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		ucred, cerr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return Cred{}, err
+	}
+	if cerr != nil {
+		return Cred{}, fmt.Errorf("failed to read peer credentials: %w", cerr)
+	}
+	return Cred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
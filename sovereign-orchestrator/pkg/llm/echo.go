@@ -0,0 +1,84 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Echo is a deterministic offline backend. It answers from a fixture table when
+// the prompt matches one exactly and otherwise echoes the prompt back, so the
+// same request always produces the same response.
+type Echo struct {
+	name     string
+	fixtures map[string]string
+}
+
+// NewEcho creates an echo backend with optional fixtures (prompt -> response).
+func NewEcho(name string, fixtures map[string]string) *Echo {
+	if fixtures == nil {
+		fixtures = make(map[string]string)
+	}
+	return &Echo{name: name, fixtures: fixtures}
+}
+
+// LoadFixtures reads a JSON object mapping prompts to responses.
+func LoadFixtures(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make(map[string]string)
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("llm: invalid fixtures file %s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// Name returns the configured provider name.
+func (e *Echo) Name() string { return e.name }
+
+// Generate returns the fixture response or the echoed prompt.
+func (e *Echo) Generate(ctx context.Context, req Request) (*Response, error) {
+	if req.Prompt == "" {
+		return nil, ErrEmptyPrompt
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	text, ok := e.fixtures[req.Prompt]
+	if !ok {
+		mode := req.Mode
+		if mode == "" {
+			mode = "default"
+		}
+		text = fmt.Sprintf("[echo:%s] %s", mode, req.Prompt)
+	}
+	if req.MaxTokens > 0 {
+		text = truncateTokens(text, req.MaxTokens)
+	}
+	return &Response{Text: text, Provider: e.name, Model: "echo", FinishReason: "stop", Usage: estimateUsage(req, text)}, nil
+}
+
+// StreamGenerate emits the Generate result word by word.
+func (e *Echo) StreamGenerate(ctx context.Context, req Request, onToken TokenFunc) (*Response, error) {
+	resp, err := e.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	words := strings.SplitAfter(resp.Text, " ")
+	for _, w := range words {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if w == "" {
+			continue
+		}
+		if err := onToken(w); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// GeminiCLI runs the Gemini CLI as a subprocess in non-interactive mode.
+// The prompt is written to the process's stdin and its stdout is the response.
+type GeminiCLI struct {
+	name    string
+	command string
+	args    []string
+	model   string
+	timeout time.Duration
+}
+
+// GeminiCLIConfig configures the Gemini CLI backend.
+type GeminiCLIConfig struct {
+	Name    string
+	Command string   // Path or name of the gemini binary (default "gemini")
+	Args    []string // Extra arguments passed before the model flag
+	Model   string   // Optional --model value
+	Timeout time.Duration
+}
+
+// NewGeminiCLI creates a Gemini CLI backend.
+func NewGeminiCLI(cfg GeminiCLIConfig) *GeminiCLI {
+	command := cfg.Command
+	if command == "" {
+		command = "gemini"
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	return &GeminiCLI{name: cfg.Name, command: command, args: cfg.Args, model: cfg.Model, timeout: timeout}
+}
+
+// Name returns the configured provider name.
+func (g *GeminiCLI) Name() string { return g.name }
+
+// Generate runs the CLI and returns its complete output.
+func (g *GeminiCLI) Generate(ctx context.Context, req Request) (*Response, error) {
+	return g.StreamGenerate(ctx, req, func(string) error { return nil })
+}
+
+// StreamGenerate runs the CLI and forwards its stdout to onToken as it is produced.
+func (g *GeminiCLI) StreamGenerate(ctx context.Context, req Request, onToken TokenFunc) (*Response, error) {
+	if req.Prompt == "" {
+		return nil, ErrEmptyPrompt
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	args := append([]string{}, g.args...)
+	if g.model != "" {
+		args = append(args, "--model", g.model)
+	}
+	cmd := exec.CommandContext(ctx, g.command, args...)
+	cmd.Stdin = strings.NewReader(g.prompt(req))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("llm: provider %q: failed to start %s: %w", g.name, g.command, err)
+	}
+
+	// Deltas end on a rune boundary, and stop at the MaxTokens cap: the
+	// process is stopped then, so streamed clients never get more than the
+	// final text.
+	var (
+		text      strings.Builder
+		pending   []byte
+		emitted   int // Runes passed to onToken
+		streamErr error
+		capped    bool
+	)
+	limit := -1
+	if req.MaxTokens > 0 {
+		limit = req.MaxTokens * 4 // The estimate of truncateTokens
+	}
+	buf := make([]byte, 4096)
+	for !capped {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			cut := completeRunes(pending)
+			if errors.Is(err, io.EOF) {
+				cut = len(pending) // Flush a broken sequence as is
+			}
+			delta := string(pending[:cut])
+			pending = append(pending[:0], pending[cut:]...)
+			if limit >= 0 && emitted+utf8.RuneCountInString(delta) > limit {
+				delta = string([]rune(delta)[:limit-emitted])
+				capped = true
+				cancel()
+			}
+			emitted += utf8.RuneCountInString(delta)
+			text.WriteString(delta)
+			if streamErr == nil && delta != "" {
+				streamErr = onToken(delta)
+				if streamErr != nil {
+					cancel()
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !capped {
+				streamErr = err
+			}
+			break
+		}
+	}
+	if len(pending) > 0 && !capped {
+		text.Write(pending)
+		if streamErr == nil {
+			streamErr = onToken(string(pending))
+		}
+	}
+
+	waitErr := cmd.Wait()
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	if waitErr != nil && !capped {
+		return nil, fmt.Errorf("llm: provider %q: %s failed: %v: %s", g.name, g.command, waitErr, strings.TrimSpace(stderr.String()))
+	}
+
+	out := strings.TrimSpace(text.String())
+	finish := "stop"
+	if capped {
+		finish = "length"
+	}
+	return &Response{Text: out, Provider: g.name, Model: g.model, FinishReason: finish, Usage: estimateUsage(req, out)}, nil
+}
+
+// completeRunes returns the length of the longest prefix of b that does not
+// end inside a UTF-8 sequence.
+func completeRunes(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if utf8.FullRune(b[i:]) {
+			return len(b)
+		}
+		return i
+	}
+	return len(b)
+}
+
+// prompt folds the system instruction into the prompt, as the CLI has no separate channel for it.
+func (g *GeminiCLI) prompt(req Request) string {
+	if req.System == "" {
+		return req.Prompt
+	}
+	return req.System + "\n\n" + req.Prompt
+}
@@ -0,0 +1,68 @@
+// Package llm contains the text generation backends used by the orchestrator:
+// an OpenAI-compatible HTTP client, a Gemini CLI subprocess wrapper and a
+// deterministic echo/fixture backend for offline use.
+package llm
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrEmptyPrompt is returned when a request has no prompt.
+var ErrEmptyPrompt = errors.New("llm: prompt is empty")
+
+// Request is a single generation request.
+type Request struct {
+	Prompt      string
+	System      string // Optional system instruction
+	Mode        string // e.g. "technical", "creative"
+	MaxTokens   int    // 0 means the backend default
+	Temperature *float64
+}
+
+// Usage reports token counts for a generation. Estimated is set when the
+// backend did not report counts and they were approximated locally.
+type Usage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	Estimated        bool `json:"estimated"`
+}
+
+// Response is the result of a generation.
+type Response struct {
+	Text         string `json:"text"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Usage        Usage  `json:"usage"`
+}
+
+// TokenFunc receives streamed text deltas. Returning an error aborts the stream.
+type TokenFunc func(delta string) error
+
+// EstimateTokens approximates the token count of s at roughly four characters per token.
+func EstimateTokens(s string) int {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
+
+// truncateTokens cuts s to roughly maxTokens tokens using the same estimate as EstimateTokens.
+func truncateTokens(s string, maxTokens int) string {
+	limit := maxTokens * 4
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit])
+}
+
+// estimateUsage fills in usage from the prompt and completion text.
+func estimateUsage(req Request, completion string) Usage {
+	prompt := EstimateTokens(req.System) + EstimateTokens(req.Prompt)
+	out := EstimateTokens(completion)
+	return Usage{PromptTokens: prompt, CompletionTokens: out, TotalTokens: prompt + out, Estimated: true}
+}
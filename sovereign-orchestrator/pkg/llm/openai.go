@@ -0,0 +1,253 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI talks to any server implementing the OpenAI chat completions API
+// (OpenAI itself, llama.cpp server, vLLM, Ollama's /v1 endpoint, ...).
+type OpenAI struct {
+	name        string
+	baseURL     string
+	apiKey      string
+	model       string
+	temperature *float64
+	client      *http.Client
+}
+
+// OpenAIConfig configures an OpenAI-compatible backend.
+type OpenAIConfig struct {
+	Name        string
+	BaseURL     string // e.g. https://api.openai.com/v1
+	APIKey      string // Optional for local servers
+	Model       string
+	Temperature *float64
+	Timeout     time.Duration
+}
+
+// NewOpenAI creates an OpenAI-compatible backend.
+func NewOpenAI(cfg OpenAIConfig) (*OpenAI, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("llm: provider %q: base_url is required", cfg.Name)
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("llm: provider %q: model is required", cfg.Name)
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	return &OpenAI{
+		name:        cfg.Name,
+		baseURL:     strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:      cfg.APIKey,
+		model:       cfg.Model,
+		temperature: cfg.Temperature,
+		client:      &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name returns the configured provider name.
+func (o *OpenAI) Name() string { return o.name }
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		Delta        chatMessage `json:"delta"`
+		FinishReason *string     `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *OpenAI) newRequest(ctx context.Context, req Request, stream bool) (*http.Request, error) {
+	if req.Prompt == "" {
+		return nil, ErrEmptyPrompt
+	}
+	body := chatRequest{
+		Model:       o.model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: o.temperature,
+		Stream:      stream,
+	}
+	if req.Temperature != nil {
+		body.Temperature = req.Temperature
+	}
+	if stream {
+		body.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	if req.System != "" {
+		body.Messages = append(body.Messages, chatMessage{Role: "system", Content: req.System})
+	}
+	body.Messages = append(body.Messages, chatMessage{Role: "user", Content: req.Prompt})
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	return httpReq, nil
+}
+
+func (o *OpenAI) do(httpReq *http.Request) (*http.Response, error) {
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("llm: provider %q: %w", o.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var parsed chatResponse
+		if json.Unmarshal(msg, &parsed) == nil && parsed.Error != nil {
+			return nil, fmt.Errorf("llm: provider %q returned %s: %s", o.name, resp.Status, parsed.Error.Message)
+		}
+		return nil, fmt.Errorf("llm: provider %q returned %s: %s", o.name, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// Generate performs a non-streaming chat completion.
+func (o *OpenAI) Generate(ctx context.Context, req Request) (*Response, error) {
+	httpReq, err := o.newRequest(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("llm: provider %q: invalid response: %w", o.name, err)
+	}
+	if len(parsed.Choices) == 0 {
+		return nil, fmt.Errorf("llm: provider %q returned no choices", o.name)
+	}
+
+	out := &Response{Text: parsed.Choices[0].Message.Content, Provider: o.name, Model: o.modelName(parsed.Model)}
+	if fr := parsed.Choices[0].FinishReason; fr != nil {
+		out.FinishReason = *fr
+	}
+	out.Usage = o.usage(req, out.Text, parsed.Usage)
+	return out, nil
+}
+
+// StreamGenerate performs a streaming chat completion, calling onToken for each delta.
+func (o *OpenAI) StreamGenerate(ctx context.Context, req Request, onToken TokenFunc) (*Response, error) {
+	httpReq, err := o.newRequest(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+	resp, err := o.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		text  strings.Builder
+		out   = &Response{Provider: o.name, Model: o.model}
+		usage *chatUsage
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			break
+		}
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return nil, fmt.Errorf("llm: provider %q: invalid stream chunk: %w", o.name, err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("llm: provider %q: %s", o.name, chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		out.Model = o.modelName(chunk.Model)
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				out.FinishReason = *choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := onToken(choice.Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("llm: provider %q: stream interrupted: %w", o.name, err)
+	}
+
+	out.Text = text.String()
+	out.Usage = o.usage(req, out.Text, usage)
+	return out, nil
+}
+
+func (o *OpenAI) modelName(reported string) string {
+	if reported != "" {
+		return reported
+	}
+	return o.model
+}
+
+func (o *OpenAI) usage(req Request, completion string, reported *chatUsage) Usage {
+	if reported == nil || reported.TotalTokens == 0 {
+		return estimateUsage(req, completion)
+	}
+	return Usage{PromptTokens: reported.PromptTokens, CompletionTokens: reported.CompletionTokens, TotalTokens: reported.TotalTokens}
+}
@@ -0,0 +1,373 @@
+// Package migrate applies numbered, embedded SQL migrations to a SQLite database
+// and records them in the schema_versions table.
+//
+// Migrations are files named NNNN_description.up.sql with an optional matching
+// NNNN_description.down.sql. A migration without a down file is irreversible.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrIrreversible is returned when rolling back a migration that has no down script.
+	ErrIrreversible = errors.New("migration is irreversible")
+	// ErrBlocked is returned when the next pending migration cannot run in
+	// this binary. Later migrations wait for it, so they never run out of order.
+	ErrBlocked = errors.New("migration is blocked")
+)
+
+// Migration is a single numbered schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // Empty when the migration cannot be rolled back
+	Checksum string // SHA-256 of Up, used to detect edited migrations
+	Blocked  string // Why this binary cannot apply it; empty when it can
+}
+
+// Status describes the state of one migration in a database.
+type Status struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+	Modified  bool      `json:"modified"`          // Applied checksum differs from the embedded migration
+	Unknown   bool      `json:"unknown"`           // Applied in the database but not known to this binary
+	Blocked   string    `json:"blocked,omitempty"` // Why a pending migration cannot be applied by this binary
+}
+
+var fileRe = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_\-]+)\.(up|down)\.sql$`)
+
+// Load reads all migrations from dir in fsys, sorted by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("migration %q: version must be positive", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+			sum := sha256.Sum256(data)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up script", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies a fixed set of migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New creates a Migrator for db. migrations must be sorted by version, as returned by Load.
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Latest returns the highest version known to the migrator, or 0 if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+type appliedVersion struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// ensureTable creates schema_versions, adding the name and checksum columns to
+// tables created by older builds that only tracked the version number.
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_versions (id INTEGER PRIMARY KEY AUTOINCREMENT, version INTEGER UNIQUE, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP, name TEXT, checksum TEXT)"); err != nil {
+		return fmt.Errorf("failed to create schema_versions: %w", err)
+	}
+
+	rows, err := m.db.QueryContext(ctx, "PRAGMA table_info(schema_versions)")
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, col := range []string{"name", "checksum"} {
+		if !columns[col] {
+			if _, err := m.db.ExecContext(ctx, "ALTER TABLE schema_versions ADD COLUMN "+col+" TEXT"); err != nil {
+				return fmt.Errorf("failed to upgrade schema_versions: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedVersion, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT version, COALESCE(name, ''), COALESCE(checksum, ''), COALESCE(applied_at, '') FROM schema_versions WHERE version IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_versions: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedVersion)
+	for rows.Next() {
+		var (
+			version   int
+			av        appliedVersion
+			appliedAt string
+		)
+		if err := rows.Scan(&version, &av.name, &av.checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		av.appliedAt = parseTimestamp(appliedAt)
+		applied[version] = av
+	}
+	return applied, rows.Err()
+}
+
+// Status reports every known migration plus any versions recorded in the
+// database that this binary does not know about.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(m.migrations))
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if av, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = av.appliedAt
+			st.Modified = av.checksum != "" && av.checksum != mig.Checksum
+		} else {
+			st.Blocked = mig.Blocked
+		}
+		statuses = append(statuses, st)
+	}
+	for version, av := range applied {
+		if !known[version] {
+			statuses = append(statuses, Status{Version: version, Name: av.name, Applied: true, AppliedAt: av.appliedAt, Unknown: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Current returns the highest applied version, or 0 for an unversioned database.
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// Verify checks that the database has not diverged from the embedded
+// migrations: every applied version must be known and unmodified.
+// Applied rows from older builds without a checksum are backfilled.
+func (m *Migrator) Verify(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		switch {
+		case st.Unknown:
+			return fmt.Errorf("database has schema version %d (%s) which is unknown to this binary (latest %d); refusing to continue", st.Version, st.Name, m.Latest())
+		case st.Modified:
+			return fmt.Errorf("migration %d (%s) was applied with a different checksum; the schema has diverged", st.Version, st.Name)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if _, err := m.db.ExecContext(ctx, "UPDATE schema_versions SET name = ?, checksum = ? WHERE version = ? AND (checksum IS NULL OR checksum = '')", mig.Name, mig.Checksum, mig.Version); err != nil {
+			return fmt.Errorf("failed to backfill schema_versions: %w", err)
+		}
+	}
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet, in order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies pending migrations up to and including target (0 means all).
+// Each migration runs in its own transaction; on failure the already
+// applied migrations are kept and the failing one is rolled back. Up stops
+// with ErrBlocked at a blocked migration, keeping the ones before it.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	if err := m.Verify(ctx); err != nil {
+		return nil, err
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range pending {
+		if target > 0 && mig.Version > target {
+			break
+		}
+		if mig.Blocked != "" {
+			return done, BlockedError(mig)
+		}
+		if err := m.run(ctx, mig, mig.Up, true); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// BlockedError describes why mig, and the migrations after it, cannot be
+// applied.
+func BlockedError(mig Migration) error {
+	return fmt.Errorf("%w: %04d_%s %s; later migrations wait for it", ErrBlocked, mig.Version, mig.Name, mig.Blocked)
+}
+
+// Down rolls back the most recently applied migrations, steps at a time.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := m.Verify(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return done, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, ErrIrreversible)
+		}
+		if err := m.run(ctx, mig, mig.Down, false); err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+func (m *Migrator) run(ctx context.Context, mig Migration, script string, up bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_versions (version, name, checksum) VALUES (?, ?, ?)", mig.Version, mig.Name, mig.Checksum)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_versions WHERE version = ?", mig.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return tx.Commit()
+}
+
+// parseTimestamp accepts the formats SQLite's CURRENT_TIMESTAMP may come back in.
+func parseTimestamp(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05Z"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
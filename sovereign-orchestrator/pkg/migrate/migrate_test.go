@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testMigrations(blocked int) []Migration {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: "CREATE TABLE one (id INTEGER)", Down: "DROP TABLE one", Checksum: "1"},
+		{Version: 2, Name: "two", Up: "CREATE TABLE two (id INTEGER)", Down: "DROP TABLE two", Checksum: "2"},
+		{Version: 3, Name: "three", Up: "CREATE TABLE three (id INTEGER)", Checksum: "3"},
+	}
+	for i := range migrations {
+		if migrations[i].Version == blocked {
+			migrations[i].Blocked = "needs a feature"
+		}
+	}
+	return migrations
+}
+
+func TestUpStopsAtBlocked(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	done, err := New(db, testMigrations(2)).Up(ctx, 0)
+	if !errors.Is(err, ErrBlocked) || len(done) != 1 || done[0].Version != 1 {
+		t.Fatalf("Up = %v, %v; want migration 1 and ErrBlocked", done, err)
+	}
+	statuses, err := New(db, testMigrations(2)).Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Status{{Version: 1, Name: "one", Applied: true}, {Version: 2, Name: "two", Blocked: "needs a feature"}, {Version: 3, Name: "three"}}
+	for i, st := range statuses {
+		st.AppliedAt = want[i].AppliedAt
+		if st != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, st, want[i])
+		}
+	}
+
+	// A binary that can run it picks up where the other stopped, in order.
+	done, err = New(db, testMigrations(0)).Up(ctx, 0)
+	if err != nil || len(done) != 2 || done[0].Version != 2 || done[1].Version != 3 {
+		t.Fatalf("Up = %v, %v; want migrations 2 and 3", done, err)
+	}
+	if current, _ := New(db, testMigrations(0)).Current(ctx); current != 3 {
+		t.Errorf("current version %d, want 3", current)
+	}
+}
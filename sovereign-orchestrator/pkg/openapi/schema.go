@@ -0,0 +1,315 @@
+// Package openapi describes the HTTP API as an OpenAPI 3.1 document. The
+// JSON Schemas in it are derived from Go types by reflection, and request
+// bodies are validated against the same schemas, so the document cannot
+// drift from what the server accepts.
+//
+// Fields are described with struct tags next to their json tag:
+//
+//	Name string `json:"name" doc:"Database name" schema:"required,maxLength=64"`
+//
+// The schema tag takes comma-separated options: required, min=, max=,
+// minLength=, maxLength=, minItems=, maxItems=, pattern=, format= and
+// enum= with the values separated by "|". A pattern may not contain a
+// comma.
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is a JSON Schema (draft 2020-12, as used by OpenAPI 3.1). Only the
+// keywords the generator emits and the validator checks are modeled.
+type Schema struct {
+	Ref         string `json:"$ref,omitempty"`
+	Type        any    `json:"type,omitempty"` // A type name, or a list of them
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	Enum        []any  `json:"enum,omitempty"`
+
+	Minimum   *float64 `json:"minimum,omitempty"`
+	Maximum   *float64 `json:"maximum,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is false for closed objects, or the *Schema of
+	// the values of a map.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+}
+
+// Schemer is implemented by types whose JSON form differs from what
+// reflection would derive, such as types with their own MarshalJSON.
+type Schemer interface {
+	JSONSchema() *Schema
+}
+
+var (
+	schemerType       = reflect.TypeFor[Schemer]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType          = reflect.TypeFor[time.Time]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+)
+
+// Generator derives schemas from Go types. Named struct types become
+// entries in Schemas, referenced with $ref, so each is described once.
+type Generator struct {
+	Schemas map[string]*Schema // By component name
+
+	names map[reflect.Type]string
+}
+
+// NewGenerator returns a Generator with no schemas yet.
+func NewGenerator() *Generator {
+	return &Generator{Schemas: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// SchemaOf returns the schema of the type of v.
+func (g *Generator) SchemaOf(v any) *Schema {
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *Generator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t.Implements(schemerType) {
+		return copySchema(reflect.Zero(t).Interface().(Schemer).JSONSchema())
+	}
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(schemerType) {
+		return copySchema(reflect.New(t).Interface().(Schemer).JSONSchema())
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Pointer {
+		if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+			return &Schema{} // Anything; the type should implement Schemer
+		}
+		if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+			return &Schema{Type: "string"}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Minimum: ptr(0.0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return &Schema{Type: "string", Format: "byte"} // Base64, like encoding/json
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.register(t)}
+	}
+	return &Schema{} // Interfaces, and kinds encoding/json cannot write
+}
+
+// register adds the schema of a named struct type to Schemas and returns
+// its component name.
+func (g *Generator) register(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := componentName(t)
+	for i := 2; g.Schemas[name] != nil; i++ {
+		name = componentName(t) + strconv.Itoa(i)
+	}
+	g.names[t] = name
+	g.Schemas[name] = &Schema{} // Placeholder, so recursive types terminate
+	*g.Schemas[name] = *g.object(t)
+	return name
+}
+
+var nameCleaner = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// componentName names types of package main by their capitalized name and
+// others by package and name, e.g. "MergeRequest" and "dbmerge.Report".
+func componentName(t reflect.Type) string {
+	name := nameCleaner.ReplaceAllString(t.Name(), "_")
+	if t.PkgPath() == "main" {
+		r := []rune(name)
+		r[0] = unicode.ToUpper(r[0])
+		return string(r)
+	}
+	return path.Base(t.PkgPath()) + "." + name
+}
+
+// object describes a struct the way encoding/json writes it: exported
+// fields under their json names, with embedded structs flattened.
+func (g *Generator) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := g.schema(ft)
+		if strings.Contains(","+opts+",", ",string,") {
+			fs = &Schema{Type: "string"}
+		}
+		if doc := f.Tag.Get("doc"); doc != "" {
+			fs.Description = doc
+		}
+		if required := applyTag(fs, f.Tag.Get("schema")); required {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// Parameters describes the fields of the struct v as parameters in the
+// location in ("query" or "path"), named by their query tag.
+func (g *Generator) Parameters(v any, in string) []*Parameter {
+	t := reflect.TypeOf(v)
+	var params []*Parameter
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name := f.Tag.Get("query")
+		if name == "" || name == "-" {
+			continue
+		}
+		s := g.schema(f.Type)
+		p := &Parameter{Name: name, In: in, Description: f.Tag.Get("doc"), Schema: s}
+		p.Required = applyTag(s, f.Tag.Get("schema")) || in == "path"
+		params = append(params, p)
+	}
+	return params
+}
+
+// applyTag sets the constraints of a schema tag on s and reports whether
+// the field is required.
+func applyTag(s *Schema, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "required":
+			required = true
+		case "min":
+			s.Minimum = ptr(mustFloat(key, value))
+		case "max":
+			s.Maximum = ptr(mustFloat(key, value))
+		case "minLength":
+			s.MinLength = ptr(mustInt(key, value))
+		case "maxLength":
+			s.MaxLength = ptr(mustInt(key, value))
+		case "minItems":
+			s.MinItems = ptr(mustInt(key, value))
+		case "maxItems":
+			s.MaxItems = ptr(mustInt(key, value))
+		case "pattern":
+			regexp.MustCompile(value)
+			s.Pattern = value
+		case "format":
+			s.Format = value
+		case "enum":
+			for _, v := range strings.Split(value, "|") {
+				s.Enum = append(s.Enum, v)
+			}
+		default:
+			panic(fmt.Sprintf("openapi: unknown schema tag option %q", opt))
+		}
+	}
+	return required
+}
+
+// Tags are fixed at compile time, so a malformed one is a programming error.
+func mustFloat(key, value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: schema tag %s=%q is not a number", key, value))
+	}
+	return f
+}
+
+func mustInt(key, value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: schema tag %s=%q is not an integer", key, value))
+	}
+	return n
+}
+
+// nullable also admits null, which encoding/json writes for nil pointers.
+func nullable(s *Schema) *Schema {
+	if t, ok := s.Type.(string); ok && s.Ref == "" {
+		s.Type = []string{t, "null"}
+		return s
+	}
+	if s.Ref == "" && s.Type == nil {
+		return s // Already anything
+	}
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
+
+func copySchema(s *Schema) *Schema {
+	if s == nil {
+		return &Schema{}
+	}
+	c := *s
+	return &c
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,284 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Violation is one way a document fails its schema.
+type Violation struct {
+	Path    string `json:"path"` // JSON Pointer to the offending value; "" is the whole document
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// maxViolations caps the list, so a huge invalid body yields a short answer.
+const maxViolations = 20
+
+// Validate checks the JSON document data against s, resolving $refs in
+// g.Schemas. It returns an error only if data is not JSON at all.
+func (g *Generator) Validate(s *Schema, data []byte) ([]Violation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	vc := &validation{defs: g.Schemas}
+	vc.check(s, v, "")
+	return vc.violations, nil
+}
+
+type validation struct {
+	defs       map[string]*Schema
+	violations []Violation
+}
+
+func (vc *validation) fail(path, format string, args ...any) {
+	if len(vc.violations) < maxViolations {
+		vc.violations = append(vc.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// resolve follows s's chain of $refs to a schema without one. It returns
+// nil if a $ref is undefined or the chain does not end, as in a cycle.
+func (vc *validation) resolve(s *Schema) *Schema {
+	for i := 0; s.Ref != ""; i++ {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok || vc.defs[name] == nil || i == 32 {
+			return nil
+		}
+		s = vc.defs[name]
+	}
+	return s
+}
+
+// check validates v at path against s. Keywords next to a $ref apply as
+// well as the referenced schema.
+func (vc *validation) check(s *Schema, v any, path string) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		target := vc.resolve(s)
+		if target == nil {
+			vc.fail(path, "schema %s is not defined", s.Ref)
+			return
+		}
+		n := len(vc.violations)
+		vc.check(target, v, path)
+		if len(vc.violations) > n {
+			return
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		ok := false
+		for _, alt := range s.AnyOf {
+			sub := &validation{defs: vc.defs}
+			sub.check(alt, v, path)
+			if len(sub.violations) == 0 {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			vc.fail(path, "matches none of the allowed forms")
+			return
+		}
+	}
+
+	if types := schemaTypes(s); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+		vc.fail(path, "must be %s, not %s", strings.Join(types, " or "), typeName(v))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return equalJSON(e, v) }) {
+		vc.fail(path, "must be one of %s", enumList(s.Enum))
+	}
+
+	switch v := v.(type) {
+	case string:
+		vc.checkString(s, v, path)
+	case json.Number:
+		vc.checkNumber(s, v, path)
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			vc.fail(path, "must have at least %d item(s)", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			vc.fail(path, "must have at most %d item(s)", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				vc.check(s.Items, item, path+"/"+strconv.Itoa(i))
+			}
+		}
+	case map[string]any:
+		vc.checkObject(s, v, path)
+	}
+}
+
+func (vc *validation) checkString(s *Schema, v, path string) {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		vc.fail(path, "must be at least %d character(s) long", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		vc.fail(path, "must be at most %d character(s) long", *s.MaxLength)
+	}
+	if s.Pattern != "" {
+		re, err := compilePattern(s.Pattern)
+		if err == nil && !re.MatchString(v) {
+			vc.fail(path, "must match %s", s.Pattern)
+		}
+	}
+}
+
+func (vc *validation) checkNumber(s *Schema, v json.Number, path string) {
+	f, err := v.Float64()
+	if err != nil {
+		vc.fail(path, "is out of range")
+		return
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		vc.fail(path, "must be at least %v", *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		vc.fail(path, "must be at most %v", *s.Maximum)
+	}
+}
+
+func (vc *validation) checkObject(s *Schema, v map[string]any, path string) {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			vc.fail(path+"/"+escapePointer(name), "is required")
+		}
+	}
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys) // Report in a stable order
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		if ps, ok := s.Properties[k]; ok {
+			vc.check(ps, v[k], p)
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case bool:
+			if !extra {
+				vc.fail(p, "is not a known field")
+			}
+		case *Schema:
+			vc.check(extra, v[k], p)
+		}
+	}
+}
+
+func schemaTypes(s *Schema) []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		r, ok := new(big.Rat).SetString(n.String())
+		return ok && r.IsInt()
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func equalJSON(a, b any) bool {
+	ja, err1 := json.Marshal(a)
+	jb, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && bytes.Equal(ja, jb)
+}
+
+func enumList(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// escapePointer escapes a key for a JSON Pointer (RFC 6901).
+func escapePointer(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(p); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(p, re)
+	return re, nil
+}
@@ -0,0 +1,167 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOwner struct {
+	Name string `json:"name" schema:"required,pattern=^[a-z]+$"`
+	Age  uint   `json:"age"`
+}
+
+type testRequest struct {
+	Name    string            `json:"name" doc:"Name" schema:"required,minLength=2,maxLength=5"`
+	Count   int               `json:"count" schema:"min=1,max=10"`
+	Ratio   float64           `json:"ratio"`
+	Mode    string            `json:"mode" schema:"enum=fast|slow"`
+	Tags    []string          `json:"tags" schema:"minItems=1,maxItems=2"`
+	Labels  map[string]int    `json:"labels"`
+	Owner   *testOwner        `json:"owner"`
+	Limit   *int              `json:"limit"`
+	Since   time.Time         `json:"since"`
+	Data    []byte            `json:"data"`
+	Any     interface{}       `json:"any"`
+	Nested  []testOwner       `json:"nested"`
+	Quoted  int64             `json:"quoted,string"`
+	Enabled bool              `json:"enabled"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	ignored string
+}
+
+func TestValidate(t *testing.T) {
+	g := NewGenerator()
+	s := g.SchemaOf(testRequest{})
+
+	tests := []struct {
+		name string
+		body string
+		want []string // Violations as path: message
+	}{
+		{"minimal", `{"name":"ab"}`, nil},
+		{"every field", `{"name":"abcde","count":10,"ratio":-1.5,"mode":"slow","tags":["a","b"],
+			"labels":{"x":1},"owner":{"name":"bob","age":3},"limit":null,"since":"2025-01-01T00:00:00Z",
+			"data":"AAE=","any":[1,{"a":null}],"nested":[{"name":"a"}],"quoted":"12","enabled":false,"extra":{}}`, nil},
+		{"null pointers", `{"name":"ab","owner":null,"limit":null}`, nil},
+		{"integer written with an exponent", `{"name":"ab","count":1e1}`, nil},
+		{"integral number with a fraction", `{"name":"ab","count":2.0}`, nil},
+		{"runes, not bytes", `{"name":"äöü"}`, nil},
+
+		{"not an object", `[]`, []string{"must be object, not array"}},
+		{"null document", `null`, []string{"must be object, not null"}},
+		{"missing required", `{}`, []string{"/name: is required"}},
+		{"unknown field", `{"name":"ab","nmae":"x"}`, []string{"/nmae: is not a known field"}},
+		{"unexported field is unknown", `{"name":"ab","ignored":"x"}`, []string{"/ignored: is not a known field"}},
+		{"wrong type", `{"name":5}`, []string{"/name: must be string, not number"}},
+		{"too short", `{"name":"a"}`, []string{"/name: must be at least 2 character(s) long"}},
+		{"too long", `{"name":"abcdef"}`, []string{"/name: must be at most 5 character(s) long"}},
+		{"too long in runes", `{"name":"ääääää"}`, []string{"/name: must be at most 5 character(s) long"}},
+		{"below minimum", `{"name":"ab","count":0}`, []string{"/count: must be at least 1"}},
+		{"above maximum", `{"name":"ab","count":11}`, []string{"/count: must be at most 10"}},
+		{"fraction for an integer", `{"name":"ab","count":1.5}`, []string{"/count: must be integer, not number"}},
+		{"number out of range", `{"name":"ab","ratio":1e400}`, []string{"/ratio: is out of range"}},
+		{"string for a number", `{"name":"ab","ratio":"1"}`, []string{"/ratio: must be number, not string"}},
+		{"not in enum", `{"name":"ab","mode":"medium"}`, []string{`/mode: must be one of "fast", "slow"`}},
+		{"too few items", `{"name":"ab","tags":[]}`, []string{"/tags: must have at least 1 item(s)"}},
+		{"too many items", `{"name":"ab","tags":["a","b","c"]}`, []string{"/tags: must have at most 2 item(s)"}},
+		{"wrong item", `{"name":"ab","tags":["a",true]}`, []string{"/tags/1: must be string, not boolean"}},
+		{"wrong map value", `{"name":"ab","labels":{"a/b~":"x"}}`, []string{"/labels/a~1b~0: must be integer, not string"}},
+		{"nested object", `{"name":"ab","owner":{"name":"Bob","age":-1}}`,
+			[]string{"/owner: matches none of the allowed forms"}},
+		{"nested array of objects", `{"name":"ab","nested":[{"name":"a"},{"name":"B","extra":1},{}]}`,
+			[]string{"/nested/1/extra: is not a known field", "/nested/1/name: must match ^[a-z]+$", "/nested/2/name: is required"}},
+		{"negative unsigned", `{"name":"ab","nested":[{"name":"a","age":-1}]}`, []string{"/nested/0/age: must be at least 0"}},
+		{"nullable integer", `{"name":"ab","limit":"x"}`, []string{"/limit: must be integer or null, not string"}},
+		{"null for a non-pointer", `{"name":"ab","count":null}`, []string{"/count: must be integer, not null"}},
+		{"string option", `{"name":"ab","quoted":12}`, []string{"/quoted: must be string, not number"}},
+		{"several at once", `{"count":0,"mode":"x","zzz":1}`,
+			[]string{"/name: is required", "/count: must be at least 1", `/mode: must be one of "fast", "slow"`, "/zzz: is not a known field"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := g.Validate(s, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNotJSON(t *testing.T) {
+	g := NewGenerator()
+	s := g.SchemaOf(testOwner{})
+	for _, body := range []string{``, `{`, `{"name":"a"} {}`, `{"name":"a"}x`, `nope`} {
+		if _, err := g.Validate(s, []byte(body)); err == nil {
+			t.Errorf("Validate(%q) succeeded", body)
+		}
+	}
+}
+
+func TestValidateLimit(t *testing.T) {
+	g := NewGenerator()
+	s := g.SchemaOf(testOwner{})
+	var fields []string
+	for i := range 50 {
+		fields = append(fields, fmt.Sprintf(`"f%02d":1`, i))
+	}
+	violations, err := g.Validate(s, []byte("{"+strings.Join(fields, ",")+"}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != maxViolations || violations[0].Path != "/name" {
+		t.Errorf("got %d violations starting with %v, want %d starting with /name", len(violations), violations[0], maxViolations)
+	}
+}
+
+func TestValidateRefs(t *testing.T) {
+	g := NewGenerator()
+	g.Schemas["Short"] = &Schema{Type: "string", MaxLength: ptr(3)}
+	g.Schemas["Alias"] = &Schema{Ref: "#/components/schemas/Short"}
+	g.Schemas["Loop"] = &Schema{Ref: "#/components/schemas/Loop"}
+
+	tests := []struct {
+		name   string
+		schema *Schema
+		body   string
+		want   []string
+	}{
+		{"ref", &Schema{Ref: "#/components/schemas/Short"}, `"abc"`, nil},
+		{"ref violated", &Schema{Ref: "#/components/schemas/Short"}, `"abcd"`, []string{"must be at most 3 character(s) long"}},
+		{"ref to ref", &Schema{Ref: "#/components/schemas/Alias"}, `7`, []string{"must be string, not number"}},
+		{"keywords next to a ref", &Schema{Ref: "#/components/schemas/Short", Pattern: "^a"}, `"bc"`, []string{"must match ^a"}},
+		{"undefined ref", &Schema{Ref: "#/components/schemas/Nope"}, `1`, []string{"schema #/components/schemas/Nope is not defined"}},
+		{"foreign ref", &Schema{Ref: "other.json#/Short"}, `1`, []string{"schema other.json#/Short is not defined"}},
+		{"ref loop", &Schema{Ref: "#/components/schemas/Loop"}, `1`, []string{"schema #/components/schemas/Loop is not defined"}},
+		{"any of", &Schema{AnyOf: []*Schema{{Type: "integer"}, {Ref: "#/components/schemas/Short"}}}, `"ab"`, nil},
+		{"any of, none", &Schema{AnyOf: []*Schema{{Type: "integer"}, {Ref: "#/components/schemas/Short"}}}, `"abcd"`,
+			[]string{"matches none of the allowed forms"}},
+		{"empty schema", &Schema{}, `{"a":[null]}`, nil},
+		{"numeric enum", &Schema{Enum: []any{1, "1"}}, `1`, nil},
+		{"numeric enum, no match", &Schema{Enum: []any{1, "1"}}, `2`, []string{`must be one of 1, "1"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := g.Validate(tt.schema, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
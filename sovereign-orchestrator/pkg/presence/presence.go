@@ -0,0 +1,148 @@
+// Package presence decides whether a human is currently at the machine.
+// It combines independent signals (tmux clients, login sessions, recent GUI
+// requests) and smooths the result so Ghost Mode does not flap between
+// paused and autonomous on a single noisy reading.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signal is one source of presence evidence.
+type Signal interface {
+	Name() string
+	// Check reports whether the signal currently sees a user, with a short
+	// human-readable reason either way.
+	Check(ctx context.Context) (present bool, reason string, err error)
+}
+
+// SignalResult is the outcome of one signal during the last check.
+type SignalResult struct {
+	Name    string `json:"name"`
+	Present bool   `json:"present"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error,omitempty"`
+}
+
+// State is the detector's smoothed view of presence.
+type State struct {
+	Attached  bool           `json:"attached"`
+	Reason    string         `json:"reason"`
+	Since     time.Time      `json:"since"`      // When Attached last changed
+	Raw       bool           `json:"raw"`        // Unsmoothed result of the last check
+	Pending   bool           `json:"pending"`    // Raw disagrees with Attached and is waiting out a delay
+	CheckedAt time.Time      `json:"checked_at"` // Zero until the first check
+	Signals   []SignalResult `json:"signals"`
+}
+
+// Detector combines signals: the user is present if any signal says so.
+//
+// AttachAfter debounces arrival: a positive reading must persist that long
+// before the state flips to attached. DetachAfter is the hysteresis on the
+// way out: the state stays attached until no signal has fired for that long,
+// so a brief gap (a tmux reattach, a page reload) does not resume autonomy.
+type Detector struct {
+	signals     []Signal
+	attachAfter time.Duration
+	detachAfter time.Duration
+	now         func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	rawSince    time.Time // Start of the current run of identical raw readings
+	lastPresent time.Time // Last time any signal fired
+	presentWhy  string    // Reason from the most recent positive reading
+	checkedOnce bool
+}
+
+// NewDetector creates a detector over signals with the given smoothing delays.
+func NewDetector(signals []Signal, attachAfter, detachAfter time.Duration) *Detector {
+	return &Detector{
+		signals:     signals,
+		attachAfter: attachAfter,
+		detachAfter: detachAfter,
+		now:         time.Now,
+		state:       State{Reason: "not checked yet", Signals: []SignalResult{}},
+	}
+}
+
+// Check polls every signal and returns the updated smoothed state.
+// A failing signal counts as "not present" and its error is reported.
+func (d *Detector) Check(ctx context.Context) State {
+	results := make([]SignalResult, 0, len(d.signals))
+	raw := false
+	var reasons []string
+	for _, s := range d.signals {
+		present, reason, err := s.Check(ctx)
+		res := SignalResult{Name: s.Name(), Present: present && err == nil, Reason: reason}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		if res.Present {
+			raw = true
+			reasons = append(reasons, fmt.Sprintf("%s: %s", res.Name, reason))
+		}
+		results = append(results, res)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if !d.checkedOnce || raw != d.state.Raw {
+		d.rawSince = now
+	}
+	if raw {
+		d.lastPresent = now
+		d.presentWhy = strings.Join(reasons, "; ")
+	}
+
+	switch {
+	case !d.checkedOnce:
+		// The first reading is taken at face value; there is no history to smooth.
+		d.setAttached(raw, now)
+	case raw && !d.state.Attached && now.Sub(d.rawSince) >= d.attachAfter:
+		d.setAttached(true, now)
+	case !raw && d.state.Attached && now.Sub(d.lastPresent) >= d.detachAfter:
+		d.setAttached(false, now)
+	}
+
+	switch {
+	case d.state.Attached && raw:
+		d.state.Reason = d.presentWhy
+	case d.state.Attached:
+		d.state.Reason = fmt.Sprintf("holding: last seen %s ago (%s)", now.Sub(d.lastPresent).Round(time.Second), d.presentWhy)
+	case raw:
+		d.state.Reason = fmt.Sprintf("debouncing: %s", d.presentWhy)
+	default:
+		d.state.Reason = "no presence signal"
+	}
+
+	d.checkedOnce = true
+	d.state.Raw = raw
+	d.state.Pending = raw != d.state.Attached
+	d.state.CheckedAt = now
+	d.state.Signals = results
+	return d.state
+}
+
+func (d *Detector) setAttached(attached bool, now time.Time) {
+	if d.checkedOnce && d.state.Attached == attached {
+		return
+	}
+	d.state.Attached = attached
+	d.state.Since = now
+}
+
+// State returns the result of the last Check without polling.
+func (d *Detector) State() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := d.state
+	st.Signals = append([]SignalResult(nil), d.state.Signals...)
+	return st
+}
@@ -0,0 +1,159 @@
+package presence
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSignal reports whatever present holds.
+type fakeSignal struct {
+	present bool
+	err     error
+}
+
+func (f *fakeSignal) Name() string { return "fake" }
+
+func (f *fakeSignal) Check(ctx context.Context) (bool, string, error) {
+	return f.present, "fake reading", f.err
+}
+
+func TestDetectorSmoothing(t *testing.T) {
+	sig := &fakeSignal{}
+	d := NewDetector([]Signal{sig}, 5*time.Second, time.Minute)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	steps := []struct {
+		after    time.Duration
+		present  bool
+		attached bool
+		pending  bool
+		reason   string
+	}{
+		{0, false, false, false, "no presence signal"},
+		{time.Second, true, false, true, "debouncing"},
+		{4 * time.Second, true, false, true, "debouncing"},
+		{time.Second, true, true, false, "fake: fake reading"},
+		// A gap shorter than DetachAfter holds the attached state.
+		{10 * time.Second, false, true, true, "holding: last seen 10s ago"},
+		{40 * time.Second, false, true, true, "holding: last seen 50s ago"},
+		// A reading in between restarts the hold.
+		{5 * time.Second, true, true, false, "fake"},
+		{59 * time.Second, false, true, true, "holding"},
+		{time.Second, false, false, false, "no presence signal"},
+		// Arrival is debounced again from the first positive reading.
+		{time.Second, true, false, true, "debouncing"},
+		{time.Second, false, false, false, "no presence signal"},
+		{10 * time.Second, true, false, true, "debouncing"},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		sig.present = step.present
+		st := d.Check(context.Background())
+		if st.Attached != step.attached || st.Pending != step.pending || st.Raw != step.present ||
+			!strings.HasPrefix(st.Reason, step.reason) {
+			t.Errorf("step %d: attached=%t pending=%t raw=%t reason %q, want attached=%t pending=%t reason %q...",
+				i, st.Attached, st.Pending, st.Raw, st.Reason, step.attached, step.pending, step.reason)
+		}
+	}
+}
+
+func TestDetectorFirstReading(t *testing.T) {
+	d := NewDetector([]Signal{&fakeSignal{present: true}}, time.Hour, time.Hour)
+	if st := d.State(); st.Attached || !st.CheckedAt.IsZero() {
+		t.Errorf("state before the first check: %+v", st)
+	}
+	if st := d.Check(context.Background()); !st.Attached {
+		t.Errorf("the first reading was debounced: %+v", st)
+	}
+}
+
+func TestDetectorFailingSignal(t *testing.T) {
+	d := NewDetector([]Signal{&fakeSignal{present: true, err: errors.New("boom")}, &fakeSignal{}}, 0, 0)
+	st := d.Check(context.Background())
+	if st.Attached || len(st.Signals) != 2 || st.Signals[0].Present || st.Signals[0].Error != "boom" {
+		t.Errorf("a failing signal counted as present: %+v", st)
+	}
+}
+
+// utmpRecord encodes one USER_PROCESS record.
+func utmpRecord(pid int, line, user string) []byte {
+	rec := make([]byte, utmpRecordSize)
+	binary.LittleEndian.PutUint16(rec[0:2], utmpUserProc)
+	binary.LittleEndian.PutUint32(rec[utmpOffPID:], uint32(pid))
+	copy(rec[utmpOffLine:utmpOffLine+utmpLenLine], line)
+	copy(rec[utmpOffUser:utmpOffUser+utmpLenUser], user)
+	return rec
+}
+
+func TestUtmpIdle(t *testing.T) {
+	dir := t.TempDir()
+	devDir = dir
+	defer func() { devDir = "/dev" }()
+	for name, age := range map[string]time.Duration{"pts/1": time.Minute, "pts/2": time.Hour} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().Add(-age)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pid := os.Getpid()
+	var data []byte
+	data = append(data, utmpRecord(pid, "pts/1", "active")...)
+	data = append(data, utmpRecord(pid, "pts/2", "idle")...)
+	data = append(data, utmpRecord(pid, ":0", "desktop")...)      // No tty: always counts
+	data = append(data, utmpRecord(1<<22+1, "pts/1", "stale")...) // Dead PID
+	data = append(data, make([]byte, 10)...)                      // Partial record
+	path := filepath.Join(dir, "utmp")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxIdle time.Duration
+		want    string
+	}{
+		{0, "3 session(s): active@pts/1, idle@pts/2, desktop@:0"},
+		{10 * time.Minute, "2 session(s): active@pts/1, desktop@:0"},
+		{10 * time.Second, "1 session(s): desktop@:0"},
+	}
+	for _, tt := range tests {
+		present, reason, err := (&Utmp{Path: path, MaxIdle: tt.maxIdle}).Check(context.Background())
+		if err != nil || !present || reason != tt.want {
+			t.Errorf("MaxIdle %v: %t, %q, %v; want %q", tt.maxIdle, present, reason, err, tt.want)
+		}
+	}
+
+	present, reason, err := (&Utmp{Path: filepath.Join(dir, "missing")}).Check(context.Background())
+	if err != nil || present || !strings.HasSuffix(reason, "does not exist") {
+		t.Errorf("missing utmp: %t, %q, %v", present, reason, err)
+	}
+}
+
+func TestActivityWindow(t *testing.T) {
+	a := NewActivity(time.Minute)
+	if present, _, _ := a.Check(context.Background()); present {
+		t.Errorf("present before any request")
+	}
+	a.Touch("GET /")
+	if present, reason, _ := a.Check(context.Background()); !present || !strings.Contains(reason, "GET /") {
+		t.Errorf("not present right after a request: %q", reason)
+	}
+	a.last = time.Now().Add(-2 * time.Minute)
+	if present, _, _ := a.Check(context.Background()); present {
+		t.Errorf("present after the window")
+	}
+}
@@ -0,0 +1,214 @@
+package presence
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tmux reports presence when a client is attached to the tmux server on Socket.
+// This is the check the original yolo_sovereign.py used.
+type Tmux struct {
+	Command string // tmux binary, default "tmux"
+	Socket  string // Passed as -S; empty uses the default server
+}
+
+// Name implements Signal.
+func (t *Tmux) Name() string { return "tmux" }
+
+// Check implements Signal. A missing socket or a server with no sessions is
+// "not present" rather than an error.
+func (t *Tmux) Check(ctx context.Context) (bool, string, error) {
+	if t.Socket != "" {
+		if _, err := os.Stat(t.Socket); errors.Is(err, fs.ErrNotExist) {
+			return false, "socket " + t.Socket + " does not exist", nil
+		}
+	}
+	command := t.Command
+	if command == "" {
+		command = "tmux"
+	}
+	var args []string
+	if t.Socket != "" {
+		args = append(args, "-S", t.Socket)
+	}
+	args = append(args, "list-clients", "-F", "#{client_tty}")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// tmux exits non-zero when no server is running on the socket.
+			return false, "no tmux server: " + strings.TrimSpace(stderr.String()), nil
+		}
+		return false, "", fmt.Errorf("failed to run %s: %w", command, err)
+	}
+
+	clients := strings.Fields(stdout.String())
+	if len(clients) == 0 {
+		return false, "no tmux clients attached", nil
+	}
+	return true, fmt.Sprintf("%d client(s) attached (%s)", len(clients), strings.Join(clients, ", ")), nil
+}
+
+// Layout of a glibc utmp record on Linux (struct utmp, 384 bytes). The time
+// fields are 32-bit on every glibc target for compatibility with 32-bit binaries.
+const (
+	utmpRecordSize = 384
+	utmpUserProc   = 7 // USER_PROCESS
+	utmpOffPID     = 4
+	utmpOffLine    = 8
+	utmpLenLine    = 32
+	utmpOffUser    = 44
+	utmpLenUser    = 32
+	utmpOffHost    = 76
+	utmpLenHost    = 256
+)
+
+// Utmp reports presence when a live login session is recorded in the utmp
+// file, as written by login, sshd and display managers via logind.
+type Utmp struct {
+	Path string // Default /var/run/utmp
+	// MaxIdle ignores sessions whose terminal has not been written to for
+	// longer than this (see ttyIdle). Zero disables the idle check.
+	MaxIdle time.Duration
+}
+
+// utmpSession is one USER_PROCESS entry.
+type utmpSession struct {
+	PID  int32
+	Line string
+	User string
+	Host string
+}
+
+// Name implements Signal.
+func (u *Utmp) Name() string { return "utmp" }
+
+// Check implements Signal.
+func (u *Utmp) Check(ctx context.Context) (bool, string, error) {
+	path := u.Path
+	if path == "" {
+		path = "/var/run/utmp"
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, path + " does not exist", nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var active []string
+	for _, s := range parseUtmp(data) {
+		// Crashed sessions can leave stale entries behind; trust only live PIDs.
+		if _, err := os.Stat(filepath.Join("/proc", fmt.Sprint(s.PID))); err != nil {
+			continue
+		}
+		// Graphical sessions (":0") have no tty to stat and always count.
+		if u.MaxIdle > 0 {
+			if idle, ok := ttyIdle(s.Line); ok && idle > u.MaxIdle {
+				continue
+			}
+		}
+		desc := s.User + "@" + s.Line
+		if s.Host != "" {
+			desc += " from " + s.Host
+		}
+		active = append(active, desc)
+	}
+	if len(active) == 0 {
+		return false, "no active login sessions", nil
+	}
+	return true, fmt.Sprintf("%d session(s): %s", len(active), strings.Join(active, ", ")), nil
+}
+
+// parseUtmp decodes the USER_PROCESS records in a utmp file. A trailing
+// partial record is ignored.
+func parseUtmp(data []byte) []utmpSession {
+	var sessions []utmpSession
+	for off := 0; off+utmpRecordSize <= len(data); off += utmpRecordSize {
+		rec := data[off : off+utmpRecordSize]
+		if int16(binary.LittleEndian.Uint16(rec[0:2])) != utmpUserProc {
+			continue
+		}
+		sessions = append(sessions, utmpSession{
+			PID:  int32(binary.LittleEndian.Uint32(rec[utmpOffPID : utmpOffPID+4])),
+			Line: cString(rec[utmpOffLine : utmpOffLine+utmpLenLine]),
+			User: cString(rec[utmpOffUser : utmpOffUser+utmpLenUser]),
+			Host: cString(rec[utmpOffHost : utmpOffHost+utmpLenHost]),
+		})
+	}
+	return sessions
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// devDir holds the terminal devices named by utmp lines.
+var devDir = "/dev"
+
+// ttyIdle returns how long ago the terminal's device was last written to,
+// which is when the session last printed anything, including the echo of
+// what the user typed. An idle shell does not write, so its age grows.
+func ttyIdle(line string) (time.Duration, bool) {
+	info, err := os.Stat(filepath.Join(devDir, line))
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(info.ModTime()), true
+}
+
+// Activity reports presence when the GUI has made a request within Window.
+// The HTTP layer calls Touch for every request that represents the user.
+type Activity struct {
+	Window time.Duration
+
+	mu     sync.Mutex
+	last   time.Time
+	source string
+}
+
+// NewActivity creates an activity signal with the given window.
+func NewActivity(window time.Duration) *Activity {
+	return &Activity{Window: window}
+}
+
+// Touch records a user request. source describes it for status reports.
+func (a *Activity) Touch(source string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last = time.Now()
+	a.source = source
+}
+
+// Name implements Signal.
+func (a *Activity) Name() string { return "http" }
+
+// Check implements Signal.
+func (a *Activity) Check(ctx context.Context) (bool, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.last.IsZero() {
+		return false, "no GUI requests yet", nil
+	}
+	ago := time.Since(a.last)
+	reason := fmt.Sprintf("last request %s ago (%s)", ago.Round(time.Second), a.source)
+	return ago <= a.Window, reason, nil
+}
@@ -0,0 +1,448 @@
+// Package runtimedir extracts embedded runtime files (scripts and a tar.gz
+// bundle) into a directory on disk and tracks them with a SHA-256 manifest,
+// so extraction only happens again when the embedded content changes.
+package runtimedir
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"sovereign-orchestrator/pkg/fsutil"
+)
+
+// ManifestName is the manifest file written at the root of the runtime directory.
+const ManifestName = ".manifest.json"
+
+// FileEntry records an extracted file.
+type FileEntry struct {
+	SHA256 string      `json:"sha256"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size"`
+}
+
+// Manifest describes the content of an extracted runtime directory.
+type Manifest struct {
+	SourceHash  string               `json:"source_hash"` // Hash of the embedded inputs
+	ExtractedAt time.Time            `json:"extracted_at"`
+	Files       map[string]FileEntry `json:"files"` // Keyed by slash-separated relative path
+}
+
+// Problem is a single verification failure.
+type Problem struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Reason
+}
+
+// Manager extracts runtime content from an embedded file system.
+type Manager struct {
+	Dir     string // Target directory, e.g. ~/.sovereign/runtime
+	FS      fs.FS  // Source of the embedded files
+	Scripts string // Glob of scripts copied to Dir/scripts with mode 0755
+	Archive string // Path of a tar.gz archive unpacked into Dir; may be empty
+}
+
+// Ensure extracts the runtime if it has never been extracted or was produced
+// from different embedded content. Local edits to an up-to-date runtime are
+// left alone; Verify reports them. It reports whether it extracted.
+func (m *Manager) Ensure() (bool, error) {
+	sourceHash, err := m.sourceHash()
+	if err != nil {
+		return false, err
+	}
+	manifest, err := m.ReadManifest()
+	if err == nil && manifest.SourceHash == sourceHash {
+		return false, nil
+	}
+	return true, m.Extract()
+}
+
+// Extract unconditionally unpacks the runtime into a staging directory and
+// swaps it into place, so a failed extraction leaves the previous runtime intact.
+func (m *Manager) Extract() error {
+	sourceHash, err := m.sourceHash()
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Dir(m.Dir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(parent, filepath.Base(m.Dir)+".staging-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+
+	manifest := &Manifest{SourceHash: sourceHash, ExtractedAt: time.Now().UTC(), Files: make(map[string]FileEntry)}
+	if m.Archive != "" {
+		if err := m.extractArchive(staging, manifest); err != nil {
+			return err
+		}
+	}
+	if m.Scripts != "" {
+		if err := m.copyScripts(staging, manifest); err != nil {
+			return err
+		}
+	}
+	if err := writeManifest(staging, manifest); err != nil {
+		return err
+	}
+
+	old := m.Dir + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(m.Dir, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move previous runtime aside: %w", err)
+	}
+	if err := os.Rename(staging, m.Dir); err != nil {
+		os.Rename(old, m.Dir)
+		return fmt.Errorf("failed to install runtime: %w", err)
+	}
+	return os.RemoveAll(old)
+}
+
+// Verify compares the extracted files against the manifest and the manifest
+// against the embedded content.
+func (m *Manager) Verify() ([]Problem, error) {
+	manifest, err := m.ReadManifest()
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Problem{{Path: ManifestName, Reason: "runtime has not been extracted"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := m.verifyFiles(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sourceHash, err := m.sourceHash()
+	if err != nil {
+		return nil, err
+	}
+	if manifest.SourceHash != sourceHash {
+		problems = append(problems, Problem{Path: ManifestName, Reason: "extracted from different embedded content; re-extraction required"})
+	}
+	return problems, nil
+}
+
+// ReadManifest loads the manifest of the extracted runtime.
+func (m *Manager) ReadManifest() (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(m.Dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid runtime manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func (m *Manager) verifyFiles(manifest *Manifest) ([]Problem, error) {
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+	for _, name := range names {
+		want := manifest.Files[name]
+		p := filepath.Join(m.Dir, filepath.FromSlash(name))
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, Problem{Path: name, Reason: "missing"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			problems = append(problems, Problem{Path: name, Reason: "not a regular file"})
+			continue
+		}
+		if info.Mode().Perm() != want.Mode.Perm() {
+			problems = append(problems, Problem{Path: name, Reason: fmt.Sprintf("mode %v, expected %v", info.Mode().Perm(), want.Mode.Perm())})
+		}
+		sum, err := fsutil.FileSHA256(p)
+		if err != nil {
+			return nil, err
+		}
+		if sum != want.SHA256 {
+			problems = append(problems, Problem{Path: name, Reason: "content modified"})
+		}
+	}
+	return problems, nil
+}
+
+// sourceHash hashes the names and contents of every embedded input.
+func (m *Manager) sourceHash() (string, error) {
+	var inputs []string
+	if m.Scripts != "" {
+		scripts, err := fs.Glob(m.FS, m.Scripts)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, scripts...)
+	}
+	if m.Archive != "" {
+		inputs = append(inputs, m.Archive)
+	}
+	sort.Strings(inputs)
+
+	h := sha256.New()
+	for _, name := range inputs {
+		f, err := m.FS.Open(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (m *Manager) copyScripts(dir string, manifest *Manifest) error {
+	scripts, err := fs.Glob(m.FS, m.Scripts)
+	if err != nil {
+		return err
+	}
+	for _, name := range scripts {
+		src, err := m.FS.Open(name)
+		if err != nil {
+			return err
+		}
+		rel := path.Join("scripts", path.Base(name))
+		err = writeFile(dir, rel, src, 0755, manifest)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) extractArchive(dir string, manifest *Manifest) error {
+	f, err := m.FS.Open(m.Archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil // Placeholder bundle; nothing to unpack
+	}
+	return ExtractTarGz(f, dir, manifest)
+}
+
+// ExtractTarGz unpacks a gzip-compressed tar stream into dir, recording regular
+// files in manifest (which may be nil). Entries that would land outside dir,
+// whether by absolute path, ".." components or link targets, are rejected.
+// Nothing is ever written through a symlink: entries below one, regular
+// files in place of one and hard links to one are refused, so links that
+// point elsewhere on disk cannot redirect writes.
+func ExtractTarGz(r io.Reader, dir string, manifest *Manifest) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if name == "." {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("tar entry %q escapes the target directory", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := checkNoSymlinkParents(dir, name); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(dir, name, tr, hdr.FileInfo().Mode().Perm(), manifest); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkLinkTarget(name, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkName := strings.TrimPrefix(path.Clean(hdr.Linkname), "./")
+			if !filepath.IsLocal(filepath.FromSlash(linkName)) {
+				return fmt.Errorf("tar hard link %q -> %q escapes the target directory", hdr.Name, hdr.Linkname)
+			}
+			if err := checkNoSymlinkParents(dir, linkName); err != nil {
+				return err
+			}
+			srcPath := filepath.Join(dir, filepath.FromSlash(linkName))
+			if info, err := os.Lstat(srcPath); err == nil && !info.Mode().IsRegular() {
+				return fmt.Errorf("tar hard link %q -> %q does not point to a regular file", hdr.Name, hdr.Linkname)
+			}
+			src, err := os.OpenFile(srcPath, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+			if err != nil {
+				return fmt.Errorf("tar hard link %q: %w", hdr.Name, err)
+			}
+			err = writeFile(dir, name, src, hdr.FileInfo().Mode().Perm(), manifest)
+			src.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			// Devices, FIFOs and other special files have no place in the runtime.
+			return fmt.Errorf("tar entry %q has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// checkLinkTarget rejects a symlink at name whose target leaves the target
+// directory. ".." is only allowed before the first named component: those
+// steps climb the real directories holding the link, which
+// checkNoSymlinkParents has verified, while a ".." after a named component
+// would climb out of wherever that component resolves to on disk, which a
+// symlink, present or created later, can place anywhere.
+func checkLinkTarget(name, linkname string) error {
+	if path.IsAbs(linkname) {
+		return fmt.Errorf("tar symlink %q -> %q escapes the target directory", name, linkname)
+	}
+	depth := strings.Count(name, "/") // Directories between dir and the link
+	named := false
+	for _, part := range strings.Split(linkname, "/") {
+		switch {
+		case part == "" || part == ".":
+		case part == "..":
+			if named {
+				return fmt.Errorf("tar symlink %q -> %q climbs out of a named directory", name, linkname)
+			}
+			if depth--; depth < 0 {
+				return fmt.Errorf("tar symlink %q -> %q escapes the target directory", name, linkname)
+			}
+		default:
+			named = true
+		}
+	}
+	return nil
+}
+
+// checkNoSymlinkParents rejects entries whose parent directories are symlinks
+// created by earlier entries, which could otherwise redirect writes elsewhere.
+// Each component is checked on disk with Lstat.
+func checkNoSymlinkParents(dir, name string) error {
+	parts := strings.Split(path.Dir(name), "/")
+	current := dir
+	for _, part := range parts {
+		if part == "." || part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("entry %q is nested under symlink %q", name, part)
+		}
+	}
+	return nil
+}
+
+// writeFile writes r to rel below dir. It refuses to write through a
+// symlink, whether in place of the file or of one of its parents.
+func writeFile(dir, rel string, r io.Reader, mode fs.FileMode, manifest *Manifest) error {
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := checkNoSymlinkParents(dir, rel); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to write %s through a symlink", rel)
+	}
+	// O_NOFOLLOW also catches a symlink created since the Lstat.
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		// OpenFile's mode is subject to the umask; set it explicitly, on
+		// the open file rather than by name.
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", rel, err)
+	}
+	if manifest != nil {
+		manifest.Files[rel] = FileEntry{SHA256: hex.EncodeToString(h.Sum(nil)), Mode: mode, Size: n}
+	}
+	return nil
+}
+
+func writeManifest(dir string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestName), data, 0644)
+}
@@ -0,0 +1,228 @@
+package runtimedir
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// entry is one member of a test archive.
+type entry struct {
+	name, link, body string
+	typ              byte
+}
+
+func dirEntry(name string) entry {
+	return entry{name: name, typ: tar.TypeDir}
+}
+
+func fileEntry(name, body string) entry {
+	return entry{name: name, body: body, typ: tar.TypeReg}
+}
+
+func symlinkEntry(name, link string) entry {
+	return entry{name: name, link: link, typ: tar.TypeSymlink}
+}
+
+func hardlinkEntry(name, link string) entry {
+	return entry{name: name, link: link, typ: tar.TypeLink}
+}
+
+func tarGz(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0644, Size: int64(len(e.body))}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// listOutside returns the files next to the target directory, where a
+// malicious archive would try to write.
+func listOutside(t *testing.T, parent string) []string {
+	t.Helper()
+	var names []string
+	filepath.WalkDir(parent, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(parent, p)
+		if rel == "root" {
+			return filepath.SkipDir
+		}
+		if rel != "." {
+			names = append(names, rel)
+		}
+		return nil
+	})
+	return names
+}
+
+func TestExtractTarGzMalicious(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"absolute path", []entry{fileEntry("/x", "pwned")}},
+		{"dot-dot path", []entry{fileEntry("a/../../x", "pwned")}},
+		{"absolute symlink", []entry{symlinkEntry("l", "/tmp")}},
+		{"symlink out of the root", []entry{symlinkEntry("l", "..")}},
+		{"symlink out of a subdirectory", []entry{symlinkEntry("d/l", "../../x")}},
+		{"chain of symlinks", []entry{
+			symlinkEntry("d/l", ".."),
+			symlinkEntry("q", "d/l/../x"),
+			fileEntry("q", "pwned"),
+		}},
+		{"chain of symlinks, link first", []entry{
+			symlinkEntry("q", "d/l/../x"),
+			symlinkEntry("d/l", ".."),
+			fileEntry("q", "pwned"),
+		}},
+		{"file under a symlink", []entry{
+			dirEntry("sub"),
+			symlinkEntry("s", "sub"),
+			fileEntry("s/f", "redirected"),
+		}},
+		{"directory under a symlink", []entry{
+			dirEntry("sub"),
+			symlinkEntry("s", "sub"),
+			dirEntry("s/d"),
+		}},
+		{"file over a symlink", []entry{
+			fileEntry("f", "original"),
+			symlinkEntry("l", "f"),
+			fileEntry("l", "redirected"),
+		}},
+		{"hard link to a symlink", []entry{
+			fileEntry("f", "original"),
+			symlinkEntry("l", "f"),
+			hardlinkEntry("h", "l"),
+		}},
+		{"hard link out of the root", []entry{hardlinkEntry("h", "../x")}},
+		{"hard link to a missing file", []entry{hardlinkEntry("h", "nope")}},
+		{"hard link through a symlink", []entry{
+			dirEntry("sub"),
+			fileEntry("sub/f", "original"),
+			symlinkEntry("s", "sub"),
+			hardlinkEntry("h", "s/f"),
+		}},
+		{"device", []entry{{name: "dev", typ: tar.TypeChar}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "root")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			data := tarGz(t, tt.entries...)
+			if err := ExtractTarGz(bytes.NewReader(data), dir, nil); err == nil {
+				t.Errorf("extraction succeeded")
+			}
+			if outside := listOutside(t, parent); len(outside) > 0 {
+				t.Errorf("wrote outside the target directory: %v", outside)
+			}
+			// Nothing may have been written through a link either.
+			filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && d.Type().IsRegular() {
+					if b, _ := os.ReadFile(p); string(b) != "original" {
+						t.Errorf("%s holds %q", p, b)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	data := tarGz(t,
+		dirEntry("./bin/"),
+		fileEntry("lib/python3.11/site.py", "print()"),
+		symlinkEntry("lib64", "lib"),
+		symlinkEntry("bin/python", "../lib64/python3.11/site.py"),
+		hardlinkEntry("bin/site.py", "lib/python3.11/site.py"),
+		dirEntry("."),
+	)
+	manifest := &Manifest{Files: map[string]FileEntry{}}
+	if err := ExtractTarGz(bytes.NewReader(data), dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bin/python", "bin/site.py", "lib/python3.11/site.py"} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != "print()" {
+			t.Errorf("%s: %q, %v", name, b, err)
+		}
+	}
+	if len(manifest.Files) != 2 || manifest.Files["bin/site.py"].Size != 7 || manifest.Files["lib/python3.11/site.py"].SHA256 == "" {
+		t.Errorf("unexpected manifest %+v", manifest.Files)
+	}
+}
+
+func TestExtractScriptsNotThroughSymlink(t *testing.T) {
+	parent := t.TempDir()
+	m := &Manager{
+		Dir: filepath.Join(parent, "root"),
+		FS: fstest.MapFS{
+			"bundle.tar.gz": {Data: tarGz(t, dirEntry("sub"), symlinkEntry("scripts", "sub"))},
+			"x.sh":          {Data: []byte("#!/bin/sh\n")},
+		},
+		Scripts: "*.sh",
+		Archive: "bundle.tar.gz",
+	}
+	err := m.Extract()
+	if err == nil || !strings.Contains(err.Error(), "symlink") {
+		t.Errorf("got %v, want a refusal to write through the symlink", err)
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "root")); err == nil {
+		t.Errorf("a failed extraction was installed")
+	}
+}
+
+func TestEnsureAndVerify(t *testing.T) {
+	m := &Manager{
+		Dir:     filepath.Join(t.TempDir(), "root"),
+		FS:      fstest.MapFS{"a.sh": {Data: []byte("echo a\n")}},
+		Scripts: "*.sh",
+	}
+	if extracted, err := m.Ensure(); err != nil || !extracted {
+		t.Fatalf("first Ensure = %v, %v", extracted, err)
+	}
+	if extracted, err := m.Ensure(); err != nil || extracted {
+		t.Fatalf("second Ensure = %v, %v", extracted, err)
+	}
+	if problems, err := m.Verify(); err != nil || len(problems) != 0 {
+		t.Fatalf("Verify = %v, %v", problems, err)
+	}
+	script := filepath.Join(m.Dir, "scripts", "a.sh")
+	if err := os.WriteFile(script, []byte("echo b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := m.Verify()
+	if err != nil || len(problems) != 1 || problems[0].String() != "scripts/a.sh: content modified" {
+		t.Errorf("Verify after an edit = %v, %v", problems, err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package schedule computes how long the Ghost Mode loop sleeps between
+// cycles: exponential backoff while the machine is busy, random jitter so
+// wakeups do not line up with other periodic jobs, and quiet hours during
+// which the loop stays asleep.
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// QuietHours is a daily local-time window, e.g. 22:00-07:00. A window whose
+// end is before its start wraps past midnight. The zero value is disabled.
+type QuietHours struct {
+	Start   time.Duration // Offset from local midnight
+	End     time.Duration
+	Enabled bool
+}
+
+// ParseQuietHours parses "HH:MM" bounds. Two empty strings disable quiet hours.
+func ParseQuietHours(start, end string) (QuietHours, error) {
+	if start == "" && end == "" {
+		return QuietHours{}, nil
+	}
+	s, err := parseClock(start)
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours start: %w", err)
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours end: %w", err)
+	}
+	if s == e {
+		return QuietHours{}, fmt.Errorf("quiet hours start and end are both %s", start)
+	}
+	return QuietHours{Start: s, End: e, Enabled: true}, nil
+}
+
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+// Contains reports whether t falls inside the window.
+func (q QuietHours) Contains(t time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+	off := sinceMidnight(t)
+	if q.Start < q.End {
+		return off >= q.Start && off < q.End
+	}
+	return off >= q.Start || off < q.End
+}
+
+// EndAfter returns when the window containing t closes. It assumes Contains(t).
+func (q QuietHours) EndAfter(t time.Time) time.Time {
+	y, m, d := t.Date()
+	end := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(q.End)
+	if !end.After(t) {
+		end = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(q.End)
+	}
+	return end
+}
+
+// Policy turns the loop's state into the next sleep duration.
+type Policy struct {
+	Base       time.Duration // Delay when the machine is idle
+	MaxBackoff time.Duration // Upper bound for backed-off delays
+	Jitter     float64       // Random spread as a fraction of the delay, 0 to 0.5
+	Quiet      QuietHours
+	Rand       func() float64 // Defaults to math/rand; in [0, 1)
+}
+
+// Backoff returns Base doubled level times, capped at MaxBackoff (but never
+// below Base).
+func (p Policy) Backoff(level int) time.Duration {
+	limit := p.MaxBackoff
+	if limit < p.Base {
+		limit = p.Base
+	}
+	d := p.Base
+	for i := 0; i < level && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// Next returns the delay before the next cycle and why it was chosen.
+// level is the number of consecutive cycles that found the machine overloaded.
+func (p Policy) Next(now time.Time, level int) (time.Duration, string) {
+	if p.Quiet.Contains(now) {
+		end := p.Quiet.EndAfter(now)
+		return end.Sub(now) + p.spread(p.Base), "quiet hours until " + end.Format("15:04")
+	}
+
+	d := p.Backoff(level)
+	reason := "base interval"
+	if level > 0 {
+		reason = fmt.Sprintf("load backoff x%d", int(d/p.Base))
+	}
+	d += p.jitter(d)
+	if d < time.Second {
+		d = time.Second
+	}
+
+	// Sleep through quiet hours rather than waking inside them.
+	if wake := now.Add(d); p.Quiet.Contains(wake) {
+		end := p.Quiet.EndAfter(wake)
+		return end.Sub(now) + p.spread(p.Base), "quiet hours until " + end.Format("15:04")
+	}
+	return d, reason
+}
+
+// jitter returns a random offset in [-Jitter*d, +Jitter*d].
+func (p Policy) jitter(d time.Duration) time.Duration {
+	return time.Duration((p.random()*2 - 1) * p.Jitter * float64(d))
+}
+
+// spread returns a random offset in [0, Jitter*d], used after quiet hours so
+// the wakeup never lands back inside the window.
+func (p Policy) spread(d time.Duration) time.Duration {
+	return time.Duration(p.random() * p.Jitter * float64(d))
+}
+
+func (p Policy) random() float64 {
+	if p.Jitter <= 0 {
+		return 0 // Multiplied by a zero Jitter; skip the generator
+	}
+	if p.Rand != nil {
+		return p.Rand()
+	}
+	return rand.Float64()
+}
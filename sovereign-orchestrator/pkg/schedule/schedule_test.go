@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2025-03-10 "+clock, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       QuietHours
+		err        string
+	}{
+		{"", "", QuietHours{}, ""},
+		{"22:00", "07:30", QuietHours{Start: 22 * time.Hour, End: 7*time.Hour + 30*time.Minute, Enabled: true}, ""},
+		{"00:00", "23:59", QuietHours{Start: 0, End: 23*time.Hour + 59*time.Minute, Enabled: true}, ""},
+		{"22:00", "", QuietHours{}, "invalid quiet hours end"},
+		{"", "07:00", QuietHours{}, "invalid quiet hours start"},
+		{"24:00", "07:00", QuietHours{}, "not HH:MM"},
+		{"7pm", "07:00", QuietHours{}, "not HH:MM"},
+		{"08:00", "08:00", QuietHours{}, "both 08:00"},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuietHours(tt.start, tt.end)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseQuietHours(%q, %q) error %v, want %q", tt.start, tt.end, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseQuietHours(%q, %q) = %+v, %v; want %+v", tt.start, tt.end, got, err, tt.want)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	overnight, _ := ParseQuietHours("22:00", "07:00")
+	daytime, _ := ParseQuietHours("12:00", "13:30")
+
+	tests := []struct {
+		name     string
+		q        QuietHours
+		now      string
+		contains bool
+		end      string // Only checked when contains
+	}{
+		{"disabled", QuietHours{}, "23:00", false, ""},
+		{"overnight, evening", overnight, "23:15", true, "2025-03-11 07:00"},
+		{"overnight, at the start", overnight, "22:00", true, "2025-03-11 07:00"},
+		{"overnight, after midnight", overnight, "03:00", true, "2025-03-10 07:00"},
+		{"overnight, at the end", overnight, "07:00", false, ""},
+		{"overnight, daytime", overnight, "12:00", false, ""},
+		{"daytime, inside", daytime, "12:45", true, "2025-03-10 13:30"},
+		{"daytime, before", daytime, "11:59", false, ""},
+		{"daytime, at the end", daytime, "13:30", false, ""},
+	}
+	for _, tt := range tests {
+		now := at(tt.now)
+		if got := tt.q.Contains(now); got != tt.contains {
+			t.Errorf("%s: Contains(%s) = %t", tt.name, tt.now, got)
+			continue
+		}
+		if tt.contains {
+			if got := tt.q.EndAfter(now).Format("2006-01-02 15:04"); got != tt.end {
+				t.Errorf("%s: EndAfter(%s) = %s, want %s", tt.name, tt.now, got, tt.end)
+			}
+		}
+	}
+}
+
+func TestPolicyNext(t *testing.T) {
+	quiet, _ := ParseQuietHours("22:00", "07:00")
+	p := Policy{Base: time.Minute, MaxBackoff: 10 * time.Minute, Quiet: quiet}
+
+	tests := []struct {
+		name   string
+		now    string
+		level  int
+		want   time.Duration
+		reason string
+	}{
+		{"base", "12:00", 0, time.Minute, "base interval"},
+		{"backoff", "12:00", 2, 4 * time.Minute, "load backoff x4"},
+		{"backoff capped", "12:00", 10, 10 * time.Minute, "load backoff x10"},
+		{"inside quiet hours", "23:00", 0, 8 * time.Hour, "quiet hours until 07:00"},
+		{"would wake inside quiet hours", "21:59", 2, 9*time.Hour + time.Minute, "quiet hours until 07:00"},
+		{"wakes right at the start", "21:56", 2, 9*time.Hour + 4*time.Minute, "quiet hours until 07:00"},
+		{"wakes before the start", "21:55", 2, 4 * time.Minute, "load backoff x4"},
+	}
+	for _, tt := range tests {
+		got, reason := p.Next(at(tt.now), tt.level)
+		if got != tt.want || reason != tt.reason {
+			t.Errorf("%s: Next(%s, %d) = %v, %q; want %v, %q", tt.name, tt.now, tt.level, got, reason, tt.want, tt.reason)
+		}
+	}
+}
+
+func TestPolicyJitter(t *testing.T) {
+	quiet, _ := ParseQuietHours("22:00", "07:00")
+	for _, r := range []float64{0, 0.5, 0.999} {
+		p := Policy{Base: 10 * time.Minute, Jitter: 0.5, Quiet: quiet, Rand: func() float64 { return r }}
+		d, _ := p.Next(at("12:00"), 0)
+		if d < 5*time.Minute || d > 15*time.Minute {
+			t.Errorf("jitter with r=%v gave %v, outside base ±50%%", r, d)
+		}
+		// After quiet hours the spread only ever delays the wakeup.
+		d, _ = p.Next(at("23:00"), 0)
+		if wake := at("23:00").Add(d); quiet.Contains(wake) || d > 8*time.Hour+5*time.Minute {
+			t.Errorf("wakeup %v after quiet hours with r=%v", wake, r)
+		}
+	}
+	if d, _ := (Policy{Base: time.Millisecond}).Next(at("12:00"), 0); d != time.Second {
+		t.Errorf("delay %v, want the one second minimum", d)
+	}
+}
@@ -0,0 +1,357 @@
+// Package search runs ranked full-text queries over the FTS5 indexes that
+// the 0006_search migration keeps in sync with the memory tables.
+//
+// FTS5 is not compiled into github.com/mattn/go-sqlite3 by default; the
+// binary must be built with -tags sqlite_fts5 for search to work. Available
+// reports whether the linked SQLite has it; without it Search returns
+// ErrUnavailable.
+package search
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// ErrInvalidQuery is returned for malformed queries and filters.
+	ErrInvalidQuery = errors.New("invalid search query")
+	// ErrUnavailable is returned when FTS5 or the search indexes are missing.
+	ErrUnavailable = errors.New("full-text search unavailable")
+)
+
+const (
+	// DefaultLimit and MaxLimit bound the number of hits per page.
+	DefaultLimit = 20
+	MaxLimit     = 200
+
+	// snippetTokens is the approximate snippet length in tokens.
+	snippetTokens = 24
+
+	// Snippets are produced with private-use markers so highlights can be
+	// split out without confusing them with text in the rows.
+	markStart = "\uE000"
+	markEnd   = "\uE001"
+	ellipsis  = "…"
+)
+
+// Source is a searchable table and its FTS5 index.
+type Source struct {
+	Table   string
+	Index   string
+	Session bool // Has a session_id column
+}
+
+// Sources lists the indexed tables, matching migration 0006_search.
+var Sources = []Source{
+	{Table: "ch", Index: "ch_fts", Session: true},
+	{Table: "jon", Index: "jon_fts"},
+	{Table: "user_context", Index: "user_context_fts"},
+	{Table: "sovereign", Index: "sovereign_fts"},
+	{Table: "philosophy", Index: "philosophy_fts"},
+	{Table: "technologies", Index: "technologies_fts"},
+}
+
+// Available reports whether db's SQLite was compiled with FTS5.
+func Available(ctx context.Context, db *sql.DB) (bool, error) {
+	var used bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used); err != nil {
+		return false, fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	return used, nil
+}
+
+// HasIndexes reports whether any search index exists in db. Databases with
+// indexes cannot be written by a SQLite without FTS5: the triggers that keep
+// the indexes in sync would fail.
+func HasIndexes(ctx context.Context, db *sql.DB) (bool, error) {
+	for _, s := range Sources {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = ?", s.Index).Scan(&n); err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Marker wraps highlighted terms in snippets. Escape, if set, is applied to
+// the text around and inside the markers, e.g. html.EscapeString.
+type Marker struct {
+	Start, End string
+	Escape     func(string) string
+}
+
+// Query is a full-text search request.
+type Query struct {
+	Text      string
+	Raw       bool     // Pass Text to FTS5 unchanged instead of matching every term
+	Tables    []string // Empty means every source
+	Since     time.Time
+	Until     time.Time // Exclusive
+	SessionID string    // Only rows of this session (ch only)
+	Limit     int
+	Offset    int
+	Marker    Marker
+}
+
+// Hit is one ranked match.
+type Hit struct {
+	Table      string   `json:"table"`
+	ID         int64    `json:"id"`
+	Timestamp  string   `json:"timestamp"`
+	SessionID  string   `json:"session_id,omitempty"`
+	Snippet    string   `json:"snippet"`
+	Highlights []string `json:"highlights"` // Matched terms as they appear in the snippet
+	Score      float64  `json:"score"`      // Higher is better
+}
+
+// Result is one page of hits, best first.
+type Result struct {
+	Query   string   `json:"query"`
+	Match   string   `json:"match"` // The FTS5 expression that was run
+	Tables  []string `json:"tables"`
+	Hits    []Hit    `json:"hits"`
+	Offset  int      `json:"offset"`
+	HasMore bool     `json:"has_more"`
+}
+
+// Search runs q against db and returns hits ranked by BM25 across all
+// selected tables.
+func Search(ctx context.Context, db *sql.DB, q Query) (*Result, error) {
+	match := strings.TrimSpace(q.Text)
+	if !q.Raw {
+		match = MatchExpr(q.Text)
+	}
+	if match == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", ErrInvalidQuery)
+	}
+	if ok, err := Available(ctx, db); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%w: this binary was built without SQLite FTS5 (build with make, or go build -tags sqlite_fts5)", ErrUnavailable)
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && !q.Until.After(q.Since) {
+		return nil, fmt.Errorf("%w: until must be after since", ErrInvalidQuery)
+	}
+	sources, err := selectSources(q.Tables, q.SessionID != "")
+	if err != nil {
+		return nil, err
+	}
+	if sources, err = indexed(ctx, db, sources, len(q.Tables) > 0); err != nil {
+		return nil, err
+	}
+
+	var parts []string
+	var args []interface{}
+	for _, s := range sources {
+		where := []string{s.Index + " MATCH ?"}
+		args = append(args, markStart, markEnd, ellipsis, snippetTokens, match)
+		if !q.Since.IsZero() {
+			where = append(where, "julianday(t.timestamp) >= julianday(?)")
+			args = append(args, q.Since.UTC().Format(time.RFC3339))
+		}
+		if !q.Until.IsZero() {
+			where = append(where, "julianday(t.timestamp) < julianday(?)")
+			args = append(args, q.Until.UTC().Format(time.RFC3339))
+		}
+		session := "''"
+		if s.Session {
+			session = "COALESCE(t.session_id, '')"
+			if q.SessionID != "" {
+				where = append(where, "t.session_id = ?")
+				args = append(args, q.SessionID)
+			}
+		}
+		// likely() drops the DATETIME decltype so the driver returns the
+		// stored text instead of converting it.
+		parts = append(parts, fmt.Sprintf(`SELECT '%s' AS source, t.id, COALESCE(likely(t.timestamp), ''), %s,
+			snippet(%s, -1, ?, ?, ?, ?), -bm25(%s) AS score
+			FROM %s JOIN %s t ON t.id = %s.rowid
+			WHERE %s`,
+			s.Table, session, s.Index, s.Index, s.Index, s.Table, s.Index, strings.Join(where, " AND ")))
+	}
+	query := strings.Join(parts, "\nUNION ALL\n") + "\nORDER BY score DESC, source, id DESC LIMIT ? OFFSET ?"
+	args = append(args, q.Limit+1, q.Offset)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "fts5:") {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	defer rows.Close()
+
+	result := &Result{Query: q.Text, Match: match, Hits: []Hit{}, Offset: q.Offset}
+	for _, s := range sources {
+		result.Tables = append(result.Tables, s.Table)
+	}
+	for rows.Next() {
+		var h Hit
+		var snippet sql.NullString
+		if err := rows.Scan(&h.Table, &h.ID, &h.Timestamp, &h.SessionID, &snippet, &h.Score); err != nil {
+			return nil, err
+		}
+		h.Snippet, h.Highlights = render(snippet.String, q.Marker)
+		result.Hits = append(result.Hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		if strings.Contains(err.Error(), "fts5:") {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return nil, err
+	}
+	if len(result.Hits) > q.Limit {
+		result.Hits = result.Hits[:q.Limit]
+		result.HasMore = true
+	}
+	return result, nil
+}
+
+// MatchExpr turns free text into an FTS5 expression that matches rows
+// containing every term. Terms are quoted so punctuation is never parsed as
+// query syntax; a trailing * keeps prefix matching.
+func MatchExpr(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+func selectSources(tables []string, session bool) ([]Source, error) {
+	if len(tables) == 0 {
+		var sources []Source
+		for _, s := range Sources {
+			if s.Session || !session {
+				sources = append(sources, s)
+			}
+		}
+		return sources, nil
+	}
+	var sources []Source
+	seen := make(map[string]bool)
+	for _, name := range tables {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		var found *Source
+		for i := range Sources {
+			if Sources[i].Table == name {
+				found = &Sources[i]
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: table %q is not searchable", ErrInvalidQuery, name)
+		}
+		if session && !found.Session {
+			return nil, fmt.Errorf("%w: table %q has no session_id", ErrInvalidQuery, name)
+		}
+		sources = append(sources, *found)
+	}
+	return sources, nil
+}
+
+// indexed drops the sources whose table or index is missing, e.g. a table
+// archived with drop. Explicitly requested tables must be searchable, and
+// at least one must remain; databases created with the empty schema have
+// no indexes at all.
+func indexed(ctx context.Context, db *sql.DB, sources []Source, explicit bool) ([]Source, error) {
+	var found []Source
+	for _, s := range sources {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN (?, ?)", s.Table, s.Index).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 2 {
+			found = append(found, s)
+		} else if explicit {
+			return nil, fmt.Errorf("%w: database has no search index for %s", ErrUnavailable, s.Table)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w: database has no search indexes", ErrUnavailable)
+	}
+	return found, nil
+}
+
+// render replaces the internal markers in a snippet with m and collects
+// the highlighted terms.
+func render(snippet string, m Marker) (string, []string) {
+	escape := m.Escape
+	if escape == nil {
+		escape = func(s string) string { return s }
+	}
+	var b strings.Builder
+	highlights := []string{}
+	seen := make(map[string]bool)
+	for {
+		i := strings.Index(snippet, markStart)
+		if i < 0 {
+			break
+		}
+		j := strings.Index(snippet[i:], markEnd)
+		if j < 0 {
+			break
+		}
+		term := snippet[i+len(markStart) : i+j]
+		b.WriteString(escape(snippet[:i]))
+		b.WriteString(m.Start)
+		b.WriteString(escape(term))
+		b.WriteString(m.End)
+		if key := strings.ToLower(term); !seen[key] {
+			seen[key] = true
+			highlights = append(highlights, term)
+		}
+		snippet = snippet[i+j+len(markEnd):]
+	}
+	b.WriteString(escape(snippet))
+	return b.String(), highlights
+}
+
+// ParseTime parses a since/until bound: RFC 3339, "2006-01-02 15:04:05" or a
+// bare date. A bare date used as an upper bound covers the whole day.
+func ParseTime(s string, upper bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid time %q", ErrInvalidQuery, s)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,361 @@
+// Package undo deletes rows through a journal: each deleted row is copied
+// into undo_journal in the same transaction as the delete, under a token
+// that restores the whole delete until it expires.
+package undo
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/dbschema"
+)
+
+const (
+	journalTable = "undo_journal"
+	timeLayout   = "2006-01-02 15:04:05"
+)
+
+var (
+	// ErrInvalid is returned for delete requests that cannot be carried out.
+	ErrInvalid = errors.New("invalid delete request")
+	// ErrProtected is returned for protected tables without the override.
+	ErrProtected = errors.New("table is protected")
+	// ErrNotFound is returned for unknown undo tokens.
+	ErrNotFound = errors.New("undo token not found")
+	// ErrExpired is returned for tokens past their undo window.
+	ErrExpired = errors.New("undo window has expired")
+	// ErrConflict is returned when the rows cannot be put back as they were.
+	ErrConflict = errors.New("undo conflicts with existing rows")
+)
+
+// Journal deletes and restores rows of one database.
+type Journal struct {
+	DB     *sql.DB
+	Window time.Duration // How long a delete can be undone
+	Schema string        // DDL creating undo_journal, run if the table is missing
+}
+
+// Result describes a delete or an undo.
+type Result struct {
+	Token     string    `json:"undo_token"`
+	Table     string    `json:"table"`
+	Rows      int       `json:"rows"`
+	IDs       []int64   `json:"ids"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Delete removes the rows of table whose key (rowid or INTEGER PRIMARY KEY)
+// is in ids, journaling them first. Ids that do not exist are ignored.
+// Protected tables need override; the journal itself can never be deleted
+// from through this package.
+func (j *Journal) Delete(ctx context.Context, table string, ids []int64, override bool) (*Result, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: no row ids given", ErrInvalid)
+	}
+	if table == journalTable {
+		return nil, fmt.Errorf("%w: %s cannot be deleted from", ErrProtected, table)
+	}
+	if dbschema.Protected[table] && !override {
+		return nil, fmt.Errorf("%w: %s requires the override flag", ErrProtected, table)
+	}
+	if err := j.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	schema, err := dbschema.Inspect(ctx, j.DB, false)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := schema.Table(table)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown table %q", ErrInvalid, table)
+	}
+	if t.Virtual {
+		return nil, fmt.Errorf("%w: virtual table %s is maintained from its content table", ErrInvalid, table)
+	}
+	keys := t.KeyColumns()
+	if t.WithoutRowID || len(keys) != 1 {
+		return nil, fmt.Errorf("%w: rows of %s have no integer key", ErrInvalid, table)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	res := &Result{Token: token, Table: table, IDs: []int64{}, ExpiresAt: now.Add(j.Window)}
+
+	tx, err := j.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin delete transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// likely() drops the declared type, so values are journaled exactly as
+	// stored rather than converted by the driver (e.g. DATETIME to time.Time).
+	selectList := make([]string, 0, len(t.Columns)+1)
+	selectList = append(selectList, keys[0])
+	for _, c := range t.Columns {
+		selectList = append(selectList, "likely("+dbschema.Quote(c.Name)+")")
+	}
+	in, args := dbschema.InList(keys[0], ids)
+	rows, err := tx.QueryContext(ctx, "SELECT "+strings.Join(selectList, ", ")+" FROM "+dbschema.Quote(table)+" WHERE "+in, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rows to delete: %w", err)
+	}
+	type journaled struct {
+		key  int64
+		data string
+	}
+	var entries []journaled
+	for rows.Next() {
+		values := make([]interface{}, len(selectList))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(t.Columns))
+		for i, c := range t.Columns {
+			row[c.Name] = encodeValue(values[i+1])
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		key, _ := values[0].(int64)
+		entries = append(entries, journaled{key: key, data: string(data)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: none of the given rows exist in %s", ErrInvalid, table)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO undo_journal (token, table_name, row_key, row_data, deleted_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err := stmt.ExecContext(ctx, token, table, e.key, e.data, now.Format(timeLayout), res.ExpiresAt.Format(timeLayout)); err != nil {
+			return nil, fmt.Errorf("failed to journal row %d: %w", e.key, err)
+		}
+		res.IDs = append(res.IDs, e.key)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+dbschema.Quote(table)+" WHERE "+in, args...); err != nil {
+		return nil, fmt.Errorf("failed to delete rows from %s: %w", table, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit delete: %w", err)
+	}
+	res.Rows = len(entries)
+	return res, nil
+}
+
+// Undo puts back the rows deleted under token, with their original keys.
+func (j *Journal) Undo(ctx context.Context, token string) (*Result, error) {
+	if err := j.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := j.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin undo transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT table_name, row_key, row_data, expires_at, undone_at IS NOT NULL
+		FROM undo_journal WHERE token = ? ORDER BY id`, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read undo journal: %w", err)
+	}
+	res := &Result{Token: token, IDs: []int64{}}
+	type entry struct {
+		key  int64
+		data map[string]interface{}
+	}
+	var entries []entry
+	undone := false
+	for rows.Next() {
+		var key sql.NullInt64
+		var data string
+		if err := rows.Scan(&res.Table, &key, &data, &res.ExpiresAt, &undone); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		row, err := decodeRow(data)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, entry{key: key.Int64, data: row})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(entries) == 0:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, token)
+	case undone:
+		return nil, fmt.Errorf("%w: token %s was already used", ErrConflict, token)
+	case time.Now().After(res.ExpiresAt):
+		return nil, fmt.Errorf("%w: token %s expired at %s", ErrExpired, token, res.ExpiresAt.Format(time.RFC3339))
+	}
+
+	// Columns dropped since the delete are skipped; added ones get their defaults.
+	var live, pk []string
+	var pkType string
+	cols, err := tx.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_info(?)", res.Table)
+	if err != nil {
+		return nil, err
+	}
+	for cols.Next() {
+		var name, typ string
+		var pos int
+		if err := cols.Scan(&name, &typ, &pos); err != nil {
+			cols.Close()
+			return nil, err
+		}
+		live = append(live, name)
+		if pos > 0 {
+			pk, pkType = append(pk, name), typ
+		}
+	}
+	cols.Close()
+	if len(live) == 0 {
+		return nil, fmt.Errorf("%w: table %s no longer exists", ErrConflict, res.Table)
+	}
+
+	// An INTEGER PRIMARY KEY column is the rowid and is restored with the
+	// other columns; otherwise the rowid is set explicitly.
+	alias := len(pk) == 1 && strings.EqualFold(pkType, "INTEGER")
+	for _, e := range entries {
+		var names []string
+		var args []interface{}
+		if !alias {
+			names, args = []string{"rowid"}, []interface{}{e.key}
+		}
+		for _, c := range live {
+			if v, ok := e.data[c]; ok {
+				names = append(names, dbschema.Quote(c))
+				args = append(args, v)
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", dbschema.Quote(res.Table), strings.Join(names, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			if strings.Contains(err.Error(), "constraint failed") {
+				// The driver's message names the constraint and is only
+				// for the log.
+				conflict := fmt.Errorf("%w: row %d of %s clashes with a row added since", ErrConflict, e.key, res.Table)
+				return nil, fmt.Errorf("%w: %v", conflict, err)
+			}
+			return nil, fmt.Errorf("failed to restore row %d of %s: %w", e.key, res.Table, err)
+		}
+		res.IDs = append(res.IDs, e.key)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE undo_journal SET undone_at = ? WHERE token = ?", time.Now().UTC().Format(timeLayout), token); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit undo: %w", err)
+	}
+	res.Rows = len(entries)
+	return res, nil
+}
+
+// Purge removes journal entries whose undo window has passed.
+func (j *Journal) Purge(ctx context.Context, now time.Time) (int64, error) {
+	res, err := j.DB.ExecContext(ctx, "DELETE FROM undo_journal WHERE expires_at < ?", now.UTC().Format(timeLayout))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge undo journal: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ensureSchema creates the journal in databases that were created without
+// the standard schema.
+func (j *Journal) ensureSchema(ctx context.Context) error {
+	var n int
+	if err := j.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", journalTable).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := j.DB.ExecContext(ctx, j.Schema); err != nil {
+		return fmt.Errorf("failed to create undo journal: %w", err)
+	}
+	return nil
+}
+
+// Values are journaled as JSON. Integers, text and NULL map directly; reals
+// and blobs are tagged so they come back with the same storage class.
+func encodeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case float64:
+		return map[string]float64{"$real": x}
+	case []byte:
+		return map[string]string{"$blob": base64.StdEncoding.EncodeToString(x)}
+	default:
+		return v
+	}
+}
+
+func decodeRow(data string) (map[string]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid undo journal entry: %w", err)
+	}
+	row := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		switch x := v.(type) {
+		case json.Number:
+			if i, err := x.Int64(); err == nil {
+				row[k] = i
+			} else {
+				row[k], _ = x.Float64()
+			}
+		case map[string]interface{}:
+			if r, ok := x["$real"].(json.Number); ok {
+				row[k], _ = r.Float64()
+			} else if b, ok := x["$blob"].(string); ok {
+				blob, err := base64.StdEncoding.DecodeString(b)
+				if err != nil {
+					return nil, fmt.Errorf("invalid blob in undo journal: %w", err)
+				}
+				row[k] = blob
+			} else {
+				return nil, fmt.Errorf("invalid value of %s in undo journal", k)
+			}
+		default:
+			row[k] = v
+		}
+	}
+	return row, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,231 @@
+package undo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const journalSchema = `CREATE TABLE IF NOT EXISTS undo_journal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token TEXT NOT NULL,
+	table_name TEXT NOT NULL,
+	row_key INTEGER,
+	row_data TEXT NOT NULL,
+	deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	undone_at DATETIME
+)`
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"null", nil},
+		{"integer", int64(42)},
+		{"negative integer", int64(-7)},
+		{"largest integer", int64(math.MaxInt64)},
+		{"smallest integer", int64(math.MinInt64)},
+		{"text", "hello"},
+		{"numeric text", "12"},
+		{"empty text", ""},
+		{"real", 3.25},
+		{"integral real", 2.0},
+		{"tiny real", 1e-300},
+		{"blob", []byte{0, 0xff, 'a', 0x80}},
+		{"empty blob", []byte{}},
+		{"text that looks tagged", `{"$blob":"AA=="}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(map[string]interface{}{"c": encodeValue(tt.v)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			row, err := decodeRow(string(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := row["c"]
+			if !reflect.DeepEqual(got, tt.v) {
+				t.Errorf("round trip of %#v gave %#v (%T) via %s", tt.v, got, got, data)
+			}
+		})
+	}
+}
+
+func TestDecodeRowInvalid(t *testing.T) {
+	for _, data := range []string{`not json`, `{"c":{"$blob":"%%"}}`, `{"c":{"$text":"a"}}`, `[1]`} {
+		if _, err := decodeRow(data); err == nil {
+			t.Errorf("decodeRow(%s) succeeded", data)
+		}
+	}
+}
+
+// newJournal opens a database with a table for each kind of key and one
+// row holding every storage class.
+func newJournal(t *testing.T, window time.Duration) *Journal {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "undo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	stmts := []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB, any, created DATETIME)`,
+		`CREATE TABLE plain (name TEXT UNIQUE, score REAL)`,
+		`CREATE TABLE prime_directives (id INTEGER PRIMARY KEY, directive TEXT)`,
+		`INSERT INTO items VALUES (1, 'one', 1.0, x'00ff', 7, '2025-01-02 03:04:05')`,
+		`INSERT INTO items VALUES (2, NULL, 2.5, NULL, 'text', NULL)`,
+		`INSERT INTO items VALUES (3, 'three', NULL, x'', 4.5, '2025-02-03')`,
+		`INSERT INTO items VALUES (4, 'four', 4, x'01', x'02', '2025-03-04')`,
+		`INSERT INTO plain (rowid, name, score) VALUES (10, 'a', 1), (20, 'b', 2)`,
+		`INSERT INTO prime_directives VALUES (1, 'keep')`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Journal{DB: db, Window: window, Schema: journalSchema}
+}
+
+// dump describes every row of a table with the storage class of each value.
+func dump(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []string
+	for rows.Next() {
+		var c string
+		rows.Scan(&c)
+		cols = append(cols, fmt.Sprintf("typeof(%q) || ':' || quote(%q)", c, c))
+	}
+	rows.Close()
+	rows, err = db.Query(`SELECT rowid || ' ' || ` + strings.Join(cols, " || ' ' || ") + ` FROM ` + table + ` ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestDeleteUndo(t *testing.T) {
+	tests := []struct {
+		table    string
+		ids      []int64
+		wantIDs  []int64
+		wantLeft int
+	}{
+		{"items", []int64{1, 2, 3, 4}, []int64{1, 2, 3, 4}, 0},
+		{"items", []int64{2, 4, 99}, []int64{2, 4}, 2},
+		{"plain", []int64{20}, []int64{20}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.table, tt.ids), func(t *testing.T) {
+			ctx := context.Background()
+			j := newJournal(t, time.Hour)
+			before := dump(t, j.DB, tt.table)
+
+			res, err := j.Delete(ctx, tt.table, tt.ids, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Rows != len(tt.wantIDs) || !reflect.DeepEqual(res.IDs, tt.wantIDs) {
+				t.Errorf("deleted %d rows %v, want %v", res.Rows, res.IDs, tt.wantIDs)
+			}
+			if left := dump(t, j.DB, tt.table); len(left) != tt.wantLeft {
+				t.Errorf("%d rows left, want %d", len(left), tt.wantLeft)
+			}
+
+			if _, err := j.Undo(ctx, res.Token); err != nil {
+				t.Fatal(err)
+			}
+			if after := dump(t, j.DB, tt.table); !reflect.DeepEqual(after, before) {
+				t.Errorf("rows after undo\n got %q\nwant %q", after, before)
+			}
+			if _, err := j.Undo(ctx, res.Token); !errors.Is(err, ErrConflict) {
+				t.Errorf("second undo: got %v, want ErrConflict", err)
+			}
+		})
+	}
+}
+
+func TestDeleteErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    string
+		ids      []int64
+		override bool
+		want     error
+	}{
+		{"no ids", "items", nil, false, ErrInvalid},
+		{"unknown table", "nope", []int64{1}, false, ErrInvalid},
+		{"missing rows", "items", []int64{98, 99}, false, ErrInvalid},
+		{"protected", "prime_directives", []int64{1}, false, ErrProtected},
+		{"schema history", "schema_versions", []int64{1}, false, ErrProtected},
+		{"journal", journalTable, []int64{1}, true, ErrProtected},
+		{"protected with override", "prime_directives", []int64{1}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newJournal(t, time.Hour)
+			_, err := j.Delete(context.Background(), tt.table, tt.ids, tt.override)
+			if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndoErrors(t *testing.T) {
+	ctx := context.Background()
+	j := newJournal(t, -time.Minute)
+	if _, err := j.Undo(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown token: got %v, want ErrNotFound", err)
+	}
+
+	res, err := j.Delete(ctx, "items", []int64{1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Undo(ctx, res.Token); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token: got %v, want ErrExpired", err)
+	}
+	if n, err := j.Purge(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("purge removed %d entries (%v), want 1", n, err)
+	}
+
+	j.Window = time.Hour
+	res, err = j.Delete(ctx, "plain", []int64{10}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.DB.Exec(`INSERT INTO plain (name) VALUES ('a')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Undo(ctx, res.Token); !errors.Is(err, ErrConflict) {
+		t.Errorf("clashing row: got %v, want ErrConflict", err)
+	}
+}
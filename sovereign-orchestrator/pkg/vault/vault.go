@@ -0,0 +1,351 @@
+// Package vault seals files with AES-256-GCM under a key derived from a
+// passphrase or key file with Argon2id.
+//
+// A sealed file starts with a header holding the KDF parameters, the salt
+// and a key check, followed by the plaintext in 64 KiB chunks. Each chunk is
+// sealed with its index in the nonce and a final-chunk flag in the
+// additional data, so reordered, dropped or truncated chunks fail to open.
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	ErrNoKey     = errors.New("no encryption key")
+	ErrWrongKey  = errors.New("wrong encryption key")
+	ErrCorrupt   = errors.New("sealed file is corrupt")
+	ErrNotSealed = errors.New("not a sealed file")
+)
+
+// Ext is appended to the name of a sealed file.
+const Ext = ".enc"
+
+const (
+	magic      = "SOVVAULT"
+	version    = 1
+	chunkSize  = 64 << 10
+	saltSize   = 16
+	prefixSize = 8 // Nonce prefix; the chunk index fills the remaining 4 bytes
+	keySize    = 32
+	// headerSize covers everything before the key check.
+	headerSize = len(magic) + 1 + 4 + 4 + 1 + saltSize + prefixSize
+	checkIndex = ^uint32(0) // Nonce index reserved for the key check
+)
+
+// Params are the Argon2id cost parameters.
+type Params struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory_kib"`
+	Threads uint8  `json:"threads"`
+}
+
+// DefaultParams follow the RFC 9106 second recommendation with more passes.
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Key is a derived key together with the salt and parameters it came from.
+// Files sealed with one Key share its salt but never a nonce.
+type Key struct {
+	aead   cipher.AEAD
+	salt   []byte
+	params Params
+}
+
+// NewKey derives a key from secret under a fresh random salt.
+func NewKey(secret []byte, p Params) (*Key, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return deriveKey(secret, salt, p)
+}
+
+func deriveKey(secret, salt []byte, p Params) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, ErrNoKey
+	}
+	if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrCorrupt)
+	}
+	block, err := aes.NewCipher(argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, keySize))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead, salt: salt, params: p}, nil
+}
+
+func nonce(prefix []byte, index uint32) []byte {
+	n := make([]byte, 0, prefixSize+4)
+	n = append(n, prefix...)
+	return binary.BigEndian.AppendUint32(n, index)
+}
+
+// chunkAD is the additional data of a chunk: the header and a final flag.
+func chunkAD(header []byte, last bool) []byte {
+	ad := append([]byte(nil), header...)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// Seal writes src to dst encrypted under k.
+func (k *Key) Seal(dst io.Writer, src io.Reader) error {
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	header = binary.BigEndian.AppendUint32(header, k.params.Time)
+	header = binary.BigEndian.AppendUint32(header, k.params.Memory)
+	header = append(header, k.params.Threads)
+	header = append(header, k.salt...)
+	header = append(header, prefix...)
+	check := k.aead.Seal(nil, nonce(prefix, checkIndex), nil, header)
+
+	w := bufio.NewWriter(dst)
+	w.Write(header)
+	w.Write(check)
+
+	// Read one chunk ahead so the final chunk is known when it is sealed.
+	cur, next := make([]byte, chunkSize), make([]byte, chunkSize)
+	n, err := readChunk(src, cur)
+	if err != nil {
+		return err
+	}
+	out := make([]byte, 0, chunkSize+k.aead.Overhead())
+	for index := uint32(0); ; index++ {
+		if index == checkIndex {
+			return fmt.Errorf("file too large to seal")
+		}
+		m := 0
+		if n == chunkSize {
+			if m, err = readChunk(src, next); err != nil {
+				return err
+			}
+		}
+		last := m == 0
+		out = k.aead.Seal(out[:0], nonce(prefix, index), cur[:n], chunkAD(header, last))
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return w.Flush()
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+// readChunk fills buf as far as src allows.
+func readChunk(src io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(src, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// readHeader reads the header and key check and gets the key from derive.
+func readHeader(src io.Reader, derive func(salt []byte, p Params) (*Key, error)) (k *Key, header, prefix []byte, err error) {
+	header = make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header[:len(magic)]); err != nil || string(header[:len(magic)]) != magic {
+		return nil, nil, nil, ErrNotSealed
+	}
+	if _, err := io.ReadFull(src, header[len(magic):]); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	r := header[len(magic):]
+	if r[0] != version {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported version %d", ErrCorrupt, r[0])
+	}
+	p := Params{
+		Time:    binary.BigEndian.Uint32(r[1:5]),
+		Memory:  binary.BigEndian.Uint32(r[5:9]),
+		Threads: r[9],
+	}
+	salt := append([]byte(nil), r[10:10+saltSize]...)
+	prefix = r[10+saltSize:]
+
+	if k, err = derive(salt, p); err != nil {
+		return nil, nil, nil, err
+	}
+	check := make([]byte, k.aead.Overhead())
+	if _, err := io.ReadFull(src, check); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	if _, err := k.aead.Open(nil, nonce(prefix, checkIndex), check, header); err != nil {
+		return nil, nil, nil, ErrWrongKey
+	}
+	return k, header, prefix, nil
+}
+
+// Open decrypts src into dst. It returns the key the file was sealed with,
+// which can seal new versions without deriving it again. A wrong secret is
+// reported as ErrWrongKey before anything is written to dst.
+func Open(dst io.Writer, src io.Reader, secret []byte) (*Key, error) {
+	return open(dst, src, func(salt []byte, p Params) (*Key, error) { return deriveKey(secret, salt, p) })
+}
+
+// Open decrypts src, which must have been sealed with k, into dst.
+func (k *Key) Open(dst io.Writer, src io.Reader) error {
+	_, err := open(dst, src, func(salt []byte, p Params) (*Key, error) {
+		if !bytes.Equal(salt, k.salt) || p != k.params {
+			return nil, ErrWrongKey
+		}
+		return k, nil
+	})
+	return err
+}
+
+func open(dst io.Writer, src io.Reader, derive func(salt []byte, p Params) (*Key, error)) (*Key, error) {
+	r := bufio.NewReader(src)
+	k, header, prefix, err := readHeader(r, derive)
+	if err != nil {
+		return nil, err
+	}
+	size := chunkSize + k.aead.Overhead()
+	cur, next := make([]byte, size), make([]byte, size)
+	n, err := readChunk(r, cur)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, chunkSize)
+	for index := uint32(0); ; index++ {
+		m := 0
+		if n == size {
+			if m, err = readChunk(r, next); err != nil {
+				return nil, err
+			}
+		}
+		last := m == 0
+		if index == checkIndex {
+			return nil, fmt.Errorf("%w: too many chunks", ErrCorrupt)
+		}
+		if out, err = k.aead.Open(out[:0], nonce(prefix, index), cur[:n], chunkAD(header, last)); err != nil {
+			return nil, fmt.Errorf("%w: chunk %d failed authentication", ErrCorrupt, index)
+		}
+		if _, err := dst.Write(out); err != nil {
+			return nil, err
+		}
+		if last {
+			return k, nil
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+// Check verifies secret against a sealed file without decrypting it and
+// returns the file's key.
+func Check(path string, secret []byte) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	k, _, _, err := readHeader(bufio.NewReader(f), func(salt []byte, p Params) (*Key, error) { return deriveKey(secret, salt, p) })
+	return k, err
+}
+
+// IsSealed reports whether path starts with the sealed file header.
+func IsSealed(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(buf, []byte(magic)), nil
+}
+
+// SealFile seals src into dst. dst is written under a temporary name and
+// renamed into place, so a failure leaves any previous dst intact.
+func (k *Key) SealFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeAtomic(dst, func(w io.Writer) error { return k.Seal(w, in) })
+}
+
+// OpenFile decrypts src into dst, which is created readable by the owner
+// only, and returns the file's key.
+func OpenFile(src, dst string, secret []byte) (*Key, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var k *Key
+	err = writeAtomic(dst, func(w io.Writer) error {
+		k, err = Open(w, in, secret)
+		return err
+	})
+	return k, err
+}
+
+// RekeyFile re-encrypts the sealed file at path from secret to k, without
+// writing the plaintext to disk.
+func RekeyFile(path string, secret []byte, k *Key) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := Check(path, secret); err != nil {
+		return err
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := Open(pw, in, secret)
+			pw.CloseWithError(err)
+		}()
+		err := k.Seal(w, pr)
+		pr.CloseWithError(err)
+		return err
+	})
+}
+
+func writeAtomic(dst string, write func(io.Writer) error) error {
+	tmp := dst + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
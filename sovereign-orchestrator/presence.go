@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/presence"
+)
+
+// passivePaths are polled by open dashboards on a timer, so they say nothing
+// about whether anyone is looking at the screen.
+var passivePaths = []string{
+	"/autonomy/status",
+	"/sentry/stream",
+	"/terminal/sys_info",
+	"/health",
+}
+
+// newPresenceDetector builds the detector from the configured signal names.
+// The returned Activity is nil when the "http" signal is disabled.
+func newPresenceDetector(cfg PresenceConfig, appDir string) (*presence.Detector, *presence.Activity, error) {
+	var (
+		signals  []presence.Signal
+		activity *presence.Activity
+	)
+	for _, name := range cfg.Signals {
+		switch name {
+		case "tmux":
+			socket := cfg.TmuxSocket
+			if socket == "" {
+				socket = filepath.Join(appDir, "brain.sock")
+			}
+			signals = append(signals, &presence.Tmux{Command: cfg.TmuxCommand, Socket: socket})
+		case "utmp":
+			signals = append(signals, &presence.Utmp{Path: cfg.UtmpPath, MaxIdle: time.Duration(cfg.UtmpMaxIdle)})
+		case "http":
+			activity = presence.NewActivity(time.Duration(cfg.HTTPWindow))
+			signals = append(signals, activity)
+		default:
+			return nil, nil, fmt.Errorf("presence: unknown signal %q", name)
+		}
+	}
+	return presence.NewDetector(signals, time.Duration(cfg.AttachAfter), time.Duration(cfg.DetachAfter)), activity, nil
+}
+
+// touchPresence records an authenticated GUI request as user activity.
+// Polling requests and requests that failed authentication do not count.
+func (app *SovereignApp) touchPresence(r *http.Request) {
+	if app.activity != nil && !isPassiveRequest(r) {
+		app.activity.Touch(r.Method + " " + r.URL.Path)
+	}
+}
+
+func isPassiveRequest(r *http.Request) bool {
+	for _, p := range passivePaths {
+		if r.URL.Path == p {
+			return true
+		}
+	}
+	// Watching the generation feed is passive; starting a generation is not.
+	return r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/stream")
+}
+
+// isUserAttached polls the presence signals and returns the smoothed result.
+func (app *SovereignApp) isUserAttached() bool {
+	return app.Presence.Check(app.ctx).Attached
+}
+
+// watchPresence polls the presence signals until shutdown, independently of
+// the Ghost Mode loop. When the smoothed verdict changes it wakes the loop,
+// saves memory and announces the change. attached is the initial verdict.
+func (app *SovereignApp) watchPresence(attached bool) {
+	every := time.Duration(app.Config.Presence.PollInterval)
+	if every <= 0 {
+		every = 5 * time.Second
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		state := app.Presence.Check(app.ctx)
+		if state.Attached == attached {
+			continue
+		}
+		attached = state.Attached
+		app.ghost.presenceChanged()
+		if attached {
+			log.Println("\n\n>>> USER DETECTED. AUTONOMY PAUSED. <<<")
+			app.triggerSave("User Connected")
+			app.publish("ghost.presence", "alert", "User detected ("+state.Reason+"). Autonomy paused.", state)
+		} else {
+			log.Println("\n\n>>> USER LEFT. RESUMING AUTONOMY... <<<")
+			app.triggerSave("User Detached")
+			app.publish("ghost.presence", "alert", "User left. Resuming autonomy.", state)
+		}
+	}
+}
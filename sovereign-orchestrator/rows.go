@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/undo"
+)
+
+// undoJournalMigration holds the undo_journal DDL, also run on databases
+// created without the standard schema.
+const undoJournalMigration = "migrations/0005_undo_journal.up.sql"
+
+// undoJournal returns the undo journal of a registered database, usable
+// until ctx is done.
+func (app *SovereignApp) undoJournal(ctx context.Context, name string) (*undo.Journal, error) {
+	db, err := app.Databases.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := embeddedFiles.ReadFile(undoJournalMigration)
+	if err != nil {
+		return nil, err
+	}
+	return &undo.Journal{
+		DB:     db,
+		Window: time.Duration(app.Config.Databases.UndoWindow),
+		Schema: string(schema),
+	}, nil
+}
+
+// deleteRowsRequest is the body of /api/delete_rows.
+type deleteRowsRequest struct {
+	DB       string  `json:"db" doc:"Registered database; the memory database when empty"`
+	Table    string  `json:"table" schema:"required,minLength=1"`
+	IDs      []int64 `json:"ids" schema:"required,minItems=1"`
+	Override bool    `json:"override" doc:"Allow deleting from protected tables"`
+}
+
+// deleteRowsResponse answers /api/delete_rows.
+type deleteRowsResponse struct {
+	Status    string    `json:"status" schema:"enum=deleted"`
+	Deleted   int       `json:"deleted"`
+	IDs       []int64   `json:"ids"`
+	UndoToken string    `json:"undo_token" doc:"Pass to /api/undo before expires_at to restore the rows"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// undoRequest is the body of /api/undo.
+type undoRequest struct {
+	DB    string `json:"db" doc:"Registered database; the memory database when empty"`
+	Token string `json:"undo_token" schema:"required,minLength=1"`
+}
+
+// undoResponse answers /api/undo.
+type undoResponse struct {
+	Status   string  `json:"status" schema:"enum=restored"`
+	Restored int     `json:"restored"`
+	Table    string  `json:"table"`
+	IDs      []int64 `json:"ids"`
+}
+
+// handleAPIDeleteRows deletes rows by id and returns a token for /api/undo.
+// prime_directives and schema_versions need "override": true.
+func (app *SovereignApp) handleAPIDeleteRows(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req deleteRowsRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	j, err := app.undoJournal(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := j.Purge(r.Context(), time.Now()); err != nil {
+		log.Printf("Warning: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired undo journal entries", n)
+	}
+
+	res, err := j.Delete(r.Context(), req.Table, req.IDs, req.Override)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("rows", "info", fmt.Sprintf("Deleted %d row(s) from %s", res.Rows, res.Table), res)
+	return deleteRowsResponse{
+		Status:    "deleted",
+		Deleted:   res.Rows,
+		IDs:       res.IDs,
+		UndoToken: res.Token,
+		ExpiresAt: res.ExpiresAt,
+	}, nil
+}
+
+// handleAPIUndo restores the rows of an earlier delete.
+func (app *SovereignApp) handleAPIUndo(w http.ResponseWriter, r *http.Request) (any, error) {
+	var req undoRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	j, err := app.undoJournal(r.Context(), req.DB)
+	if err != nil {
+		return nil, err
+	}
+	res, err := j.Undo(r.Context(), req.Token)
+	if err != nil {
+		return nil, err
+	}
+	app.publish("rows", "info", fmt.Sprintf("Restored %d row(s) into %s", res.Rows, res.Table), res)
+	return undoResponse{
+		Status:   "restored",
+		Restored: res.Rows,
+		Table:    res.Table,
+		IDs:      res.IDs,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"sovereign-orchestrator/pkg/command"
+	"sovereign-orchestrator/pkg/runtimedir"
+)
+
+const runtimeBundleName = "sovereign-system.tar.gz"
+
+// runtimeDir returns the directory the embedded runtime is extracted to.
+func (app *SovereignApp) runtimeDir() string {
+	return filepath.Join(app.AppDir, "runtime")
+}
+
+// runtimeManager returns the manager for the embedded scripts and runtime bundle.
+func (app *SovereignApp) runtimeManager() *runtimedir.Manager {
+	return &runtimedir.Manager{
+		Dir:     app.runtimeDir(),
+		FS:      embeddedFiles,
+		Scripts: "scripts/*",
+		Archive: runtimeBundleName,
+	}
+}
+
+// ensureRuntime extracts the embedded runtime unless an up-to-date copy is already on disk.
+func (app *SovereignApp) ensureRuntime() error {
+	extracted, err := app.runtimeManager().Ensure()
+	if err != nil {
+		return err
+	}
+	if extracted {
+		log.Printf("Runtime: extracted embedded runtime to %s", app.runtimeDir())
+	}
+	return nil
+}
+
+// runtimeCommands builds the "runtime" subcommand tree.
+func (app *SovereignApp) runtimeCommands() *command.Registry {
+	reg := command.NewRegistry("runtime")
+	reg.Register(&command.Subcommand{
+		Name:    "verify",
+		Summary: "Check the extracted runtime against its manifest and the embedded content",
+		Run:     app.cmdRuntimeVerify,
+	})
+	reg.Register(&command.Subcommand{
+		Name:    "reextract",
+		Summary: "Extract the embedded runtime again, replacing the current copy",
+		Run:     app.cmdRuntimeReextract,
+	})
+	reg.Register(&command.Subcommand{
+		Name:    "path",
+		Summary: "Print the runtime directory",
+		Run:     app.cmdRuntimePath,
+	})
+	return reg
+}
+
+func (app *SovereignApp) cmdRuntimeVerify(cmd *command.Command) error {
+	problems, err := app.runtimeManager().Verify()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Printf("Runtime at %s is intact.\n", app.runtimeDir())
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return fmt.Errorf("runtime verification found %d problem(s); run '%s runtime reextract' to repair", len(problems), appName)
+}
+
+func (app *SovereignApp) cmdRuntimeReextract(cmd *command.Command) error {
+	if err := app.runtimeManager().Extract(); err != nil {
+		return err
+	}
+	fmt.Printf("Runtime extracted to %s\n", app.runtimeDir())
+	return nil
+}
+
+func (app *SovereignApp) cmdRuntimePath(cmd *command.Command) error {
+	fmt.Println(app.runtimeDir())
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/command"
+	"sovereign-orchestrator/pkg/search"
+)
+
+// searchQuery builds a query from the shared API and CLI parameters.
+func searchQuery(text, tables, since, until, sessionID string) (search.Query, error) {
+	q := search.Query{Text: text, SessionID: sessionID}
+	for _, t := range strings.Split(tables, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			q.Tables = append(q.Tables, t)
+		}
+	}
+	var err error
+	if q.Since, err = search.ParseTime(since, false); err != nil {
+		return q, err
+	}
+	if q.Until, err = search.ParseTime(until, true); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// searchParams holds the parameters of /api/search.
+type searchParams struct {
+	Q         string   `query:"q" doc:"Words to find, or an FTS5 expression with raw=true" schema:"required"`
+	Table     []string `query:"table" doc:"Tables to search, comma-separated or repeated; all when omitted"`
+	Since     string   `query:"since" doc:"RFC 3339 time or YYYY-MM-DD"`
+	Until     string   `query:"until" doc:"RFC 3339 time or YYYY-MM-DD, inclusive"`
+	SessionID string   `query:"session_id"`
+	Limit     int      `query:"limit" schema:"min=0"`
+	Offset    int      `query:"offset" schema:"min=0"`
+	Raw       bool     `query:"raw" doc:"Pass q to FTS5 as a query expression"`
+	DB        string   `query:"db" doc:"Registered database; the memory database when empty"`
+}
+
+// handleAPISearch runs a ranked full-text search over the memory tables.
+//
+//	GET /api/search?q=deploy+script&table=ch,jon&since=2025-01-01&until=2025-02-01&session_id=&limit=20&offset=0&raw=false&db=
+//
+// Snippets are HTML-escaped with the matches wrapped in <mark>. raw=true
+// passes q to FTS5 as a query expression (AND/OR/NEAR, column filters).
+func (app *SovereignApp) handleAPISearch(w http.ResponseWriter, r *http.Request) (any, error) {
+	p := r.URL.Query()
+	db, err := app.Databases.Get(r.Context(), p.Get("db"))
+	if err != nil {
+		return nil, err
+	}
+	q, err := searchQuery(p.Get("q"), strings.Join(p["table"], ","), p.Get("since"), p.Get("until"), p.Get("session_id"))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &q.Limit}, {"offset", &q.Offset}} {
+		if s := p.Get(n.name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				return nil, api.Errorf(api.CodeInvalidRequest, "%s must be a non-negative integer", n.name)
+			}
+			*n.dst = v
+		}
+	}
+	q.Raw = p.Get("raw") == "true"
+	q.Marker = search.Marker{Start: "<mark>", End: "</mark>", Escape: html.EscapeString}
+
+	return search.Search(r.Context(), db, q)
+}
+
+// cmdSearch runs a full-text search from the command line.
+func (app *SovereignApp) cmdSearch(cmd *command.Command) error {
+	if len(cmd.Args) == 0 {
+		return errors.New("search: no query given")
+	}
+	q, err := searchQuery(strings.Join(cmd.Args, " "), cmd.Flags["tables"], cmd.Flags["since"], cmd.Flags["until"], cmd.Flags["session"])
+	if err != nil {
+		return err
+	}
+	if q.Limit, err = cmd.Int("limit"); err != nil {
+		return err
+	}
+	q.Raw = cmd.Bool("raw")
+	if !cmd.Bool("json") {
+		q.Marker = search.Marker{Start: "\x1b[1;33m", End: "\x1b[0m"}
+	}
+
+	if err := app.openDB(); err != nil {
+		return err
+	}
+	result, err := search.Search(app.ctx, app.DB, q)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	if len(result.Hits) == 0 {
+		fmt.Println("No matches.")
+		return nil
+	}
+	for _, h := range result.Hits {
+		header := fmt.Sprintf("%s #%d  %s", h.Table, h.ID, h.Timestamp)
+		if h.SessionID != "" {
+			header += "  session " + h.SessionID
+		}
+		fmt.Printf("%s  (score %.2f)\n    %s\n\n", header, h.Score, strings.Join(strings.Fields(h.Snippet), " "))
+	}
+	if result.HasMore {
+		fmt.Printf("More results available; raise --limit (max %d).\n", search.MaxLimit)
+	}
+	return nil
+}
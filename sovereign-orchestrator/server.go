@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+)
+
+// streamRoutes answer with event streams. They are exempt from the request
+// timeout, and the subscriptions (GET) end as soon as shutdown begins.
+var streamRoutes = map[string]bool{
+	"GET /sentry/stream":    true,
+	"GET /generate/stream":  true,
+	"POST /generate/stream": true,
+}
+
+// uploadRoutes take Server.MaxUploadBytes instead of Server.MaxBodyBytes.
+var uploadRoutes = map[string]bool{
+	"POST /upload": true,
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// A client-supplied request id is kept if it is short and printable, so
+// callers can correlate their logs with ours.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestInfo follows a request through the middleware chain.
+type requestInfo struct {
+	id   string
+	user string // Set by protect once the request is authenticated
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// background runs fn in a goroutine that Close waits for.
+func (app *SovereignApp) background(fn func()) {
+	app.workers.Add(1)
+	go func() {
+		defer app.workers.Done()
+		fn()
+	}()
+}
+
+// waitBackground waits for background goroutines to notice that app.ctx was
+// canceled, for at most the shutdown timeout.
+func (app *SovereignApp) waitBackground() {
+	done := make(chan struct{})
+	go func() {
+		app.workers.Wait()
+		close(done)
+	}()
+	timeout := time.Duration(app.Config.Server.ShutdownTimeout)
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Warning: background tasks still running after %v; closing the database anyway", timeout)
+	}
+}
+
+// withRequestInfo gives every request an id, echoed in the X-Request-ID
+// response header.
+func (app *SovereignApp) withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		info := &requestInfo{id: id}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+// statusRecorder remembers the status and size of a response. It passes
+// Flush through for event streams and Unwrap for http.ResponseController.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests writes one access log line per finished request.
+func (app *SovereignApp) logRequests(next http.Handler) http.Handler {
+	if !app.Config.Server.AccessLog {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		id, user := "-", "-"
+		if info := requestInfoFrom(r); info != nil {
+			id = info.id
+			if info.user != "" {
+				user = info.user
+			}
+		}
+		log.Printf("HTTP %s %s %d %dB %v id=%s user=%s remote=%s", r.Method, r.URL.RequestURI(), rec.status, rec.bytes,
+			time.Since(start).Round(time.Millisecond), id, user, r.RemoteAddr)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 instead of a dropped
+// connection, and logs the stack under the request id.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			id := "-"
+			if info := requestInfoFrom(r); info != nil {
+				id = info.id
+			}
+			log.Printf("Error: panic serving %s %s (id=%s): %v\n%s", r.Method, r.URL.Path, id, err, debug.Stack())
+			if rec, ok := w.(*statusRecorder); !ok || rec.status == 0 {
+				api.WriteError(w, api.Errorf(api.CodeInternal, "Internal server error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit applies the body-size limit and request timeout of a route.
+// Streams have no timeout; their subscriptions end when draining is done.
+func (app *SovereignApp) limit(pattern string, draining context.Context, next http.Handler) http.Handler {
+	cfg := app.Config.Server
+	maxBody := cfg.MaxBodyBytes
+	if uploadRoutes[pattern] {
+		maxBody = cfg.MaxUploadBytes
+	}
+	stream := streamRoutes[pattern]
+	timeout := time.Duration(cfg.RequestTimeout)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxBody > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+		ctx := r.Context()
+		var cancel context.CancelFunc
+		switch {
+		case stream && r.Method == "GET":
+			ctx, cancel = context.WithCancel(ctx)
+			stop := context.AfterFunc(draining, cancel)
+			defer stop()
+		case !stream && timeout > 0:
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			// Leave the handler time to report the timeout before the
+			// connection itself gives up.
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routeErrors answers requests that match no route with an error envelope
+// in place of the mux's plain-text 404 and 405 pages.
+func routeErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// Only the mux knows whether another method would have matched;
+		// ask its handler without letting it write the body.
+		probe := &headerProbe{header: http.Header{}}
+		h.ServeHTTP(probe, r)
+		if probe.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", probe.header.Get("Allow"))
+			api.WriteError(w, api.Errorf(api.CodeMethodNotAllowed, "%s is not allowed on %s", r.Method, r.URL.Path))
+			return
+		}
+		api.WriteError(w, api.Errorf(api.CodeNotFound, "No route for %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// headerProbe records the status and headers of a response and discards
+// its body.
+type headerProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *headerProbe) Header() http.Header { return p.header }
+
+func (p *headerProbe) Write(b []byte) (int, error) { return len(b), nil }
+
+func (p *headerProbe) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-	"io"
-	"os/exec"
-	"net/http"
-	"context"
-	"encoding/json"
 
 	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
 
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/auth"
+	"sovereign-orchestrator/pkg/dbregistry"
+	"sovereign-orchestrator/pkg/dbschema"
+	"sovereign-orchestrator/pkg/embed"
+	"sovereign-orchestrator/pkg/events"
+	"sovereign-orchestrator/pkg/ingest"
+	"sovereign-orchestrator/pkg/presence"
+	"sovereign-orchestrator/pkg/search"
+	"sovereign-orchestrator/pkg/vault"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -25,21 +36,40 @@ import (
 const (
 	appName    = "sovereign"
 	dbFileName = "sovereign_memory.db"
-	SAVE_INTERVAL = 20 * time.Minute 
-	GHOST_MODE_SLEEP_INTERVAL = 5 * time.Second 
+	// Ghost Mode defaults; the live values are set through /autonomy/config.
+	SAVE_INTERVAL             = 20 * time.Minute
+	GHOST_MODE_SLEEP_INTERVAL = 5 * time.Second
 )
 
 // SovereignApp holds the application's configuration and state
 type SovereignApp struct {
-	AppDir   string
-	DBPath   string
-	DB       *sql.DB
-	ctx      context.Context
-	cancel   context.CancelFunc
+	AppDir    string
+	DBPath    string
+	DB        *sql.DB
+	Config    *Config
+	LLM       *llmRouter
+	Events    *events.Bus
+	Presence  *presence.Detector
+	activity  *presence.Activity // GUI request signal; nil when disabled
+	Ingester  *ingest.Ingester   // nil when session ingestion is disabled
+	Databases *dbregistry.Registry
+	Vectors   *embed.Store // nil when vector memory is disabled
+	embedWake chan struct{}
+	trainJobs *trainJobs
+	sealed    *sealedDB   // nil unless the memory database is encrypted
+	Auth      *auth.Store // nil when authentication is disabled
+	openAPI   *apiSpec    // The API document, built with the routes
+	ghost     *ghostState
+	saveMu    sync.Mutex     // Serializes memory saves
+	backupMu  sync.Mutex     // Serializes backups and restores
+	writeMu   sync.RWMutex   // Read-held by background writers to app.DB; restores take it to pause them
+	workers   sync.WaitGroup // Background goroutines, waited for by Close
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 // NewSovereignApp initializes a new SovereignApp instance
-func NewSovereignApp(customDBPath string) (*SovereignApp, error) {
+func NewSovereignApp(customDBPath, customConfigPath string) (*SovereignApp, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
@@ -56,13 +86,45 @@ func NewSovereignApp(customDBPath string) (*SovereignApp, error) {
 		log.Printf("Using custom database path: %s", dbPath)
 	}
 
+	cfgPath, explicit := configPath(appDir, customConfigPath)
+	cfg, err := loadConfig(cfgPath, explicit)
+	if err != nil {
+		return nil, err
+	}
+	router, err := newLLMRouter(cfg.LLM)
+	if err != nil {
+		return nil, err
+	}
+	detector, activity, err := newPresenceDetector(cfg.Presence, appDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Memory.validate(); err != nil {
+		return nil, err
+	}
+	embedder, err := newEmbedder(cfg.Embeddings)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	app := &SovereignApp{
-		AppDir: appDir,
-		DBPath: dbPath,
-		ctx:    ctx,
-		cancel: cancel,
+		AppDir:    appDir,
+		DBPath:    dbPath,
+		Config:    cfg,
+		LLM:       router,
+		Events:    events.NewBus(eventBufferSize),
+		Presence:  detector,
+		activity:  activity,
+		ghost:     newGhostState(defaultAutonomyConfig()),
+		trainJobs: newTrainJobs(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if embedder != nil {
+		app.Vectors = &embed.Store{Embedder: embedder, BatchSize: cfg.Embeddings.BatchSize}
+		app.embedWake = make(chan struct{}, 1)
 	}
 
 	return app, nil
@@ -72,47 +134,105 @@ func NewSovereignApp(customDBPath string) (*SovereignApp, error) {
 func (app *SovereignApp) Init() error {
 	log.Printf("Initializing Sovereign App in %s", app.AppDir)
 
-	db, err := sql.Open("sqlite3", app.DBPath)
+	if err := app.openDB(); err != nil {
+		return err
+	}
+
+	autonomyCfg, err := app.loadAutonomyConfig()
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return err
 	}
-	app.DB = db
+	app.ghost = newGhostState(autonomyCfg)
 
-	if err := app.initDB(); err != nil {
-		app.DB.Close()
-		return fmt.Errorf("failed to initialize database schema: %w", err)
+	app.Databases, err = app.newDatabaseRegistry()
+	if err != nil {
+		return err
+	}
+	if app.Vectors != nil {
+		app.Vectors.DB = app.DB
+	}
+
+	// A damaged runtime should not keep the memory server from starting.
+	if err := app.ensureRuntime(); err != nil {
+		log.Printf("Warning: failed to prepare runtime: %v", err)
 	}
 
 	log.Println("Sovereign App initialized successfully.")
-	
+
+	// Presence is polled on its own ticker, so the status and the save hooks
+	// keep up while Ghost Mode is disabled or asleep.
+	attached := app.isUserAttached()
+	app.background(func() { app.watchPresence(attached) })
+
 	// Start Ghost Mode as a goroutine
-	go app.startGhostMode()
+	app.background(app.startGhostMode)
+
+	if app.Vectors != nil {
+		app.background(app.startEmbedder)
+	}
+
+	if app.sealed != nil && app.sealed.lock != nil {
+		app.background(app.startSealer)
+	}
+
+	if app.Config.Backups.Enabled {
+		app.background(app.startBackups)
+	}
+
+	if app.Config.Ingest.Enabled {
+		app.Ingester = app.newIngester()
+		app.background(app.startIngester)
+	}
 
 	return nil
 }
 
-// initDB creates tables and populates initial data if necessary
-func (app *SovereignApp) initDB() error {
-	tables := []string{
-		"CREATE TABLE IF NOT EXISTS schema_versions (id INTEGER PRIMARY KEY AUTOINCREMENT, version INTEGER UNIQUE, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP)",
-		"CREATE TABLE IF NOT EXISTS ch (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, session_id TEXT, type TEXT, content TEXT, metadata TEXT)",
-		"CREATE TABLE IF NOT EXISTS vs (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, component TEXT, version TEXT, changelog TEXT)",
-		"CREATE TABLE IF NOT EXISTS user_context (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, category TEXT, key TEXT, value TEXT, context TEXT)",
-		"CREATE TABLE IF NOT EXISTS sovereign (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, focus_area TEXT, entry_type TEXT, content TEXT, metadata TEXT)",
-		"CREATE TABLE IF NOT EXISTS evolution (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, milestone TEXT, description TEXT, growth_index REAL DEFAULT 1.0)",
-		"CREATE TABLE IF NOT EXISTS prime_directives (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, directive TEXT UNIQUE, description TEXT)",
-		"CREATE TABLE IF NOT EXISTS philosophy (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, topic TEXT, insight TEXT)",
-		"CREATE TABLE IF NOT EXISTS technologies (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, topic TEXT, key TEXT, value TEXT, success_rate REAL DEFAULT 1.0)",
-        "CREATE TABLE IF NOT EXISTS jon (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, category TEXT, key TEXT, value TEXT, context TEXT)",
-	}
-
-	for _, query := range tables {
-		if _, err := app.DB.Exec(query); err != nil {
-			return fmt.Errorf("error creating table: %w", err)
+// openDB opens the database and brings its schema up to date.
+// It is a no-op if the database is already open.
+func (app *SovereignApp) openDB() error {
+	if app.DB != nil {
+		return nil
+	}
+
+	if err := app.openDatabase(); err != nil {
+		return err
+	}
+
+	if err := app.initDB(); err != nil {
+		app.DB.Close()
+		app.DB = nil
+		return fmt.Errorf("failed to initialize database schema: %w", err)
+	}
+	return nil
+}
+
+// openDatabase opens the database file without touching its schema.
+func (app *SovereignApp) openDatabase() error {
+	if app.DB != nil {
+		return nil
+	}
+	if _, err := os.Stat(app.DBPath + vault.Ext); err == nil && app.sealed == nil {
+		if err := app.unlockDB(); err != nil {
+			return err
 		}
 	}
+	db, err := sql.Open("sqlite3", app.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if ok, err := search.Available(app.ctx, db); err != nil {
+		db.Close()
+		return err
+	} else if !ok {
+		log.Println("Full-text search is disabled: this binary was built without SQLite FTS5 (build with make, or go build -tags sqlite_fts5)")
+	}
+	app.DB = db
+	return nil
+}
 
-	if err := app.applyMigrations(); err != nil {
+// initDB brings the schema up to date and populates initial data if necessary
+func (app *SovereignApp) initDB() error {
+	if err := app.applyMigrations(true); err != nil {
 		return fmt.Errorf("failed to apply database migrations: %w", err)
 	}
 
@@ -155,69 +275,57 @@ func (app *SovereignApp) initDB() error {
 	return nil
 }
 
-// triggerSave simulates the memory save protocol.
-func (app *SovereignApp) triggerSave(reason string) {
-	log.Printf(">>> TRIGGERING MEMORY SAVE (%s) <<<", reason)
-	log.Println("SYSTEM MANDATE: Save Protocol initiated. IMMEDIATELY append a concise summary of your recent actions, successful or failed, to 'MEMORY_VAULT'. This updates your 'LoRA-style' long-term memory.")
-	// The actual mechanism to append to MEMORY_VAULT will be implemented later
-	// in the LLM interaction logic, likely involving a call to a specific LLM capability.
-}
-
-// startGhostMode runs the autonomous operation loop
+// startGhostMode runs the autonomous operation loop. Every wait is cancellable:
+// shutdown stops it immediately, configuration changes posted to
+// /autonomy/config re-plan the current wait, and presence changes pause or
+// resume it at once.
 func (app *SovereignApp) startGhostMode() {
 	log.Println("--- SOVEREIGN ORCHESTRATOR V5 (AUTONOMOUS) INITIALIZED ---")
 
 	lastSaveTime := time.Now()
-	wasAttached := app.isUserAttached() // Initial check
+	backoff := 0 // Consecutive overloaded cycles
 
+	cfg := app.ghost.Config()
+	attached := app.Presence.State().Attached
+	if attached {
+		app.ghost.setStatus("paused")
+	}
+	delay, reason := planGhostWake(cfg, backoff, attached)
 	for {
-		select {
-		case <-app.ctx.Done():
+		app.ghost.scheduled(delay, reason, backoff)
+		woke, err := app.ghostWait(delay)
+		if err != nil {
+			app.ghost.setStatus("stopped")
 			log.Println("Ghost Mode stopped.")
 			return
-		case <-time.After(GHOST_MODE_SLEEP_INTERVAL):
-			userIsHere := app.isUserAttached()
-			currentTime := time.Now()
-
-			if userIsHere {
-				if !wasAttached {
-					// Just connected
-					app.triggerSave("User Connected")
-					log.Println("\n\n>>> USER DETECTED. AUTONOMY PAUSED. <<<")
-					// Send notification to LLM (me) or interface
-					// For now, just log
-				}
-				wasAttached = true
-				// While user is attached, sleep longer or just wait.
-				// For now, continue loop but don't perform autonomous actions.
-				time.Sleep(GHOST_MODE_SLEEP_INTERVAL * 2) // Longer sleep while user is present
-			} else {
-				// GHOST MODE
-				if wasAttached {
-					// User just left
-					app.triggerSave("User Detached")
-					log.Println("\n\n>>> USER LEFT. RESUMING AUTONOMY... <<<")
-					// Send notification to LLM (me)
-				}
-				wasAttached = false
-
-				if currentTime.Sub(lastSaveTime) > SAVE_INTERVAL {
-					app.triggerSave("Periodic")
-					lastSaveTime = currentTime
-				}
-
-				// Perform autonomous actions here (e.g., get context, generate command)
-				contextData := app.getSystemContext()
-				_ = contextData // Use contextData (will be fed to LLM later)
-				// log.Printf("Ghost Mode: Sensing environment. Context: %s", contextData)
-				// Placeholder for LLM interaction:
-				// prompt := app.generateAutonomousPrompt(contextData)
-				// app.sendPromptToLLM(prompt)
+		}
+		cfg = app.ghost.Config()
+		attached = app.Presence.State().Attached
+		if woke == wokeReload {
+			log.Printf("Ghost Mode: configuration reloaded (enabled=%t, interval=%ds)", cfg.Enabled, cfg.BaseInterval)
+		}
+		if attached {
+			app.ghost.setStatus("paused")
+			delay, reason = planGhostWake(cfg, backoff, true)
+			continue
+		}
+		app.ghost.setStatus("active")
+		if woke != wokeTimer {
+			// The user left or the configuration changed; plan from now.
+			delay, reason = planGhostWake(cfg, backoff, false)
+			continue
+		}
 
-				log.Println("Ghost Mode: Performing autonomous actions... (Placeholder)")
-				app.diagnoseAndCorrect() // Call self-diagnosis and correction
-			}
+		// GHOST MODE
+		currentTime := time.Now()
+		if currentTime.Sub(lastSaveTime) > time.Duration(cfg.SaveInterval)*time.Second {
+			app.triggerSave("Periodic")
+			lastSaveTime = currentTime
 		}
+
+		cycle := app.runGhostCycle(cfg, &backoff)
+		delay, reason = planGhostWake(cfg, backoff, false)
+		app.recordGhostCycle(cycle, backoff, delay)
 	}
 }
 
@@ -233,89 +341,283 @@ func (app *SovereignApp) diagnoseAndCorrect() {
 	// - Reporting critical issues to the LLM (me) for higher-level reasoning.
 }
 
-
 func (app *SovereignApp) Close() error {
 	// Signal to stop any running goroutines
 	if app.cancel != nil {
 		app.cancel()
 	}
+	app.waitBackground()
+	if app.Databases != nil {
+		app.Databases.Close()
+	}
+	if app.Auth != nil {
+		app.Auth.Close()
+	}
+	var err error
 	if app.DB != nil {
-		return app.DB.Close()
+		err = app.DB.Close()
 	}
-	return nil
+	if sealErr := app.closeSealed(); sealErr != nil {
+		log.Printf("Error: %v", sealErr)
+		if err == nil {
+			err = sealErr
+		}
+	}
+	return err
 }
 
-// Run starts the main application loop and HTTP server
-func (app *SovereignApp) Run() {
+// Run serves the HTTP API until ctx is canceled, then shuts the server down
+// gracefully: new connections are refused and in-flight requests drain.
+// Background work is stopped afterwards by Close.
+func (app *SovereignApp) Run(ctx context.Context) error {
 	fmt.Println("Sovereign System is up and running.")
-	
-	// Setup HTTP server to serve embedded web files
-	// The http.StripPrefix ensures that "/web/" is removed from the request path
-	// before http.FileServer looks for the file in the embeddedFiles FS.
-	http.Handle("/web/", http.StripPrefix("/web/", http.FileServer(http.FS(embeddedFiles))))
-	
-	app.setupAPIRoutes() // Call the method to set up API routes
-
-	log.Println("Starting HTTP server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-// setupAPIRoutes configures all API endpoints
-func (app *SovereignApp) setupAPIRoutes() {
-	// API Endpoints
-	http.HandleFunc("/terminal/sys_info", app.handleSysInfo)
-	http.HandleFunc("/upload", app.handleUpload)
-	http.HandleFunc("/analyze_code_file", app.handleAnalyzeCodeFile)
-	http.HandleFunc("/process_text_file", app.handleProcessTextFile)
-	http.HandleFunc("/generate", app.handleGenerate)
-	http.HandleFunc("/process_image", app.handleProcessImage)
-	http.HandleFunc("/scout/scan", app.handleScoutScan)
-	http.HandleFunc("/visual/screenshot", app.handleVisualScreenshot)
-	http.HandleFunc("/analyze/anomaly_file", app.handleAnalyzeAnomalyFile)
-	http.HandleFunc("/analyze/anomaly_text", app.handleAnalyzeAnomalyText)
-	http.HandleFunc("/analyze/visual_signature", app.handleAnalyzeVisualSignature)
-	http.HandleFunc("/introspect/god_mode", app.handleIntrospectGodMode)
-	http.HandleFunc("/sentinel/data", app.handleSentinelData)
-	http.HandleFunc("/sentinel/scout", app.handleSentinelScout)
-	http.HandleFunc("/sentinel/log_scan", app.handleSentinelLogScan)
-	http.HandleFunc("/sentinel/scribe", app.handleSentinelScribe)
-	http.HandleFunc("/autonomy/status", app.handleAutonomyStatus)
-	http.HandleFunc("/sentry/stream", app.handleSentryStream)
-	http.HandleFunc("/autonomy/config", app.handleAutonomyConfig) // Handle both GET and POST in this handler
-	http.HandleFunc("/api/databases", app.handleAPIDatabases)
-	http.HandleFunc("/api/tables", app.handleAPITables)
-	http.HandleFunc("/api/table_data", app.handleAPITableData)
-	http.HandleFunc("/api/train", app.handleAPITrain)
-	http.HandleFunc("/api/crawl", app.handleAPICrawl)
-	http.HandleFunc("/api/stop_crawl", app.handleAPIStopCrawl)
-	http.HandleFunc("/api/delete_rows", app.handleAPIDeleteRows)
-	http.HandleFunc("/api/create_database", app.handleAPICreateDatabase)
-	http.HandleFunc("/api/delete_database", app.handleAPIDeleteDatabase)
-	http.HandleFunc("/api/copy_database", app.handleAPICopyDatabase)
-	http.HandleFunc("/api/merge_databases", app.handleAPIMergeDatabases)
-	http.HandleFunc("/api/archive_database", app.handleAPIArchiveDatabase)
-	http.HandleFunc("/api/archive_table", app.handleAPIArchiveTable)
-	http.HandleFunc("/api/archive_rows", app.handleAPIArchiveRows)
-	http.HandleFunc("/api/ai_analyze", app.handleAPIAIAnalyze)
-	http.HandleFunc("/api/status", app.handleAPIStatus)
-	http.HandleFunc("/api/cast", app.handleAPICast)
-	http.HandleFunc("/health", app.handleHealth)
-	
-	// Redirect root to a default GUI entry point - keep this last
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
+
+	if err := app.startAuth(); err != nil {
+		return fmt.Errorf("failed to start authentication: %w", err)
+	}
+	cfg := app.Config.Server
+
+	// Event streams never finish on their own; they end when shutdown
+	// begins so that draining does not wait for them.
+	draining, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+	mux := http.NewServeMux()
+	if err := app.setupAPIRoutes(mux, draining); err != nil {
+		return err
+	}
+	listeners, err := app.openListeners()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           app.withRequestInfo(app.logRequests(recoverPanics(routeErrors(mux)))),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		ConnContext:       connContext,
+	}
+	srv.RegisterOnShutdown(stopStreams)
+
+	// One server serves every listener, so Shutdown drains them together.
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				errc <- fmt.Errorf("HTTP server on %s failed: %w", l.Addr(), err)
+			}
+		}()
+	}
+	select {
+	case err := <-errc:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down: draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: requests still running after %v, closing their connections: %v", time.Duration(cfg.ShutdownTimeout), err)
+		srv.Close()
+	}
+	log.Println("HTTP server stopped.")
+	return nil
+}
+
+// setupAPIRoutes configures all endpoints on mux with Go 1.22 method and
+// path patterns. Every route in the table goes through protect; only
+// sign-in, the health check, the API document and the GUI files are public.
+// The table also yields the OpenAPI document served at /api/openapi.json.
+func (app *SovereignApp) setupAPIRoutes(mux *http.ServeMux, draining context.Context) error {
+	const (
+		read  = auth.ScopeDBRead
+		write = auth.ScopeDBWrite
+		admin = auth.ScopeAutonomyAdmin
+		exec  = auth.ScopeExec
+	)
+	routes := []route{
+		// Pattern, scope (empty: any signed-in user), handler, and what the
+		// API document says about it. JSON routes go through handle; event
+		// streams write their own responses.
+		{"GET /auth/session", "", handle(app.handleAuthSession), endpoint{tag: "Auth",
+			summary: "Describe the caller", resp: sessionResponse{}}},
+		{"POST /auth/logout", "", handle(app.handleAuthLogout), endpoint{tag: "Auth",
+			summary: "End the session and clear its cookies", resp: statusResponse{}}},
+		{"GET /terminal/sys_info", "", handle(app.handleSysInfo), endpoint{tag: "System",
+			summary: "Report CPU, memory, OS and uptime", resp: sysInfoResponse{}}},
+		{"POST /upload", write, handle(app.handleUpload), endpoint{tag: "Files",
+			summary: "Upload a file", body: uploadForm{}, resp: uploadResponse{}}},
+		{"POST /analyze_code_file", read, handle(app.handleAnalyzeCodeFile), endpoint{tag: "Files",
+			summary: "Analyze an uploaded source file", body: fileRequest{}, resp: codeAnalysisResponse{}}},
+		{"POST /process_text_file", read, handle(app.handleProcessTextFile), endpoint{tag: "Files",
+			summary: "Read an uploaded text file", body: fileRequest{}, resp: fileContentResponse{}}},
+		{"POST /generate", read, handle(app.handleGenerate), endpoint{tag: "Generation",
+			summary: "Generate a reply", body: generateRequest{}, resp: generationResult{}}},
+		{"GET /generate/stream", read, app.handleGenerateStream, endpoint{tag: "Events",
+			summary:     "Follow the generation events",
+			description: "Topics must lie under generate. Without Accept: text/event-stream, answers with the backlog after last_event_id instead.",
+			query:       eventStreamParams{}, events: events.Event{}, resp: []events.Event{}}},
+		{"POST /generate/stream", read, app.handleGenerateStream, endpoint{tag: "Generation",
+			summary:     "Generate a reply, streaming its tokens",
+			description: "Sends token events, then one done event with the result or one error event with the error object.",
+			body:        generateRequest{}, events: either(generateToken{}, generationResult{}, api.Error{})}},
+		{"POST /process_image", "", handle(app.handleProcessImage), endpoint{tag: "Analysis",
+			summary: "Describe an image", planned: true}},
+		{"GET /scout/scan", exec, handle(app.handleScoutScan), endpoint{tag: "Analysis",
+			summary: "Scan the local network", planned: true}},
+		{"POST /visual/screenshot", exec, handle(app.handleVisualScreenshot), endpoint{tag: "Analysis",
+			summary: "Take a screenshot", planned: true}},
+		{"POST /analyze/anomaly_file", "", handle(app.handleAnalyzeAnomalyFile), endpoint{tag: "Analysis",
+			summary: "Find anomalies in a file", planned: true}},
+		{"POST /analyze/anomaly_text", "", handle(app.handleAnalyzeAnomalyText), endpoint{tag: "Analysis",
+			summary: "Find anomalies in text", planned: true}},
+		{"POST /analyze/visual_signature", "", handle(app.handleAnalyzeVisualSignature), endpoint{tag: "Analysis",
+			summary: "Compare visual signatures", planned: true}},
+		{"POST /introspect/god_mode", exec, handle(app.handleIntrospectGodMode), endpoint{tag: "Analysis",
+			summary: "Introspect the system", planned: true}},
+		{"GET /sentinel/data", read, handle(app.handleSentinelData), endpoint{tag: "Analysis",
+			summary: "Read the sentinel findings", planned: true}},
+		{"POST /sentinel/scout", exec, handle(app.handleSentinelScout), endpoint{tag: "Analysis",
+			summary: "Run the sentinel scout", planned: true}},
+		{"POST /sentinel/log_scan", exec, handle(app.handleSentinelLogScan), endpoint{tag: "Analysis",
+			summary: "Scan the system logs", planned: true}},
+		{"POST /sentinel/scribe", write, handle(app.handleSentinelScribe), endpoint{tag: "Analysis",
+			summary: "Record sentinel findings", planned: true}},
+		{"GET /autonomy/status", "", handle(app.handleAutonomyStatus), endpoint{tag: "Autonomy",
+			summary: "Report the state of Ghost Mode", resp: autonomyStatus{}}},
+		{"GET /sentry/stream", read, app.handleSentryStream, endpoint{tag: "Events",
+			summary:     "Follow the event bus",
+			description: "Without Accept: text/event-stream, answers with the backlog after last_event_id instead.",
+			query:       eventStreamParams{}, events: events.Event{}, resp: []events.Event{}}},
+		{"GET /autonomy/config", "", handle(app.handleAutonomyConfig), endpoint{tag: "Autonomy",
+			summary: "Read the Ghost Mode configuration", resp: autonomyConfig{}}},
+		{"POST /autonomy/config", admin, handle(app.handleAutonomyConfig), endpoint{tag: "Autonomy",
+			summary:     "Change the Ghost Mode configuration",
+			description: "Takes any subset of the fields; the others keep their values.",
+			body:        autonomyConfig{}, resp: autonomyConfigResponse{}}},
+		{"GET /memory/saves", read, handle(app.handleMemorySaves), endpoint{tag: "Memory",
+			summary: "List saved summaries, newest first", query: memorySavesParams{}, resp: []memorySave{}}},
+		{"POST /memory/saves", write, handle(app.handleMemorySaves), endpoint{tag: "Memory",
+			summary: "Save a summary now", body: manualSaveRequest{}, optionalBody: true,
+			resp: either(memorySave{}, skippedSaveResponse{})}},
+		{"GET /api/ingest_stats", read, handle(app.handleIngestStats), endpoint{tag: "Memory",
+			summary: "Report session ingestion", resp: ingestStatsResponse{}}},
+		{"GET /api/databases", read, handle(app.handleAPIDatabases), endpoint{tag: "Databases",
+			summary: "List the registered databases", resp: databasesResponse{}}},
+		{"GET /api/tables", read, handle(app.handleAPITables), endpoint{tag: "Databases",
+			summary: "Describe the tables of a database", query: tablesParams{}, resp: tablesResponse{}}},
+		{"GET /api/table_data", read, handle(app.handleAPITableData), endpoint{tag: "Databases",
+			summary: "Read a page of a table", query: tableDataParams{}, resp: dbschema.Page{}}},
+		{"GET /api/search", read, handle(app.handleAPISearch), endpoint{tag: "Search",
+			summary:     "Search the memory tables",
+			description: "Snippets are HTML-escaped, with the matches wrapped in <mark>.",
+			query:       searchParams{}, resp: search.Result{}}},
+		{"GET /api/recall", read, handle(app.handleAPIRecall), endpoint{tag: "Memory",
+			summary: "Recall related memories", query: recallParams{}, resp: embed.Recall{}}},
+		{"GET /api/backups", read, handle(app.handleAPIBackups), endpoint{tag: "Backups",
+			summary: "List the backups", resp: backupsResponse{}}},
+		{"POST /api/backups", write, handle(app.handleAPIBackups), endpoint{tag: "Backups",
+			summary: "Take a backup now", resp: backupResponse{}}},
+		{"POST /api/backups/restore", write, handle(app.handleAPIRestoreBackup), endpoint{tag: "Backups",
+			summary: "Restore the memory database from a backup", body: restoreBackupRequest{}, resp: restoreBackupResponse{}}},
+		{"GET /api/train", read, handle(app.handleAPITrain), endpoint{tag: "Training",
+			summary: "List the exports, or describe one with job", query: trainParams{},
+			resp: either(trainListResponse{}, trainJob{})}},
+		{"POST /api/train", write, handle(app.handleAPITrain), endpoint{tag: "Training",
+			summary: "Start a dataset export", body: trainRequest{}, resp: trainJobResponse{}, status: http.StatusAccepted}},
+		{"DELETE /api/train", write, handle(app.handleAPITrain), endpoint{tag: "Training",
+			summary: "Cancel a running export", query: trainParams{}, resp: trainJobResponse{}}},
+		{"POST /api/crawl", write, handle(app.handleAPICrawl), endpoint{tag: "Analysis",
+			summary: "Start a web crawl", planned: true}},
+		{"POST /api/stop_crawl", write, handle(app.handleAPIStopCrawl), endpoint{tag: "Analysis",
+			summary: "Stop the web crawl", planned: true}},
+		{"POST /api/delete_rows", write, handle(app.handleAPIDeleteRows), endpoint{tag: "Databases",
+			summary: "Delete rows, keeping them for undo", body: deleteRowsRequest{}, resp: deleteRowsResponse{}}},
+		{"POST /api/undo", write, handle(app.handleAPIUndo), endpoint{tag: "Databases",
+			summary: "Undo a deletion", body: undoRequest{}, resp: undoResponse{}}},
+		{"POST /api/create_database", write, handle(app.handleAPICreateDatabase), endpoint{tag: "Databases",
+			summary: "Create a database", body: createDatabaseRequest{}, resp: databaseResponse{}, status: http.StatusCreated}},
+		{"POST /api/delete_database", write, handle(app.handleAPIDeleteDatabase), endpoint{tag: "Databases",
+			summary: "Delete a database", body: deleteDatabaseRequest{}, resp: deleteDatabaseResponse{}}},
+		{"POST /api/copy_database", write, handle(app.handleAPICopyDatabase), endpoint{tag: "Databases",
+			summary:     "Copy a database",
+			description: "The encrypted memory database cannot be copied: the copy would be unencrypted.",
+			body:        copyDatabaseRequest{}, resp: databaseResponse{}, status: http.StatusCreated}},
+		{"POST /api/merge_databases", write, handle(app.handleAPIMergeDatabases), endpoint{tag: "Databases",
+			summary: "Merge one database into another", body: mergeRequest{}, resp: mergeResponse{}}},
+		{"POST /api/archive_database", write, handle(app.handleAPIArchiveDatabase), endpoint{tag: "Archives",
+			summary:     "Snapshot a database",
+			description: "Snapshots are unencrypted, so the encrypted memory database is refused; back it up instead.",
+			body:        archiveDatabaseRequest{}, resp: snapshotResponse{}}},
+		{"POST /api/archive_table", write, handle(app.handleAPIArchiveTable), endpoint{tag: "Archives",
+			summary:     "Move a table into the archive",
+			description: "Archive sidecars are unencrypted, so tables of the encrypted memory database are refused.",
+			body:        archiveTableRequest{}, resp: archiveBatchResponse{}}},
+		{"POST /api/archive_rows", write, handle(app.handleAPIArchiveRows), endpoint{tag: "Archives",
+			summary:     "Move rows into the archive",
+			description: "Archive sidecars are unencrypted, so rows of the encrypted memory database are refused.",
+			body:        archiveRowsRequest{}, resp: archiveBatchResponse{}}},
+		{"GET /api/archives", read, handle(app.handleAPIArchives), endpoint{tag: "Archives",
+			summary: "List archived batches and snapshots", query: archivesParams{}, resp: archivesResponse{}}},
+		{"POST /api/restore_archive", write, handle(app.handleAPIRestoreArchive), endpoint{tag: "Archives",
+			summary: "Move an archived batch back", body: restoreArchiveRequest{}, resp: archiveBatchResponse{}}},
+		{"POST /api/restore_database", write, handle(app.handleAPIRestoreDatabase), endpoint{tag: "Archives",
+			summary: "Restore a database from a snapshot", body: restoreDatabaseRequest{}, resp: snapshotRestoredResponse{}}},
+		{"POST /api/ai_analyze", read, handle(app.handleAPIAIAnalyze), endpoint{tag: "Analysis",
+			summary: "Analyze rows with the LLM", planned: true}},
+		{"GET /api/status", "", handle(app.handleAPIStatus), endpoint{tag: "Analysis",
+			summary: "Report the crawler status", planned: true}},
+		{"POST /api/cast", exec, handle(app.handleAPICast), endpoint{tag: "Analysis",
+			summary: "Cast media to a device", planned: true}},
+	}
+	public := []route{
+		{"POST /auth/login", "", handle(app.handleAuthLogin), endpoint{tag: "Auth",
+			summary: "Sign in", description: "Sets the session and CSRF cookies.", body: loginRequest{}, resp: loginResponse{}}},
+		{"GET /health", "", handle(app.handleHealth), endpoint{tag: "System",
+			summary: "Check that the server is up", resp: statusResponse{}}},
+		{"GET /api/openapi.json", "", app.handleOpenAPI, endpoint{}}, // The document itself
+		{"GET /api/docs", "", http.RedirectHandler("/web/api_docs.html", http.StatusFound).ServeHTTP, endpoint{}},
+		// Redirect root to a default GUI entry point. Other unknown paths get
+		// a 404, and known paths with the wrong method a 405 (routeErrors).
+		{"GET /{$}", "", func(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/web/nexus_index.html", http.StatusFound) // Default GUI
-			return
-		}
-		// Fallback for any other unhandled paths
-		fmt.Fprintf(w, "Sovereign System API is running. Access GUIs via specific paths.")
-	})
+		}, endpoint{}},
+	}
+
+	// The same declarations describe the API and validate request bodies.
+	spec, err := newAPISpec(routes, public)
+	if err != nil {
+		return fmt.Errorf("failed to describe the API: %w", err)
+	}
+	app.openAPI = spec
+	for _, rt := range routes {
+		rt.handler = spec.validateBody(rt)
+		mux.Handle(rt.pattern, app.limit(rt.pattern, draining, app.protect(rt)))
+	}
+	for _, rt := range public {
+		mux.Handle(rt.pattern, app.limit(rt.pattern, draining, spec.validateBody(rt)))
+	}
+
+	// The http.StripPrefix ensures that "/web/" is removed from the request path
+	// before http.FileServer looks for the file in the embedded web directory.
+	webFiles, err := fs.Sub(embeddedFiles, "web")
+	if err != nil {
+		return fmt.Errorf("failed to open embedded web files: %w", err)
+	}
+	mux.Handle("GET /web/", http.StripPrefix("/web/", http.FileServer(http.FS(webFiles))))
+	return nil
 }
 
-// Placeholder Handlers (to be implemented)
-func (app *SovereignApp) handleSysInfo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// sysInfoResponse summarizes the host for the terminal GUI.
+type sysInfoResponse struct {
+	CPU    string `json:"cpu" doc:"CPU use, e.g. 12.5%"`
+	RAM    string `json:"ram" doc:"Memory use, e.g. 40.2%"`
+	OS     string `json:"os"`
+	Uptime string `json:"uptime"`
+}
 
+// Placeholder Handlers (to be implemented)
+func (app *SovereignApp) handleSysInfo(w http.ResponseWriter, r *http.Request) (any, error) {
 	// Get CPU info
 	cpuPercents, err := cpu.Percent(time.Second, false)
 	cpuInfo := "N/A"
@@ -339,14 +641,7 @@ func (app *SovereignApp) handleSysInfo(w http.ResponseWriter, r *http.Request) {
 		uptimeInfo = (time.Duration(hostStat.Uptime) * time.Second).String()
 	}
 
-	response := map[string]string{
-		"cpu":    cpuInfo,
-		"ram":    ramInfo,
-		"os":     osInfo,
-		"uptime": uptimeInfo,
-	}
-
-	json.NewEncoder(w).Encode(response)
+	return sysInfoResponse{CPU: cpuInfo, RAM: ramInfo, OS: osInfo, Uptime: uptimeInfo}, nil
 }
 
 // min returns the smaller of two ints.
@@ -356,65 +651,110 @@ func min(a, b int) int {
 	}
 	return b
 }
-func (app *SovereignApp) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
-		return
-	}
 
+// uploadForm is the multipart form of /upload.
+type uploadForm struct {
+	File []byte `json:"file" doc:"The file; stored under its own name in the uploads directory" schema:"required,format=binary"`
+}
+
+// uploadResponse tells where an upload was stored.
+type uploadResponse struct {
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+func (app *SovereignApp) handleUpload(w http.ResponseWriter, r *http.Request) (any, error) {
 	// 10 MB limit for uploaded files
 	r.ParseMultipartForm(10 << 20)
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving file from form: %v", err), http.StatusBadRequest)
-		return
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, api.Errorf(api.CodeInvalidRequest, "Error retrieving file from form: %v", err)
 	}
 	defer file.Close()
+	name, err := uploadName(handler.Filename)
+	if err != nil {
+		return nil, err
+	}
 
 	uploadDir := filepath.Join(app.AppDir, "uploads")
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating upload directory: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
-	dstPath := filepath.Join(uploadDir, handler.Filename)
+	dstPath := filepath.Join(uploadDir, name)
 	dst, err := os.Create(dstPath)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating destination file: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer dst.Close()
 
 	if _, err := io.Copy(dst, file); err != nil {
-		http.Error(w, fmt.Sprintf("Error copying file content: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to copy file content: %w", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"filename": handler.Filename, "path": dstPath, "message": "File uploaded successfully"})
+	app.publish("upload", "info", "File uploaded: "+name, map[string]string{"filename": name, "path": dstPath})
+	return uploadResponse{Filename: name, Path: dstPath, Message: "File uploaded successfully"}, nil
 }
-func (app *SovereignApp) handleAnalyzeCodeFile(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
-		return
+// uploadName checks that a client-supplied file name stays inside the
+// uploads directory.
+func uploadName(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", api.Errorf(api.CodeInvalidRequest, "Invalid file name %q", name)
 	}
+	return name, nil
+}
 
-	var requestBody struct {
-		Filename string `json:"filename"`
+// readUpload reads a previously uploaded file.
+func (app *SovereignApp) readUpload(name string) ([]byte, error) {
+	name, err := uploadName(name)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
-		return
+	content, err := os.ReadFile(filepath.Join(app.AppDir, "uploads", name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, api.Errorf(api.CodeNotFound, "No uploaded file named %q", name)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	return content, nil
+}
 
-	filePath := filepath.Join(app.AppDir, "uploads", requestBody.Filename)
-	contentBytes, err := os.ReadFile(filePath)
+// fileRequest names a previously uploaded file.
+type fileRequest struct {
+	Filename string `json:"filename" schema:"required,minLength=1"`
+}
+
+// codeAnalysisResponse is the (simulated) analysis of an uploaded source file.
+type codeAnalysisResponse struct {
+	Filename     string   `json:"filename"`
+	Language     string   `json:"language"`
+	Preview      string   `json:"preview" doc:"The first lines of the file"`
+	ToolAnalysis string   `json:"tool_analysis"`
+	Suggestions  []string `json:"suggestions"`
+}
+
+// fileContentResponse holds the text of an uploaded file.
+type fileContentResponse struct {
+	Content string `json:"content"`
+}
+
+func (app *SovereignApp) handleAnalyzeCodeFile(w http.ResponseWriter, r *http.Request) (any, error) {
+	var requestBody fileRequest
+	if err := api.DecodeJSON(r, &requestBody); err != nil {
+		return nil, err
+	}
+
+	contentBytes, err := app.readUpload(requestBody.Filename)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	content := string(contentBytes)
 
@@ -443,102 +783,111 @@ func (app *SovereignApp) handleAnalyzeCodeFile(w http.ResponseWriter, r *http.Re
 	// Simulate tool analysis and suggestions
 	previewLines := strings.Join(strings.Split(content, "\n")[:min(5, len(strings.Split(content, "\n")))], "\n")
 
-toolAnalysis := fmt.Sprintf("Simulated static analysis for %s. Found potential areas for optimization.", language)
-suggestions := []string{
+	toolAnalysis := fmt.Sprintf("Simulated static analysis for %s. Found potential areas for optimization.", language)
+	suggestions := []string{
 		"Consider adding more comments for complex logic.",
 		"Check for unused variables or imports.",
 		"Ensure error handling is robust in all critical paths.",
 	}
 
-	response := map[string]interface{}{
-		"filename":      requestBody.Filename,
-		"language":      language,
-		"preview":       previewLines,
-		"tool_analysis": toolAnalysis,
-		"suggestions":   suggestions,
-	}
-	json.NewEncoder(w).Encode(response)
+	return codeAnalysisResponse{
+		Filename:     requestBody.Filename,
+		Language:     language,
+		Preview:      previewLines,
+		ToolAnalysis: toolAnalysis,
+		Suggestions:  suggestions,
+	}, nil
 }
-func (app *SovereignApp) handleProcessTextFile(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
-		return
+func (app *SovereignApp) handleProcessTextFile(w http.ResponseWriter, r *http.Request) (any, error) {
+	var requestBody fileRequest
+	if err := api.DecodeJSON(r, &requestBody); err != nil {
+		return nil, err
 	}
 
-	var requestBody struct {
-		Filename string `json:"filename"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
-		return
+	content, err := app.readUpload(requestBody.Filename)
+	if err != nil {
+		return nil, err
 	}
+	return fileContentResponse{Content: string(content)}, nil
+}
+func (app *SovereignApp) handleProcessImage(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleScoutScan(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleVisualScreenshot(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAnalyzeAnomalyFile(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAnalyzeAnomalyText(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAnalyzeVisualSignature(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleIntrospectGodMode(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleSentinelData(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleSentinelScout(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleSentinelLogScan(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleSentinelScribe(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAPICrawl(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAPIStopCrawl(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAPIAIAnalyze(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAPIStatus(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleAPICast(w http.ResponseWriter, r *http.Request) (any, error) {
+	return notImplemented(w, r)
+}
+func (app *SovereignApp) handleHealth(w http.ResponseWriter, r *http.Request) (any, error) {
+	return statusResponse{Status: "up"}, nil
+}
 
-	filePath := filepath.Join(app.AppDir, "uploads", requestBody.Filename)
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	response := map[string]string{"content": string(content)}
-	json.NewEncoder(w).Encode(response)
-}
-func (app *SovereignApp) handleGenerate(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleProcessImage(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleScoutScan(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleVisualScreenshot(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAnalyzeAnomalyFile(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAnalyzeAnomalyText(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAnalyzeVisualSignature(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleIntrospectGodMode(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleSentinelData(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleSentinelScout(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleSentinelLogScan(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleSentinelScribe(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAutonomyStatus(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleSentryStream(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAutonomyConfig(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIDatabases(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPITables(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPITableData(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPITrain(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPICrawl(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIStopCrawl(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIDeleteRows(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPICreateDatabase(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIDeleteDatabase(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPICopyDatabase(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIMergeDatabases(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIArchiveDatabase(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIArchiveTable(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIArchiveRows(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIAIAnalyze(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPIStatus(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleAPICast(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not Implemented", http.StatusNotImplemented) }
-func (app *SovereignApp) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
-}
-
-// isUserAttached checks if a user is currently interacting with the system.
-// This is a placeholder implementation.
-func (app *SovereignApp) isUserAttached() bool {
-	// In a real scenario, this would check for active GUI sessions,
-	// SSH connections, or recent API calls from the user interface.
-	// For now, we'll simulate this with a simple heuristic or configuration.
-	return false 
-}
-
-// getSystemContext gathers relevant system information for autonomous operation.
-// This is a placeholder implementation.
+// getSystemContext gathers relevant system information for autonomous operation:
+// the memories most related to the latest conversation, recalled from the
+// vector store.
 func (app *SovereignApp) getSystemContext() string {
-	// In a real scenario, this would collect data from various sources:
-	// - Running processes
-	// - File system changes
-	// - Network activity
-	// - User interaction history
-	// - Internal state of the Sovereign App
-	return "System context: All systems nominal. Awaiting directives."
+	const nominal = "System context: All systems nominal. Awaiting directives."
+	n := app.Config.Embeddings.ContextMemories
+	if app.Vectors == nil || n <= 0 {
+		return nominal
+	}
+	memories, err := app.recallRecent(app.ctx, n)
+	if err != nil {
+		log.Printf("Ghost Mode: memory recall failed: %v", err)
+		return nominal
+	}
+	if len(memories) == 0 {
+		return nominal
+	}
+	var b strings.Builder
+	b.WriteString(nominal)
+	b.WriteString(" Relevant memories:")
+	for _, m := range memories {
+		text := strings.Join(strings.Fields(m.Text), " ")
+		if r := []rune(text); len(r) > 200 {
+			text = string(r[:200]) + "…"
+		}
+		fmt.Fprintf(&b, "\n- [%s #%d, %.2f] %s", m.Table, m.ID, m.Score, text)
+	}
+	return b.String()
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/events"
+)
+
+const (
+	eventBufferSize   = 4096
+	sseHeartbeatEvery = 15 * time.Second
+)
+
+// publish is a shorthand for publishing to the application event bus.
+func (app *SovereignApp) publish(topic, typ, msg string, data interface{}) {
+	app.Events.Publish(topic, typ, msg, data)
+}
+
+// sseWriter writes Server-Sent Events to a response.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter sets the streaming headers. It fails if the response cannot be flushed.
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming unsupported by response writer")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// send writes one event. id may be empty for events that are not resumable, and
+// an empty event name delivers to the client's default "message" handler.
+func (s *sseWriter) send(id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// comment writes an SSE comment line, used as a heartbeat to keep proxies from timing out.
+func (s *sseWriter) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// eventStreamParams holds the parameters of the event stream routes.
+type eventStreamParams struct {
+	Topics      []string `query:"topics" doc:"Topics to receive, comma-separated; all when omitted"`
+	Topic       []string `query:"topic" doc:"A topic to receive; may repeat"`
+	LastEventID uint64   `query:"last_event_id" doc:"Resume after this event, for clients that cannot set Last-Event-ID"`
+}
+
+// streamTopics parses ?topics=a,b (or repeated ?topic=a) from the request.
+func streamTopics(r *http.Request, defaults ...string) []string {
+	var topics []string
+	for _, v := range r.URL.Query()["topics"] {
+		topics = append(topics, strings.Split(v, ",")...)
+	}
+	topics = append(topics, r.URL.Query()["topic"]...)
+	if len(topics) == 0 {
+		return defaults
+	}
+	return topics
+}
+
+// lastEventID reads the resume position from the Last-Event-ID header, or from
+// ?last_event_id= for clients that cannot set headers.
+func lastEventID(r *http.Request) uint64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseUint(v, 10, 64)
+	return id
+}
+
+// wantsEventStream reports whether the client asked for SSE rather than JSON.
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// serveEventStream streams bus events matching topics until the client disconnects.
+// Events are sent unnamed with the topic inside the payload, so one onmessage
+// handler sees every topic the client filtered for.
+// Clients that do not accept text/event-stream get the buffered events as a JSON array.
+func (app *SovereignApp) serveEventStream(w http.ResponseWriter, r *http.Request, topics []string) {
+	since := lastEventID(r)
+	if !wantsEventStream(r) {
+		backlog := app.Events.Since(since, topics)
+		if backlog == nil {
+			backlog = []events.Event{}
+		}
+		api.Write(w, http.StatusOK, backlog)
+		return
+	}
+
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		api.WriteError(w, apiAdapter.Resolve(r, err))
+		return
+	}
+	sub, backlog := app.Events.Subscribe(topics, since)
+	defer sub.Close()
+
+	for _, ev := range backlog {
+		if err := sse.send(strconv.FormatUint(ev.ID, 10), "", ev); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatEvery)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.ctx.Done():
+			return
+		case <-heartbeat.C:
+			if err := sse.comment("heartbeat"); err != nil {
+				return
+			}
+		case ev, ok := <-sub.C():
+			if !ok {
+				// Dropped for falling behind; the client reconnects with Last-Event-ID.
+				return
+			}
+			if err := sse.send(strconv.FormatUint(ev.ID, 10), "", ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSentryStream exposes the event bus. Filter with ?topics=ghost,save.
+func (app *SovereignApp) handleSentryStream(w http.ResponseWriter, r *http.Request) {
+	app.serveEventStream(w, r, streamTopics(r))
+}
+
+// generateToken is the data of a "token" event of POST /generate/stream.
+type generateToken struct {
+	Delta string `json:"delta" doc:"Text generated since the previous token"`
+}
+
+// handleGenerateStream streams generation output.
+//
+// GET subscribes to the "generate" topics of the event bus, optionally
+// narrowed with ?topics= to topics under "generate", and sees the tokens of
+// every streamed generation as "generate.token" events. POST takes the same
+// body as /generate and streams that generation's tokens back as "token"
+// events, followed by "done" or "error". Tokens are published live only, so
+// they cannot crowd the resume backlog out of the ring; a resumed GET stream
+// misses the tokens sent while it was away.
+func (app *SovereignApp) handleGenerateStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		topics := streamTopics(r, "generate")
+		for _, t := range topics {
+			if t != "generate" && !strings.HasPrefix(t, "generate.") {
+				api.WriteError(w, api.Errorf(api.CodeInvalidRequest, "Topic %q is not a generate topic; use /sentry/stream", t))
+				return
+			}
+		}
+		app.serveEventStream(w, r, topics)
+		return
+	}
+
+	var requestBody generateRequest
+	if err := api.DecodeJSON(r, &requestBody); err != nil {
+		api.WriteError(w, apiAdapter.Resolve(r, err))
+		return
+	}
+	if requestBody.Prompt == "" {
+		api.WriteError(w, api.Errorf(api.CodeInvalidRequest, "prompt is required"))
+		return
+	}
+
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		api.WriteError(w, apiAdapter.Resolve(r, err))
+		return
+	}
+	result, err := app.generate(r.Context(), requestBody, func(delta string) error {
+		return sse.send("", "token", generateToken{Delta: delta})
+	})
+	if err != nil {
+		// The error event carries the same object as an error envelope.
+		sse.send("", "error", apiAdapter.Resolve(r, generateError(r.Context(), err)))
+		return
+	}
+	sse.send("", "done", result)
+}
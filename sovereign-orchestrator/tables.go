@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/dbschema"
+)
+
+// tablesParams holds the parameters of /api/tables.
+type tablesParams struct {
+	DB     string `query:"db" doc:"Registered database; the memory database when empty"`
+	Counts bool   `query:"counts" doc:"Count the rows of each table; true unless false is given"`
+}
+
+// tablesResponse describes the tables of a database.
+type tablesResponse struct {
+	Database string            `json:"database"`
+	Tables   []*dbschema.Table `json:"tables"`
+}
+
+// handleAPITables describes the tables of a database: columns, types, row
+// counts and indexes. ?db= selects a registered database (default primary)
+// and ?counts=false skips the row counts.
+func (app *SovereignApp) handleAPITables(w http.ResponseWriter, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	db, err := app.Databases.Get(r.Context(), q.Get("db"))
+	if err != nil {
+		return nil, err
+	}
+	schema, err := dbschema.Inspect(r.Context(), db, q.Get("counts") != "false")
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect database %q: %w", q.Get("db"), err)
+	}
+
+	name := q.Get("db")
+	if name == "" {
+		name = app.Databases.PrimaryName()
+	}
+	return tablesResponse{Database: name, Tables: schema.Tables}, nil
+}
+
+// tableDataParams holds the parameters of /api/table_data.
+type tableDataParams struct {
+	DB     string   `query:"db" doc:"Registered database; the memory database when empty"`
+	Table  string   `query:"table" schema:"required"`
+	Sort   string   `query:"sort" doc:"Column to sort by; the primary key when empty"`
+	Order  string   `query:"order" schema:"enum=asc|desc"`
+	Limit  int      `query:"limit" schema:"min=1"`
+	Cursor string   `query:"cursor" doc:"next_cursor of the previous page"`
+	Filter []string `query:"filter" doc:"column:op:value, e.g. type:eq:input; may repeat"`
+}
+
+// handleAPITableData returns one page of a table.
+//
+//	GET /api/table_data?db=&table=ch&sort=timestamp&order=desc&limit=50&cursor=&filter=type:eq:input
+//
+// filter may be repeated; see dbschema.Filter for the grammar. Pass the
+// returned next_cursor to get the following page.
+func (app *SovereignApp) handleAPITableData(w http.ResponseWriter, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	req := dbschema.PageRequest{
+		Table:  q.Get("table"),
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		req.Desc = true
+	default:
+		return nil, api.Errorf(api.CodeInvalidRequest, "order must be asc or desc")
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return nil, api.Errorf(api.CodeInvalidRequest, "limit must be a positive integer")
+		}
+		req.Limit = limit
+	}
+	for _, expr := range q["filter"] {
+		f, err := dbschema.ParseFilter(expr)
+		if err != nil {
+			return nil, api.Errorf(api.CodeInvalidRequest, "%s", err)
+		}
+		req.Filters = append(req.Filters, f)
+	}
+	if req.Table == "" {
+		return nil, api.Errorf(api.CodeInvalidRequest, "Missing table parameter")
+	}
+
+	db, err := app.Databases.Get(r.Context(), q.Get("db"))
+	if err != nil {
+		return nil, err
+	}
+	schema, err := dbschema.Inspect(r.Context(), db, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect database %q: %w", q.Get("db"), err)
+	}
+	page, err := dbschema.ReadPage(r.Context(), db, schema, req)
+	if err != nil && !errors.Is(err, dbschema.ErrInvalidQuery) {
+		return nil, fmt.Errorf("failed to read table %s: %w", req.Table, err)
+	}
+	return page, err
+}
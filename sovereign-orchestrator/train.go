@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"sovereign-orchestrator/pkg/api"
+	"sovereign-orchestrator/pkg/dataset"
+)
+
+const (
+	datasetDirName = "datasets"
+	// maxFinishedTrainJobs bounds the finished jobs kept in memory; their
+	// datasets stay on disk and are listed from their manifests.
+	maxFinishedTrainJobs = 50
+)
+
+// trainJob is one asynchronous dataset export.
+type trainJob struct {
+	ID         string            `json:"id"`
+	Database   string            `json:"database"`
+	Status     string            `json:"status"` // "running", "done", "failed" or "canceled"
+	Spec       dataset.Spec      `json:"spec"`
+	Progress   dataset.Progress  `json:"progress"`
+	Manifest   *dataset.Manifest `json:"manifest,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Dir        string            `json:"dir"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// trainJobs tracks the exports started since the app came up.
+type trainJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*trainJob
+	seq  int
+}
+
+func newTrainJobs() *trainJobs {
+	return &trainJobs{jobs: make(map[string]*trainJob)}
+}
+
+// get returns a copy of a job, so callers can read it without the lock.
+func (t *trainJobs) get(id string) (trainJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return trainJob{}, false
+	}
+	return *job, true
+}
+
+// list returns copies of every job, newest first.
+func (t *trainJobs) list() []trainJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jobs := make([]trainJob, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.After(jobs[j].StartedAt) })
+	return jobs
+}
+
+// update changes a job under the lock and returns a copy of the result.
+func (t *trainJobs) update(id string, fn func(*trainJob)) trainJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job := t.jobs[id]
+	fn(job)
+	return *job
+}
+
+// prune forgets the oldest finished jobs beyond maxFinishedTrainJobs.
+// Callers hold the lock.
+func (t *trainJobs) prune() {
+	var finished []*trainJob
+	for _, job := range t.jobs {
+		if job.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedTrainJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.After(*finished[j].FinishedAt) })
+	for _, job := range finished[maxFinishedTrainJobs:] {
+		delete(t.jobs, job.ID)
+	}
+}
+
+// datasetDir is where exported datasets are written.
+func (app *SovereignApp) datasetDir() string {
+	return filepath.Join(app.AppDir, datasetDirName)
+}
+
+// startTrainJob validates a spec and exports it in the background. The job
+// is canceled with the app, and its partial output is discarded.
+func (app *SovereignApp) startTrainJob(name string, spec dataset.Spec) (trainJob, error) {
+	if name == "" {
+		name = app.Databases.PrimaryName()
+	}
+	if err := app.refuseSealedCopy(name, "exporting a dataset"); err != nil {
+		return trainJob{}, err
+	}
+	if err := spec.Validate(); err != nil {
+		return trainJob{}, err
+	}
+	// The job holds the database until it ends, so deleting it waits.
+	ctx, cancel := context.WithCancel(app.ctx)
+	db, err := app.Databases.Get(ctx, name)
+	if err != nil {
+		cancel()
+		return trainJob{}, err
+	}
+
+	now := time.Now().UTC()
+	t := app.trainJobs
+	t.mu.Lock()
+	t.seq++
+	id := fmt.Sprintf("%s-%s-%s-%d", name, spec.Table, now.Format("20060102-150405"), t.seq)
+	job := &trainJob{
+		ID:        id,
+		Database:  name,
+		Status:    "running",
+		Spec:      spec,
+		Progress:  dataset.Progress{Phase: "queued"},
+		Dir:       filepath.Join(app.datasetDir(), id),
+		StartedAt: now,
+		cancel:    cancel,
+	}
+	t.jobs[id] = job
+	snapshot := *job
+	t.mu.Unlock()
+
+	app.publish("train", "info", fmt.Sprintf("Started dataset export %s", id), snapshot)
+	app.background(func() {
+		defer cancel()
+		m, err := dataset.Export(ctx, db, name, spec, snapshot.Dir, func(p dataset.Progress) {
+			job := t.update(id, func(j *trainJob) { j.Progress = p })
+			if p.Phase == "reading" && p.Rows > 0 {
+				app.publish("train", "info", fmt.Sprintf("Dataset export %s read %d of %d rows", id, p.Rows, p.Total), job)
+			}
+		})
+		finished := time.Now().UTC()
+		job := t.update(id, func(j *trainJob) {
+			j.FinishedAt = &finished
+			switch {
+			case err == nil:
+				j.Status, j.Manifest = "done", m
+			case ctx.Err() != nil:
+				j.Status, j.Error = "canceled", ctx.Err().Error()
+			default:
+				j.Status, j.Error = "failed", err.Error()
+			}
+		})
+		t.mu.Lock()
+		t.prune()
+		t.mu.Unlock()
+
+		switch job.Status {
+		case "done":
+			app.publish("train", "info", fmt.Sprintf("Exported %d training example(s) from %s.%s (%d train, %d val)",
+				m.Counts.Examples, name, spec.Table, m.Counts.Train, m.Counts.Val), job)
+		case "canceled":
+			app.publish("train", "alert", fmt.Sprintf("Dataset export %s was canceled", id), job)
+		default:
+			log.Printf("Dataset export %s failed: %v", id, err)
+			app.publish("train", "alert", fmt.Sprintf("Dataset export %s failed: %v", id, err), job)
+		}
+	})
+	return snapshot, nil
+}
+
+// trainParams holds the parameters of GET and DELETE /api/train.
+type trainParams struct {
+	Job string `query:"job" doc:"Job id; GET lists all jobs without it"`
+}
+
+// trainRequest is the body of POST /api/train. dedupe and scrub default to
+// true.
+type trainRequest struct {
+	DB string `json:"db" doc:"Registered database; the memory database when empty"`
+	dataset.Spec
+}
+
+// trainListResponse answers GET /api/train without a job.
+type trainListResponse struct {
+	Dir      string             `json:"dir"`
+	Jobs     []trainJob         `json:"jobs"`
+	Datasets []dataset.Manifest `json:"datasets"`
+}
+
+// trainJobResponse answers the routes that start or cancel a job.
+type trainJobResponse struct {
+	Status string   `json:"status" schema:"enum=accepted|canceling"`
+	Job    trainJob `json:"job"`
+}
+
+// handleAPITrain starts, inspects and cancels dataset exports.
+//
+//	POST   /api/train {"db": "", "table": "ch", "ids": [], "val_ratio": 0.1, "seed": "", "dedupe": true, "scrub": true, "rules": []}
+//	GET    /api/train             jobs of this run and datasets on disk
+//	GET    /api/train?job=<id>    one job with its progress
+//	DELETE /api/train?job=<id>    cancel a running job
+func (app *SovereignApp) handleAPITrain(w http.ResponseWriter, r *http.Request) (any, error) {
+	id := r.URL.Query().Get("job")
+	switch r.Method {
+	case "GET":
+		if id != "" {
+			job, ok := app.trainJobs.get(id)
+			if !ok {
+				return nil, api.Errorf(api.CodeNotFound, "Unknown job %s", id)
+			}
+			return job, nil
+		}
+		datasets, err := dataset.List(app.datasetDir())
+		if err != nil {
+			return nil, err
+		}
+		return trainListResponse{Dir: app.datasetDir(), Jobs: app.trainJobs.list(), Datasets: datasets}, nil
+	case "DELETE":
+		job, ok := app.trainJobs.get(id)
+		if !ok {
+			return nil, api.Errorf(api.CodeNotFound, "Unknown job %s", id)
+		}
+		if job.FinishedAt != nil {
+			return nil, api.Errorf(api.CodeConflict, "Job %s has already finished", id)
+		}
+		job.cancel()
+		return trainJobResponse{Status: "canceling", Job: job}, nil
+	}
+
+	req := trainRequest{Spec: dataset.Spec{Dedupe: true, Scrub: true}}
+	if err := api.DecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	job, err := app.startTrainJob(req.DB, req.Spec)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("Location", "/api/train?job="+job.ID)
+	return api.Accepted(trainJobResponse{Status: "accepted", Job: job}), nil
+}
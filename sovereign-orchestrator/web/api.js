@@ -0,0 +1,33 @@
+// API glue for the GUIs: every JSON route answers with an envelope,
+// {"status": "ok", "data": ...} or {"status": "error", "error": {"code",
+// "message", "details"}}. sovereignAPI fetches a route and resolves to its
+// data, or rejects with an Error carrying the code, details and HTTP status.
+// Load it after auth.js so requests still get the CSRF token.
+(function () {
+    window.sovereignAPI = async function (input, init) {
+        const res = await window.fetch(input, init);
+        let body = null;
+        try {
+            body = await res.json();
+        } catch (e) {
+            // Not JSON: a proxy error page or a dropped connection.
+        }
+        if (body && body.status === 'ok') return body.data;
+
+        const info = (body && body.error) || { code: 'internal', message: `HTTP ${res.status}` };
+        const err = new Error(info.message);
+        err.code = info.code;
+        err.details = info.details;
+        err.status = res.status;
+        throw err;
+    };
+
+    // sovereignJSON builds the init of a request with a JSON body.
+    window.sovereignJSON = function (method, body) {
+        return {
+            method,
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body),
+        };
+    };
+})();
@@ -0,0 +1,42 @@
+// Sign-in glue for the GUIs: adds the session's CSRF token to requests that
+// change something and sends the browser to the sign-in page when the API
+// answers 401. Load it in <head>, before any script that calls fetch.
+(function () {
+    const CSRF_COOKIE = 'sovereign_csrf';
+    const CSRF_HEADER = 'X-CSRF-Token';
+    const LOGIN_PAGE = '/web/login.html';
+
+    function csrfToken() {
+        for (const part of document.cookie.split(';')) {
+            const [name, ...value] = part.trim().split('=');
+            if (name === CSRF_COOKIE) return decodeURIComponent(value.join('='));
+        }
+        return '';
+    }
+
+    const nativeFetch = window.fetch.bind(window);
+    window.fetch = async function (input, init) {
+        init = init || {};
+        const url = new URL(input instanceof Request ? input.url : input, location.href);
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        const sameOrigin = url.origin === location.origin;
+
+        if (sameOrigin && method !== 'GET' && method !== 'HEAD') {
+            const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+            const token = csrfToken();
+            if (token) headers.set(CSRF_HEADER, token);
+            init = Object.assign({}, init, { headers });
+        }
+
+        const res = await nativeFetch(input, init);
+        if (sameOrigin && res.status === 401 && location.pathname !== LOGIN_PAGE && !url.pathname.startsWith('/auth/')) {
+            location.href = LOGIN_PAGE + '?next=' + encodeURIComponent(location.pathname + location.search);
+        }
+        return res;
+    };
+
+    window.sovereignLogout = async function () {
+        await window.fetch('/auth/logout', { method: 'POST' });
+        location.href = LOGIN_PAGE;
+    };
+})();